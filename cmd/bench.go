@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var benchLines int
+var benchSampleCount int
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench [sample-file]",
+	Short: "measure parsing throughput",
+	Long: `Parse lines (replayed from sample-file, or generated in the combined
+log format) with the configured pattern, marshal them as JSON, and discard
+them, reporting lines per second, allocations per event, and the time spent
+matching the pattern, converting fields, and marshaling.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var lines []string
+		if len(args) > 0 {
+			var err error
+			lines, err = readSampleLines(args[0], benchSampleCount)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to read sample lines: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(lines) == 0 {
+			lines = worker.GenerateBenchLines(benchSampleCount)
+			if viper.GetString("parse.pattern") == "" {
+				fmt.Fprintln(os.Stderr, "No parse.pattern configured; using the default pattern")
+			}
+		}
+		w := &worker.LogParser{}
+		w.Init()
+		result, err := w.Bench(lines, benchLines)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to measure the parser: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(result)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().IntVarP(&benchLines, "lines", "n", 100000, "number of lines to parse")
+	benchCmd.Flags().IntVar(&benchSampleCount, "samples", 10000, "number of distinct lines to read or generate")
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// chatCmd represents the chat command
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "post events to Slack or Microsoft Teams",
+	Long: `Post the events matching chat.where, formatted with chat.template, to the
+Slack or Teams incoming webhook chat.webhook, in batches`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.ChatWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(chatCmd)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
+)
+
+var configSchema bool
+var configShowSecrets bool
+
+// configCmd groups the configuration commands
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "inspect the configuration",
+}
+
+// configDumpCmd represents the config dump command
+var configDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "print the resolved configuration",
+	Long: `Print the fully resolved configuration: every supported key, with its
+value after merging the defaults, the configuration file, environment
+variables and flags, annotated with where the value came from. Keys that are
+set but not supported are flagged. With --schema, print a JSON description
+of all supported keys (type, default, and description) instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configSchema {
+			if err := run.DumpConfigSchema(os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+		if used := viper.ConfigFileUsed(); used != "" {
+			fmt.Printf("# configuration file: %s\n", used)
+		}
+		flags := map[string]bool{}
+		RootCmd.PersistentFlags().Visit(func(f *pflag.Flag) {
+			if f.Name == "pprof" {
+				flags["admin.pprof"] = true
+			}
+		})
+		run.DumpConfig(os.Stdout, run.ResolvedConfig(flags), configShowSecrets)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configDumpCmd)
+	configDumpCmd.Flags().BoolVar(&configSchema, "schema", false, "print a JSON schema of the supported keys instead")
+	configDumpCmd.Flags().BoolVar(&configShowSecrets, "show-secrets", false, "show the values of passwords and other secrets")
+}
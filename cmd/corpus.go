@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/worker"
+)
+
+var corpusUpdate bool
+
+// verifyCorpusCmd represents the verify-corpus command
+var verifyCorpusCmd = &cobra.Command{
+	Use:   "verify-corpus [dir]",
+	Short: "check the parser against a golden corpus",
+	Long: `Parse the cases of a golden corpus (worker/testdata/corpus by default):
+for each <name>.toml, the lines of <name>.log are parsed with that
+configuration, and the events compared with those expected in <name>.json.
+Differences are printed, and the exit status is 1 if there are any. With
+--update, the expected events are replaced by the actual ones instead, for
+when a change in the parser's behavior is intended.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := "worker/testdata/corpus"
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		cases, mismatches, err := worker.VerifyCorpus(dir, corpusUpdate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to verify the corpus: %v\n", err)
+			os.Exit(1)
+		}
+		if corpusUpdate {
+			fmt.Printf("Updated the expected events of %d cases\n", cases)
+			return
+		}
+		for _, m := range mismatches {
+			fmt.Println(m)
+		}
+		fmt.Printf("%d cases, %d mismatched lines\n", cases, len(mismatches))
+		if len(mismatches) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCorpusCmd)
+	verifyCorpusCmd.Flags().BoolVar(&corpusUpdate, "update", false, "replace the expected events by the actual ones")
+}
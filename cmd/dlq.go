@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+var dlqReason string
+var dlqOlderThan time.Duration
+var dlqLimit int
+
+// dlqFilter returns the filter selecting the dead letters the flags name
+func dlqFilter() worker.DeadLetterFilter {
+	filter := worker.DeadLetterFilter{Reason: dlqReason, Limit: dlqLimit}
+	if dlqOlderThan > 0 {
+		filter.Before = time.Now().Add(-dlqOlderThan)
+	}
+	return filter
+}
+
+// dlqCmd groups the dead letter commands
+var dlqCmd = &cobra.Command{
+	Use:   "dlq",
+	Short: "inspect and replay the stored dead letters",
+	Long: `Inspect, replay, and delete the dead letters stored in the SQLite database
+named by dead_letter.sqlite. Each command takes all of them, oldest first,
+or those selected with --reason, --older-than and --limit.`,
+}
+
+// dlqListCmd represents the dlq list command
+var dlqListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "print the stored dead letters",
+	Long: `Print the stored dead letters, one JSON object per line, with their id,
+the time they were rejected, the reason, the error, and the line or event
+that was rejected.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		letters, err := worker.ListDeadLetters(dlqFilter())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to list the dead letters: %v\n", err)
+			os.Exit(1)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		for _, letter := range letters {
+			encoder.Encode(letter)
+		}
+	},
+}
+
+// dlqRequeueCmd represents the dlq requeue command
+var dlqRequeueCmd = &cobra.Command{
+	Use:   "requeue",
+	Short: "replay the stored dead letters, and delete them",
+	Long: `Replay the stored dead letters, typically after fixing the configuration
+that got them rejected, and delete them: lines the parser rejected are
+parsed again, and events the output rejected are sent to the output again,
+which is the output of the pipeline configuration. Those rejected again are
+stored again, as new dead letters.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !run.HasPipelineConfig() {
+			fmt.Fprintln(os.Stderr, "No output configured")
+			os.Exit(1)
+		}
+		p, err := run.LoadPipelineConfig()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sink := p.Sink()
+		if sink == nil {
+			fmt.Fprintln(os.Stderr, "No output configured")
+			os.Exit(1)
+		}
+		n, err := run.Requeue(sink, dlqFilter())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to requeue the dead letters: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Requeued %d dead letters\n", n)
+	},
+}
+
+// dlqPurgeCmd represents the dlq purge command
+var dlqPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "delete the stored dead letters",
+	Long:  `Delete the stored dead letters, without replaying them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		n, err := worker.PurgeDeadLetters(dlqFilter())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to purge the dead letters: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Purged %d dead letters\n", n)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(dlqCmd)
+	dlqCmd.AddCommand(dlqListCmd)
+	dlqCmd.AddCommand(dlqRequeueCmd)
+	dlqCmd.AddCommand(dlqPurgeCmd)
+	dlqCmd.PersistentFlags().StringVar(&dlqReason, "reason", "", "only dead letters rejected for this reason (e.g. unmatched, rejected)")
+	dlqCmd.PersistentFlags().DurationVar(&dlqOlderThan, "older-than", 0, "only dead letters rejected longer ago than this (e.g. 24h)")
+	dlqCmd.PersistentFlags().IntVar(&dlqLimit, "limit", 0, "at most this many dead letters, oldest first; 0 for no limit")
+}
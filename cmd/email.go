@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// emailCmd represents the email command
+var emailCmd = &cobra.Command{
+	Use:   "email",
+	Short: "email digests of events",
+	Long: `Email the events matching email.where to email.to, as a digest every
+email.interval, or at once for events matching email.immediate`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.EmailWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(emailCmd)
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// forwardCmd represents the forward command
+var forwardCmd = &cobra.Command{
+	Use:   "forward",
+	Short: "forward log data to another translog, over gRPC",
+	Long: `Send batches of events to the forward input (input.type = "forward") of
+the translog at forward.address, over gRPC, retrying each batch until it is
+acknowledged`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.ForwardWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(forwardCmd)
+}
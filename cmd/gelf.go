@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// gelfCmd represents the gelf command
+var gelfCmd = &cobra.Command{
+	Use:   "gelf",
+	Short: "send log data to Graylog, as GELF",
+	Long:  `Send log data as GELF messages to gelf.address, over UDP or TCP (gelf.protocol)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.GELFWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gelfCmd)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/worker"
+)
+
+var generatePreset string
+var generateRate float64
+var generateDuration time.Duration
+var generateCount int64
+var generateOutput string
+var generateSeed int64
+
+// generateCmd represents the generate command
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "generate synthetic log lines",
+	Long: `Generate realistic synthetic log lines from a preset (` + strings.Join(worker.GeneratePresets(), ", ") + `),
+at --rate lines per second, for --duration or until --count lines have been
+written (forever, if neither is given), to standard output or appended to
+--output. Use it to load-test the pipeline and its outputs without production
+data.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := worker.GenerateLine(generatePreset, rand.New(rand.NewSource(0)), time.Now()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out := os.Stdout
+		if generateOutput != "" && generateOutput != "-" {
+			f, err := os.OpenFile(generateOutput, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to open output file: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := generate(bufio.NewWriter(out)); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// generate writes lines at the configured rate, flushing them every tick
+// so that a tailing reader sees them as they're written
+func generate(out *bufio.Writer) error {
+	seed := generateSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	start := time.Now()
+	var written int64
+	for now := start; ; now = <-ticker.C {
+		elapsed := now.Sub(start)
+		if generateDuration > 0 && elapsed >= generateDuration {
+			break
+		}
+		due := int64(elapsed.Seconds()*generateRate) + 1
+		if generateRate <= 0 {
+			due = written + 10000 // as fast as possible
+		}
+		for ; written < due; written++ {
+			if generateCount > 0 && written >= generateCount {
+				return out.Flush()
+			}
+			line, _ := worker.GenerateLine(generatePreset, r, now)
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func init() {
+	RootCmd.AddCommand(generateCmd)
+	generateCmd.Flags().StringVarP(&generatePreset, "preset", "p", "nginx", "kind of log lines: "+strings.Join(worker.GeneratePresets(), ", "))
+	generateCmd.Flags().Float64VarP(&generateRate, "rate", "r", 100, "lines per second; 0 for as fast as possible")
+	generateCmd.Flags().DurationVarP(&generateDuration, "duration", "d", 0, "how long to generate lines; 0 for no limit")
+	generateCmd.Flags().Int64VarP(&generateCount, "count", "n", 0, "number of lines to generate; 0 for no limit")
+	generateCmd.Flags().StringVarP(&generateOutput, "output", "o", "", "file to append lines to; standard output by default")
+	generateCmd.Flags().Int64Var(&generateSeed, "seed", 0, "random seed, for reproducible lines; random by default")
+}
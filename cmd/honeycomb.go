@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// honeycombCmd represents the honeycomb command
+var honeycombCmd = &cobra.Command{
+	Use:   "honeycomb",
+	Short: "send log data to Honeycomb",
+	Long: `Send events, as wide events, to the Honeycomb dataset honeycomb.dataset,
+passing the sample rate of events sampled upstream`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.HoneycombWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(honeycombCmd)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/importer"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "convert other log shippers' configurations",
+	Long: `Convert the configuration of another log shipper into a translog
+pipeline configuration, written as YAML to stdout. Anything that cannot be
+converted is listed as a warning at the top of the output.`,
+}
+
+// importLogstashCmd represents the import logstash command
+var importLogstashCmd = &cobra.Command{
+	Use:   "logstash pipeline.conf",
+	Short: "convert a Logstash pipeline configuration",
+	Long: `Convert a Logstash pipeline configuration (file input; grok, date,
+and mutate filters; elasticsearch, file, and stdout outputs) into a translog
+pipeline configuration.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		writeImport(importer.ImportLogstash(string(config)))
+	},
+}
+
+// importFilebeatCmd represents the import filebeat command
+var importFilebeatCmd = &cobra.Command{
+	Use:   "filebeat filebeat.yml",
+	Short: "convert a Filebeat configuration",
+	Long: `Convert a Filebeat configuration (log and filestream inputs; dissect and
+drop_fields processors; elasticsearch, file, and console outputs) into a
+translog pipeline configuration.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		writeImport(importer.ImportFilebeat(string(config)))
+	},
+}
+
+var importParsersFiles []string
+
+// importFluentBitCmd represents the import fluentbit command
+var importFluentBitCmd = &cobra.Command{
+	Use:   "fluentbit fluent-bit.conf",
+	Short: "convert a Fluent Bit configuration",
+	Long: `Convert a Fluent Bit configuration (tail input with a regex parser; es,
+file, and stdout outputs) into a translog pipeline configuration. Parsers
+defined in separate files are read with --parsers.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		files := make([]string, 0, len(importParsersFiles)+1)
+		for _, name := range append(args, importParsersFiles...) {
+			bs, err := ioutil.ReadFile(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			files = append(files, string(bs))
+		}
+		writeImport(importer.ImportFluentBit(files[0], files[1:]...))
+	},
+}
+
+// writeImport writes the imported pipeline, or exits with the error
+func writeImport(r *importer.Result, err error) {
+	if err == nil {
+		err = r.WriteYAML(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(os.Stderr, "%d warnings; see the comments at the top of the output\n", len(r.Warnings))
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importLogstashCmd)
+	importCmd.AddCommand(importFilebeatCmd)
+	importCmd.AddCommand(importFluentBitCmd)
+	importFluentBitCmd.Flags().StringSliceVar(&importParsersFiles, "parsers", nil, "Fluent Bit parsers file(s)")
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// kinesisCmd represents the kinesis command
+var kinesisCmd = &cobra.Command{
+	Use:   "kinesis",
+	Short: "send log data to AWS Kinesis or Firehose",
+	Long: `Send log data to an AWS Kinesis stream (kinesis.stream) or Firehose
+delivery stream (kinesis.delivery_stream)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.KinesisWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(kinesisCmd)
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// mqttCmd represents the mqtt command
+var mqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "send log data to an MQTT broker",
+	Long:  `Publish log data to an MQTT broker (mqtt.broker), on mqtt.topic`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.MQTTWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mqttCmd)
+}
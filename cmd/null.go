@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// nullCmd represents the null command
+var nullCmd = &cobra.Command{
+	Use:   "null",
+	Short: "count and validate log data, without sending it anywhere",
+	Long: `Count events, and check that they have null.required_fields and a sane
+timestamp, without writing them anywhere: for benchmarking ingestion, and for
+trying a configuration on production traffic`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.NullWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(nullCmd)
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// opsgenieCmd represents the opsgenie command
+var opsgenieCmd = &cobra.Command{
+	Use:   "opsgenie",
+	Short: "create Opsgenie alerts for events",
+	Long: `Create Opsgenie alerts for the events matching opsgenie.where (or the
+alerts of alert rules), folding repeated events by opsgenie.alias`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.PagingWorker{Service: "opsgenie"}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(opsgenieCmd)
+}
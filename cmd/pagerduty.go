@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// pagerdutyCmd represents the pagerduty command
+var pagerdutyCmd = &cobra.Command{
+	Use:   "pagerduty",
+	Short: "trigger PagerDuty incidents for events",
+	Long: `Trigger PagerDuty incidents, through the Events API v2, for the events
+matching pagerduty.where (or the alerts of alert rules), folding repeated
+events by pagerduty.dedup_key`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.PagingWorker{Service: "pagerduty"}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pagerdutyCmd)
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+)
+
+// pipelineCmd represents the pipeline command
+var pipelineCmd = &cobra.Command{
+	Use:   "pipeline",
+	Short: "run the pipeline described by inputs, filters, and outputs",
+	Long: `Run the pipeline described by the inputs, filters, and outputs
+lists of the configuration file, sending log data to the configured output.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := run.LoadPipelineConfig()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sink := p.Sink()
+		if sink == nil {
+			fmt.Fprintln(os.Stderr, "No output configured")
+			os.Exit(1)
+		}
+		run.Run(sink)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pipelineCmd)
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// pulsarCmd represents the pulsar command
+var pulsarCmd = &cobra.Command{
+	Use:   "pulsar",
+	Short: "produce log data to Apache Pulsar",
+	Long: `Produce events, as JSON messages keyed by pulsar.key, in batches to the
+Pulsar topic pulsar.topic, which may depend on the event`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.PulsarWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pulsarCmd)
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var replSampleCount int
+
+const replHelp = `Commands:
+  pattern <regex>   set the parse pattern
+  time <layout>     add a time pattern (Go layout, e.g. 2006-01-02 15:04:05)
+  times             list the time patterns
+  clear times       remove all time patterns
+  add <line>        add a sample line
+  lines             list the sample lines
+  show              parse the sample lines again
+  help              show this help
+  quit              exit
+`
+
+// replCmd represents the repl command
+var replCmd = &cobra.Command{
+	Use:   "repl [sample-file]",
+	Short: "interactively develop a parse pattern",
+	Long: `Load sample lines (from sample-file, or from parse.input_file) and
+iteratively edit the parse pattern and time patterns, showing the matched
+fields and their types after each change.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sampleFile := viper.GetString("parse.input_file")
+		if len(args) > 0 {
+			sampleFile = args[0]
+		}
+		var lines []string
+		if sampleFile != "" {
+			var err error
+			lines, err = readSampleLines(sampleFile, replSampleCount)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to read sample lines: %v\n", err)
+			}
+		}
+		repl(os.Stdin, os.Stdout, lines)
+	},
+}
+
+// readSampleLines reads up to n lines from the file
+func readSampleLines(fileName string, n int) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := make([]string, 0, n)
+	scanner := bufio.NewScanner(f)
+	for len(lines) < n && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// showParse parses each line, and writes the fields found with their types
+func showParse(out io.Writer, parser *worker.LogParser, lines []string) {
+	fmt.Fprintf(out, "pattern: %s\n", parser.CachedRegex())
+	for i, line := range lines {
+		fmt.Fprintf(out, "\n[%d] %s\n", i+1, line)
+		v, err := parser.ParseEvents(line)
+		if err != nil {
+			fmt.Fprintf(out, "    (no match)\n")
+			if d, err := worker.LongestMatchingPrefix(parser.CachedRegex().String(), line); err == nil && d.NextPattern != "" {
+				fmt.Fprintf(out, "    matched %q with `%s`, then `%s` failed at %q\n", d.Matched, d.MatchedPattern, d.NextPattern, d.Rest)
+			}
+			continue
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(out, "    %-20s %-12T %v\n", key, v[key], v[key])
+		}
+	}
+}
+
+func repl(in io.Reader, out io.Writer, lines []string) {
+	parser := &worker.LogParser{}
+	parser.Init()
+	showParse(out, parser, lines)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "\ntranslog> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		input := strings.TrimSpace(scanner.Text())
+		command, arg := input, ""
+		if i := strings.Index(input, " "); i >= 0 {
+			command, arg = input[:i], strings.TrimSpace(input[i+1:])
+		}
+		switch command {
+		case "":
+		case "pattern":
+			viper.Set("parse.pattern", arg)
+			showParse(out, parser, lines)
+		case "time":
+			viper.Set("parse.time_patterns", append(viper.GetStringSlice("parse.time_patterns"), arg))
+			showParse(out, parser, lines)
+		case "times":
+			for _, layout := range viper.GetStringSlice("parse.time_patterns") {
+				fmt.Fprintln(out, layout)
+			}
+		case "clear":
+			viper.Set("parse.time_patterns", []string{})
+			showParse(out, parser, lines)
+		case "add":
+			lines = append(lines, arg)
+			showParse(out, parser, lines)
+		case "lines":
+			for i, line := range lines {
+				fmt.Fprintf(out, "[%d] %s\n", i+1, line)
+			}
+		case "show":
+			showParse(out, parser, lines)
+		case "help":
+			fmt.Fprint(out, replHelp)
+		case "quit", "exit":
+			return
+		default:
+			fmt.Fprintf(out, "Unknown command %s\n%s", command, replHelp)
+		}
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(replCmd)
+
+	replCmd.Flags().IntVar(&replSampleCount, "lines", 10, "number of sample lines to load")
+}
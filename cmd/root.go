@@ -6,6 +6,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
 )
 
 var cfgFile string
@@ -40,6 +41,12 @@ func init() {
 	// will be global for your application.
 
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.translog.yaml)")
+	RootCmd.PersistentFlags().Bool("pprof", false, "serve pprof profiles and expvar variables on the admin listener")
+	viper.BindPFlag("admin.pprof", RootCmd.PersistentFlags().Lookup("pprof"))
+	RootCmd.PersistentFlags().String("trace-events", "", "trace a sample of events (e.g. 0.01), or the events matching a condition (e.g. \"status >= 500\"), through the pipeline")
+	viper.BindPFlag("trace.events", RootCmd.PersistentFlags().Lookup("trace-events"))
+	RootCmd.PersistentFlags().String("trace-file", "translog-trace.jsonl", "file traces are appended to")
+	viper.BindPFlag("trace.file", RootCmd.PersistentFlags().Lookup("trace-file"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -47,14 +54,31 @@ func initConfig() {
 	if cfgFile != "" { // enable ability to specify config file via flag
 		// fmt.Printf("Using configuration file: %v\n", cfgFile)
 		viper.SetConfigFile(cfgFile)
+	} else {
+		// setting the name would override the file given with --config
+		viper.SetConfigName(".translog") // name of config file (without extension)
+		viper.AddConfigPath("$HOME")     // adding home directory as first search path
 	}
-
-	viper.SetConfigName(".translog") // name of config file (without extension)
-	viper.AddConfigPath("$HOME")     // adding home directory as first search path
-	viper.AutomaticEnv()             // read in environment variables that match
+	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		// fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
+
+	// ${ENV_VAR} and ${file:/path} references are filled in before use
+	if err := run.InterpolateConfig(); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	// a structured pipeline is translated into the flat configuration keys
+	if run.HasPipelineConfig() {
+		p, err := run.LoadPipelineConfig()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		p.Apply()
+	}
 }
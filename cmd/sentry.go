@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// sentryCmd represents the sentry command
+var sentryCmd = &cobra.Command{
+	Use:   "sentry",
+	Short: "send error events to Sentry",
+	Long: `Send the events matching sentry.where to the Sentry project of sentry.dsn,
+with their message, stack trace, and the sentry.tags fields as tags`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.SentryWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sentryCmd)
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// snowflakeCmd represents the snowflake command
+var snowflakeCmd = &cobra.Command{
+	Use:   "snowflake",
+	Short: "load log data into Snowflake",
+	Long: `Stage events as gzipped JSON lines in an S3 (or Google Cloud Storage)
+bucket, and load them into the Snowflake table snowflake.table with
+COPY INTO every snowflake.copy_interval`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.SnowflakeWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(snowflakeCmd)
+}
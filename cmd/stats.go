@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/worker"
+)
+
+var statsFields []string
+var statsTop int
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats [log-file]",
+	Short: "summarize a log file",
+	Long: `Parse a whole log file (or standard input) with the configured pattern,
+without shipping anything, and print a summary: the number of lines, how many
+matched, the types each field was parsed as, the time range of the events,
+and the top values of the fields given with --field. Use it to check a
+pattern before setting up continuous shipping.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var in io.Reader = os.Stdin
+		if len(args) > 0 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to open log file: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			in = f
+		}
+		w := &worker.LogParser{}
+		w.Init()
+		summary, err := w.Summarize(in, statsFields, statsTop)
+		fmt.Print(summary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read the whole log file: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().StringSliceVarP(&statsFields, "field", "f", nil, "field whose top values are shown (may be repeated)")
+	statsCmd.Flags().IntVar(&statsTop, "top", 10, "number of top values shown for each field")
+}
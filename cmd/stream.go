@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// streamCmd represents the stream command
+var streamCmd = &cobra.Command{
+	Use:   "stream",
+	Short: "serve log data as a live stream",
+	Long: `Serve log data on stream.address, as Server-Sent Events (/events) or
+WebSocket messages (/ws), for browser dashboards`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.StreamWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(streamCmd)
+}
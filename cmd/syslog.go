@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// syslogCmd represents the syslog command
+var syslogCmd = &cobra.Command{
+	Use:   "syslog",
+	Short: "forward log data as syslog messages",
+	Long:  `Forward log data as RFC 5424 syslog messages to syslog.address, over TCP, TLS, or RELP (syslog.protocol)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.SyslogWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(syslogCmd)
+}
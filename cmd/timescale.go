@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// timescaleCmd represents the timescale command
+var timescaleCmd = &cobra.Command{
+	Use:   "timescale",
+	Short: "insert log data into TimescaleDB",
+	Long: `Insert events, in batches, into the TimescaleDB hypertable
+timescale.table, creating it, keyed on the event timestamp, if it doesn't
+exist`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.TimescaleWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(timescaleCmd)
+}
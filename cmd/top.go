@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
+)
+
+var topAddress string
+var topInterval time.Duration
+
+// topCmd represents the top command
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "show live statistics of a running translog",
+	Long: `Show live statistics of a running translog (events/sec, parse
+failures, pending events, and output latency), read from its admin API.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		address := topAddress
+		if address == "" {
+			address = viper.GetString("admin.address")
+		}
+		if address == "" {
+			fmt.Fprintln(os.Stderr, "No admin address; use --address or set admin.address")
+			os.Exit(1)
+		}
+		client := run.NewAdminClient(address)
+		var last *run.Stats
+		for {
+			stats, err := client.Stats()
+			fmt.Print("\033[H\033[2J") // home and clear screen
+			if err != nil {
+				fmt.Printf("translog top - %s\n\n%v\n", address, err)
+			} else {
+				fmt.Print(formatTop(address, stats, last))
+				last = &stats
+			}
+			time.Sleep(topInterval)
+		}
+	},
+}
+
+// formatTop formats the statistics for display
+func formatTop(address string, stats run.Stats, last *run.Stats) string {
+	var b strings.Builder
+	state := "running"
+	if stats.Paused {
+		state = "paused"
+	}
+	fmt.Fprintf(&b, "translog top - %s - up %s - %s\n\n", address, time.Duration(stats.Uptime)*time.Second, state)
+	rate := "-"
+	if last != nil && stats.Uptime > last.Uptime {
+		rate = fmt.Sprintf("%.1f", float64(stats.LinesRead-last.LinesRead)/(stats.Uptime-last.Uptime))
+	}
+	parsed, unmatched := stats.Counters["lines_parsed"], stats.Counters["lines_unmatched"]
+	failureRate := 0.0
+	if parsed+unmatched > 0 {
+		failureRate = 100 * float64(unmatched) / float64(parsed+unmatched)
+	}
+	fmt.Fprintf(&b, "%-24s %s\n", "events/sec", rate)
+	fmt.Fprintf(&b, "%-24s %d\n", "lines read", stats.LinesRead)
+	fmt.Fprintf(&b, "%-24s %d (%.1f%%)\n", "parse failures", unmatched, failureRate)
+	fmt.Fprintf(&b, "%-24s %d\n", "pending events", stats.Pending)
+	if stats.Health != nil {
+		health := "healthy"
+		if !stats.Health.Healthy {
+			health = "FAILING: " + stats.Health.LastError
+		}
+		fmt.Fprintf(&b, "%-24s %s\n", "output ("+stats.Sink+")", health)
+		fmt.Fprintf(&b, "%-24s %.3fs\n", "output latency", stats.Health.LastLatency)
+	} else {
+		fmt.Fprintf(&b, "%-24s %s\n", "output", stats.Sink)
+	}
+	fmt.Fprintf(&b, "\n")
+	names := make([]string, 0, len(stats.Counters))
+	for name := range stats.Counters {
+		if name != "lines_parsed" && name != "lines_unmatched" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%-24s %d\n", name, stats.Counters[name])
+	}
+	return b.String()
+}
+
+func init() {
+	RootCmd.AddCommand(topCmd)
+
+	topCmd.Flags().StringVar(&topAddress, "address", "", "admin address of the running translog (default is admin.address)")
+	topCmd.Flags().DurationVar(&topInterval, "interval", time.Second, "refresh interval")
+}
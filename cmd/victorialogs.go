@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// victorialogsCmd represents the victorialogs command
+var victorialogsCmd = &cobra.Command{
+	Use:   "victorialogs",
+	Short: "send log data to VictoriaLogs",
+	Long: `Send events, as JSON lines with _msg and _time fields, to VictoriaLogs,
+in the log streams selected by victorialogs.stream_fields`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.VictoriaLogsWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(victorialogsCmd)
+}
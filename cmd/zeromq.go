@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+// zeromqCmd represents the zeromq command
+var zeromqCmd = &cobra.Command{
+	Use:   "zeromq",
+	Short: "publish log data on a ZeroMQ socket",
+	Long: `Publish events, as JSON, on a ZeroMQ PUB (or PUSH) socket bound to
+zeromq.address, for local processes to subscribe to, by the value of
+zeromq.topic_field`,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := &worker.ZeroMQWorker{}
+		run.Run(w)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(zeromqCmd)
+}
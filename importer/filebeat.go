@@ -0,0 +1,154 @@
+package importer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+	"gopkg.in/yaml.v2"
+)
+
+var dissectKeyRegex = regexp.MustCompile(`%\{([^}]*)\}`)
+
+// DissectToRegex converts a dissect tokenizer (e.g. "%{ip} - %{user}
+// [%{ts}]") into a regular expression. Skipped keys (%{} and %{?key}) are
+// not captured; modifiers such as -> and + are not supported.
+func DissectToRegex(tokenizer string) (string, error) {
+	var regex strings.Builder
+	regex.WriteString("^")
+	matches := dissectKeyRegex.FindAllStringSubmatchIndex(tokenizer, -1)
+	last := 0
+	for i, match := range matches {
+		regex.WriteString(regexp.QuoteMeta(tokenizer[last:match[0]]))
+		key := tokenizer[match[2]:match[3]]
+		capture := ".*?"
+		if i == len(matches)-1 && match[1] == len(tokenizer) {
+			capture = ".*"
+		}
+		switch {
+		case key == "" || strings.HasPrefix(key, "?"):
+			regex.WriteString("(?:" + capture + ")")
+		case strings.ContainsAny(key, "+&*") || strings.HasSuffix(key, "->"):
+			return "", fmt.Errorf("dissect modifiers are not supported: %%{%s}", key)
+		default:
+			regex.WriteString("(?P<" + worker.GrokFieldName(key) + ">" + capture + ")")
+		}
+		last = match[1]
+	}
+	regex.WriteString(regexp.QuoteMeta(tokenizer[last:]))
+	regex.WriteString("$")
+	_, err := regexp.Compile(regex.String())
+	return regex.String(), err
+}
+
+// ImportFilebeat converts a Filebeat configuration (log and filestream
+// inputs; dissect and drop_fields processors; elasticsearch, file and
+// console outputs)
+func ImportFilebeat(config string) (*Result, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal([]byte(config), &raw); err != nil {
+		return nil, err
+	}
+	m := normalizeYAML(raw).(map[string]interface{})
+	r := &Result{}
+	inputs, _ := lookupPath(m, "filebeat.inputs").([]interface{})
+	if len(inputs) == 0 {
+		r.warn("no filebeat.inputs found")
+	}
+	processors, _ := lookupPath(m, "processors").([]interface{})
+	for i, value := range inputs {
+		input, _ := value.(map[string]interface{})
+		if i > 0 {
+			r.warn("only one input is supported; ignoring input %d", i+1)
+			continue
+		}
+		if inputType := fmt.Sprint(input["type"]); inputType != "log" && inputType != "filestream" {
+			r.warn("input type %s is not supported", inputType)
+			continue
+		}
+		r.input().Path = r.asString("input", "paths", input["paths"])
+		if encoding, ok := input["encoding"].(string); ok && encoding != "plain" {
+			r.input().Charset = encoding
+		}
+		r.warnIgnoredSettings("input "+fmt.Sprint(input["type"]), input, "type", "paths", "encoding", "processors", "enabled", "id")
+		if inputProcessors, ok := input["processors"].([]interface{}); ok {
+			processors = append(inputProcessors, processors...)
+		}
+	}
+	for _, value := range processors {
+		processor, _ := value.(map[string]interface{})
+		for name, settings := range processor {
+			r.importFilebeatProcessor(name, settings)
+		}
+	}
+	outputs := 0
+	for _, name := range []string{"elasticsearch", "file", "console", "logstash", "kafka", "redis"} {
+		settings, ok := lookupPath(m, "output."+name).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		outputs++
+		switch name {
+		case "elasticsearch":
+			r.importFilebeatElasticsearch(settings)
+		case "file":
+			r.warnIgnoredSettings("output file", settings, "path", "filename")
+			path := fmt.Sprint(settings["path"])
+			if filename, ok := settings["filename"]; ok {
+				path = strings.TrimSuffix(path, "/") + "/" + fmt.Sprint(filename)
+			}
+			r.addOutput(run.OutputConfig{Type: "file", Path: path})
+		case "console":
+			r.addOutput(run.OutputConfig{Type: "stdout"})
+		default:
+			r.warn("output %s is not supported", name)
+		}
+	}
+	if outputs == 0 {
+		r.warn("no supported output found")
+	}
+	return r, nil
+}
+
+func (r *Result) importFilebeatProcessor(name string, value interface{}) {
+	settings, _ := value.(map[string]interface{})
+	switch name {
+	case "dissect":
+		if field, ok := settings["field"]; ok && field != "message" {
+			r.warn("processor dissect: dissects field %v; translog matches the whole line", field)
+		}
+		tokenizer := fmt.Sprint(settings["tokenizer"])
+		regex, err := DissectToRegex(tokenizer)
+		if err != nil {
+			r.warn("processor dissect: unable to convert %s: %v", tokenizer, err)
+			return
+		}
+		if r.input().Pattern != "" {
+			r.warn("processor dissect: only one pattern is supported; ignoring %s", tokenizer)
+			return
+		}
+		r.input().Pattern = regex
+		r.warnIgnoredSettings("processor dissect", settings, "tokenizer", "field")
+	case "drop_fields":
+		input := r.input()
+		input.KeysToIgnore = append(input.KeysToIgnore, asStrings(settings["fields"])...)
+		r.warnIgnoredSettings("processor drop_fields", settings, "fields", "ignore_missing")
+	default:
+		r.warn("processor %s is not supported", name)
+	}
+}
+
+func (r *Result) importFilebeatElasticsearch(settings map[string]interface{}) {
+	// Filebeat's hosts are URLs or host:port, just like Logstash's
+	plugin := LogstashPlugin{Section: "output", Name: "elasticsearch", Settings: make(map[string]interface{})}
+	for key, value := range settings {
+		if index, ok := value.(string); ok && key == "index" {
+			// Filebeat writes the date as %{+yyyy.MM.dd}, Logstash as %{+YYYY.MM.dd}
+			value = strings.Replace(index, "%{+yyyy.MM.dd}", "%{+YYYY.MM.dd}", 1)
+		}
+		plugin.Settings[key] = value
+	}
+	r.importLogstashElasticsearch(plugin)
+}
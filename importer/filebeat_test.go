@@ -0,0 +1,139 @@
+package importer_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/willf/translog/importer"
+)
+
+var filebeatConfig = `
+filebeat.inputs:
+- type: log
+  paths:
+    - /var/log/app/*.log
+  multiline.pattern: '^\['
+  processors:
+    - dissect:
+        tokenizer: "%{ip} - %{?ident} [%{time}] %{message}"
+        field: message
+processors:
+  - drop_fields:
+      fields: ["host", "agent"]
+  - add_host_metadata: ~
+output.elasticsearch:
+  hosts: ["https://es1:9243"]
+  index: "app-%{+yyyy.MM.dd}"
+`
+
+func TestImportFilebeat(t *testing.T) {
+	r, err := importer.ImportFilebeat(filebeatConfig)
+	if err != nil {
+		t.Fatalf("expected the configuration to be imported, got %v", err)
+	}
+	if len(r.Pipeline.Inputs) != 1 || r.Pipeline.Inputs[0].Path != "/var/log/app/*.log" {
+		t.Fatalf("expected a file input, got %v", r.Pipeline.Inputs)
+	}
+	input := r.Pipeline.Inputs[0]
+	regex := regexp.MustCompile(input.Pattern)
+	match := regex.FindStringSubmatch("10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] GET /x")
+	if match == nil || match[regex.SubexpIndex("ip")] != "10.0.0.1" || match[regex.SubexpIndex("message")] != "GET /x" {
+		t.Errorf("expected pattern %s to capture ip and message, got %v", input.Pattern, match)
+	}
+	if strings.Join(input.KeysToIgnore, ",") != "host,agent" {
+		t.Errorf("expected dropped fields to be ignored, got %v", input.KeysToIgnore)
+	}
+	output := r.Pipeline.Outputs[0]
+	if output.Type != "elasticsearch" || output.Scheme != "https" || output.Index != "app-" || !*output.UseDateSuffix {
+		t.Errorf("expected the elasticsearch output to be converted, got %+v", output)
+	}
+	warnings := strings.Join(r.Warnings, "\n")
+	for _, expected := range []string{"multiline.pattern", "add_host_metadata"} {
+		if !strings.Contains(warnings, expected) {
+			t.Errorf("expected a warning about %s, got %v", expected, warnings)
+		}
+	}
+}
+
+var fluentBitConfig = `
+[SERVICE]
+    Flush 5
+
+[INPUT]
+    Name           tail
+    Path           /var/log/nginx/access.log
+    Parser         nginx
+    Read_from_Head On
+    Mem_Buf_Limit  5MB
+
+[FILTER]
+    Name  grep
+    Match *
+
+[OUTPUT]
+    Name            es
+    Match           *
+    Host            es.local
+    Port            9200
+    Logstash_Format On
+    Logstash_Prefix nginx
+`
+
+var fluentBitParsers = `
+[PARSER]
+    Name        nginx
+    Format      regex
+    Regex       ^(?<remote>[^ ]*) - (?<user>[^ ]*) \[(?<time>[^\]]*)\] "(?<method>\S+) (?<path>[^"]*)" (?<code>[^ ]*)
+    Time_Key    time
+    Time_Format %d/%b/%Y:%H:%M:%S %z
+`
+
+func TestImportFluentBit(t *testing.T) {
+	r, err := importer.ImportFluentBit(fluentBitConfig, fluentBitParsers)
+	if err != nil {
+		t.Fatalf("expected the configuration to be imported, got %v", err)
+	}
+	if len(r.Pipeline.Inputs) != 1 || r.Pipeline.Inputs[0].Path != "/var/log/nginx/access.log" || !*r.Pipeline.Inputs[0].FromBeginning {
+		t.Fatalf("expected a file input, got %v", r.Pipeline.Inputs)
+	}
+	input := r.Pipeline.Inputs[0]
+	regex := regexp.MustCompile(input.Pattern)
+	match := regex.FindStringSubmatch(`1.2.3.4 - - [10/Oct/2000:13:55:36 -0700] "GET /x" 200`)
+	if match == nil || match[regex.SubexpIndex("code")] != "200" {
+		t.Errorf("expected pattern %s to capture code, got %v", input.Pattern, match)
+	}
+	if len(input.TimePatterns) != 1 || input.TimePatterns[0] != "02/Jan/2006:15:04:05 -0700" {
+		t.Errorf("expected the time format to be converted, got %v", input.TimePatterns)
+	}
+	output := r.Pipeline.Outputs[0]
+	if output.Type != "elasticsearch" || output.Hosts[0] != "es.local" || output.Index != "nginx-" || !*output.UseDateSuffix {
+		t.Errorf("expected the es output to be converted, got %+v", output)
+	}
+	warnings := strings.Join(r.Warnings, "\n")
+	for _, expected := range []string{"mem_buf_limit", "filter grep"} {
+		if !strings.Contains(warnings, expected) {
+			t.Errorf("expected a warning about %s, got %v", expected, warnings)
+		}
+	}
+}
+
+var strftimeTestCases = []struct {
+	format     string
+	expected   string
+	should_err bool
+}{
+	{"%d/%b/%Y:%H:%M:%S %z", "02/Jan/2006:15:04:05 -0700", false},
+	{"%Y-%m-%dT%H:%M:%S.%L", "2006-01-02T15:04:05.000", false},
+	{"%s", "", true},
+	{"100%", "", true},
+}
+
+func TestStrftimeToLayout(t *testing.T) {
+	for i, tt := range strftimeTestCases {
+		actual, err := importer.StrftimeToLayout(tt.format)
+		if (err != nil) != tt.should_err || actual != tt.expected {
+			t.Errorf("In test %d, StrftimeToLayout(%v): expected %v, actual %v (error: %v)", i+1, tt.format, tt.expected, actual, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/willf/translog/run"
+)
+
+// FluentBitSection is a section of a Fluent Bit configuration, e.g.
+// [INPUT]; keys are lower case, as Fluent Bit's keys are case insensitive
+type FluentBitSection struct {
+	Name     string
+	Settings map[string]string
+}
+
+// ParseFluentBitConfig parses Fluent Bit's classic configuration format
+// (which is also used for parser files). @INCLUDE and @SET are not supported.
+func ParseFluentBitConfig(config string) ([]FluentBitSection, error) {
+	sections := make([]FluentBitSection, 0)
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "@"):
+			return nil, fmt.Errorf("line %d: %s is not supported", lineNumber, strings.Fields(line)[0])
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.ToUpper(strings.TrimSpace(line[1 : len(line)-1]))
+			sections = append(sections, FluentBitSection{Name: name, Settings: make(map[string]string)})
+		case len(sections) == 0:
+			return nil, fmt.Errorf("line %d: setting outside of a section", lineNumber)
+		default:
+			fields := strings.Fields(line)
+			value := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+			sections[len(sections)-1].Settings[strings.ToLower(fields[0])] = value
+		}
+	}
+	return sections, scanner.Err()
+}
+
+// onigmoGroupRegex matches Onigmo (Ruby) style named groups, (?<name>
+var onigmoGroupRegex = regexp.MustCompile(`\(\?<([A-Za-z_][A-Za-z0-9_]*)>`)
+
+// ImportFluentBit converts a Fluent Bit configuration (tail input with a
+// regex parser; es, file, and stdout outputs). Parsers may be in the
+// configuration itself or in separate parsers files.
+func ImportFluentBit(config string, parsersFiles ...string) (*Result, error) {
+	sections, err := ParseFluentBitConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	parsers := make(map[string]FluentBitSection)
+	for _, parsersFile := range parsersFiles {
+		more, err := ParseFluentBitConfig(parsersFile)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, more...)
+	}
+	for _, section := range sections {
+		if section.Name == "PARSER" {
+			parsers[section.Settings["name"]] = section
+		}
+	}
+	r := &Result{}
+	for _, section := range sections {
+		name := strings.ToLower(section.Settings["name"])
+		switch section.Name {
+		case "SERVICE", "PARSER":
+		case "INPUT":
+			if name != "tail" {
+				r.warn("input %s is not supported", name)
+				continue
+			}
+			r.importFluentBitTail(section, parsers)
+		case "FILTER":
+			r.warn("filter %s is not supported", name)
+		case "OUTPUT":
+			r.importFluentBitOutput(section)
+		default:
+			r.warn("section [%s] is not supported", section.Name)
+		}
+	}
+	if len(r.Pipeline.Inputs) == 0 {
+		r.warn("no tail input found")
+	}
+	if len(r.Pipeline.Outputs) == 0 {
+		r.warn("no supported output found")
+	}
+	return r, nil
+}
+
+// fluentBitSettings returns the settings of a section as a map, for
+// warnIgnoredSettings
+func fluentBitSettings(section FluentBitSection) map[string]interface{} {
+	settings := make(map[string]interface{}, len(section.Settings))
+	for key, value := range section.Settings {
+		settings[key] = value
+	}
+	return settings
+}
+
+// fluentBitBool interprets a Fluent Bit boolean (on/off, true/false, yes/no)
+func fluentBitBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes":
+		return true
+	}
+	return false
+}
+
+func (r *Result) importFluentBitTail(section FluentBitSection, parsers map[string]FluentBitSection) {
+	if len(r.Pipeline.Inputs) > 0 {
+		r.warn("only one input is supported; ignoring tail input %s", section.Settings["path"])
+		return
+	}
+	input := r.input()
+	paths := strings.Split(section.Settings["path"], ",")
+	if len(paths) > 1 {
+		r.warn("input tail: only the first of %d values of path is used", len(paths))
+	}
+	input.Path = strings.TrimSpace(paths[0])
+	if fluentBitBool(section.Settings["read_from_head"]) {
+		fromBeginning := true
+		input.FromBeginning = &fromBeginning
+	}
+	r.warnIgnoredSettings("input tail", fluentBitSettings(section), "name", "path", "read_from_head", "parser", "tag")
+	if parserName, found := section.Settings["parser"]; found {
+		parser, found := parsers[parserName]
+		if !found {
+			r.warn("input tail: parser %s not found", parserName)
+			return
+		}
+		r.importFluentBitParser(parser)
+	}
+}
+
+func (r *Result) importFluentBitParser(parser FluentBitSection) {
+	name := "parser " + parser.Settings["name"]
+	if format := parser.Settings["format"]; format != "regex" {
+		r.warn("%s: format %s is not supported", name, format)
+		return
+	}
+	regex := onigmoGroupRegex.ReplaceAllString(parser.Settings["regex"], "(?P<$1>")
+	if _, err := regexp.Compile(regex); err != nil {
+		r.warn("%s: unable to convert %s: %v", name, parser.Settings["regex"], err)
+		return
+	}
+	input := r.input()
+	input.Pattern = regex
+	if format, found := parser.Settings["time_format"]; found {
+		layout, err := StrftimeToLayout(format)
+		if err != nil {
+			r.warn("%s: %v", name, err)
+		} else {
+			input.TimePatterns = append(input.TimePatterns, layout)
+		}
+	}
+	// translog recognizes times in any field, so time_key needs no conversion
+	r.warnIgnoredSettings(name, fluentBitSettings(parser), "name", "format", "regex", "time_format", "time_key")
+}
+
+func (r *Result) importFluentBitOutput(section FluentBitSection) {
+	settings := fluentBitSettings(section)
+	switch name := strings.ToLower(section.Settings["name"]); name {
+	case "es":
+		output := run.OutputConfig{Type: "elasticsearch", Hosts: []string{"127.0.0.1"}}
+		if host, found := section.Settings["host"]; found {
+			output.Hosts = []string{host}
+		}
+		if port, found := section.Settings["port"]; found {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				r.warn("output es: invalid port %s", port)
+			} else {
+				output.Port = &p
+			}
+		}
+		if fluentBitBool(section.Settings["tls"]) {
+			output.Scheme = "https"
+		}
+		output.Index = section.Settings["index"]
+		if fluentBitBool(section.Settings["logstash_format"]) {
+			output.Index = "logstash-"
+			if prefix, found := section.Settings["logstash_prefix"]; found {
+				output.Index = prefix + "-"
+			}
+			useDateSuffix := true
+			output.UseDateSuffix = &useDateSuffix
+		}
+		output.DocumentType = section.Settings["type"]
+		r.addOutput(output)
+		r.warnIgnoredSettings("output es", settings, "name", "match", "host", "port", "tls", "index", "logstash_format", "logstash_prefix", "type")
+	case "file":
+		path := section.Settings["path"]
+		if file, found := section.Settings["file"]; found {
+			path = strings.TrimSuffix(path, "/") + "/" + file
+		}
+		r.addOutput(run.OutputConfig{Type: "file", Path: path})
+		r.warnIgnoredSettings("output file", settings, "name", "match", "path", "file")
+	case "stdout":
+		r.addOutput(run.OutputConfig{Type: "stdout"})
+		r.warnIgnoredSettings("output stdout", settings, "name", "match")
+	default:
+		r.warn("output %s is not supported", name)
+	}
+}
@@ -0,0 +1,26 @@
+package importer
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/willf/translog/worker"
+)
+
+// GrokToRegex expands a grok expression into a regular expression with
+// named capture groups, using the grok patterns built into translog. Fields
+// captured more than once are renamed, since Go does not allow duplicate
+// group names; warnings describe such changes.
+func GrokToRegex(grok string) (regex string, warnings []string, err error) {
+	regex, renamed, err := worker.ExpandGrokFields(grok, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, r := range renamed {
+		if name := worker.GrokFieldName(r.Field); name != r.Name {
+			warnings = append(warnings, fmt.Sprintf("field %s is captured more than once; renamed to %s", name, r.Name))
+		}
+	}
+	_, err = regexp.Compile(regex)
+	return
+}
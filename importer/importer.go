@@ -0,0 +1,149 @@
+// Package importer converts the configuration of other log shippers into
+// translog pipeline configurations.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/willf/translog/run"
+	"gopkg.in/yaml.v2"
+)
+
+// Result is an imported pipeline, and warnings about anything that could
+// not be converted
+type Result struct {
+	Pipeline run.PipelineConfig
+	Warnings []string
+}
+
+func (r *Result) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// input returns the pipeline's (single) input, creating it if necessary
+func (r *Result) input() *run.FileInputConfig {
+	if len(r.Pipeline.Inputs) == 0 {
+		r.Pipeline.Inputs = append(r.Pipeline.Inputs, run.FileInputConfig{Type: "file"})
+	}
+	return &r.Pipeline.Inputs[0]
+}
+
+// addOutput adds an output, unless there already is one (translog supports
+// only one output)
+func (r *Result) addOutput(output run.OutputConfig) {
+	if len(r.Pipeline.Outputs) > 0 {
+		r.warn("only one output is supported; ignoring %s output", output.Type)
+		return
+	}
+	r.Pipeline.Outputs = append(r.Pipeline.Outputs, output)
+}
+
+// WriteYAML writes the pipeline as YAML, preceded by the warnings as
+// comments
+func (r *Result) WriteYAML(out io.Writer) error {
+	for _, warning := range r.Warnings {
+		if _, err := fmt.Fprintf(out, "# WARNING: %s\n", warning); err != nil {
+			return err
+		}
+	}
+	bs, err := yaml.Marshal(r.Pipeline)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(bs)
+	return err
+}
+
+// warnIgnoredSettings warns about the settings that are not converted
+func (r *Result) warnIgnoredSettings(name string, settings map[string]interface{}, converted ...string) {
+	ignored := make([]string, 0)
+	for key := range settings {
+		found := false
+		for _, c := range converted {
+			found = found || key == c
+		}
+		if !found {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+	for _, key := range ignored {
+		r.warn("%s: setting %s is not supported", name, key)
+	}
+}
+
+// asString returns the value as a string, or the first element of a list
+// (with a warning if there are more)
+func (r *Result) asString(plugin string, setting string, value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 1 {
+			r.warn("%s: only the first of %d values of %s is used", plugin, len(v), setting)
+		}
+		if len(v) > 0 {
+			return fmt.Sprint(v[0])
+		}
+	}
+	return ""
+}
+
+// asStrings returns the value as a list of strings
+func asStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = fmt.Sprint(item)
+		}
+		return values
+	}
+	return nil
+}
+
+// normalizeYAML converts the map[interface{}]interface{} values produced by
+// the YAML decoder into map[string]interface{}
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return m
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeYAML(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+	}
+	return value
+}
+
+// lookupPath finds the value at a dotted path in nested maps; like
+// Filebeat, it allows any part of the path to be written as one dotted key
+// (output.elasticsearch.hosts, or output: {elasticsearch.hosts: ...}, ...)
+func lookupPath(m map[string]interface{}, path string) interface{} {
+	if value, found := m[path]; found {
+		return value
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+		if inner, ok := m[path[:i]].(map[string]interface{}); ok {
+			if value := lookupPath(inner, path[i+1:]); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
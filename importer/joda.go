@@ -0,0 +1,65 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jodaTokens maps Joda-Time (Logstash date filter) tokens to Go layout
+// elements; longer tokens come first, so they are matched first
+var jodaTokens = []struct {
+	joda   string
+	layout string
+}{
+	{"yyyy", "2006"}, {"yy", "06"},
+	{"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"}, {"M", "1"},
+	{"dd", "02"}, {"d", "2"},
+	{"EEEE", "Monday"}, {"EEE", "Mon"},
+	{"HH", "15"}, {"hh", "03"}, {"h", "3"},
+	{"mm", "04"}, {"m", "4"},
+	{"ss", "05"}, {"s", "5"},
+	{"SSSSSSSSS", "000000000"}, {"SSSSSS", "000000"}, {"SSS", "000"},
+	{"a", "PM"},
+	{"ZZZ", "MST"}, {"ZZ", "-07:00"}, {"Z", "-0700"}, {"z", "MST"},
+}
+
+// JodaToLayout converts a Joda-Time date pattern, as used by the Logstash
+// date filter, into a Go time layout
+func JodaToLayout(pattern string) (string, error) {
+	switch pattern {
+	case "ISO8601":
+		return time.RFC3339Nano, nil
+	case "UNIX", "UNIX_MS", "TAI64N":
+		return "", fmt.Errorf("date format %s is not supported", pattern)
+	}
+	var layout strings.Builder
+	for i := 0; i < len(pattern); {
+		if pattern[i] == '\'' { // quoted literal
+			end := strings.IndexByte(pattern[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated quote in date format %s", pattern)
+			}
+			layout.WriteString(pattern[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+		matched := false
+		for _, token := range jodaTokens {
+			if strings.HasPrefix(pattern[i:], token.joda) {
+				layout.WriteString(token.layout)
+				i += len(token.joda)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			if c := pattern[i]; (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+				return "", fmt.Errorf("unsupported token %c in date format %s", c, pattern)
+			}
+			layout.WriteByte(pattern[i])
+			i++
+		}
+	}
+	return layout.String(), nil
+}
@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/willf/translog/run"
+)
+
+// ImportLogstash converts a Logstash pipeline configuration. The common
+// plugins (file input; grok, date and mutate filters; elasticsearch, file
+// and stdout outputs) are converted; anything else results in a warning.
+func ImportLogstash(config string) (*Result, error) {
+	plugins, err := ParseLogstashConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{}
+	for _, plugin := range plugins {
+		name := plugin.Section + " " + plugin.Name
+		if plugin.Conditional {
+			r.warn("%s is inside a conditional; it is applied unconditionally", name)
+		}
+		switch name {
+		case "input file":
+			r.importLogstashFileInput(plugin)
+		case "filter grok":
+			r.importLogstashGrok(plugin)
+		case "filter date":
+			r.importLogstashDate(plugin)
+		case "filter mutate":
+			r.importLogstashMutate(plugin)
+		case "output elasticsearch":
+			r.importLogstashElasticsearch(plugin)
+		case "output file":
+			r.addOutput(run.OutputConfig{Type: "file", Path: r.asString(name, "path", plugin.Settings["path"])})
+		case "output stdout":
+			r.addOutput(run.OutputConfig{Type: "stdout"})
+		default:
+			r.warn("%s is not supported", name)
+		}
+	}
+	return r, nil
+}
+
+// warnIgnored warns about the settings of a plugin that are not converted
+func (r *Result) warnIgnored(plugin LogstashPlugin, converted ...string) {
+	r.warnIgnoredSettings(plugin.Section+" "+plugin.Name, plugin.Settings, converted...)
+}
+
+func (r *Result) importLogstashFileInput(plugin LogstashPlugin) {
+	if len(r.Pipeline.Inputs) > 0 && r.Pipeline.Inputs[0].Path != "" {
+		r.warn("only one input is supported; ignoring file input %v", plugin.Settings["path"])
+		return
+	}
+	input := r.input()
+	input.Path = r.asString("input file", "path", plugin.Settings["path"])
+	if plugin.Settings["start_position"] == "beginning" {
+		fromBeginning := true
+		input.FromBeginning = &fromBeginning
+	}
+	r.warnIgnored(plugin, "path", "start_position")
+}
+
+func (r *Result) importLogstashGrok(plugin LogstashPlugin) {
+	var field, grok string
+	switch match := plugin.Settings["match"].(type) {
+	case map[string]interface{}:
+		fields := make([]string, 0, len(match))
+		for key := range match {
+			fields = append(fields, key)
+		}
+		sort.Strings(fields)
+		if len(fields) > 0 {
+			field = fields[0]
+			grok = r.asString("filter grok", "match", match[field])
+		}
+		if len(fields) > 1 {
+			r.warn("filter grok: only the pattern for field %s is used", field)
+		}
+	case []interface{}: // the old style: match => ["message", "pattern"]
+		if len(match) >= 2 {
+			field, grok = asStrings(match)[0], asStrings(match)[1]
+		}
+	}
+	if grok == "" {
+		r.warn("filter grok: no match pattern found")
+		return
+	}
+	if field != "message" {
+		r.warn("filter grok: matches field %s; translog matches the whole line", field)
+	}
+	input := r.input()
+	if input.Pattern != "" {
+		r.warn("filter grok: only one pattern is supported; ignoring %s", grok)
+		return
+	}
+	regex, warnings, err := GrokToRegex(grok)
+	for _, warning := range warnings {
+		r.warn("filter grok: %s", warning)
+	}
+	if err != nil {
+		r.warn("filter grok: unable to convert %s: %v", grok, err)
+		return
+	}
+	input.Pattern = regex
+	r.warnIgnored(plugin, "match")
+}
+
+func (r *Result) importLogstashDate(plugin LogstashPlugin) {
+	match := asStrings(plugin.Settings["match"])
+	if len(match) < 2 {
+		r.warn("filter date: no match formats found")
+		return
+	}
+	input := r.input()
+	// the first element is the field; translog recognizes times in any field
+	for _, format := range match[1:] {
+		layout, err := JodaToLayout(format)
+		if err != nil {
+			r.warn("filter date: %v", err)
+			continue
+		}
+		input.TimePatterns = append(input.TimePatterns, layout)
+	}
+	r.warnIgnored(plugin, "match", "target")
+}
+
+func (r *Result) importLogstashMutate(plugin LogstashPlugin) {
+	if removed, found := plugin.Settings["remove_field"]; found {
+		input := r.input()
+		input.KeysToIgnore = append(input.KeysToIgnore, asStrings(removed)...)
+	}
+	r.warnIgnored(plugin, "remove_field")
+}
+
+func (r *Result) importLogstashElasticsearch(plugin LogstashPlugin) {
+	output := run.OutputConfig{Type: "elasticsearch"}
+	for i, host := range asStrings(plugin.Settings["hosts"]) {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		u, err := url.Parse(host)
+		if err != nil {
+			r.warn("output elasticsearch: invalid host %s", host)
+			continue
+		}
+		output.Hosts = append(output.Hosts, u.Hostname())
+		if i == 0 {
+			if u.Scheme != "http" {
+				output.Scheme = u.Scheme
+			}
+			if port, err := strconv.Atoi(u.Port()); err == nil {
+				output.Port = &port
+			}
+		} else if (output.Port == nil && u.Port() != "") || (output.Port != nil && u.Port() != strconv.Itoa(*output.Port)) {
+			r.warn("output elasticsearch: all hosts must use the same port; using the first host's")
+		}
+	}
+	if index, found := plugin.Settings["index"].(string); found {
+		if strings.HasSuffix(index, "%{+YYYY.MM.dd}") {
+			index = strings.TrimSuffix(index, "%{+YYYY.MM.dd}")
+			useDateSuffix := true
+			output.UseDateSuffix = &useDateSuffix
+		}
+		if strings.Contains(index, "%{") {
+			r.warn("output elasticsearch: index %s uses unsupported references", index)
+		}
+		output.Index = index
+	}
+	if documentType, found := plugin.Settings["document_type"].(string); found {
+		output.DocumentType = documentType
+	}
+	r.addOutput(output)
+	r.warnIgnored(plugin, "hosts", "index", "document_type")
+}
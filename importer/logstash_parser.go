@@ -0,0 +1,226 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A LogstashPlugin is a plugin block, e.g. grok { match => ... }, within
+// an input, filter or output section
+type LogstashPlugin struct {
+	Section     string
+	Name        string
+	Settings    map[string]interface{}
+	Conditional bool // true if the plugin is inside an if/else block
+}
+
+// logstashParser parses the Logstash configuration language. Values are
+// strings, numbers (kept as strings), []interface{} or
+// map[string]interface{}.
+type logstashParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokenizeLogstash splits a Logstash configuration into tokens; quoted
+// strings keep their quotes, so they can be told apart from barewords
+func tokenizeLogstash(config string) ([]string, error) {
+	tokens := make([]string, 0)
+	runes := []rune(config)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, string(runes[i:j+1]))
+			i = j + 1
+		case r == '=' && i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '=' || runes[i+1] == '~'):
+			tokens = append(tokens, string(runes[i:i+2]))
+			i += 2
+		case strings.ContainsRune("{}[](),", r):
+			tokens = append(tokens, string(r))
+			i++
+		case strings.ContainsRune("!<>=", r):
+			j := i + 1
+			if j < len(runes) && (runes[j] == '=' || runes[j] == '~') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("{}[](),#\"'=!<>", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *logstashParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *logstashParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *logstashParser) expect(token string) error {
+	if actual := p.next(); actual != token {
+		return fmt.Errorf("expected %q but found %q", token, actual)
+	}
+	return nil
+}
+
+// unquote removes the quotes (and escapes) from a string token
+func unquote(token string) string {
+	if len(token) >= 2 && (token[0] == '"' || token[0] == '\'') {
+		token = token[1 : len(token)-1]
+		return strings.NewReplacer(`\"`, `"`, `\'`, `'`, `\\`, `\`).Replace(token)
+	}
+	return token
+}
+
+func (p *logstashParser) parseValue() (interface{}, error) {
+	switch token := p.next(); token {
+	case "[":
+		values := make([]interface{}, 0)
+		for p.peek() != "]" {
+			if p.peek() == "" {
+				return nil, fmt.Errorf("unterminated array")
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if p.peek() == "," {
+				p.next()
+			}
+		}
+		p.next()
+		return values, nil
+	case "{":
+		hash := make(map[string]interface{})
+		for p.peek() != "}" {
+			if p.peek() == "" {
+				return nil, fmt.Errorf("unterminated hash")
+			}
+			key := unquote(p.next())
+			if err := p.expect("=>"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			hash[key] = value
+			if p.peek() == "," {
+				p.next()
+			}
+		}
+		p.next()
+		return hash, nil
+	case "", "}", "]", "=>":
+		return nil, fmt.Errorf("expected a value but found %q", token)
+	default:
+		return unquote(token), nil
+	}
+}
+
+// parsePlugins parses plugins (and conditionals) up to the closing brace
+func (p *logstashParser) parsePlugins(section string, conditional bool) ([]LogstashPlugin, error) {
+	plugins := make([]LogstashPlugin, 0)
+	for p.peek() != "}" {
+		token := p.next()
+		switch token {
+		case "":
+			return nil, fmt.Errorf("unexpected end of configuration in %s section", section)
+		case "if", "else":
+			// skip the condition
+			for p.peek() != "{" && p.peek() != "" {
+				p.next()
+			}
+			if err := p.expect("{"); err != nil {
+				return nil, err
+			}
+			inner, err := p.parsePlugins(section, true)
+			if err != nil {
+				return nil, err
+			}
+			plugins = append(plugins, inner...)
+			continue
+		}
+		plugin := LogstashPlugin{Section: section, Name: token, Settings: make(map[string]interface{}), Conditional: conditional}
+		if err := p.expect("{"); err != nil {
+			return nil, fmt.Errorf("in plugin %s: %v", token, err)
+		}
+		for p.peek() != "}" {
+			key := unquote(p.next())
+			if key == "" {
+				return nil, fmt.Errorf("unexpected end of configuration in plugin %s", plugin.Name)
+			}
+			if err := p.expect("=>"); err != nil {
+				return nil, fmt.Errorf("in plugin %s: %v", plugin.Name, err)
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, fmt.Errorf("in plugin %s, setting %s: %v", plugin.Name, key, err)
+			}
+			plugin.Settings[key] = value
+		}
+		p.next()
+		plugins = append(plugins, plugin)
+	}
+	p.next()
+	return plugins, nil
+}
+
+// ParseLogstashConfig parses a Logstash pipeline configuration into its
+// plugins, in order
+func ParseLogstashConfig(config string) ([]LogstashPlugin, error) {
+	tokens, err := tokenizeLogstash(config)
+	if err != nil {
+		return nil, err
+	}
+	p := &logstashParser{tokens: tokens}
+	plugins := make([]LogstashPlugin, 0)
+	for p.peek() != "" {
+		section := p.next()
+		if section != "input" && section != "filter" && section != "output" {
+			return nil, fmt.Errorf("expected input, filter or output but found %q", section)
+		}
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		sectionPlugins, err := p.parsePlugins(section, false)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, sectionPlugins...)
+	}
+	return plugins, nil
+}
@@ -0,0 +1,116 @@
+package importer_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/willf/translog/importer"
+)
+
+var logstashConfig = `
+# a typical nginx pipeline
+input {
+  file {
+    path => ["/var/log/nginx/access.log"]
+    start_position => "beginning"
+  }
+}
+filter {
+  grok {
+    match => { "message" => "%{COMBINEDAPACHELOG}" }
+  }
+  date {
+    match => [ "timestamp", "dd/MMM/yyyy:HH:mm:ss Z" ]
+  }
+  mutate {
+    remove_field => [ "ident", "auth" ]
+    rename => { "agent" => "user_agent" }
+  }
+  if [response] == "404" {
+    drop { }
+  }
+}
+output {
+  elasticsearch {
+    hosts => ["https://es1:9243", "https://es2:9243"]
+    index => "nginx-%{+YYYY.MM.dd}"
+  }
+}
+`
+
+func TestImportLogstash(t *testing.T) {
+	r, err := importer.ImportLogstash(logstashConfig)
+	if err != nil {
+		t.Fatalf("expected the configuration to be imported, got %v", err)
+	}
+	if len(r.Pipeline.Inputs) != 1 || r.Pipeline.Inputs[0].Path != "/var/log/nginx/access.log" {
+		t.Fatalf("expected a file input, got %v", r.Pipeline.Inputs)
+	}
+	input := r.Pipeline.Inputs[0]
+	regex := regexp.MustCompile(input.Pattern)
+	line := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`
+	match := regex.FindStringSubmatch(line)
+	if match == nil {
+		t.Fatalf("expected pattern %s to match %s", input.Pattern, line)
+	}
+	if match[regex.SubexpIndex("response")] != "200" || match[regex.SubexpIndex("clientip")] != "127.0.0.1" {
+		t.Errorf("expected response and clientip to be captured, got %v", match)
+	}
+	if len(input.TimePatterns) != 1 || input.TimePatterns[0] != "02/Jan/2006:15:04:05 -0700" {
+		t.Errorf("expected the date format to be converted, got %v", input.TimePatterns)
+	}
+	if strings.Join(input.KeysToIgnore, ",") != "ident,auth" {
+		t.Errorf("expected removed fields to be ignored, got %v", input.KeysToIgnore)
+	}
+	output := r.Pipeline.Outputs[0]
+	if output.Type != "elasticsearch" || output.Scheme != "https" || *output.Port != 9243 || output.Index != "nginx-" || !*output.UseDateSuffix {
+		t.Errorf("expected the elasticsearch output to be converted, got %+v", output)
+	}
+	warnings := strings.Join(r.Warnings, "\n")
+	for _, expected := range []string{"rename", "filter drop is not supported", "conditional"} {
+		if !strings.Contains(warnings, expected) {
+			t.Errorf("expected a warning about %s, got %v", expected, warnings)
+		}
+	}
+	var b bytes.Buffer
+	if err := r.WriteYAML(&b); err != nil || !strings.Contains(b.String(), "type: elasticsearch") {
+		t.Errorf("expected YAML output, got %v (%v)", b.String(), err)
+	}
+}
+
+func TestParseLogstashConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		`input { file { path => "/x" }`,
+		`inptu { }`,
+		`filter { grok { match => } }`,
+		`filter { grok { match => "unterminated } }`,
+	} {
+		if _, err := importer.ParseLogstashConfig(config); err == nil {
+			t.Errorf("expected an error parsing %s", config)
+		}
+	}
+}
+
+var jodaTestCases = []struct {
+	joda     string
+	expected string
+}{
+	{"dd/MMM/yyyy:HH:mm:ss Z", "02/Jan/2006:15:04:05 -0700"},
+	{"yyyy-MM-dd'T'HH:mm:ss.SSSZZ", "2006-01-02T15:04:05.000-07:00"},
+	{"MMM  d HH:mm:ss", "Jan  2 15:04:05"},
+	{"EEE MMM dd hh:mm:ss a yyyy", "Mon Jan 02 03:04:05 PM 2006"},
+}
+
+func TestJodaToLayout(t *testing.T) {
+	for i, tt := range jodaTestCases {
+		actual, err := importer.JodaToLayout(tt.joda)
+		if err != nil || actual != tt.expected {
+			t.Errorf("In test %d, JodaToLayout(%v): expected %v, actual %v (error: %v)", i+1, tt.joda, tt.expected, actual, err)
+		}
+	}
+	if _, err := importer.JodaToLayout("UNIX"); err == nil {
+		t.Errorf("expected UNIX to be unsupported")
+	}
+}
@@ -0,0 +1,42 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// strftimeDirectives maps strftime directives (as used by Fluent Bit's
+// Time_Format) to Go layout elements
+var strftimeDirectives = map[byte]string{
+	'Y': "2006", 'y': "06",
+	'm': "01", 'b': "Jan", 'h': "Jan", 'B': "January",
+	'd': "02", 'e': "_2",
+	'a': "Mon", 'A': "Monday",
+	'H': "15", 'I': "03", 'p': "PM",
+	'M': "04", 'S': "05",
+	'L': "000", 'N': "000000000",
+	'z': "-0700", 'Z': "MST",
+	'T': "15:04:05", 'D': "01/02/06", 'F': "2006-01-02",
+	'%': "%",
+}
+
+// StrftimeToLayout converts a strftime time format into a Go time layout
+func StrftimeToLayout(format string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		if i+1 >= len(format) {
+			return "", fmt.Errorf("time format %s ends with %%", format)
+		}
+		i++
+		element, found := strftimeDirectives[format[i]]
+		if !found {
+			return "", fmt.Errorf("unsupported directive %%%c in time format %s", format[i], format)
+		}
+		layout.WriteString(element)
+	}
+	return layout.String(), nil
+}
@@ -0,0 +1,162 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+const configAdminAddress = "admin.address"
+
+// Admin serves the runtime control API. It listens on a TCP address
+// (e.g. 127.0.0.1:6060) or, for addresses of the form unix:/path, on a
+// Unix domain socket.
+//
+//	GET  /stats   current statistics, as JSON
+//	POST /pause   pause reading the input
+//	POST /resume  resume reading the input
+//	POST /flush   flush the sink's buffered output
+//	POST /rotate  make the sink reopen its output files
+//
+// as well as a dashboard (/), recent dead letters (/deadletters), the last
+// parse failure diagnostic (/diagnostics) and Prometheus metrics (/metrics). With admin.pprof (or --pprof), it also
+// serves profiles (/debug/pprof/) and expvar variables (/debug/vars).
+type Admin struct {
+	Parser    *worker.LogParser
+	Sink      worker.Worker
+	Mux       *http.ServeMux
+	startTime time.Time
+}
+
+// Stats are the statistics reported by the admin API
+type Stats struct {
+	StartTime  time.Time        `json:"start_time"`
+	Uptime     float64          `json:"uptime"`
+	LinesRead  int64            `json:"lines_read"`
+	Pending    int64            `json:"pending"`
+	Paused     bool             `json:"paused"`
+	Goroutines int              `json:"goroutines"`
+	Counters   map[string]int64 `json:"counters"`
+	Restarts   map[string]int64 `json:"restarts"`
+	Sink       string           `json:"sink"`
+	Health     *worker.Health   `json:"health,omitempty"`
+}
+
+// NewAdmin creates the admin API for the parser and sink
+func NewAdmin(parser *worker.LogParser, sink worker.Worker) *Admin {
+	a := &Admin{Parser: parser, Sink: sink, Mux: http.NewServeMux(), startTime: time.Now()}
+	a.Mux.HandleFunc("/", a.handleDashboard)
+	a.Mux.HandleFunc("/stats", a.handleStats)
+	a.Mux.HandleFunc("/deadletters", a.handleDeadLetters)
+	a.Mux.HandleFunc("/diagnostics", a.handleDiagnostics)
+	a.Mux.HandleFunc("/metrics", a.handleMetrics)
+	a.Mux.HandleFunc("/pause", a.command(func() bool { a.Parser.Pause(); return true }))
+	a.Mux.HandleFunc("/resume", a.command(func() bool { a.Parser.Resume(); return true }))
+	a.Mux.HandleFunc("/flush", a.command(func() bool {
+		flusher, ok := a.Sink.(worker.Flusher)
+		if ok {
+			flusher.Flush()
+		}
+		return ok
+	}))
+	a.Mux.HandleFunc("/rotate", a.command(func() bool {
+		reopener, ok := a.Sink.(worker.Reopener)
+		if ok {
+			reopener.Reopen()
+		}
+		return ok
+	}))
+	if viper.GetBool(configAdminPprof) {
+		a.handleDebug()
+	}
+	return a
+}
+
+// CurrentStats returns the current statistics
+func (a *Admin) CurrentStats() Stats {
+	now := time.Now()
+	stats := Stats{
+		StartTime:  a.startTime,
+		Uptime:     now.Sub(a.startTime).Seconds(),
+		LinesRead:  a.Parser.LinesRead(),
+		Pending:    a.Parser.Pending(),
+		Paused:     a.Parser.Paused(),
+		Goroutines: runtime.NumGoroutine(),
+		Counters:   worker.Counters.Snapshot(),
+		Restarts:   worker.Restarts.Snapshot(),
+		Sink:       sinkName(a.Sink),
+	}
+	if reporter, ok := a.Sink.(worker.HealthReporter); ok {
+		health := reporter.Health()
+		stats.Health = &health
+	}
+	return stats
+}
+
+// sinkName returns a short name for the sink, e.g. FileWorker
+func sinkName(sink worker.Worker) string {
+	name := fmt.Sprintf("%T", sink)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+func (a *Admin) handleStats(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, a.CurrentStats())
+}
+
+// handleDiagnostics serves the diagnostic of the last window in which too
+// many lines failed to parse, or null
+func (a *Admin) handleDiagnostics(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, a.Parser.ParseDiagnostic())
+}
+
+// command wraps a control command in a handler. The command returns false
+// if it is not supported by the sink.
+func (a *Admin) command(f func() bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			writeJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		logs.Info("Admin command %s", req.URL.Path)
+		if !f() {
+			writeJSON(rw, http.StatusNotImplemented, map[string]string{"error": "not supported by this sink"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// adminListener listens on a TCP address or a unix:/path socket
+func adminListener(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		os.Remove(path) // remove a stale socket from a previous run
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+// ListenAndServe serves the admin API on address
+func (a *Admin) ListenAndServe(address string) error {
+	listener, err := adminListener(address)
+	if err != nil {
+		return err
+	}
+	logs.Info("Admin API listening on %s", address)
+	return http.Serve(listener, a.Mux)
+}
@@ -0,0 +1,61 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminClient talks to the admin API of a running translog
+type AdminClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewAdminClient creates a client for the admin API on address, which is
+// either a TCP address or a unix:/path socket
+func NewAdminClient(address string) *AdminClient {
+	transport := &http.Transport{}
+	baseURL := "http://" + address
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		baseURL = "http://translog"
+	}
+	return &AdminClient{baseURL: baseURL, client: &http.Client{Transport: transport, Timeout: 5 * time.Second}}
+}
+
+// Stats fetches the current statistics
+func (c *AdminClient) Stats() (stats Stats, err error) {
+	resp, err := c.client.Get(c.baseURL + "/stats")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("Admin API returned %s", resp.Status)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return
+}
+
+// Command sends a command (pause, resume, flush, rotate)
+func (c *AdminClient) Command(command string) error {
+	resp, err := c.client.Post(c.baseURL+"/"+command, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Admin API returned %s for %s", resp.Status, command)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package run_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+func TestAdminPauseResume(t *testing.T) {
+	parser := &worker.LogParser{}
+	admin := run.NewAdmin(parser, &worker.StdOutWorker{})
+	server := httptest.NewServer(admin.Mux)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/pause", "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected pause to succeed, got %v %v", resp, err)
+	}
+	if !parser.Paused() {
+		t.Errorf("expected parser to be paused")
+	}
+
+	resp, err = http.Get(server.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats run.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !stats.Paused {
+		t.Errorf("expected stats to report paused, got %v", stats)
+	}
+
+	http.Post(server.URL+"/resume", "application/json", nil)
+	if parser.Paused() {
+		t.Errorf("expected parser to be resumed")
+	}
+}
+
+func TestAdminUnsupported(t *testing.T) {
+	admin := run.NewAdmin(&worker.LogParser{}, &worker.StdOutWorker{})
+	server := httptest.NewServer(admin.Mux)
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/rotate", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected rotate to be unsupported for stdout, got %v", resp.Status)
+	}
+	resp, err = http.Get(server.URL + "/flush")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET /flush not to be allowed, got %v", resp.Status)
+	}
+}
+
+func TestAdminDashboardAndMetrics(t *testing.T) {
+	admin := run.NewAdmin(&worker.LogParser{}, &worker.FileWorker{})
+	server := httptest.NewServer(admin.Mux)
+	defer server.Close()
+	for _, path := range []string{"/", "/metrics", "/deadletters", "/diagnostics"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected %s to be served, got %v", path, resp.Status)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(server.URL + "/nonesuch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected /nonesuch not to be found, got %v", resp.Status)
+	}
+}
+
+func TestAdminPprof(t *testing.T) {
+	viper.Reset()
+	admin := run.NewAdmin(&worker.LogParser{}, &worker.StdOutWorker{})
+	server := httptest.NewServer(admin.Mux)
+	resp, err := http.Get(server.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected no expvar endpoint without admin.pprof, got %v", resp.Status)
+	}
+	server.Close()
+
+	viper.Set("admin.pprof", true)
+	admin = run.NewAdmin(&worker.LogParser{}, &worker.StdOutWorker{})
+	server = httptest.NewServer(admin.Mux)
+	defer server.Close()
+	resp, err = http.Get(server.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars map[string]json.RawMessage
+	err = json.NewDecoder(resp.Body).Decode(&vars)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := vars["translog"]; !found {
+		t.Errorf("expected translog statistics in expvar variables, got %v", vars)
+	}
+	resp, err = http.Get(server.URL + "/debug/pprof/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the pprof index, got %v", resp.Status)
+	}
+}
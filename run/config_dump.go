@@ -0,0 +1,128 @@
+package run
+
+/*
+	config_dump.go shows the resolved configuration
+
+	ResolvedConfig merges the defaults of ConfigSchema with what was set in
+	the configuration file, the environment (as upper-case key names, e.g.
+	ES.INDEX) and command line flags, and says where each value came from.
+	Values set by a structured pipeline (see pipeline.go) come from
+	"pipeline". Keys that are set but not in the schema are included too,
+	so that typos stand out.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigSetting is the resolved value of a configuration key
+type ConfigSetting struct {
+	Key    string
+	Value  interface{}
+	Source string // default, file, env, flag, or pipeline
+	Known  bool   // whether the key is in the schema
+}
+
+// secretKeyWords are parts of the names of keys whose values aren't shown
+var secretKeyWords = []string{"password", "secret", "token"}
+
+// isSecretKey returns true if the key's value shouldn't be shown
+func isSecretKey(key string) bool {
+	for _, word := range secretKeyWords {
+		if strings.Contains(strings.ToLower(key), word) {
+			return true
+		}
+	}
+	return false
+}
+
+// knownKey returns true if key is in the schema, or an entry of one of its
+// tables
+func knownKey(key string) bool {
+	for _, k := range ConfigSchema {
+		if k.Key == key || strings.HasSuffix(k.Key, ".*") && strings.HasPrefix(key, strings.TrimSuffix(k.Key, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolvedConfig returns the settings of the keys of the schema, and of the
+// other keys that are set, sorted by key; flags are the keys set with
+// command line flags
+func ResolvedConfig(flags map[string]bool) []ConfigSetting {
+	file := viper.New()
+	if used := viper.ConfigFileUsed(); used != "" {
+		file.SetConfigFile(used)
+		file.ReadInConfig()
+	}
+	keys := make(map[string]bool)
+	for _, k := range ConfigSchema {
+		if !strings.HasSuffix(k.Key, ".*") {
+			keys[k.Key] = true
+		}
+	}
+	for _, key := range viper.AllKeys() {
+		keys[key] = true
+	}
+	settings := make([]ConfigSetting, 0, len(keys))
+	for key := range keys {
+		s := ConfigSetting{Key: key, Source: "default", Known: knownKey(key)}
+		for _, k := range ConfigSchema {
+			if k.Key == key {
+				s.Value = k.Default
+			}
+		}
+		if viper.IsSet(key) {
+			s.Value = viper.Get(key)
+			if _, found := os.LookupEnv(strings.ToUpper(key)); found {
+				s.Source = "env"
+			} else if flags[key] {
+				s.Source = "flag"
+			} else if file.IsSet(key) {
+				s.Source = "file"
+			} else {
+				s.Source = "pipeline"
+			}
+		}
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+// DumpConfig writes the settings, one key per line, with their sources;
+// the values of secret keys, such as passwords, are hidden unless
+// showSecrets is set
+func DumpConfig(out io.Writer, settings []ConfigSetting, showSecrets bool) {
+	for _, s := range settings {
+		value := "null"
+		if bs, err := json.Marshal(s.Value); err == nil {
+			value = string(bs)
+		}
+		if isSecretKey(s.Key) && !showSecrets && s.Value != nil && s.Value != "" {
+			value = `"********"`
+		}
+		source := s.Source
+		if !s.Known {
+			source += "; not a known key"
+		}
+		fmt.Fprintf(out, "%-40s = %-30s # %s\n", s.Key, value, source)
+	}
+}
+
+// DumpConfigSchema writes the schema as JSON
+func DumpConfigSchema(out io.Writer) error {
+	bs, err := json.MarshalIndent(ConfigSchema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", bs)
+	return err
+}
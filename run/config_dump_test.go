@@ -0,0 +1,56 @@
+package run_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
+)
+
+func TestResolvedConfig(t *testing.T) {
+	viper.Reset()
+	config := filepath.Join(t.TempDir(), "translog.toml")
+	ioutil.WriteFile(config, []byte("[es]\nindex = \"nginx\"\n[mqtt]\npassword = \"hunter2\"\n[es.extra]\ntypo = 1\n"), 0644)
+	viper.SetConfigFile(config)
+	viper.AutomaticEnv()
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ES.MAX_RETRIES", "7")
+	defer os.Unsetenv("ES.MAX_RETRIES")
+	viper.Set("parse.pattern", `(?P<status>\d+)`)
+	sources := map[string]string{}
+	known := map[string]bool{}
+	for _, s := range run.ResolvedConfig(map[string]bool{}) {
+		sources[s.Key] = s.Source
+		known[s.Key] = s.Known
+	}
+	expected := map[string]string{"es.index": "file", "es.max_retries": "env", "parse.pattern": "pipeline", "tail.poll": "default", "es.extra.typo": "file"}
+	for key, source := range expected {
+		if sources[key] != source {
+			t.Errorf("ResolvedConfig(): expected %s from %s, actual %q", key, source, sources[key])
+		}
+	}
+	if known["es.extra.typo"] || !known["es.index"] {
+		t.Errorf("ResolvedConfig(): expected es.extra.typo to be unknown and es.index to be known")
+	}
+	var out bytes.Buffer
+	run.DumpConfig(&out, run.ResolvedConfig(map[string]bool{}), false)
+	if strings.Contains(out.String(), "hunter2") || !strings.Contains(out.String(), `"nginx"`) {
+		t.Errorf("DumpConfig(): expected the password to be hidden, actual\n%s", out.String())
+	}
+	out.Reset()
+	if err := run.DumpConfigSchema(&out); err != nil {
+		t.Fatal(err)
+	}
+	var schema []run.ConfigKey
+	if err := json.Unmarshal(out.Bytes(), &schema); err != nil || len(schema) != len(run.ConfigSchema) {
+		t.Errorf("DumpConfigSchema(): expected %d keys, actual %d (%v)", len(run.ConfigSchema), len(schema), err)
+	}
+}
@@ -0,0 +1,110 @@
+package run
+
+import (
+	"net/http"
+
+	"github.com/willf/translog/worker"
+)
+
+func (a *Admin) handleDeadLetters(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, worker.RecentDeadLetters())
+}
+
+// handleDashboard serves the dashboard, which polls /stats and
+// /deadletters
+func (a *Admin) handleDashboard(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(rw, req)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write([]byte(dashboardHTML))
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>translog</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.tiles { display: flex; gap: 1em; }
+.tile { border: 1px solid #ccc; border-radius: 4px; padding: 0.5em 1em; min-width: 10em; }
+.tile .value { font-size: 1.6em; }
+.bad { color: #b00; }
+.good { color: #070; }
+canvas { border: 1px solid #ccc; margin-top: 1em; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>translog</h1>
+<div class="tiles">
+  <div class="tile">lines/sec<div class="value" id="rate">-</div></div>
+  <div class="tile">lines read<div class="value" id="lines">-</div></div>
+  <div class="tile">parse failures<div class="value" id="failures">-</div></div>
+  <div class="tile">output (<span id="sink"></span>)<div class="value" id="health">-</div></div>
+</div>
+<canvas id="graph" width="720" height="160"></canvas>
+<h2>Recent dead letters</h2>
+<div id="deadletters"></div>
+<script>
+var rates = [], last = null;
+function fetchJSON(url, f) {
+  var xhr = new XMLHttpRequest();
+  xhr.onload = function () { f(JSON.parse(xhr.responseText)); };
+  xhr.open("GET", url);
+  xhr.send();
+}
+function draw() {
+  var canvas = document.getElementById("graph"), ctx = canvas.getContext("2d");
+  var max = Math.max.apply(null, rates.concat([1]));
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  ctx.beginPath();
+  rates.forEach(function (v, i) {
+    var x = i * canvas.width / 120, y = canvas.height - v / max * (canvas.height - 10);
+    if (i === 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+  });
+  ctx.stroke();
+}
+function refresh() {
+  fetchJSON("stats", function (s) {
+    var parsed = s.counters.lines_parsed || 0, unmatched = s.counters.lines_unmatched || 0;
+    if (last !== null) {
+      var rate = (s.lines_read - last.lines_read) / (s.uptime - last.uptime);
+      rates.push(rate);
+      if (rates.length > 120) { rates.shift(); }
+      document.getElementById("rate").textContent = rate.toFixed(1);
+    }
+    last = s;
+    document.getElementById("lines").textContent = s.lines_read + (s.paused ? " (paused)" : "");
+    document.getElementById("failures").textContent = unmatched +
+      (parsed + unmatched > 0 ? " (" + (100 * unmatched / (parsed + unmatched)).toFixed(1) + "%)" : "");
+    document.getElementById("sink").textContent = s.sink;
+    var health = document.getElementById("health");
+    if (s.health) {
+      health.textContent = s.health.healthy ? "healthy" : "failing";
+      health.className = "value " + (s.health.healthy ? "good" : "bad");
+      health.title = s.health.last_error || "";
+    } else {
+      health.textContent = "n/a";
+    }
+    draw();
+  });
+  fetchJSON("deadletters", function (records) {
+    var div = document.getElementById("deadletters");
+    div.innerHTML = "";
+    records.reverse().forEach(function (r) {
+      var pre = document.createElement("pre");
+      pre.textContent = r.time + " [" + r.reason + "] " + (r.error || "") + "\n" + (r.line || JSON.stringify(r.event));
+      div.appendChild(pre);
+    });
+    if (records.length === 0) { div.textContent = "none"; }
+  });
+}
+refresh();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,33 @@
+package run
+
+import (
+	"github.com/fizx/logs"
+	"github.com/willf/translog/worker"
+)
+
+// Requeue replays the stored dead letters selected by the filter through the
+// parser and the sink, deletes them, and returns how many it replayed. Those
+// rejected again are stored again, as new dead letters.
+func Requeue(sink worker.Worker, filter worker.DeadLetterFilter) (int, error) {
+	letters, err := worker.ListDeadLetters(filter)
+	if err != nil || len(letters) == 0 {
+		return 0, err
+	}
+	logWorker := &worker.LogParser{}
+	connect(logWorker, sink, make(chan map[string]interface{}))
+	sink.Start()
+	logs.Info("Requeueing %d dead letters", len(letters))
+	logWorker.Requeue(letters)
+	logWorker.Stop()
+	if flusher, ok := sink.(worker.Flusher); ok {
+		flusher.Flush()
+	}
+	sink.Stop()
+	ids := make([]int64, len(letters))
+	for i, letter := range letters {
+		ids[i] = letter.ID
+	}
+	err = worker.DeleteDeadLetters(ids)
+	worker.FlushDeadLetters()
+	return len(letters), err
+}
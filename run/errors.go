@@ -0,0 +1,22 @@
+package run
+
+import (
+	"github.com/fizx/logs"
+	"github.com/willf/translog/worker"
+)
+
+// logErrors logs the errors reported by the workers (output errors are also
+// reflected in the sink's health), until errors is closed
+func logErrors(errors <-chan error) {
+	for err := range errors {
+		switch e := err.(type) {
+		case *worker.ParseError:
+			// unmatched lines are common, and counted as lines_unmatched
+			logs.Debug("%v", e)
+		case *worker.ConfigError:
+			logs.Warn("Configuration error: %v", e)
+		default:
+			logs.Warn("%v", e)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package run
+
+/*
+	grpc.go serves the management API over gRPC (see management.proto)
+
+	The service is registered by hand, rather than through generated code,
+	since all its messages are protobuf well-known types.
+*/
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const configAdminGrpcAddress = "admin.grpc_address"
+
+// tailBuffer is the number of events buffered for each Tail stream
+const tailBuffer = 100
+
+// ManagementServer is the server API for the translog.Management service
+type ManagementServer interface {
+	Status(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	Pipelines(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	Pause(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	Resume(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	Tail(*emptypb.Empty, grpc.ServerStream) error
+}
+
+// toStruct converts anything that marshals to a JSON object into a Struct
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	err = protojson.Unmarshal(bs, s)
+	return s, err
+}
+
+// Status implements ManagementServer
+func (a *Admin) Status(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	return toStruct(a.CurrentStats())
+}
+
+// Pipelines implements ManagementServer
+func (a *Admin) Pipelines(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	pipeline := map[string]interface{}{
+		"name":    "main",
+		"input":   viper.GetString("parse.input_file"),
+		"pattern": a.Parser.CachedRegex().String(),
+		"sink":    sinkName(a.Sink),
+		"paused":  a.Parser.Paused(),
+	}
+	return toStruct(map[string]interface{}{"pipelines": []interface{}{pipeline}})
+}
+
+// Pause implements ManagementServer
+func (a *Admin) Pause(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	a.Parser.Pause()
+	return &emptypb.Empty{}, nil
+}
+
+// Resume implements ManagementServer
+func (a *Admin) Resume(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	a.Parser.Resume()
+	return &emptypb.Empty{}, nil
+}
+
+// Tail implements ManagementServer
+func (a *Admin) Tail(_ *emptypb.Empty, stream grpc.ServerStream) error {
+	events := a.Parser.Tap.Subscribe(tailBuffer)
+	defer a.Parser.Tap.Unsubscribe(events)
+	for {
+		select {
+		case v := <-events:
+			s, err := toStruct(v)
+			if err != nil {
+				logs.Debug("Unable to convert event %v: %v", v, err)
+				continue
+			}
+			if err := stream.SendMsg(s); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func unaryHandler(call func(ManagementServer, context.Context, *emptypb.Empty) (interface{}, error), method string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(emptypb.Empty)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(ManagementServer), ctx, req.(*emptypb.Empty))
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/translog.Management/" + method}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+var managementServiceDesc = grpc.ServiceDesc{
+	ServiceName: "translog.Management",
+	HandlerType: (*ManagementServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Status", Handler: unaryHandler(func(s ManagementServer, ctx context.Context, in *emptypb.Empty) (interface{}, error) {
+			return s.Status(ctx, in)
+		}, "Status")},
+		{MethodName: "Pipelines", Handler: unaryHandler(func(s ManagementServer, ctx context.Context, in *emptypb.Empty) (interface{}, error) {
+			return s.Pipelines(ctx, in)
+		}, "Pipelines")},
+		{MethodName: "Pause", Handler: unaryHandler(func(s ManagementServer, ctx context.Context, in *emptypb.Empty) (interface{}, error) {
+			return s.Pause(ctx, in)
+		}, "Pause")},
+		{MethodName: "Resume", Handler: unaryHandler(func(s ManagementServer, ctx context.Context, in *emptypb.Empty) (interface{}, error) {
+			return s.Resume(ctx, in)
+		}, "Resume")},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Tail",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := new(emptypb.Empty)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(ManagementServer).Tail(in, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "management.proto",
+}
+
+// NewGrpcServer creates a gRPC server with the management service
+// registered
+func NewGrpcServer(a *Admin) *grpc.Server {
+	server := grpc.NewServer()
+	server.RegisterService(&managementServiceDesc, a)
+	return server
+}
+
+// ListenAndServeGrpc serves the gRPC management API on address
+func (a *Admin) ListenAndServeGrpc(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	logs.Info("gRPC management API listening on %s", address)
+	return NewGrpcServer(a).Serve(listener)
+}
@@ -0,0 +1,52 @@
+package run_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestGrpcManagement(t *testing.T) {
+	parser := &worker.LogParser{}
+	parser.Init()
+	admin := run.NewAdmin(parser, &worker.StdOutWorker{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := run.NewGrpcServer(admin)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if err := conn.Invoke(ctx, "/translog.Management/Pause", &emptypb.Empty{}, &emptypb.Empty{}); err != nil {
+		t.Fatalf("expected Pause to succeed, got %v", err)
+	}
+	status := &structpb.Struct{}
+	if err := conn.Invoke(ctx, "/translog.Management/Status", &emptypb.Empty{}, status); err != nil {
+		t.Fatalf("expected Status to succeed, got %v", err)
+	}
+	if !status.Fields["paused"].GetBoolValue() {
+		t.Errorf("expected status to report paused, got %v", status)
+	}
+	pipelines := &structpb.Struct{}
+	if err := conn.Invoke(ctx, "/translog.Management/Pipelines", &emptypb.Empty{}, pipelines); err != nil {
+		t.Fatalf("expected Pipelines to succeed, got %v", err)
+	}
+	if len(pipelines.Fields["pipelines"].GetListValue().GetValues()) != 1 {
+		t.Errorf("expected one pipeline, got %v", pipelines)
+	}
+}
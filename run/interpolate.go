@@ -0,0 +1,161 @@
+package run
+
+/*
+	interpolate.go fills in references in configuration values
+
+	Any string in the configuration may refer to an environment variable,
+	as ${ES_PASSWORD}, to the contents of a file, as
+	${file:/run/secrets/es_password} (without its trailing newline), or to
+	a secret in a secrets manager (see secrets.go), so that credentials and
+	per-host values don't have to be written into the configuration file.
+	$${ is a literal ${. A reference to a variable that isn't set, or to a
+	file or secret that can't be read, is an error.
+*/
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// referenceRegex matches an escaped ${, or a reference
+var referenceRegex = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// Interpolate fills in the references in s
+func Interpolate(s string) (string, error) {
+	return interpolate(s, nil)
+}
+
+// interpolate fills in the references in s, passing the secrets read to
+// found
+func interpolate(s string, found func(secret, SecretProvider)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var err error
+	result := referenceRegex.ReplaceAllStringFunc(s, func(reference string) string {
+		if reference == "$${" {
+			return "${"
+		}
+		name := reference[2 : len(reference)-1]
+		if i := strings.Index(name, ":"); i > 0 {
+			if provider, ok := providerFor(name[:i]); ok {
+				secret, serr := readSecret(provider, name[i+1:])
+				if serr != nil {
+					err = fmt.Errorf("%s: %v", name, serr)
+					return ""
+				}
+				if found != nil {
+					found(secret, provider)
+				}
+				return secret.value
+			}
+		}
+		if strings.HasPrefix(name, "file:") {
+			bs, ferr := ioutil.ReadFile(strings.TrimPrefix(name, "file:"))
+			if ferr != nil {
+				err = ferr
+				return ""
+			}
+			return strings.TrimRight(string(bs), "\r\n")
+		}
+		value, found := os.LookupEnv(name)
+		if !found {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	return result, err
+}
+
+// interpolateValue fills in the references in the strings of a value,
+// returning whether it changed
+func interpolateValue(value interface{}, found func(secret, SecretProvider)) (interface{}, bool, error) {
+	switch v := value.(type) {
+	case string:
+		s, err := interpolate(v, found)
+		return s, s != v, err
+	case []string:
+		changed := false
+		values := make([]string, len(v))
+		for i, s := range v {
+			var err error
+			if values[i], err = interpolate(s, found); err != nil {
+				return nil, false, err
+			}
+			changed = changed || values[i] != s
+		}
+		return values, changed, nil
+	case []interface{}:
+		changed := false
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			var c bool
+			var err error
+			if values[i], c, err = interpolateValue(item, found); err != nil {
+				return nil, false, err
+			}
+			changed = changed || c
+		}
+		return values, changed, nil
+	case map[string]interface{}:
+		changed := false
+		values := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var c bool
+			var err error
+			if values[key], c, err = interpolateValue(item, found); err != nil {
+				return nil, false, err
+			}
+			changed = changed || c
+		}
+		return values, changed, nil
+	case map[interface{}]interface{}:
+		changed := false
+		values := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			var c bool
+			var err error
+			if values[fmt.Sprint(key)], c, err = interpolateValue(item, found); err != nil {
+				return nil, false, err
+			}
+			changed = changed || c
+		}
+		return values, changed, nil
+	}
+	return value, false, nil
+}
+
+// InterpolateConfig fills in the references in all configuration values;
+// the configuration of the secrets managers comes first, as it may refer
+// to environment variables and files
+func InterpolateConfig() error {
+	resetSecretProviders()
+	keys := viper.AllKeys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		return strings.HasPrefix(keys[i], "secrets.") && !strings.HasPrefix(keys[j], "secrets.")
+	})
+	now := time.Now()
+	for _, key := range keys {
+		template := viper.Get(key)
+		value, changed, use, err := resolve(key, template, now)
+		if err != nil {
+			return &worker.ConfigError{Key: key, Value: template, Reason: err.Error()}
+		}
+		if use != nil {
+			secretUses.Lock()
+			secretUses.uses[key] = use
+			secretUses.Unlock()
+		}
+		if changed {
+			viper.Set(key, value)
+		}
+	}
+	return nil
+}
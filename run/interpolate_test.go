@@ -0,0 +1,51 @@
+package run_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
+)
+
+func TestInterpolate(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "es_password")
+	ioutil.WriteFile(secret, []byte("hunter2\n"), 0600)
+	os.Setenv("TRANSLOG_TEST_HOST", "es1")
+	defer os.Unsetenv("TRANSLOG_TEST_HOST")
+	var interpolateTestCases = []struct {
+		s        string
+		expected string
+		ok       bool
+	}{
+		{"plain", "plain", true},
+		{"https://${TRANSLOG_TEST_HOST}:9200", "https://es1:9200", true},
+		{"${file:" + secret + "}", "hunter2", true},
+		{"cost: $${TRANSLOG_TEST_HOST}", "cost: ${TRANSLOG_TEST_HOST}", true},
+		{"${TRANSLOG_TEST_UNSET}", "", false},
+		{"${file:/nonexistent/secret}", "", false},
+	}
+	for i, tt := range interpolateTestCases {
+		actual, err := run.Interpolate(tt.s)
+		if (err == nil) != tt.ok || tt.ok && actual != tt.expected {
+			t.Errorf("In test %d, Interpolate(%v): expected %v (ok %v), actual %v (%v)", i, tt.s, tt.expected, tt.ok, actual, err)
+		}
+	}
+
+	viper.Reset()
+	viper.Set("es.hosts", []interface{}{"${TRANSLOG_TEST_HOST}", "es2"})
+	viper.Set("mqtt.password", "${file:"+secret+"}")
+	viper.Set("es.max", 500)
+	if err := run.InterpolateConfig(); err != nil {
+		t.Fatalf("InterpolateConfig(): unexpected error %v", err)
+	}
+	if hosts := viper.GetStringSlice("es.hosts"); len(hosts) != 2 || hosts[0] != "es1" || viper.GetString("mqtt.password") != "hunter2" || viper.GetInt("es.max") != 500 {
+		t.Errorf("InterpolateConfig(): unexpected configuration %v", viper.AllSettings())
+	}
+	viper.Set("es.index", "${TRANSLOG_TEST_UNSET}")
+	if err := run.InterpolateConfig(); err == nil {
+		t.Errorf("InterpolateConfig(): expected an error for an unset variable")
+	}
+}
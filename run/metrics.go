@@ -0,0 +1,48 @@
+package run
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/willf/translog/worker"
+)
+
+var invalidMetricCharacters = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// metricName converts a counter name into a Prometheus metric name
+func metricName(name string) string {
+	return "translog_" + invalidMetricCharacters.ReplaceAllString(name, "_")
+}
+
+func boolGauge(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// handleMetrics serves the statistics in the Prometheus text format
+func (a *Admin) handleMetrics(rw http.ResponseWriter, req *http.Request) {
+	stats := a.CurrentStats()
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(rw, "# TYPE translog_uptime_seconds gauge\ntranslog_uptime_seconds %g\n", stats.Uptime)
+	fmt.Fprintf(rw, "# TYPE translog_lines_read_total counter\ntranslog_lines_read_total %d\n", stats.LinesRead)
+	fmt.Fprintf(rw, "# TYPE translog_paused gauge\ntranslog_paused %d\n", boolGauge(stats.Paused))
+	fmt.Fprintf(rw, "# TYPE translog_goroutines gauge\ntranslog_goroutines %d\n", stats.Goroutines)
+	for _, name := range worker.Counters.Names() {
+		metric := metricName(name) + "_total"
+		fmt.Fprintf(rw, "# TYPE %s counter\n%s %d\n", metric, metric, stats.Counters[name])
+	}
+	if len(stats.Restarts) > 0 {
+		fmt.Fprintf(rw, "# TYPE translog_restarts_total counter\n")
+		for _, name := range worker.Restarts.Names() {
+			fmt.Fprintf(rw, "translog_restarts_total{goroutine=%q} %d\n", name, stats.Restarts[name])
+		}
+	}
+	if stats.Health != nil {
+		fmt.Fprintf(rw, "# TYPE translog_sink_healthy gauge\ntranslog_sink_healthy{sink=%q} %d\n", stats.Sink, boolGauge(stats.Health.Healthy))
+		fmt.Fprintf(rw, "# TYPE translog_sink_failures_total counter\ntranslog_sink_failures_total{sink=%q} %d\n", stats.Sink, stats.Health.Failures)
+	}
+	worker.EventMetrics.WritePrometheus(rw)
+}
@@ -0,0 +1,586 @@
+package run
+
+/*
+	pipeline.go reads the structured pipeline configuration
+
+	Instead of the flat configuration keys, a pipeline can be described as
+	lists of inputs, filters and outputs, e.g. in YAML:
+
+		inputs:
+		  - type: file
+		    path: /var/log/nginx/access.log
+		    pattern: '(?P<ip>\S+) ...'
+		filters:
+		  - type: derive
+		    fields:
+		      status_class: classify(status)
+		outputs:
+		  - type: elasticsearch
+		    hosts: [es1, es2]
+		    index: nginx
+
+	Each stage is decoded into a typed configuration struct, and validated.
+	The pipeline is then applied by setting the equivalent flat keys, so that
+	both kinds of configuration keep working. Flat keys are only overridden
+	by settings that are actually present in the pipeline.
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// FileInputConfig configures an input: a file (tailed), a Unix socket (unix),
+// a named pipe (fifo), the output of a command (exec), a polled URL (http),
+// a GELF listener (gelf), a Redis Stream (redis), a polled table (sql), or
+// events forwarded by another translog (forward)
+type FileInputConfig struct {
+	Type          string            `json:"type" yaml:"type"`
+	Path          string            `json:"path" yaml:"path"`
+	Pattern       string            `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	TimePatterns  []string          `json:"time_patterns,omitempty" yaml:"time_patterns,omitempty"`
+	KeysToIgnore  []string          `json:"keys_to_ignore,omitempty" yaml:"keys_to_ignore,omitempty"`
+	Charset       string            `json:"charset,omitempty" yaml:"charset,omitempty"`
+	MaxLineBytes  *int              `json:"max_line_bytes,omitempty" yaml:"max_line_bytes,omitempty"`
+	FromBeginning *bool             `json:"from_beginning,omitempty" yaml:"from_beginning,omitempty"`
+	Reopen        *bool             `json:"reopen,omitempty" yaml:"reopen,omitempty"`
+	Poll          *bool             `json:"poll,omitempty" yaml:"poll,omitempty"`
+	PollInterval  string            `json:"poll_interval,omitempty" yaml:"poll_interval,omitempty"`
+	Command       []string          `json:"command,omitempty" yaml:"command,omitempty"`
+	Restart       *bool             `json:"restart,omitempty" yaml:"restart,omitempty"`
+	URL           string            `json:"url,omitempty" yaml:"url,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	PollEvery     string            `json:"poll_every,omitempty" yaml:"poll_every,omitempty"`
+	Format        string            `json:"format,omitempty" yaml:"format,omitempty"`
+	JSONPath      string            `json:"json_path,omitempty" yaml:"json_path,omitempty"`
+	MessageField  string            `json:"message_field,omitempty" yaml:"message_field,omitempty"`
+	CursorField   string            `json:"cursor_field,omitempty" yaml:"cursor_field,omitempty"`
+	CursorParam   string            `json:"cursor_param,omitempty" yaml:"cursor_param,omitempty"`
+	Address       string            `json:"address,omitempty" yaml:"address,omitempty"`
+	Protocol      string            `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Stream        string            `json:"stream,omitempty" yaml:"stream,omitempty"`
+	Group         string            `json:"group,omitempty" yaml:"group,omitempty"`
+	Driver        string            `json:"driver,omitempty" yaml:"driver,omitempty"`
+	DSN           string            `json:"dsn,omitempty" yaml:"dsn,omitempty"`
+	Query         string            `json:"query,omitempty" yaml:"query,omitempty"`
+	CursorColumn  string            `json:"cursor_column,omitempty" yaml:"cursor_column,omitempty"`
+	Framing       string            `json:"framing,omitempty" yaml:"framing,omitempty"`
+	Delimiter     string            `json:"delimiter,omitempty" yaml:"delimiter,omitempty"`
+	Codec         string            `json:"codec,omitempty" yaml:"codec,omitempty"`
+}
+
+// FilterConfig configures a filter; which settings apply depends on the
+// type (derive, fingerprint_stack_traces, normalize_keys, normalize_levels)
+type FilterConfig struct {
+	Type             string            `json:"type" yaml:"type"`
+	Fields           map[string]string `json:"fields,omitempty" yaml:"fields,omitempty"`
+	Style            string            `json:"style,omitempty" yaml:"style,omitempty"`
+	LevelFields      []string          `json:"level_fields,omitempty" yaml:"level_fields,omitempty"`
+	StackTraceFields []string          `json:"stack_trace_fields,omitempty" yaml:"stack_trace_fields,omitempty"`
+}
+
+// OutputConfig configures an output; which settings apply depends on the
+// type (chat, elasticsearch, email, file, forward, gelf, honeycomb, kinesis,
+// mqtt, null, opsgenie, pagerduty, pulsar, sentry, snowflake, stream, stdout,
+// syslog, timescale, victorialogs, zeromq)
+type OutputConfig struct {
+	Type           string   `json:"type" yaml:"type"`
+	Path           string   `json:"path,omitempty" yaml:"path,omitempty"`
+	Hosts          []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Port           *int     `json:"port,omitempty" yaml:"port,omitempty"`
+	Scheme         string   `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Index          string   `json:"index,omitempty" yaml:"index,omitempty"`
+	DocumentType   string   `json:"document_type,omitempty" yaml:"document_type,omitempty"`
+	Pipeline       string   `json:"pipeline,omitempty" yaml:"pipeline,omitempty"`
+	Routing        string   `json:"routing,omitempty" yaml:"routing,omitempty"`
+	Max            *int     `json:"max,omitempty" yaml:"max,omitempty"`
+	FlushEvery     *int     `json:"flush_every,omitempty" yaml:"flush_every,omitempty"`
+	UseDateSuffix  *bool    `json:"use_date_suffix,omitempty" yaml:"use_date_suffix,omitempty"`
+	Mocking        *bool    `json:"mocking,omitempty" yaml:"mocking,omitempty"`
+	Compress       *bool    `json:"compress,omitempty" yaml:"compress,omitempty"`
+	Stream         string   `json:"stream,omitempty" yaml:"stream,omitempty"`
+	DeliveryStream string   `json:"delivery_stream,omitempty" yaml:"delivery_stream,omitempty"`
+	Region         string   `json:"region,omitempty" yaml:"region,omitempty"`
+	PartitionKey   string   `json:"partition_key,omitempty" yaml:"partition_key,omitempty"`
+	Aggregate      *bool    `json:"aggregate,omitempty" yaml:"aggregate,omitempty"`
+	Broker         string   `json:"broker,omitempty" yaml:"broker,omitempty"`
+	Topic          string   `json:"topic,omitempty" yaml:"topic,omitempty"`
+	QoS            *int     `json:"qos,omitempty" yaml:"qos,omitempty"`
+	Address        string   `json:"address,omitempty" yaml:"address,omitempty"`
+	Protocol       string   `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Schema         string   `json:"schema,omitempty" yaml:"schema,omitempty"`
+	RequiredFields []string `json:"required_fields,omitempty" yaml:"required_fields,omitempty"`
+	DSN            string   `json:"dsn,omitempty" yaml:"dsn,omitempty"`
+	Where          string   `json:"where,omitempty" yaml:"where,omitempty"`
+	Tags           []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	AlertsOnly     *bool    `json:"alerts_only,omitempty" yaml:"alerts_only,omitempty"`
+	DedupKey       string   `json:"dedup_key,omitempty" yaml:"dedup_key,omitempty"`
+	Webhook        string   `json:"webhook,omitempty" yaml:"webhook,omitempty"`
+	Format         string   `json:"format,omitempty" yaml:"format,omitempty"`
+	Template       string   `json:"template,omitempty" yaml:"template,omitempty"`
+	To             []string `json:"to,omitempty" yaml:"to,omitempty"`
+	Immediate      string   `json:"immediate,omitempty" yaml:"immediate,omitempty"`
+	Dataset        string   `json:"dataset,omitempty" yaml:"dataset,omitempty"`
+	Bucket         string   `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	Stage          string   `json:"stage,omitempty" yaml:"stage,omitempty"`
+	Table          string   `json:"table,omitempty" yaml:"table,omitempty"`
+	URL            string   `json:"url,omitempty" yaml:"url,omitempty"`
+	StreamFields   []string `json:"stream_fields,omitempty" yaml:"stream_fields,omitempty"`
+	Key            string   `json:"key,omitempty" yaml:"key,omitempty"`
+	Socket         string   `json:"socket,omitempty" yaml:"socket,omitempty"`
+	TopicField     string   `json:"topic_field,omitempty" yaml:"topic_field,omitempty"`
+}
+
+// PipelineConfig is the structured pipeline configuration
+type PipelineConfig struct {
+	Inputs  []FileInputConfig `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Filters []FilterConfig    `json:"filters,omitempty" yaml:"filters,omitempty"`
+	Outputs []OutputConfig    `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+}
+
+// normalizeConfigValue converts the map[interface{}]interface{} values some
+// YAML decoders produce into map[string]interface{}, so they can be
+// marshaled to JSON
+func normalizeConfigValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeConfigValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeConfigValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = normalizeConfigValue(item)
+		}
+		return l
+	}
+	return value
+}
+
+// decodeStages decodes the list of stages at key into out (a pointer to a
+// slice of stage configs), rejecting unknown settings
+func decodeStages(key string, out interface{}) error {
+	if !viper.IsSet(key) {
+		return nil
+	}
+	bs, err := json.Marshal(normalizeConfigValue(viper.Get(key)))
+	if err != nil {
+		return fmt.Errorf("Invalid %s: %v", key, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("Invalid %s: %v", key, err)
+	}
+	return nil
+}
+
+// HasPipelineConfig returns true if a structured pipeline is configured
+func HasPipelineConfig() bool {
+	return viper.IsSet("inputs") || viper.IsSet("filters") || viper.IsSet("outputs")
+}
+
+// LoadPipelineConfig reads and validates the structured pipeline
+// configuration
+func LoadPipelineConfig() (p *PipelineConfig, err error) {
+	p = &PipelineConfig{}
+	for key, out := range map[string]interface{}{"inputs": &p.Inputs, "filters": &p.Filters, "outputs": &p.Outputs} {
+		if err = decodeStages(key, out); err != nil {
+			return
+		}
+	}
+	err = p.Validate()
+	return
+}
+
+// Validate checks the pipeline configuration
+func (p *PipelineConfig) Validate() error {
+	errors := make([]string, 0)
+	if len(p.Inputs) > 1 {
+		errors = append(errors, "only one input is supported")
+	}
+	for i, input := range p.Inputs {
+		switch input.Type {
+		case "file", "unix", "fifo":
+			if input.Path == "" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: path is required", i))
+			}
+		case "exec":
+			if len(input.Command) == 0 {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: command is required", i))
+			}
+		case "http":
+			if input.URL == "" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: url is required", i))
+			}
+			if input.Format != "" && input.Format != "lines" && input.Format != "json" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: format must be lines or json", i))
+			}
+		case "gelf":
+			if input.Protocol != "" && input.Protocol != "udp" && input.Protocol != "tcp" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: protocol must be udp or tcp", i))
+			}
+		case "redis":
+			if input.Stream == "" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: stream is required", i))
+			}
+		case "sql":
+			if input.Query == "" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: query is required", i))
+			}
+			if input.Driver != "" && input.Driver != "postgres" && input.Driver != "mysql" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: driver must be postgres or mysql", i))
+			}
+		case "forward":
+		default:
+			errors = append(errors, fmt.Sprintf("inputs[%d]: unknown type %q", i, input.Type))
+		}
+		switch input.Framing {
+		case "", "lines", "null", "octet", "uint32":
+		case "delimiter", "regexp":
+			if input.Delimiter == "" {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: delimiter is required", i))
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("inputs[%d]: framing must be lines, null, delimiter, regexp, octet, or uint32", i))
+		}
+		switch input.Codec {
+		case "", "regex", "grok", "json", "csv", "logfmt", "protobuf":
+		default:
+			errors = append(errors, fmt.Sprintf("inputs[%d]: codec must be regex, grok, json, csv, logfmt, or protobuf", i))
+		}
+		if input.Pattern != "" && input.Codec != "grok" {
+			if _, err := regexp.Compile(input.Pattern); err != nil {
+				errors = append(errors, fmt.Sprintf("inputs[%d]: invalid pattern: %v", i, err))
+			}
+		}
+	}
+	for i, filter := range p.Filters {
+		switch filter.Type {
+		case "derive":
+			if len(filter.Fields) == 0 {
+				errors = append(errors, fmt.Sprintf("filters[%d]: fields are required", i))
+			}
+		case "normalize_keys":
+			if filter.Style != "snake_case" && filter.Style != "camelCase" && filter.Style != "lower" {
+				errors = append(errors, fmt.Sprintf("filters[%d]: style must be snake_case, camelCase or lower", i))
+			}
+		case "normalize_levels", "fingerprint_stack_traces":
+		default:
+			errors = append(errors, fmt.Sprintf("filters[%d]: unknown type %q", i, filter.Type))
+		}
+	}
+	if len(p.Outputs) > 1 {
+		errors = append(errors, "only one output is supported")
+	}
+	for i, output := range p.Outputs {
+		if output.Schema != "" && output.Schema != "ecs" {
+			errors = append(errors, fmt.Sprintf("outputs[%d]: schema must be ecs", i))
+		}
+		switch output.Type {
+		case "elasticsearch", "forward", "honeycomb", "null", "stream", "stdout", "timescale", "victorialogs":
+		case "email":
+			if len(output.To) == 0 {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: to is required", i))
+			}
+			for _, expr := range []string{output.Where, output.Immediate} {
+				if _, err := worker.CompileCondition(expr); err != nil {
+					errors = append(errors, fmt.Sprintf("outputs[%d]: %v", i, err))
+				}
+			}
+		case "chat", "opsgenie", "pagerduty", "sentry":
+			if output.Type == "chat" && output.Format != "" && output.Format != "slack" && output.Format != "teams" {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: format must be slack or teams", i))
+			}
+			if output.Where != "" {
+				if _, err := worker.CompileCondition(output.Where); err != nil {
+					errors = append(errors, fmt.Sprintf("outputs[%d]: where: %v", i, err))
+				}
+			}
+		case "mqtt":
+			if output.QoS != nil && (*output.QoS < 0 || *output.QoS > 2) {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: qos must be 0, 1, or 2", i))
+			}
+		case "gelf":
+			if output.Protocol != "" && output.Protocol != "udp" && output.Protocol != "tcp" {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: protocol must be udp or tcp", i))
+			}
+		case "syslog":
+			switch output.Protocol {
+			case "", "tcp", "tls", "relp", "relp+tls":
+			default:
+				errors = append(errors, fmt.Sprintf("outputs[%d]: protocol must be tcp, tls, relp, or relp+tls", i))
+			}
+		case "kinesis":
+			if (output.Stream == "") == (output.DeliveryStream == "") {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: either stream or delivery_stream is required", i))
+			}
+		case "snowflake":
+			if output.Bucket == "" {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: bucket is required", i))
+			}
+			if output.Stage == "" || output.Table == "" {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: stage and table are required", i))
+			}
+		case "zeromq":
+			if socket := strings.ToLower(output.Socket); socket != "" && socket != "pub" && socket != "push" {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: socket must be pub or push", i))
+			}
+		case "pulsar":
+			if output.Topic != "" {
+				if _, err := worker.PulsarTopicPath(output.Topic); err != nil {
+					errors = append(errors, fmt.Sprintf("outputs[%d]: %v", i, err))
+				}
+			}
+		case "file":
+			if output.Path == "" {
+				errors = append(errors, fmt.Sprintf("outputs[%d]: path is required", i))
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("outputs[%d]: unknown type %q", i, output.Type))
+		}
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("Invalid pipeline configuration: %s", strings.Join(errors, "; "))
+	}
+	return nil
+}
+
+func setIfPresent(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			viper.Set(key, v)
+		}
+	case []string:
+		if v != nil {
+			viper.Set(key, v)
+		}
+	case *int:
+		if v != nil {
+			viper.Set(key, *v)
+		}
+	case *bool:
+		if v != nil {
+			viper.Set(key, *v)
+		}
+	}
+}
+
+// Apply sets the flat configuration keys equivalent to the pipeline
+func (p *PipelineConfig) Apply() {
+	for _, input := range p.Inputs {
+		setIfPresent("input.type", input.Type)
+		setIfPresent("parse.input_file", input.Path)
+		setIfPresent("parse.pattern", input.Pattern)
+		setIfPresent("parse.time_patterns", input.TimePatterns)
+		setIfPresent("parse.keys_to_ignore", input.KeysToIgnore)
+		setIfPresent("parse.charset", input.Charset)
+		setIfPresent("parse.max_line_bytes", input.MaxLineBytes)
+		setIfPresent("tail.from_beginning", input.FromBeginning)
+		setIfPresent("tail.reopen", input.Reopen)
+		setIfPresent("tail.poll", input.Poll)
+		setIfPresent("tail.poll_interval", input.PollInterval)
+		setIfPresent("input.command", input.Command)
+		setIfPresent("input.restart", input.Restart)
+		setIfPresent("input.url", input.URL)
+		if len(input.Headers) > 0 {
+			viper.Set("input.headers", input.Headers)
+		}
+		setIfPresent("input.poll_every", input.PollEvery)
+		setIfPresent("input.format", input.Format)
+		setIfPresent("input.json_path", input.JSONPath)
+		setIfPresent("input.message_field", input.MessageField)
+		setIfPresent("input.cursor_field", input.CursorField)
+		setIfPresent("input.cursor_param", input.CursorParam)
+		setIfPresent("input.protocol", input.Protocol)
+		if input.Type == "redis" {
+			setIfPresent("input.redis.address", input.Address)
+			setIfPresent("input.redis.stream", input.Stream)
+			setIfPresent("input.redis.group", input.Group)
+		} else {
+			setIfPresent("input.address", input.Address)
+		}
+		setIfPresent("input.sql.driver", input.Driver)
+		setIfPresent("input.sql.dsn", input.DSN)
+		setIfPresent("input.sql.query", input.Query)
+		setIfPresent("input.sql.cursor_column", input.CursorColumn)
+		setIfPresent("input.framing", input.Framing)
+		setIfPresent("input.delimiter", input.Delimiter)
+		setIfPresent("input.codec", input.Codec)
+	}
+	derive := make(map[string]interface{})
+	for _, filter := range p.Filters {
+		switch filter.Type {
+		case "derive":
+			for key, expr := range filter.Fields {
+				derive[key] = expr
+			}
+		case "normalize_keys":
+			viper.Set("transform.normalize_keys", filter.Style)
+		case "normalize_levels":
+			viper.Set("transform.level.normalize", true)
+			setIfPresent("transform.level.fields", filter.LevelFields)
+		case "fingerprint_stack_traces":
+			viper.Set("transform.stack_trace.fingerprint", true)
+			setIfPresent("transform.stack_trace.fields", filter.StackTraceFields)
+		}
+	}
+	if len(derive) > 0 {
+		viper.Set("transform.derive", derive)
+	}
+	for _, output := range p.Outputs {
+		setIfPresent("output.schema", output.Schema)
+		switch output.Type {
+		case "file":
+			setIfPresent("file.output", output.Path)
+		case "elasticsearch":
+			setIfPresent("es.hosts", output.Hosts)
+			setIfPresent("es.port", output.Port)
+			setIfPresent("es.scheme", output.Scheme)
+			setIfPresent("es.index", output.Index)
+			setIfPresent("es.document_type", output.DocumentType)
+			setIfPresent("es.pipeline", output.Pipeline)
+			setIfPresent("es.routing", output.Routing)
+			setIfPresent("es.max", output.Max)
+			setIfPresent("es.flush_every", output.FlushEvery)
+			setIfPresent("es.use_date_suffix", output.UseDateSuffix)
+			setIfPresent("es.mocking", output.Mocking)
+			setIfPresent("es.compress", output.Compress)
+		case "gelf":
+			setIfPresent("gelf.address", output.Address)
+			setIfPresent("gelf.protocol", output.Protocol)
+		case "syslog":
+			setIfPresent("syslog.address", output.Address)
+			setIfPresent("syslog.protocol", output.Protocol)
+		case "kinesis":
+			setIfPresent("kinesis.stream", output.Stream)
+			setIfPresent("kinesis.delivery_stream", output.DeliveryStream)
+			setIfPresent("kinesis.region", output.Region)
+			setIfPresent("kinesis.partition_key", output.PartitionKey)
+			setIfPresent("kinesis.aggregate", output.Aggregate)
+		case "mqtt":
+			setIfPresent("mqtt.broker", output.Broker)
+			setIfPresent("mqtt.topic", output.Topic)
+			setIfPresent("mqtt.qos", output.QoS)
+		case "stream":
+			setIfPresent("stream.address", output.Address)
+		case "forward":
+			setIfPresent("forward.address", output.Address)
+			setIfPresent("forward.compress", output.Compress)
+			setIfPresent("forward.max", output.Max)
+		case "null":
+			setIfPresent("null.required_fields", output.RequiredFields)
+		case "sentry":
+			setIfPresent("sentry.dsn", output.DSN)
+			setIfPresent("sentry.where", output.Where)
+			setIfPresent("sentry.tags", output.Tags)
+		case "chat":
+			setIfPresent("chat.webhook", output.Webhook)
+			setIfPresent("chat.format", output.Format)
+			setIfPresent("chat.where", output.Where)
+			setIfPresent("chat.template", output.Template)
+		case "email":
+			setIfPresent("email.to", output.To)
+			setIfPresent("email.where", output.Where)
+			setIfPresent("email.immediate", output.Immediate)
+			setIfPresent("email.template", output.Template)
+		case "honeycomb":
+			setIfPresent("honeycomb.dataset", output.Dataset)
+			setIfPresent("honeycomb.max", output.Max)
+		case "pagerduty":
+			setIfPresent("pagerduty.where", output.Where)
+			setIfPresent("pagerduty.alerts_only", output.AlertsOnly)
+			setIfPresent("pagerduty.dedup_key", output.DedupKey)
+		case "opsgenie":
+			setIfPresent("opsgenie.where", output.Where)
+			setIfPresent("opsgenie.alerts_only", output.AlertsOnly)
+			setIfPresent("opsgenie.alias", output.DedupKey)
+		case "snowflake":
+			setIfPresent("snowflake.bucket", output.Bucket)
+			setIfPresent("snowflake.region", output.Region)
+			setIfPresent("snowflake.stage", output.Stage)
+			setIfPresent("snowflake.table", output.Table)
+			setIfPresent("snowflake.max", output.Max)
+		case "timescale":
+			setIfPresent("timescale.dsn", output.DSN)
+			setIfPresent("timescale.table", output.Table)
+			setIfPresent("timescale.max", output.Max)
+		case "pulsar":
+			setIfPresent("pulsar.url", output.URL)
+			setIfPresent("pulsar.topic", output.Topic)
+			setIfPresent("pulsar.key", output.Key)
+			setIfPresent("pulsar.max", output.Max)
+		case "zeromq":
+			setIfPresent("zeromq.address", output.Address)
+			setIfPresent("zeromq.socket", output.Socket)
+			setIfPresent("zeromq.topic_field", output.TopicField)
+		case "victorialogs":
+			setIfPresent("victorialogs.url", output.URL)
+			setIfPresent("victorialogs.stream_fields", output.StreamFields)
+			setIfPresent("victorialogs.max", output.Max)
+		}
+	}
+}
+
+// Sink creates the worker for the pipeline's output, or nil if there is no
+// output configured
+func (p *PipelineConfig) Sink() worker.Worker {
+	if len(p.Outputs) == 0 {
+		return nil
+	}
+	switch p.Outputs[0].Type {
+	case "chat":
+		return &worker.ChatWorker{}
+	case "elasticsearch":
+		return &worker.ElasticSearchWorker{}
+	case "email":
+		return &worker.EmailWorker{}
+	case "file":
+		return &worker.FileWorker{}
+	case "forward":
+		return &worker.ForwardWorker{}
+	case "gelf":
+		return &worker.GELFWorker{}
+	case "honeycomb":
+		return &worker.HoneycombWorker{}
+	case "kinesis":
+		return &worker.KinesisWorker{}
+	case "mqtt":
+		return &worker.MQTTWorker{}
+	case "null":
+		return &worker.NullWorker{}
+	case "opsgenie", "pagerduty":
+		return &worker.PagingWorker{Service: p.Outputs[0].Type}
+	case "pulsar":
+		return &worker.PulsarWorker{}
+	case "sentry":
+		return &worker.SentryWorker{}
+	case "snowflake":
+		return &worker.SnowflakeWorker{}
+	case "stream":
+		return &worker.StreamWorker{}
+	case "syslog":
+		return &worker.SyslogWorker{}
+	case "timescale":
+		return &worker.TimescaleWorker{}
+	case "victorialogs":
+		return &worker.VictoriaLogsWorker{}
+	case "zeromq":
+		return &worker.ZeroMQWorker{}
+	}
+	return &worker.StdOutWorker{}
+}
@@ -0,0 +1,88 @@
+package run_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
+	"github.com/willf/translog/worker"
+)
+
+var pipelineConfig = []byte(`
+inputs:
+  - type: file
+    path: /var/log/nginx/access.log
+    pattern: '(?P<ip>\S+) (?P<status>\d+)'
+    from_beginning: true
+filters:
+  - type: derive
+    fields:
+      status_class: classify(status)
+  - type: normalize_keys
+    style: snake_case
+outputs:
+  - type: elasticsearch
+    hosts: [es1, es2]
+    index: nginx
+`)
+
+func TestLoadPipelineConfig(t *testing.T) {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.ReadConfig(bytes.NewBuffer(pipelineConfig))
+	viper.Set("es.port", 9400)
+	if !run.HasPipelineConfig() {
+		t.Fatalf("expected a pipeline configuration")
+	}
+	p, err := run.LoadPipelineConfig()
+	if err != nil {
+		t.Fatalf("expected a valid pipeline, got %v", err)
+	}
+	p.Apply()
+	if viper.GetString("parse.input_file") != "/var/log/nginx/access.log" {
+		t.Errorf("expected input file to be set, got %v", viper.GetString("parse.input_file"))
+	}
+	if !viper.GetBool("tail.from_beginning") {
+		t.Errorf("expected from_beginning to be set")
+	}
+	if viper.GetStringMapString("transform.derive")["status_class"] != "classify(status)" {
+		t.Errorf("expected derived field to be set, got %v", viper.GetStringMapString("transform.derive"))
+	}
+	if worker.ConfiguredElasticSearchIndex() != "nginx" || worker.ConfiguredElasticSearchHosts()[1] != "es2" {
+		t.Errorf("expected elasticsearch settings to be applied")
+	}
+	if worker.ConfiguredElasticSearchPort() != 9400 {
+		t.Errorf("expected flat keys not in the pipeline to be kept, got port %v", worker.ConfiguredElasticSearchPort())
+	}
+	if _, ok := p.Sink().(*worker.ElasticSearchWorker); !ok {
+		t.Errorf("expected an elasticsearch sink, got %T", p.Sink())
+	}
+}
+
+func TestLoadPipelineConfigInvalid(t *testing.T) {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.ReadConfig(strings.NewReader(`
+inputs:
+  - type: file
+    pattern: '(?P<unclosed'
+    colour: blue
+`))
+	if _, err := run.LoadPipelineConfig(); err == nil || !strings.Contains(err.Error(), "colour") {
+		t.Errorf("expected an unknown field error, got %v", err)
+	}
+	viper.Reset()
+	viper.Set("inputs", []interface{}{map[string]interface{}{"type": "socket"}})
+	viper.Set("outputs", []interface{}{map[string]interface{}{"type": "file"}})
+	_, err := run.LoadPipelineConfig()
+	if err == nil {
+		t.Fatalf("expected validation errors")
+	}
+	for _, expected := range []string{`unknown type "socket"`, "path is required"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to mention %s, got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package run
+
+import (
+	"expvar"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+)
+
+const configAdminPprof = "admin.pprof"
+
+// expvarOnce publishes the statistics as an expvar variable once; expvar
+// panics on duplicate names
+var expvarOnce sync.Once
+
+// expvarAdmin is the admin whose statistics are published
+var expvarAdmin atomic.Value
+
+// handleDebug adds the net/http/pprof profiles (/debug/pprof/) and the expvar
+// variables (/debug/vars, including the statistics, as "translog") to the
+// admin API
+func (a *Admin) handleDebug() {
+	expvarAdmin.Store(a)
+	expvarOnce.Do(func() {
+		expvar.Publish("translog", expvar.Func(func() interface{} {
+			return expvarAdmin.Load().(*Admin).CurrentStats()
+		}))
+	})
+	a.Mux.HandleFunc("/debug/pprof/", pprof.Index)
+	a.Mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.Mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.Mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.Mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	a.Mux.Handle("/debug/vars", expvar.Handler())
+}
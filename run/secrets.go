@@ -0,0 +1,487 @@
+package run
+
+/*
+	secrets.go resolves configuration references from secrets managers
+
+	Besides environment variables and files (see interpolate.go), a
+	configuration string may refer to a secret kept in a secrets manager:
+
+		${vault:secret/data/mqtt#password}       HashiCorp Vault
+		${aws:prod/mqtt#password}                AWS Secrets Manager
+		${gcp:projects/my-project/secrets/mqtt}  GCP Secret Manager
+
+	After the #, the field of the secret to use; secrets that are JSON
+	objects (as Vault's always are) need one, unless they have a single
+	field. Vault's KV version 2 secrets are unwrapped.
+
+	The providers are configured in secrets.vault (address and token,
+	VAULT_ADDR and VAULT_TOKEN by default), secrets.aws (region, AWS_REGION
+	by default; credentials are taken, as for the kinesis output, from the
+	usual AWS environment variables, shared credentials file, or instance
+	role) and secrets.gcp (the access token, GOOGLE_OAUTH_ACCESS_TOKEN by
+	default, or else one from the metadata server).
+
+	While translog runs, Vault leases are renewed when two thirds of their
+	duration have passed, and secrets are read again when their lease can't
+	be renewed, and every secrets.refresh otherwise, so that rotated
+	credentials are picked up: they are published with
+	worker.PublishRefreshed, rather than written to the configuration, and
+	outputs use them when they next connect.
+*/
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+const configSecretsRefresh = "secrets.refresh"
+const configSecretsVaultAddress = "secrets.vault.address"
+const configSecretsVaultToken = "secrets.vault.token"
+const configSecretsAWSRegion = "secrets.aws.region"
+const configSecretsAWSEndpoint = "secrets.aws.endpoint"
+const configSecretsGCPToken = "secrets.gcp.token"
+const configSecretsGCPEndpoint = "secrets.gcp.endpoint"
+
+// secretsTimeout is how long a request to a secrets manager may take
+const secretsTimeout = 10 * time.Second
+
+// gcpMetadataTokenURL is where GCP instances get access tokens from
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// ConfiguredSecretsRefresh returns how often secrets are read again
+func ConfiguredSecretsRefresh() time.Duration {
+	if viper.IsSet(configSecretsRefresh) {
+		return viper.GetDuration(configSecretsRefresh)
+	}
+	return time.Hour
+}
+
+// secret is a value read from a secrets manager
+type secret struct {
+	value     string
+	leaseID   string        // Vault's lease, if any
+	lease     time.Duration // how long the value is valid, if it has a lease
+	renewable bool
+}
+
+// SecretProvider reads secrets from a secrets manager
+type SecretProvider interface {
+	// Read reads the secret at path
+	Read(path string) (secret, error)
+	// Renew renews the lease of a secret, returning its new duration
+	Renew(leaseID string) (time.Duration, error)
+}
+
+// secretProviders are the secrets managers, by their reference prefix
+var secretProviders = map[string]func() SecretProvider{
+	"vault": func() SecretProvider { return newVaultProvider() },
+	"aws":   func() SecretProvider { return newAWSProvider() },
+	"gcp":   func() SecretProvider { return newGCPProvider() },
+}
+
+// configOrEnv returns the configured value, or the environment variable
+func configOrEnv(key string, env string) string {
+	if value := viper.GetString(key); value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// secretsClient is the HTTP client for secrets managers
+var secretsClient = &http.Client{Timeout: secretsTimeout}
+
+// doJSON sends a request, and decodes the JSON response into v
+func doJSON(req *http.Request, v interface{}) error {
+	resp, err := secretsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// secretField picks the field of a secret; a value that isn't a JSON object
+// is returned as it is, when no field is asked for
+func secretField(value string, data map[string]interface{}, field string) (string, error) {
+	if data == nil && value != "" {
+		if json.Unmarshal([]byte(value), &data) != nil || data == nil {
+			if field != "" {
+				return "", fmt.Errorf("secret is not a JSON object, so has no field %s", field)
+			}
+			return value, nil
+		}
+	}
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("secret has %d fields; choose one with #field", len(data))
+		}
+		for key := range data {
+			field = key
+		}
+	}
+	v, found := data[field]
+	if !found {
+		return "", fmt.Errorf("secret has no field %s", field)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	bs, err := json.Marshal(v)
+	return string(bs), err
+}
+
+// vaultProvider reads secrets from HashiCorp Vault
+type vaultProvider struct {
+	address string
+	token   string
+}
+
+func newVaultProvider() *vaultProvider {
+	return &vaultProvider{
+		address: strings.TrimSuffix(configOrEnv(configSecretsVaultAddress, "VAULT_ADDR"), "/"),
+		token:   configOrEnv(configSecretsVaultToken, "VAULT_TOKEN"),
+	}
+}
+
+func (p *vaultProvider) request(method string, path string, body interface{}) (*http.Request, error) {
+	if p.address == "" {
+		return nil, fmt.Errorf("no Vault address; set secrets.vault.address or VAULT_ADDR")
+	}
+	var bs []byte
+	if body != nil {
+		bs, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, p.address+"/v1/"+strings.TrimPrefix(path, "/"), bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	return req, nil
+}
+
+func (p *vaultProvider) Read(path string) (s secret, err error) {
+	req, err := p.request("GET", path, nil)
+	if err != nil {
+		return
+	}
+	var resp struct {
+		LeaseID       string                 `json:"lease_id"`
+		LeaseDuration int64                  `json:"lease_duration"`
+		Renewable     bool                   `json:"renewable"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err = doJSON(req, &resp); err != nil {
+		return
+	}
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			// a KV version 2 secret
+			data = nested
+		}
+	}
+	bs, _ := json.Marshal(data)
+	return secret{value: string(bs), leaseID: resp.LeaseID, lease: time.Duration(resp.LeaseDuration) * time.Second, renewable: resp.Renewable}, nil
+}
+
+func (p *vaultProvider) Renew(leaseID string) (time.Duration, error) {
+	req, err := p.request("PUT", "sys/leases/renew", map[string]string{"lease_id": leaseID})
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		LeaseDuration int64 `json:"lease_duration"`
+	}
+	err = doJSON(req, &resp)
+	return time.Duration(resp.LeaseDuration) * time.Second, err
+}
+
+// awsProvider reads secrets from AWS Secrets Manager
+type awsProvider struct {
+	region   string
+	endpoint string
+}
+
+func newAWSProvider() *awsProvider {
+	return &awsProvider{region: configOrEnv(configSecretsAWSRegion, "AWS_REGION"), endpoint: viper.GetString(configSecretsAWSEndpoint)}
+}
+
+// client creates the Secrets Manager client, with the same credential chain
+// as the kinesis output
+func (p *awsProvider) client() (*secretsmanager.SecretsManager, error) {
+	config := aws.NewConfig().WithHTTPClient(secretsClient)
+	if p.region != "" {
+		config = config.WithRegion(p.region)
+	}
+	if p.endpoint != "" {
+		config = config.WithEndpoint(p.endpoint)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Config: *config, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		return nil, fmt.Errorf("no AWS region; set secrets.aws.region or AWS_REGION")
+	}
+	return secretsmanager.New(sess), nil
+}
+
+func (p *awsProvider) Read(path string) (s secret, err error) {
+	client, err := p.client()
+	if err != nil {
+		return
+	}
+	resp, err := client.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(path)})
+	if err != nil {
+		return
+	}
+	s.value = aws.StringValue(resp.SecretString)
+	if s.value == "" {
+		s.value = string(resp.SecretBinary)
+	}
+	return
+}
+
+func (p *awsProvider) Renew(leaseID string) (time.Duration, error) {
+	return 0, fmt.Errorf("AWS secrets have no leases")
+}
+
+// gcpProvider reads secrets from GCP Secret Manager
+type gcpProvider struct {
+	token    string
+	endpoint string
+}
+
+func newGCPProvider() *gcpProvider {
+	p := &gcpProvider{token: configOrEnv(configSecretsGCPToken, "GOOGLE_OAUTH_ACCESS_TOKEN"), endpoint: viper.GetString(configSecretsGCPEndpoint)}
+	if p.endpoint == "" {
+		p.endpoint = "https://secretmanager.googleapis.com"
+	}
+	return p
+}
+
+// accessToken returns the configured token, or one from the metadata server
+func (p *gcpProvider) accessToken() (string, error) {
+	if p.token != "" {
+		return p.token, nil
+	}
+	req, err := http.NewRequest("GET", gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		return "", fmt.Errorf("no GCP access token; set secrets.gcp.token or GOOGLE_OAUTH_ACCESS_TOKEN (%v)", err)
+	}
+	return resp.AccessToken, nil
+}
+
+func (p *gcpProvider) Read(path string) (s secret, err error) {
+	if !strings.Contains(path, "/versions/") {
+		path += "/versions/latest"
+	}
+	token, err := p.accessToken()
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("GET", p.endpoint+"/v1/"+strings.TrimPrefix(path, "/")+":access", nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err = doJSON(req, &resp); err != nil {
+		return
+	}
+	bs, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	s.value = string(bs)
+	return
+}
+
+func (p *gcpProvider) Renew(leaseID string) (time.Duration, error) {
+	return 0, fmt.Errorf("GCP secrets have no leases")
+}
+
+// secretUse is a configuration key whose value refers to secrets
+type secretUse struct {
+	key      string
+	template interface{}               // the value with its references
+	provider map[string]SecretProvider // of each renewable lease, by lease id
+	renewAt  time.Time                 // when the leases are renewed (or the secrets read again), if they expire
+	readAt   time.Time
+}
+
+// secretUses are the configuration keys referring to secrets
+var secretUses = struct {
+	sync.Mutex
+	uses map[string]*secretUse
+}{uses: make(map[string]*secretUse)}
+
+// providers are the secrets managers in use
+var providers = struct {
+	sync.Mutex
+	m map[string]SecretProvider
+}{m: make(map[string]SecretProvider)}
+
+// providerFor returns the secrets manager of a prefix, if there is one
+func providerFor(prefix string) (SecretProvider, bool) {
+	newProvider, ok := secretProviders[prefix]
+	if !ok {
+		return nil, false
+	}
+	providers.Lock()
+	defer providers.Unlock()
+	if providers.m[prefix] == nil {
+		providers.m[prefix] = newProvider()
+	}
+	return providers.m[prefix], true
+}
+
+// readSecret reads a secret reference, such as vault:secret/data/mqtt#password,
+// returning the secret with the chosen field as its value
+func readSecret(provider SecretProvider, reference string) (secret, error) {
+	path, field := reference, ""
+	if i := strings.LastIndex(reference, "#"); i >= 0 {
+		path, field = reference[:i], reference[i+1:]
+	}
+	s, err := provider.Read(path)
+	if err != nil {
+		return s, err
+	}
+	s.value, err = secretField(s.value, nil, field)
+	return s, err
+}
+
+// resetSecretProviders forgets the secrets managers, the keys referring to
+// them, and their refreshed values, so that they are configured again
+func resetSecretProviders() {
+	providers.Lock()
+	providers.m = make(map[string]SecretProvider)
+	providers.Unlock()
+	secretUses.Lock()
+	secretUses.uses = make(map[string]*secretUse)
+	secretUses.Unlock()
+	worker.ResetRefreshed()
+}
+
+// renewSecrets renews the leases of secrets, and reads them again when
+// needed, until the process ends
+func renewSecrets() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		secretUses.Lock()
+		uses := make([]*secretUse, 0, len(secretUses.uses))
+		for _, use := range secretUses.uses {
+			uses = append(uses, use)
+		}
+		secretUses.Unlock()
+		for _, use := range uses {
+			use.maintain(now)
+		}
+	}
+}
+
+// maintain renews the leases of a key's secrets when they are due, and
+// reads them again when they can't be renewed, or when it's time to
+func (use *secretUse) maintain(now time.Time) {
+	refresh := ConfiguredSecretsRefresh()
+	reread := refresh > 0 && now.Sub(use.readAt) >= refresh
+	if !use.renewAt.IsZero() && !now.Before(use.renewAt) {
+		// a lease that can't be renewed expires: read the secret again
+		reread = reread || len(use.provider) == 0
+		shortest := time.Duration(0)
+		for leaseID, provider := range use.provider {
+			lease, err := provider.Renew(leaseID)
+			if err != nil || lease <= 0 {
+				logs.Warn("Could not renew the lease of %s; reading it again: %v", use.key, err)
+				reread = true
+				break
+			}
+			if shortest == 0 || lease < shortest {
+				shortest = lease
+			}
+		}
+		use.renewAt = now.Add(shortest * 2 / 3)
+	}
+	if !reread {
+		return
+	}
+	value, _, fresh, err := resolve(use.key, use.template, now)
+	if err != nil {
+		worker.Counters.Inc("secret_refresh_failures")
+		logs.Warn("Could not read the secrets of %s again; keeping the current value: %v", use.key, err)
+		use.readAt = now // try again after the next refresh interval
+		return
+	}
+	use.provider, use.renewAt, use.readAt = fresh.provider, fresh.renewAt, fresh.readAt
+	// the configuration isn't safe to change while workers read it
+	worker.PublishRefreshed(use.key, value)
+	worker.Counters.Inc("secret_refreshes")
+}
+
+// resolve fills in the references in the value of a key, returning whether
+// it changed, and how to keep its secrets fresh, if it has any
+func resolve(key string, template interface{}, now time.Time) (interface{}, bool, *secretUse, error) {
+	use := &secretUse{key: key, template: template, provider: make(map[string]SecretProvider), readAt: now}
+	shortest := time.Duration(0)
+	secrets := 0
+	value, changed, err := interpolateValue(template, func(s secret, provider SecretProvider) {
+		secrets++
+		if s.leaseID != "" && s.renewable {
+			use.provider[s.leaseID] = provider
+		}
+		if s.lease > 0 && (shortest == 0 || s.lease < shortest) {
+			shortest = s.lease
+		}
+	})
+	if err != nil || secrets == 0 {
+		return value, changed, nil, err
+	}
+	if shortest > 0 {
+		use.renewAt = now.Add(shortest * 2 / 3)
+	}
+	return value, true, use, nil
+}
+
+// hasSecrets returns true if any configuration key refers to secrets
+func hasSecrets() bool {
+	secretUses.Lock()
+	defer secretUses.Unlock()
+	return len(secretUses.uses) > 0
+}
+
+// StartSecretRenewal keeps the secrets in the configuration fresh
+func StartSecretRenewal() {
+	if hasSecrets() {
+		go worker.Supervise("secrets", renewSecrets)
+	}
+}
@@ -0,0 +1,102 @@
+package run_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/run"
+)
+
+func TestSecretsManagers(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/mqtt" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_duration": 0,
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"username": "translog", "password": "hunter2"},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	defer vault.Close()
+	gcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ya29.test" || r.URL.Path != "/v1/projects/p/secrets/es-host/versions/latest:access" {
+			http.Error(w, "{}", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"payload": map[string]interface{}{"data": base64.StdEncoding.EncodeToString([]byte("es.example.com"))},
+		})
+	}))
+	defer gcp.Close()
+	aws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") ||
+			r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || !strings.Contains(string(body), `"prod/kinesis"`) {
+			http.Error(w, "{}", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"SecretString": `{"key":"abc"}`})
+	}))
+	defer aws.Close()
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	token := filepath.Join(t.TempDir(), "vault_token")
+	ioutil.WriteFile(token, []byte("s.root\n"), 0600)
+
+	viper.Reset()
+	viper.Set("secrets.vault.address", vault.URL)
+	viper.Set("secrets.vault.token", "${file:"+token+"}")
+	viper.Set("secrets.gcp.endpoint", gcp.URL)
+	viper.Set("secrets.gcp.token", "ya29.test")
+	viper.Set("secrets.aws.region", "us-east-1")
+	viper.Set("secrets.aws.endpoint", aws.URL)
+	viper.Set("mqtt.username", "${vault:secret/data/mqtt#username}")
+	viper.Set("mqtt.password", "${vault:secret/data/mqtt#password}")
+	viper.Set("es.host", "${gcp:projects/p/secrets/es-host}")
+	viper.Set("kinesis.key", "${aws:prod/kinesis#key}")
+	if err := run.InterpolateConfig(); err != nil {
+		t.Fatalf("InterpolateConfig(): expected no error, actual %v", err)
+	}
+	var secretsTestCases = []struct {
+		key      string
+		expected string
+	}{
+		{"mqtt.username", "translog"},
+		{"mqtt.password", "hunter2"},
+		{"es.host", "es.example.com"},
+		{"kinesis.key", "abc"},
+	}
+	for i, tt := range secretsTestCases {
+		if actual := viper.GetString(tt.key); actual != tt.expected {
+			t.Errorf("In test %d, %s: expected %v, actual %v", i, tt.key, tt.expected, actual)
+		}
+	}
+
+	for _, reference := range []string{"${vault:secret/data/missing#password}", "${vault:secret/data/mqtt}", "${vault:secret/data/mqtt#nope}"} {
+		viper.Reset()
+		viper.Set("secrets.vault.address", vault.URL)
+		viper.Set("secrets.vault.token", "s.root")
+		viper.Set("mqtt.password", reference)
+		if err := run.InterpolateConfig(); err == nil {
+			t.Errorf("InterpolateConfig() with %s: expected an error, actual none", reference)
+		}
+	}
+}
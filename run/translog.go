@@ -79,6 +79,25 @@ func createPidFile(pidFileName string) (pidFile *os.File, err error) {
 	return
 }
 
+// connect connects the parser to the sink, and initializes both
+func connect(logWorker *worker.LogParser, sink worker.Worker, work chan map[string]interface{}) {
+	logWorker.SetWorkChannel(work)
+	logWorker.Init()
+
+	sink.SetWorkChannel(work)
+	if batchWorker, ok := sink.(worker.BatchWorker); ok {
+		batches := make(chan []map[string]interface{})
+		logWorker.SetBatchChannel(batches)
+		batchWorker.SetBatchChannel(batches)
+	}
+	if eventWorker, ok := sink.(worker.EventWorker); ok {
+		events := make(chan *worker.Event)
+		logWorker.SetEventChannel(events)
+		eventWorker.SetEventChannel(events)
+	}
+	sink.Init()
+}
+
 func Run(sink worker.Worker) {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	if viper.IsSet(configLogFile) {
@@ -107,23 +126,47 @@ func Run(sink worker.Worker) {
 	// create the channels
 
 	work := make(chan map[string]interface{})
+	go logErrors(worker.Errors())
+	StartSecretRenewal()
 
 	logWorker := &worker.LogParser{}
+	connect(logWorker, sink, work)
 
-	logWorker.SetWorkChannel(work)
-	logWorker.Init()
-
-	sink.SetWorkChannel(work)
-	sink.Init()
-
-	go logWorker.Start()
+	go worker.Supervise("LogParser", logWorker.Start)
 	go sink.Start()
 
+	admin := NewAdmin(logWorker, sink)
+	if address := viper.GetString(configAdminAddress); address != "" {
+		go func() {
+			if err := admin.ListenAndServe(address); err != nil {
+				logs.Warn("Unable to start admin API on %s: %v", address, err)
+			}
+		}()
+	}
+	if address := viper.GetString(configAdminGrpcAddress); address != "" {
+		go func() {
+			if err := admin.ListenAndServeGrpc(address); err != nil {
+				logs.Warn("Unable to start gRPC management API on %s: %v", address, err)
+			}
+		}()
+	}
+
 	sigs := make(chan os.Signal, 1)
 	finished := make(chan bool, 0)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	fmt.Fprintf(os.Stderr, "Logging to %v. Send SIGINT or SIGTERM to %v to stop.\n", viper.GetString(configLogLevel), os.Getpid())
 
+	if reopener, ok := sink.(worker.Reopener); ok {
+		reopenSigs := make(chan os.Signal, 1)
+		signal.Notify(reopenSigs, syscall.SIGHUP, syscall.SIGUSR1)
+		go func() {
+			for sig := range reopenSigs {
+				logs.Info("Caught signal: %s; reopening output files", sig)
+				reopener.Reopen()
+			}
+		}()
+	}
+
 	go func() {
 		sig := <-sigs
 		logs.Info("Stopping: Caught signal: %s", sig)
@@ -139,6 +182,7 @@ func Run(sink worker.Worker) {
 		logWorker.Stop()
 		logs.Info("Stopping sink worker")
 		sink.Stop()
+		worker.FlushDeadLetters()
 		logs.Info("Exiting translog")
 		finished <- true
 	}()
@@ -0,0 +1,296 @@
+package worker
+
+/*
+	alert.go raises alerts when events cross a threshold
+
+	Alert rules are declared in alerts.rules, e.g.
+
+		[alerts.rules.server_errors]
+		where = "status >= 500"
+		threshold = 50
+		window = "1m"
+		message = "{count} server errors in the last minute"
+
+	which raises an alert when more than 50 events with a status of 500 or
+	more have been parsed in the last minute. A condition compares event
+	fields with values, using =, !=, <, <=, > or >=, and conditions can be
+	joined with "and"; a rule without a condition counts every event.
+
+	When a rule fires, a synthetic alert event is emitted, like
+
+		{"alert": "server_errors", "count": 51, "threshold": 50,
+		 "window_seconds": 60, "message": "51 server errors in the last minute",
+		 "created": "2024-03-01T12:00:00Z"}
+
+	and notifications are sent to the configured destinations: a Slack
+	incoming webhook (alerts.slack_webhook), a webhook that is posted the
+	alert event (alerts.webhook), and email (alerts.email_to, sent through
+	alerts.smtp_address from alerts.email_from). The message may use the
+	fields of the alert event as placeholders. A rule doesn't fire again
+	until alerts.cooldown (5m by default) has passed. Webhooks are posted
+	through alerts.proxy, if set (see proxy.go).
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configAlertsRules = "alerts.rules"
+const configAlertsCooldown = "alerts.cooldown"
+const configAlertsSlackWebhook = "alerts.slack_webhook"
+const configAlertsWebhook = "alerts.webhook"
+const configAlertsSMTPAddress = "alerts.smtp_address"
+const configAlertsEmailFrom = "alerts.email_from"
+const configAlertsEmailTo = "alerts.email_to"
+
+// notifyTimeout is how long sending a notification may take
+const notifyTimeout = 10 * time.Second
+
+// conditionRegex matches a comparison of a field with a value
+var conditionRegex = regexp.MustCompile(`^\s*([\w.@-]+)\s*(==|!=|<=|>=|=|<|>)\s*(.*?)\s*$`)
+
+// conditionAndRegex separates the conditions joined with "and"
+var conditionAndRegex = regexp.MustCompile(`\s+and\s+`)
+
+// ConfiguredAlertsCooldown returns how long a rule waits before firing again
+func ConfiguredAlertsCooldown() time.Duration {
+	if viper.IsSet(configAlertsCooldown) {
+		cooldown := viper.GetDuration(configAlertsCooldown)
+		if cooldown >= 0 {
+			return cooldown
+		}
+		reportError(&ConfigError{Key: configAlertsCooldown, Value: viper.Get(configAlertsCooldown), Reason: "using 5m"})
+	}
+	return 5 * time.Minute
+}
+
+// compare compares an event value with a condition's value: numerically if
+// both are numbers, and as strings otherwise
+func compare(value interface{}, operand string) int {
+	if a, ok := toFloat(value); ok {
+		if b, err := strconv.ParseFloat(operand, 64); err == nil {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(value), operand)
+}
+
+// CompileCondition compiles a condition, such as "status >= 500 and
+// method = GET"; an empty condition is true for every event
+func CompileCondition(expr string) (func(v map[string]interface{}) bool, error) {
+	var tests []func(v map[string]interface{}) bool
+	if strings.TrimSpace(expr) == "" {
+		return func(v map[string]interface{}) bool { return true }, nil
+	}
+	for _, part := range conditionAndRegex.Split(expr, -1) {
+		match := conditionRegex.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("expected field, comparison and value in %q", part)
+		}
+		field, op, operand := match[1], match[2], strings.Trim(match[3], `"'`)
+		tests = append(tests, func(v map[string]interface{}) bool {
+			value, found := lookupField(v, field)
+			if !found || value == nil {
+				return op == "!="
+			}
+			c := compare(value, operand)
+			switch op {
+			case "=", "==":
+				return c == 0
+			case "!=":
+				return c != 0
+			case "<":
+				return c < 0
+			case "<=":
+				return c <= 0
+			case ">":
+				return c > 0
+			}
+			return c >= 0
+		})
+	}
+	return func(v map[string]interface{}) bool {
+		for _, test := range tests {
+			if !test(v) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// alertBucket counts the matching events of one second
+type alertBucket struct {
+	second int64
+	count  int64
+}
+
+// alertRule counts the events matching its condition over a sliding window
+type alertRule struct {
+	name      string
+	where     func(v map[string]interface{}) bool
+	threshold int64
+	window    time.Duration
+	message   string
+	cooldown  time.Duration
+	lock      sync.Mutex
+	buckets   []alertBucket
+	count     int64
+	fired     time.Time
+}
+
+// ConfiguredAlertRules returns the alert rules
+func ConfiguredAlertRules() []*alertRule {
+	config := viper.GetStringMap(configAlertsRules)
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cooldown := ConfiguredAlertsCooldown()
+	rules := make([]*alertRule, 0, len(names))
+	for _, name := range names {
+		key := configAlertsRules + "." + name
+		where, err := CompileCondition(viper.GetString(key + ".where"))
+		if err != nil {
+			reportError(&ConfigError{Key: key + ".where", Value: viper.Get(key + ".where"), Reason: err.Error() + "; ignoring the rule"})
+			continue
+		}
+		r := &alertRule{
+			name:      name,
+			where:     where,
+			threshold: viper.GetInt64(key + ".threshold"),
+			window:    viper.GetDuration(key + ".window"),
+			message:   viper.GetString(key + ".message"),
+			cooldown:  cooldown,
+		}
+		if r.window < time.Second {
+			reportError(&ConfigError{Key: key + ".window", Value: viper.Get(key + ".window"), Reason: "using 1m"})
+			r.window = time.Minute
+		}
+		if r.message == "" {
+			r.message = "{alert}: {count} events in {window_seconds}s (threshold {threshold})"
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// observe counts the event if it matches, and returns the alert event if
+// the rule fires
+func (r *alertRule) observe(v map[string]interface{}, now time.Time) map[string]interface{} {
+	if !r.where(v) {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	second := now.Unix()
+	if n := len(r.buckets); n > 0 && r.buckets[n-1].second == second {
+		r.buckets[n-1].count++
+	} else {
+		r.buckets = append(r.buckets, alertBucket{second: second, count: 1})
+	}
+	r.count++
+	oldest := second - int64(r.window/time.Second)
+	for len(r.buckets) > 0 && r.buckets[0].second <= oldest {
+		r.count -= r.buckets[0].count
+		r.buckets = r.buckets[1:]
+	}
+	if r.count <= r.threshold || now.Sub(r.fired) < r.cooldown {
+		return nil
+	}
+	r.fired = now
+	alert := map[string]interface{}{
+		"alert":          r.name,
+		"count":          r.count,
+		"threshold":      r.threshold,
+		"window_seconds": int64(r.window / time.Second),
+		"created":        now.UTC().Format(time.RFC3339),
+	}
+	alert["message"] = FillTemplate(r.message, alert)
+	return alert
+}
+
+// checkAlerts applies the alert rules to an event, passing the alerts that
+// fire to emit, and sending them
+func (w *LogParser) checkAlerts(v map[string]interface{}, emit func(map[string]interface{})) {
+	now := time.Now()
+	for _, r := range w.alerts {
+		alert := r.observe(v, now)
+		if alert == nil {
+			continue
+		}
+		logs.Warn("Alert %s: %s", r.name, alert["message"])
+		Counters.Inc("alerts")
+		sent := copyEvent(alert) // the output may release the alert to the pool
+		go runRecovered("alert notification", func() { notify(sent) })
+		emit(alert)
+	}
+}
+
+// notify sends an alert to the configured destinations
+func notify(alert map[string]interface{}) {
+	message := fmt.Sprint(alert["message"])
+	if url := viper.GetString(configAlertsSlackWebhook); url != "" {
+		bs, _ := json.Marshal(map[string]string{"text": message})
+		notifyFailed("slack", postJSON(url, bs))
+	}
+	if url := viper.GetString(configAlertsWebhook); url != "" {
+		bs, err := json.Marshal(alert)
+		if err == nil {
+			err = postJSON(url, bs)
+		}
+		notifyFailed("webhook", err)
+	}
+	if to := viper.GetStringSlice(configAlertsEmailTo); len(to) > 0 {
+		from := viper.GetString(configAlertsEmailFrom)
+		body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [translog] alert %s\r\n\r\n%s\r\n", from, strings.Join(to, ", "), alert["alert"], message)
+		notifyFailed("email", smtp.SendMail(viper.GetString(configAlertsSMTPAddress), nil, from, to, []byte(body)))
+	}
+}
+
+// postJSON posts a JSON body to url
+func postJSON(url string, bs []byte) error {
+	transport, err := configuredProxyTransport("alerts")
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: notifyTimeout, Transport: transport}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyFailed reports a notification that could not be sent
+func notifyFailed(destination string, err error) {
+	if err == nil {
+		Counters.Inc("alert_notifications")
+		return
+	}
+	Counters.Inc("alert_notifications_failed")
+	reportError(&OutputError{Destination: "alert " + destination, Err: err})
+}
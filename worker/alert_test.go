@@ -0,0 +1,90 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var conditionTestCases = []struct {
+	expr     string
+	event    map[string]interface{}
+	expected bool
+}{
+	{"", map[string]interface{}{}, true},
+	{"status >= 500", map[string]interface{}{"status": int64(503)}, true},
+	{"status >= 500", map[string]interface{}{"status": int64(404)}, false},
+	{"status >= 500", map[string]interface{}{}, false},
+	{"status != 200", map[string]interface{}{}, true},
+	{"status >= 500 and method = GET", map[string]interface{}{"status": int64(500), "method": "GET"}, true},
+	{"status >= 500 and method == 'GET'", map[string]interface{}{"status": int64(500), "method": "POST"}, false},
+	{"latency < 0.5", map[string]interface{}{"latency": 0.25}, true},
+	{"http.method = GET", map[string]interface{}{"http": map[string]interface{}{"method": "GET"}}, true},
+}
+
+func TestCompileCondition(t *testing.T) {
+	for i, tt := range conditionTestCases {
+		where, err := worker.CompileCondition(tt.expr)
+		if err != nil {
+			t.Fatalf("In test %d, CompileCondition(%v): unexpected error %v", i, tt.expr, err)
+		}
+		if actual := where(tt.event); actual != tt.expected {
+			t.Errorf("In test %d, CompileCondition(%v)(%v): expected %v, actual %v", i, tt.expr, tt.event, tt.expected, actual)
+		}
+	}
+	if _, err := worker.CompileCondition("status is bad"); err == nil {
+		t.Errorf("CompileCondition(status is bad): expected an error")
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	viper.Reset()
+	posted := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var alert map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&alert)
+		posted <- alert
+	}))
+	defer server.Close()
+	viper.Set("parse.pattern", `^(?P<status>\d+)$`)
+	viper.Set("alerts.webhook", server.URL)
+	viper.Set("alerts.rules", map[string]interface{}{
+		"server_errors": map[string]interface{}{"where": "status >= 500", "threshold": 2, "window": "1m", "message": "{count} server errors"},
+	})
+	channel := make(chan map[string]interface{}, 10)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	for _, line := range []string{"500", "200", "502", "503", "504"} {
+		w.ProcessLine(line)
+	}
+	alerts := 0
+	for i := 0; i < 6; i++ {
+		select {
+		case v := <-channel:
+			if v["alert"] != nil {
+				alerts++
+				if v["message"] != "3 server errors" {
+					t.Errorf("expected the message 3 server errors, actual %v", v["message"])
+				}
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if alerts != 1 {
+		t.Errorf("expected 1 alert event, actual %d", alerts)
+	}
+	select {
+	case alert := <-posted:
+		if alert["alert"] != "server_errors" {
+			t.Errorf("expected the webhook to be posted server_errors, actual %v", alert["alert"])
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the webhook to be posted the alert")
+	}
+}
@@ -0,0 +1,129 @@
+package worker
+
+/*
+	batch.go sends events to batch-oriented sinks in batches
+
+	Sinks that implement BatchWorker (such as Elasticsearch and Kinesis)
+	receive slices of events instead of single events, which amortizes the
+	cost of channel sends and goroutine scheduling. A batch is sent when it
+	holds pipeline.batch_size events, or when its oldest event has waited
+	pipeline.batch_latency, whichever comes first.
+*/
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configPipelineBatchSize = "pipeline.batch_size"
+const configPipelineBatchLatency = "pipeline.batch_latency"
+
+// batchQueueSize is how many full batches may wait to be sent before
+// parsing blocks
+const batchQueueSize = 16
+
+// ConfiguredPipelineBatchSize returns the largest batch
+func ConfiguredPipelineBatchSize() int {
+	if viper.IsSet(configPipelineBatchSize) {
+		if size := viper.GetInt(configPipelineBatchSize); size > 0 {
+			return size
+		}
+		reportError(&ConfigError{Key: configPipelineBatchSize, Value: viper.Get(configPipelineBatchSize), Reason: "using 100"})
+	}
+	return 100
+}
+
+// ConfiguredPipelineBatchLatency returns the longest an event waits for its
+// batch to fill up
+func ConfiguredPipelineBatchLatency() time.Duration {
+	if viper.IsSet(configPipelineBatchLatency) {
+		if latency := viper.GetDuration(configPipelineBatchLatency); latency > 0 {
+			return latency
+		}
+		reportError(&ConfigError{Key: configPipelineBatchLatency, Value: viper.Get(configPipelineBatchLatency), Reason: "using 100ms"})
+	}
+	return 100 * time.Millisecond
+}
+
+// batcher collects events into batches
+type batcher struct {
+	lock      sync.Mutex
+	events    []map[string]interface{}
+	first     time.Time // when the first event of the batch was added
+	size      int
+	latency   time.Duration
+	ready     chan []map[string]interface{} // batches waiting to be sent, in order
+	timerOnce sync.Once
+}
+
+// SetBatchChannel sets the channel batches of events are sent on, for sinks
+// that implement BatchWorker
+func (w *LogParser) SetBatchChannel(channel chan []map[string]interface{}) {
+	w.BatchChannel = channel
+}
+
+// sendBatch queues a batch to be put on the batch channel
+func (w *LogParser) sendBatch(events []map[string]interface{}) {
+	w.batcher.ready <- events
+}
+
+// sendBatches puts the queued batches on the batch channel, one at a time so
+// that they arrive in order
+func (w *LogParser) sendBatches() {
+	for events := range w.batcher.ready {
+		w.BatchChannel <- events
+		atomic.AddInt64(&w.pending, -int64(len(events)))
+	}
+}
+
+// addToBatch adds an event to the current batch, sending the batch if it is
+// full
+func (w *LogParser) addToBatch(v map[string]interface{}) {
+	b := &w.batcher
+	b.timerOnce.Do(func() {
+		b.size = ConfiguredPipelineBatchSize()
+		b.latency = ConfiguredPipelineBatchLatency()
+		b.ready = make(chan []map[string]interface{}, batchQueueSize)
+		go Supervise("batcher", w.flushBatches)
+		go Supervise("batch sender", w.sendBatches)
+	})
+	atomic.AddInt64(&w.pending, 1)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.events) == 0 {
+		b.first = time.Now()
+		b.events = make([]map[string]interface{}, 0, b.size)
+	}
+	b.events = append(b.events, v)
+	if len(b.events) >= b.size {
+		w.sendBatch(b.events)
+		b.events = nil
+	}
+}
+
+// flushBatch sends the current batch, if it is not empty and is older than
+// maxAge
+func (w *LogParser) flushBatch(maxAge time.Duration) {
+	b := &w.batcher
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.events) > 0 && time.Since(b.first) >= maxAge {
+		w.sendBatch(b.events)
+		b.events = nil
+	}
+}
+
+// flushBatches sends batches that have waited long enough, until the parser
+// is stopped
+func (w *LogParser) flushBatches() {
+	ticker := time.NewTicker(w.batcher.latency / 4)
+	defer ticker.Stop()
+	for range ticker.C {
+		if w.isStopped() {
+			return
+		}
+		w.flushBatch(w.batcher.latency)
+	}
+}
@@ -0,0 +1,46 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestProcessLineBatches(t *testing.T) {
+	viper.Reset()
+	viper.Set("pipeline.batch_size", 3)
+	viper.Set("pipeline.batch_latency", "50ms")
+	w := &worker.LogParser{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	batches := make(chan []map[string]interface{})
+	w.SetBatchChannel(batches)
+	w.Init()
+	for _, line := range []string{"a", "b", "c", "d"} {
+		w.ProcessLine(line)
+	}
+	select {
+	case batch := <-batches:
+		if len(batch) != 3 || batch[0]["line"] != "a" || batch[2]["line"] != "c" {
+			t.Errorf("expected a full batch of a, b, and c, actual %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a full batch to be sent")
+	}
+	start := time.Now()
+	select {
+	case batch := <-batches:
+		if len(batch) != 1 || batch[0]["line"] != "d" {
+			t.Errorf("expected a partial batch of d, actual %v", batch)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("expected a partial batch within the latency, took %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a partial batch to be sent after the latency")
+	}
+	if pending := w.Pending(); pending != 0 {
+		t.Errorf("expected no pending events, actual %d", pending)
+	}
+}
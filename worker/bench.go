@@ -0,0 +1,133 @@
+package worker
+
+/*
+	bench.go measures how fast lines go through the configured pattern
+
+	Bench parses lines with the configured pattern, marshals the events as
+	JSON, and releases them (like an output would), measuring the time
+	spent in each step: matching the regular expression, converting the
+	fields (which is mostly trying time layouts, see ParseStringForValue),
+	and marshaling.
+	With a schema (see event.go), lines are parsed into typed events
+	instead, as they would be in the pipeline. Other codecs than regex and
+	grok don't match a pattern, and aren't measured.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// BenchResult reports the throughput of the parser
+type BenchResult struct {
+	Lines          int64
+	Matched        int64
+	Elapsed        time.Duration
+	Regex          time.Duration
+	Fields         time.Duration
+	Marshal        time.Duration
+	AllocsPerEvent float64
+	BytesPerEvent  float64
+}
+
+// LinesPerSecond returns the throughput
+func (r BenchResult) LinesPerSecond() float64 {
+	return float64(r.Lines) / r.Elapsed.Seconds()
+}
+
+// share returns d as a percentage of the elapsed time
+func (r BenchResult) share(d time.Duration) float64 {
+	return 100 * float64(d) / float64(r.Elapsed)
+}
+
+func (r BenchResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Lines:          %d (%d matched)\n", r.Lines, r.Matched)
+	fmt.Fprintf(&b, "Elapsed:        %v\n", r.Elapsed)
+	fmt.Fprintf(&b, "Throughput:     %.0f lines/sec\n", r.LinesPerSecond())
+	fmt.Fprintf(&b, "Allocations:    %.1f per event (%.0f bytes)\n", r.AllocsPerEvent, r.BytesPerEvent)
+	fmt.Fprintf(&b, "Regex:          %5.1f%% (%v)\n", r.share(r.Regex), r.Regex)
+	fmt.Fprintf(&b, "Fields:         %5.1f%% (%v; times, numbers, URIs, transforms)\n", r.share(r.Fields), r.Fields)
+	fmt.Fprintf(&b, "JSON marshal:   %5.1f%% (%v)\n", r.share(r.Marshal), r.Marshal)
+	return b.String()
+}
+
+// Bench parses n lines, cycling through lines, and reports the throughput;
+// it returns an error if the codec isn't regex or grok, or if the pattern
+// doesn't compile
+func (w *LogParser) Bench(lines []string, n int) (BenchResult, error) {
+	var result BenchResult
+	var buffer []byte
+	if codec := ConfiguredInputCodec(); codec != "regex" && codec != "grok" {
+		return result, fmt.Errorf("Codec %s can't be measured; bench measures the regex and grok codecs", codec)
+	}
+	regex, err := w.CompiledRegex()
+	if regex == nil {
+		return result, err
+	}
+	if len(lines) == 0 {
+		return result, nil
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		line := lines[i%len(lines)]
+		t0 := time.Now()
+		match := regex.FindStringSubmatch(line)
+		t1 := time.Now()
+		result.Regex += t1.Sub(t0)
+		result.Lines++
+		if match == nil {
+			continue
+		}
+		result.Matched++
+		if w.schema != nil {
+			e := w.schema.Parse(match)
+			t2 := time.Now()
+			result.Fields += t2.Sub(t1)
+			buffer = e.AppendJSON(buffer[:0])
+			e.Release()
+			result.Marshal += time.Since(t2)
+			continue
+		}
+		v := w.eventFromMatch(regex, match)
+		t2 := time.Now()
+		result.Fields += t2.Sub(t1)
+		json.Marshal(v)
+		ReleaseEvent(v)
+		result.Marshal += time.Since(t2)
+	}
+	result.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	if result.Lines > 0 {
+		result.AllocsPerEvent = float64(after.Mallocs-before.Mallocs) / float64(result.Lines)
+		result.BytesPerEvent = float64(after.TotalAlloc-before.TotalAlloc) / float64(result.Lines)
+	}
+	return result, nil
+}
+
+var benchMethods = []string{"GET", "GET", "GET", "POST", "PUT", "DELETE"}
+var benchPaths = []string{"/", "/index.html", "/api/v1/users?id=42&sort=asc", "/static/app.js", "/search?q=translog"}
+var benchStatuses = []int{200, 200, 200, 301, 304, 404, 500}
+var benchAgents = []string{"Mozilla/5.0 (X11; Linux x86_64)", "curl/7.68.0", "Googlebot/2.1"}
+
+// GenerateBenchLines generates n lines in the combined log format
+func GenerateBenchLines(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`10.0.%d.%d - - [%s] "%s %s HTTP/1.1" %d %d "-" "%s"`,
+			r.Intn(256), r.Intn(256),
+			created.Add(time.Duration(i)*time.Second).Format("02/Jan/2006:15:04:05 -0700"),
+			benchMethods[r.Intn(len(benchMethods))], benchPaths[r.Intn(len(benchPaths))],
+			benchStatuses[r.Intn(len(benchStatuses))], r.Intn(100000),
+			benchAgents[r.Intn(len(benchAgents))])
+	}
+	return lines
+}
@@ -0,0 +1,52 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestBench(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<ip>\S+) \S+ \S+ \[(?P<created>[^\]]+)\] "(?P<method>[A-Z]+) (?P<uri>\S+) [^"]*" (?P<status>\d+)`)
+	w := &worker.LogParser{}
+	w.Init()
+	lines := append(worker.GenerateBenchLines(10), "not a log line")
+	result, err := w.Bench(lines, 110)
+	if err != nil {
+		t.Fatalf("expected no error, actual %v", err)
+	}
+	if result.Lines != 110 || result.Matched != 100 {
+		t.Errorf("expected 100 of 110 lines to match, got %d of %d", result.Matched, result.Lines)
+	}
+	if result.Regex <= 0 || result.Fields <= 0 || result.Marshal <= 0 || result.Regex+result.Fields+result.Marshal > result.Elapsed {
+		t.Errorf("unexpected timings %+v", result)
+	}
+	if result.AllocsPerEvent <= 0 {
+		t.Errorf("expected allocations to be measured, got %+v", result)
+	}
+}
+
+func TestBenchInvalidPattern(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<ip>\S+`)
+	w := &worker.LogParser{}
+	w.Init()
+	if _, err := w.Bench(worker.GenerateBenchLines(10), 10); err == nil {
+		t.Error("expected an error for a pattern that doesn't compile")
+	}
+	if _, err := w.Codec().Decode("10.0.0.1"); err == nil {
+		t.Error("expected Decode to fail for a pattern that doesn't compile")
+	}
+}
+
+func TestBenchOtherCodec(t *testing.T) {
+	viper.Reset()
+	viper.Set("input.codec", "json")
+	w := &worker.LogParser{}
+	w.Init()
+	if _, err := w.Bench([]string{`{"status": 200}`}, 10); err == nil {
+		t.Error("expected an error for the json codec")
+	}
+}
@@ -0,0 +1,277 @@
+package worker
+
+/*
+	chat.go posts events to Slack or Microsoft Teams
+
+	translog chat is for streams of few but important events, such as
+	deploys, or payments that failed. The events matching chat.where, a
+	condition as in alert rules (see alert.go; every event, if it is
+	empty), are formatted with chat.template, a Go template over the event,
+	e.g.
+
+		:rotating_light: {{.service}} on {{.host}}: {{.message}}
+
+	(use {{index . "error.fingerprint"}} for fields with dots; {{json .}}
+	formats the whole event), and posted to the incoming webhook
+	chat.webhook, as a Slack message or, with chat.format = "teams", a
+	Teams message card.
+
+	Messages are collected and posted together every chat.flush_interval
+	(10s by default), so that a burst of events is one post, and a channel
+	is posted to at most once per interval. A post holds at most
+	chat.max_messages messages (20 by default); the others are counted as
+	chat_suppressed, and mentioned at the end of the post. When the webhook
+	is rate limited, messages are kept until its Retry-After has passed;
+	posts that fail otherwise are dead-lettered. Requests go through
+	chat.proxy, if set (see proxy.go).
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configChatWebhook = "chat.webhook"
+const configChatFormat = "chat.format"
+const configChatWhere = "chat.where"
+const configChatTemplate = "chat.template"
+const configChatFlushInterval = "chat.flush_interval"
+const configChatMaxMessages = "chat.max_messages"
+
+// defaultEventTemplate formats events whose template isn't configured
+const defaultEventTemplate = `{{if .message}}{{.message}}{{else}}{{json .}}{{end}}`
+
+// eventTemplateFuncs are the functions event templates may use
+var eventTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) string {
+		bs, _ := json.Marshal(v)
+		return string(bs)
+	},
+}
+
+// ConfiguredChatFormat returns the format of posts: slack or teams
+func ConfiguredChatFormat() string {
+	switch format := strings.ToLower(viper.GetString(configChatFormat)); format {
+	case "", "slack":
+		return "slack"
+	case "teams":
+		return format
+	default:
+		reportError(&ConfigError{Key: configChatFormat, Value: format, Reason: "expected slack or teams; using slack"})
+		return "slack"
+	}
+}
+
+// ConfiguredChatFlushInterval returns how often messages are posted
+func ConfiguredChatFlushInterval() time.Duration {
+	if viper.IsSet(configChatFlushInterval) {
+		if interval := viper.GetDuration(configChatFlushInterval); interval > 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configChatFlushInterval, Value: viper.Get(configChatFlushInterval), Reason: "using 10s"})
+	}
+	return 10 * time.Second
+}
+
+// ConfiguredChatMaxMessages returns the most messages in a post
+func ConfiguredChatMaxMessages() int {
+	if viper.IsSet(configChatMaxMessages) {
+		if max := viper.GetInt(configChatMaxMessages); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configChatMaxMessages, Value: viper.Get(configChatMaxMessages), Reason: "using 20"})
+	}
+	return 20
+}
+
+// configuredEventTemplate returns the template events are formatted with,
+// set in key
+func configuredEventTemplate(key string) *template.Template {
+	text := defaultEventTemplate
+	if viper.IsSet(key) {
+		text = viper.GetString(key)
+	}
+	t, err := template.New(key).Funcs(eventTemplateFuncs).Parse(text)
+	if err != nil {
+		reportError(&ConfigError{Key: key, Value: text, Reason: err.Error() + "; using the message"})
+		t = template.Must(template.New(key).Funcs(eventTemplateFuncs).Parse(defaultEventTemplate))
+	}
+	return t
+}
+
+// ChatPost returns the body of a post of messages, in the format of slack
+// or teams, mentioning how many more messages were suppressed
+func ChatPost(format string, messages []string, suppressed int) map[string]interface{} {
+	separator := "\n"
+	if format == "teams" {
+		// Teams only breaks lines between paragraphs
+		separator = "\n\n"
+	}
+	text := strings.Join(messages, separator)
+	if suppressed > 0 {
+		text += fmt.Sprintf("%s(and %d more)", separator, suppressed)
+	}
+	if format == "teams" {
+		return map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  messages[0],
+			"text":     text,
+		}
+	}
+	return map[string]interface{}{"text": text}
+}
+
+// ChatWorker posts events to Slack or Teams
+type ChatWorker struct {
+	WorkChannel  chan map[string]interface{}
+	QuitChannel  chan bool
+	FlushChannel chan bool
+	client       *http.Client
+	format       string
+	where        func(v map[string]interface{}) bool
+	template     *template.Template
+	maxMessages  int
+	messages     []string
+	suppressed   int
+	retryAfter   time.Time
+	startTime    time.Time
+	healthTracker
+}
+
+func (w *ChatWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *ChatWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	w.where, err = CompileCondition(viper.GetString(configChatWhere))
+	if err != nil {
+		reportError(&ConfigError{Key: configChatWhere, Value: viper.Get(configChatWhere), Reason: err.Error() + "; posting every event"})
+		w.where, _ = CompileCondition("")
+	}
+	w.format = ConfiguredChatFormat()
+	w.template = configuredEventTemplate(configChatTemplate)
+	w.maxMessages = ConfiguredChatMaxMessages()
+	transport, err := configuredProxyTransport("chat")
+	if err != nil {
+		logs.Warn("Invalid chat proxy: %v", err)
+	}
+	w.client = &http.Client{Timeout: notifyTimeout, Transport: transport}
+	return nil
+}
+
+// Start the work
+func (w *ChatWorker) Start() {
+	go Supervise("ChatWorker", w.Work)
+}
+
+// add formats an event, if it matches chat.where, and keeps the message
+// for the next post
+func (w *ChatWorker) add(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	if !w.where(obj) {
+		Counters.Inc("chat_skipped")
+		return
+	}
+	if len(w.messages) >= w.maxMessages {
+		w.suppressed++
+		Counters.Inc("chat_suppressed")
+		return
+	}
+	var message bytes.Buffer
+	if err := w.template.Execute(&message, obj); err != nil {
+		logs.Info("Unable to format %v for chat: %v", obj, err)
+		Counters.Inc("chat_template_errors")
+		return
+	}
+	w.messages = append(w.messages, message.String())
+}
+
+// post posts a body to the webhook; if the webhook is rate limited, it
+// returns how long to wait before posting again
+func (w *ChatWorker) post(bs []byte) (time.Duration, error) {
+	resp, err := w.client.Post(viper.GetString(configChatWebhook), "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait := retryAfter(resp)
+		return wait, fmt.Errorf("rate limited for %v", wait)
+	}
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return 0, nil
+}
+
+// flush posts the messages collected since the last post
+func (w *ChatWorker) flush() {
+	if len(w.messages) == 0 || time.Now().Before(w.retryAfter) {
+		return
+	}
+	bs, err := json.Marshal(ChatPost(w.format, w.messages, w.suppressed))
+	if err != nil {
+		logs.Info("Unable to marshal chat messages: %v", err)
+		return
+	}
+	start := time.Now()
+	wait, err := w.post(bs)
+	if err != nil {
+		w.failed(&OutputError{Destination: w.format + " webhook", Err: err})
+		if wait > 0 {
+			// keep the messages for when the webhook accepts them again
+			w.retryAfter = time.Now().Add(wait)
+			return
+		}
+		deadLetterDocument(string(bs), "rejected", err.Error())
+	} else {
+		Counters.Add("chat_messages", int64(len(w.messages)))
+		w.succeeded(time.Since(start))
+	}
+	w.messages, w.suppressed = nil, 0
+}
+
+// Work the queue
+func (w *ChatWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("ChatWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredChatFlushInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.add(obj)
+
+		case <-ticker.C:
+			w.flush()
+
+		case <-w.FlushChannel:
+			w.flush()
+
+		case <-w.QuitChannel:
+			logs.Info("ChatWorker received quit")
+			w.flush()
+			return
+		}
+	}
+}
+
+// Flush asks the worker to post the messages it has collected
+func (w *ChatWorker) Flush() {
+	w.FlushChannel <- true
+}
+
+// Stop stops the worker, after posting the messages it has collected
+func (w *ChatWorker) Stop() {
+	w.QuitChannel <- true
+}
@@ -0,0 +1,77 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var chatPostTestCases = []struct {
+	format     string
+	messages   []string
+	suppressed int
+	expected   map[string]interface{}
+}{
+	{"slack", []string{"a"}, 0, map[string]interface{}{"text": "a"}},
+	{"slack", []string{"a", "b"}, 3, map[string]interface{}{"text": "a\nb\n(and 3 more)"}},
+	{"teams", []string{"a", "b"}, 0, map[string]interface{}{"@type": "MessageCard", "@context": "https://schema.org/extensions", "summary": "a", "text": "a\n\nb"}},
+}
+
+func TestChatPost(t *testing.T) {
+	for i, tt := range chatPostTestCases {
+		actual := worker.ChatPost(tt.format, tt.messages, tt.suppressed)
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("In test %d, ChatPost(%v, %v, %v): expected %v, actual %v", i, tt.format, tt.messages, tt.suppressed, tt.expected, actual)
+		}
+	}
+}
+
+func TestChatWorker(t *testing.T) {
+	viper.Reset()
+	posts := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		bs, _ := io.ReadAll(req.Body)
+		var post map[string]interface{}
+		json.Unmarshal(bs, &post)
+		posts <- post
+	}))
+	defer server.Close()
+	viper.Set("chat.webhook", server.URL)
+	viper.Set("chat.where", "status >= 500")
+	viper.Set("chat.template", "{{.status}} on {{.uri}}")
+	viper.Set("chat.max_messages", 2)
+	viper.Set("chat.flush_interval", "1h")
+
+	w := &worker.ChatWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"status": int64(200), "uri": "/"}
+	w.WorkChannel <- map[string]interface{}{"status": int64(500), "uri": "/a"}
+	w.WorkChannel <- map[string]interface{}{"status": int64(502), "uri": "/b"}
+	w.WorkChannel <- map[string]interface{}{"status": int64(503), "uri": "/c"}
+	w.Flush()
+
+	select {
+	case post := <-posts:
+		if expected := "500 on /a\n502 on /b\n(and 1 more)"; post["text"] != expected {
+			t.Errorf("ChatWorker: expected %q, actual %q", expected, post["text"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ChatWorker: timed out waiting for the post")
+	}
+	w.Flush()
+	select {
+	case post := <-posts:
+		t.Errorf("ChatWorker: expected nothing to post, actual %v", post)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
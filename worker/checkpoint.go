@@ -0,0 +1,101 @@
+package worker
+
+/*
+	checkpoint.go saves and restores the read position in the input file,
+	or the cursor of the SQL input (see input_sql.go)
+
+	A checkpoint is written to a temporary file, which is fsync'ed and then
+	atomically renamed over the previous checkpoint, so a crash or power loss
+	leaves either the old or the new checkpoint in place, never a partial one.
+	Each checkpoint includes a CRC-32 checksum of its contents, and a
+	checkpoint that fails verification is ignored.
+
+	The read position is that of the lines read, not of the events the
+	output has acknowledged: outputs don't report back which events they
+	have sent. Resuming from a checkpoint is therefore at-most-once: events
+	read before the checkpoint, but still in the pipeline, in an output's
+	batch (e.g. up to es.max documents) or in a request in flight (see
+	in_flight.go) when translog crashes or is killed are lost. When translog
+	is stopped, the outputs send what they have before it exits, and
+	nothing is lost.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint records how far an input file, or a SQL table, has been read
+type Checkpoint struct {
+	File     string    `json:"file"`
+	Offset   int64     `json:"offset"`
+	Cursor   string    `json:"cursor,omitempty"`
+	Time     time.Time `json:"time"`
+	Checksum uint32    `json:"checksum"`
+}
+
+// checksum computes the checksum of the checkpoint (excluding the checksum
+// itself)
+func (c Checkpoint) checksum() uint32 {
+	c.Checksum = 0
+	bs, _ := json.Marshal(c) // a Checkpoint can always be marshaled
+	return crc32.ChecksumIEEE(bs)
+}
+
+// WriteCheckpoint atomically writes the checkpoint to path
+func WriteCheckpoint(path string, c Checkpoint) (err error) {
+	c.Checksum = c.checksum()
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(append(bs, '\n')); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return
+	}
+	// sync the directory, so the rename itself is durable
+	if dir, derr := os.Open(filepath.Dir(path)); derr == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return
+}
+
+// ReadCheckpoint reads and verifies the checkpoint at path
+func ReadCheckpoint(path string) (c Checkpoint, err error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(bs, &c); err != nil {
+		err = fmt.Errorf("Invalid checkpoint file %s: %v", path, err)
+		return
+	}
+	if c.Checksum != c.checksum() {
+		err = fmt.Errorf("Checksum mismatch in checkpoint file %s", path)
+	}
+	return
+}
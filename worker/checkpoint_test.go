@@ -0,0 +1,52 @@
+package worker_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/willf/translog/worker"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "translog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	c := worker.Checkpoint{File: "/var/log/example.log", Offset: 4242, Time: time.Now().UTC()}
+	if err := worker.WriteCheckpoint(path, c); err != nil {
+		t.Fatalf("expected checkpoint to be written, but got %v", err)
+	}
+	actual, err := worker.ReadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("expected checkpoint to be read, but got %v", err)
+	}
+	if actual.File != c.File || actual.Offset != c.Offset || !actual.Time.Equal(c.Time) {
+		t.Errorf("expected %v, got %v", c, actual)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the checkpoint file to remain, but found %d files", len(files))
+	}
+}
+
+func TestCheckpointCorrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "translog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	ioutil.WriteFile(path, []byte(`{"file":"/var/log/example.log","offset":99,"checksum":12}`), 0640)
+	if _, err := worker.ReadCheckpoint(path); err == nil {
+		t.Errorf("expected a checksum error")
+	}
+	ioutil.WriteFile(path, []byte(`{"file":"/var/log/exa`), 0640)
+	if _, err := worker.ReadCheckpoint(path); err == nil {
+		t.Errorf("expected an error for a truncated checkpoint")
+	}
+}
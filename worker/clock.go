@@ -0,0 +1,82 @@
+package worker
+
+/*
+	clock.go corrects and guards event timestamps
+
+	A host whose clock is off writes timestamps that are off too. time.skew
+	(e.g. "-90s") is added to each event's timestamp (the first of the
+	timestampFields that was parsed as a time) to correct a known, fixed
+	skew. Events whose timestamp is more than time.max_future ahead of the
+	wall clock, or more than time.max_past behind it, are counted in
+	timestamps_out_of_range and get a timestamp_out_of_range field ("future"
+	or "past"); with time.out_of_range = "clamp", their timestamp is also
+	replaced by the current time, and the original is kept in
+	original_<field>. Such events would otherwise seem to be missing, in
+	dashboards looking at the last few minutes.
+*/
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configTimeSkew = "time.skew"
+const configTimeMaxFuture = "time.max_future"
+const configTimeMaxPast = "time.max_past"
+const configTimeOutOfRange = "time.out_of_range"
+
+// clockGuard corrects and guards event timestamps
+type clockGuard struct {
+	skew      time.Duration
+	maxFuture time.Duration // 0 for no limit
+	maxPast   time.Duration // 0 for no limit
+	clamp     bool
+}
+
+// ConfiguredClockGuard returns how event timestamps are corrected and
+// guarded
+func ConfiguredClockGuard() (c clockGuard) {
+	c.skew = viper.GetDuration(configTimeSkew)
+	c.maxFuture = viper.GetDuration(configTimeMaxFuture)
+	c.maxPast = viper.GetDuration(configTimeMaxPast)
+	switch action := viper.GetString(configTimeOutOfRange); action {
+	case "", "flag":
+	case "clamp":
+		c.clamp = true
+	default:
+		reportError(&ConfigError{Key: configTimeOutOfRange, Value: action, Reason: "expected flag or clamp; using flag"})
+	}
+	return
+}
+
+// enabled returns true if timestamps are corrected or guarded
+func (c clockGuard) enabled() bool {
+	return c.skew != 0 || c.maxFuture > 0 || c.maxPast > 0
+}
+
+// apply corrects and guards the timestamp of an event, as of now
+func (c clockGuard) apply(v map[string]interface{}, now time.Time) {
+	for _, key := range timestampFields {
+		t, ok := v[key].(time.Time)
+		if !ok {
+			continue
+		}
+		t = t.Add(c.skew)
+		v[key] = t
+		var outOfRange string
+		if c.maxFuture > 0 && t.Sub(now) > c.maxFuture {
+			outOfRange = "future"
+		} else if c.maxPast > 0 && now.Sub(t) > c.maxPast {
+			outOfRange = "past"
+		}
+		if outOfRange != "" {
+			Counters.Inc("timestamps_out_of_range")
+			v["timestamp_out_of_range"] = outOfRange
+			if c.clamp {
+				v["original_"+key] = t
+				v[key] = now
+			}
+		}
+		return
+	}
+}
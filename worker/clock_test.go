@@ -0,0 +1,63 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var clockTestCases = []struct {
+	settings   map[string]string
+	offset     time.Duration // of the event's timestamp from now
+	expected   time.Duration // offset of the corrected timestamp from now
+	outOfRange interface{}
+}{
+	{map[string]string{"time.skew": "-1h"}, time.Hour, 0, nil},
+	{map[string]string{"time.max_future": "5m"}, time.Hour, time.Hour, "future"},
+	{map[string]string{"time.max_future": "5m"}, time.Minute, time.Minute, nil},
+	{map[string]string{"time.max_past": "24h", "time.out_of_range": "clamp"}, -48 * time.Hour, 0, "past"},
+}
+
+func TestClockGuard(t *testing.T) {
+	for i, tt := range clockTestCases {
+		viper.Reset()
+		viper.Set("parse.pattern", `^(?P<created>\S+)$`)
+		viper.Set("parse.time_patterns", []string{time.RFC3339})
+		for key, value := range tt.settings {
+			viper.Set(key, value)
+		}
+		w := &worker.LogParser{}
+		w.Init()
+		now := time.Now().Truncate(time.Second)
+		v, err := w.ParseEvents(now.Add(tt.offset).Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := v["created"].(time.Time).Sub(now)
+		if actual < tt.expected-2*time.Second || actual > tt.expected+2*time.Second || v["timestamp_out_of_range"] != tt.outOfRange {
+			t.Errorf("In test %d, with %v: expected an offset of %v (%v), actual %v (%v)", i, tt.settings, tt.expected, tt.outOfRange, actual, v["timestamp_out_of_range"])
+		}
+	}
+}
+
+func TestFileTime(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<time>\S+)?$`)
+	viper.Set("parse.time_patterns", []string{"15:04:05"})
+	viper.Set("parse.input_file", "/var/log/app-2024-06-01.log")
+	viper.Set("parse.file_time_pattern", `(?P<year>\d{4})-(?P<month>\d\d)-(?P<day>\d\d)`)
+	w := &worker.LogParser{}
+	w.Init()
+	v, _ := w.ParseEvents("12:34:56")
+	expected := time.Date(2024, 6, 1, 12, 34, 56, 0, time.UTC)
+	if actual, _ := v["time"].(time.Time); !actual.Equal(expected) {
+		t.Errorf("expected the time of day on the date of the file, %v, actual %v", expected, v["time"])
+	}
+	v, _ = w.ParseEvents("")
+	expected = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if actual, _ := v["created"].(time.Time); !actual.Equal(expected) {
+		t.Errorf("expected the date of the file, %v, actual %v", expected, v["created"])
+	}
+}
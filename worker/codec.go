@@ -0,0 +1,277 @@
+package worker
+
+/*
+	codec.go decodes input records into events
+
+	Whatever the transport (a tailed file, a socket, a command, a polled
+	URL...), each record read is decoded by the codec input.codec:
+
+		regex     (the default) the named groups of parse.pattern
+		grok      the named fields of parse.pattern, a grok expression such
+		          as "%{IPORHOST:client} %{WORD:method} %{URIPATHPARAM:uri}"
+		          (see codec_grok.go)
+		json      the fields of a JSON object
+		csv       the fields of a CSV record, named by codec.csv.columns,
+		          and separated by codec.csv.separator ("," by default)
+		logfmt    the key=value pairs of a logfmt record (a key without a
+		          value is true)
+		protobuf  the fields of a binary protocol buffer (see
+		          codec_protobuf.go)
+
+	Text values are converted as with regex (numbers, booleans, times,
+	durations and byte sizes), and uri, cookie, request_headers, and referer
+	fields are decomposed, whatever the codec.
+*/
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+const configInputCodec = "input.codec"
+const configCodecCSVColumns = "codec.csv.columns"
+const configCodecCSVSeparator = "codec.csv.separator"
+
+// Codec decodes a record into the fields of an event
+type Codec interface {
+	Decode(record string) (map[string]interface{}, error)
+}
+
+// ConfiguredInputCodec returns the name of the codec records are decoded
+// with
+func ConfiguredInputCodec() string {
+	if viper.IsSet(configInputCodec) {
+		switch codec := viper.GetString(configInputCodec); codec {
+		case "regex", "grok", "json", "csv", "logfmt", "protobuf":
+			return codec
+		}
+		reportError(&ConfigError{Key: configInputCodec, Value: viper.Get(configInputCodec), Reason: "using regex"})
+	}
+	return "regex"
+}
+
+// Codec returns the codec the parser decodes records with
+func (w *LogParser) Codec() Codec {
+	w.codecOnce.Do(func() {
+		w.codec = w.configuredCodec()
+	})
+	return w.codec
+}
+
+// configuredCodec creates the configured codec; if it can't, it reports the
+// error, and uses the regex codec
+func (w *LogParser) configuredCodec() Codec {
+	switch ConfiguredInputCodec() {
+	case "json":
+		return jsonCodec{w}
+	case "csv":
+		columns := viper.GetStringSlice(configCodecCSVColumns)
+		if len(columns) == 0 {
+			reportError(&ConfigError{Key: configCodecCSVColumns, Value: nil, Reason: "required for the csv codec; using regex"})
+			break
+		}
+		separator := ','
+		if viper.IsSet(configCodecCSVSeparator) {
+			s := viper.GetString(configCodecCSVSeparator)
+			if r, size := utf8.DecodeRuneInString(s); size == len(s) && r != '"' && r != '\n' && r != utf8.RuneError {
+				separator = r
+			} else {
+				reportError(&ConfigError{Key: configCodecCSVSeparator, Value: s, Reason: "expected a single character; using ,"})
+			}
+		}
+		return csvCodec{w, columns, separator}
+	case "logfmt":
+		return logfmtCodec{w}
+	case "protobuf":
+		codec, err := newProtobufCodec(w)
+		if err != nil {
+			reportError(err)
+			break
+		}
+		return codec
+	}
+	return regexCodec{w}
+}
+
+// binaryCodec returns true if records are binary, rather than text lines
+func (w *LogParser) binaryCodec() bool {
+	_, binary := w.Codec().(*protobufCodec)
+	return binary
+}
+
+// trimLine removes a leading byte order mark and a trailing carriage
+// return (from Windows produced files) from a text record
+func trimLine(line string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(line, utf8BOM), "\r")
+}
+
+// regexCodec decodes records with parse.pattern (or its grok expression)
+type regexCodec struct {
+	w *LogParser
+}
+
+func (c regexCodec) Decode(record string) (map[string]interface{}, error) {
+	line := trimLine(record)
+	regex, err := c.w.CompiledRegex()
+	if regex == nil {
+		return nil, err
+	}
+	match := regex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, &ParseError{Line: line, Pattern: c.w.pattern}
+	}
+	return c.w.fieldsFromMatch(regex, match), nil
+}
+
+// jsonCodec decodes JSON objects
+type jsonCodec struct {
+	w *LogParser
+}
+
+func (c jsonCodec) Decode(record string) (map[string]interface{}, error) {
+	return c.w.fieldsFromJSON([]byte(trimLine(record)), "json")
+}
+
+// fieldsFromJSON converts the fields of a JSON object; string values are
+// converted like those matched by a pattern, and other values kept
+func (w *LogParser) fieldsFromJSON(record []byte, codec string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.UseNumber()
+	var m map[string]interface{}
+	if err := decoder.Decode(&m); err != nil {
+		return nil, &ParseError{Line: string(record), Codec: codec, Err: err}
+	}
+	if m == nil {
+		return nil, &ParseError{Line: string(record), Codec: codec, Err: fmt.Errorf("not an object")}
+	}
+	v := newEvent(len(m))
+	for name, value := range m {
+		if s, ok := value.(string); ok {
+			w.addField(name, s, v)
+		} else if !w.shouldIgnore(name) {
+			v[name] = jsonValue(value)
+		}
+	}
+	return v, nil
+}
+
+// jsonValue converts the numbers in a decoded JSON value to int64s, or
+// float64s if they aren't integers
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range value {
+			value[k] = jsonValue(e)
+		}
+	case []interface{}:
+		for i, e := range value {
+			value[i] = jsonValue(e)
+		}
+	}
+	return value
+}
+
+// csvCodec decodes CSV records
+type csvCodec struct {
+	w         *LogParser
+	columns   []string
+	separator rune
+}
+
+func (c csvCodec) Decode(record string) (map[string]interface{}, error) {
+	line := trimLine(record)
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = c.separator
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	values, err := reader.Read()
+	if err != nil {
+		return nil, &ParseError{Line: line, Codec: "csv", Err: err}
+	}
+	v := newEvent(len(c.columns))
+	for i, value := range values {
+		if i < len(c.columns) {
+			c.w.addField(c.columns[i], value, v)
+		}
+	}
+	return v, nil
+}
+
+// logfmtCodec decodes logfmt records
+type logfmtCodec struct {
+	w *LogParser
+}
+
+func (c logfmtCodec) Decode(record string) (map[string]interface{}, error) {
+	line := trimLine(record)
+	pairs, err := parseLogfmt(line)
+	if err != nil {
+		return nil, &ParseError{Line: line, Codec: "logfmt", Err: err}
+	}
+	v := newEvent(len(pairs))
+	for _, pair := range pairs {
+		c.w.addField(pair[0], pair[1], v)
+	}
+	return v, nil
+}
+
+// parseLogfmt splits a logfmt record into keys and values
+func parseLogfmt(line string) ([][2]string, error) {
+	pairs := make([][2]string, 0, 8)
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("missing key at offset %d", start)
+		}
+		if i == len(line) || line[i] != '=' {
+			pairs = append(pairs, [2]string{key, "true"})
+			continue
+		}
+		i++
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted value of %s", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value of %s: %v", key, err)
+			}
+			pairs = append(pairs, [2]string{key, value})
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		pairs = append(pairs, [2]string{key, line[start:i]})
+	}
+	return pairs, nil
+}
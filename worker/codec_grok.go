@@ -0,0 +1,204 @@
+package worker
+
+/*
+	codec_grok.go expands grok expressions into regular expressions
+
+	With input.codec = "grok", parse.pattern is a grok expression: a regular
+	expression in which %{NAME} stands for the named pattern NAME, and
+	%{NAME:field} captures it as field (a :int or :float suffix is accepted,
+	but values are converted as usual anyway). The usual patterns, from
+	WORD, NUMBER and IP to HTTPDATE, TIMESTAMP_ISO8601 and
+	COMBINEDAPACHELOG, are built in, rewritten for Go's regular expressions,
+	which lack lookarounds; codec.grok.patterns adds or replaces patterns:
+
+		[codec.grok.patterns]
+		REQUEST_ID = "[0-9a-f]{16}"
+
+	Group names are letters, digits, and underscores: a field like
+	[http][verb] or http.verb is captured as http_verb, and a field captured
+	more than once is numbered (status_2, ...).
+*/
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const configCodecGrokPatterns = "codec.grok.patterns"
+
+// maxGrokDepth limits how deeply patterns refer to other patterns, which
+// catches cycles
+const maxGrokDepth = 32
+
+// grokReference matches a reference to a pattern in a grok expression
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w@\[\].-]+))?(?::(int|float|string))?\}`)
+
+// invalidGroupCharacters are the characters of field names that group names
+// can't have
+var invalidGroupCharacters = regexp.MustCompile(`[^\w]+`)
+
+// grokPatterns are the built-in grok patterns
+var grokPatterns = map[string]string{
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"EMAILADDRESS":      `[a-zA-Z0-9!#$%&'*+/=?^_{|}~.-]+@[a-zA-Z0-9.-]+`,
+	"HTTPDUSER":         `%{EMAILADDRESS}|%{USER}`,
+	"INT":               `[+-]?[0-9]+`,
+	"BASE10NUM":         `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":            `%{BASE10NUM}`,
+	"BASE16NUM":         `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":            `\b[1-9][0-9]*\b`,
+	"NONNEGINT":         `\b[0-9]+\b`,
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":                `%{QUOTEDSTRING}`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MAC":               `(?:[A-Fa-f0-9]{2}[:-]){5}[A-Fa-f0-9]{2}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:%{IPV4}|[0-9A-Fa-f]{0,4})(?:%\w+)?`,
+	"IP":                `%{IPV6}|%{IPV4}`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"UNIXPATH":          `(?:/[^/\s]*)+`,
+	"PATH":              `%{UNIXPATH}`,
+	"URIPROTO":          `[A-Za-z][A-Za-z0-9+.-]*`,
+	"URIHOST":           `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":               `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":               `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?(?:%{ISO8601_TIMEZONE})?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"PROG":              `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG":        `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"LOGLEVEL":          `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
+
+// GrokRename is a field renamed in the regular expression: Go's regular
+// expressions need a group name of letters, digits, and underscores, and a
+// field captured more than once is numbered (status_2, status_3, ...)
+type GrokRename struct {
+	Field string
+	Name  string
+}
+
+// ExpandGrok expands the patterns a grok expression refers to (the built-in
+// ones, or those in patterns), into a regular expression
+func ExpandGrok(expression string, patterns map[string]string) (string, error) {
+	regex, _, err := ExpandGrokFields(expression, patterns)
+	return regex, err
+}
+
+// ExpandGrokFields expands a grok expression like ExpandGrok, and returns
+// the fields captured under another name
+func ExpandGrokFields(expression string, patterns map[string]string) (string, []GrokRename, error) {
+	g := &grokExpansion{patterns: patterns, used: make(map[string]bool)}
+	regex, err := g.expand(expression, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	return regex, g.renamed, nil
+}
+
+// grokExpansion keeps the group names used while expanding an expression
+type grokExpansion struct {
+	patterns map[string]string
+	used     map[string]bool
+	renamed  []GrokRename
+}
+
+func (g *grokExpansion) expand(expression string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", fmt.Errorf("Grok patterns nested too deeply in %s", expression)
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(expression, func(reference string) string {
+		parts := grokReference.FindStringSubmatch(reference)
+		pattern, found := g.patterns[parts[1]]
+		if !found {
+			// configuration keys are case-insensitive
+			pattern, found = lookupGrokPattern(parts[1], g.patterns)
+		}
+		if !found {
+			if err == nil {
+				err = fmt.Errorf("Unknown grok pattern %s", parts[1])
+			}
+			return ""
+		}
+		inner, e := g.expand(pattern, depth+1)
+		if e != nil && err == nil {
+			err = e
+		}
+		if parts[2] != "" {
+			return "(?P<" + g.groupName(parts[2]) + ">" + inner + ")"
+		}
+		return "(?:" + inner + ")"
+	})
+	if err != nil {
+		return "", err
+	}
+	if reference := strings.Index(expanded, "%{"); reference >= 0 {
+		return "", fmt.Errorf("Invalid grok reference at %s", expanded[reference:])
+	}
+	return expanded, nil
+}
+
+// groupName returns the group name a field is captured as, which is unique
+// in the expression
+func (g *grokExpansion) groupName(field string) string {
+	name := GrokFieldName(field)
+	if g.used[name] {
+		numbered := name
+		for i := 2; g.used[numbered]; i++ {
+			numbered = fmt.Sprintf("%s_%d", name, i)
+		}
+		name = numbered
+	}
+	if name != field {
+		g.renamed = append(g.renamed, GrokRename{Field: field, Name: name})
+	}
+	g.used[name] = true
+	return name
+}
+
+// GrokFieldName converts a grok field name, which may be nested, like
+// [http][verb], or dotted, into a valid group name
+func GrokFieldName(field string) string {
+	name := strings.Trim(invalidGroupCharacters.ReplaceAllString(field, "_"), "_")
+	if name == "" {
+		name = "field"
+	}
+	return name
+}
+
+// lookupGrokPattern finds a pattern configured in lower case, or a built-in
+// one
+func lookupGrokPattern(name string, patterns map[string]string) (string, bool) {
+	for key, pattern := range patterns {
+		if strings.EqualFold(key, name) {
+			return pattern, true
+		}
+	}
+	pattern, found := grokPatterns[name]
+	return pattern, found
+}
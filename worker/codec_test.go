@@ -0,0 +1,168 @@
+package worker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var codecTestCases = []struct {
+	settings map[string]interface{}
+	line     string
+	expected map[string]interface{}
+}{
+	{
+		map[string]interface{}{"input.codec": "json"},
+		`{"status": 200, "user": "bob", "ratio": 0.5, "tags": ["a", 1]}`,
+		map[string]interface{}{"status": int64(200), "user": "bob", "ratio": 0.5, "tags": []interface{}{"a", int64(1)}},
+	},
+	{
+		map[string]interface{}{"input.codec": "csv", "codec.csv.columns": []string{"ip", "status", "message"}},
+		`10.0.0.1,404,"not, found"`,
+		map[string]interface{}{"ip": "10.0.0.1", "status": int64(404), "message": "not, found"},
+	},
+	{
+		map[string]interface{}{"input.codec": "csv", "codec.csv.columns": []string{"ip", "", "status"}, "codec.csv.separator": ";"},
+		`10.0.0.1;ignored;404;extra`,
+		map[string]interface{}{"ip": "10.0.0.1", "status": int64(404)},
+	},
+	{
+		map[string]interface{}{"input.codec": "logfmt"},
+		`level=info msg="user \"bob\" logged in" took=12 debug`,
+		map[string]interface{}{"level": "info", "msg": `user "bob" logged in`, "took": int64(12), "debug": true},
+	},
+	{
+		map[string]interface{}{"input.codec": "grok", "parse.pattern": `^%{IPORHOST:client} %{WORD:method} %{URIPATHPARAM:uri} %{NUMBER:status:int}$`},
+		`10.1.2.3 GET /search?q=go 200`,
+		map[string]interface{}{"client": "10.1.2.3", "method": "GET", "uri": "/search?q=go", "status": int64(200),
+			"q": "go", "uri_path": "/search", "uri_query_raw": "q=go", "uri_segments": []interface{}{"search"}},
+	},
+	{
+		map[string]interface{}{"input.codec": "grok", "parse.pattern": `^%{REQUEST_ID:id} %{LOGLEVEL:level}`, "codec.grok.patterns": map[string]string{"REQUEST_ID": "[0-9a-f]{4}"}},
+		`beef WARN`,
+		map[string]interface{}{"id": "beef", "level": "WARN"},
+	},
+}
+
+func TestCodecs(t *testing.T) {
+	for i, tt := range codecTestCases {
+		viper.Reset()
+		for key, value := range tt.settings {
+			viper.Set(key, value)
+		}
+		w := &worker.LogParser{}
+		actual, err := w.ParseEvents(tt.line)
+		if err != nil || !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("In test %d, ParseEvents(%v): expected %v, actual %v (%v)", i, tt.line, tt.expected, actual, err)
+		}
+	}
+}
+
+func TestGrokApacheLog(t *testing.T) {
+	viper.Reset()
+	viper.Set("input.codec", "grok")
+	viper.Set("parse.pattern", "^%{COMBINEDAPACHELOG}$")
+	w := &worker.LogParser{}
+	v, err := w.ParseEvents(`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["clientip"] != "127.0.0.1" || v["auth"] != "frank" || v["verb"] != "GET" || v["request"] != "/apache_pb.gif" || v["response"] != int64(200) || v["bytes"] != int64(2326) {
+		t.Errorf("expected the fields of the log line, actual %v", v)
+	}
+}
+
+var codecErrorTestCases = []struct {
+	codec string
+	line  string
+}{
+	{"json", `{"status": `},
+	{"json", `[1, 2]`},
+	{"logfmt", `msg="unterminated`},
+	{"logfmt", `=value`},
+}
+
+func TestCodecErrors(t *testing.T) {
+	for i, tt := range codecErrorTestCases {
+		viper.Reset()
+		viper.Set("input.codec", tt.codec)
+		w := &worker.LogParser{}
+		_, err := w.ParseEvents(tt.line)
+		if e, ok := err.(*worker.ParseError); !ok || e.Codec != tt.codec {
+			t.Errorf("In test %d, ParseEvents(%v): expected a %s ParseError, actual %v", i, tt.line, tt.codec, err)
+		}
+	}
+}
+
+func TestExpandGrokErrors(t *testing.T) {
+	for _, expression := range []string{"%{NOPE:x}", "%{WORD:method:long}", "%{LOOP}"} {
+		if _, err := worker.ExpandGrok(expression, map[string]string{"LOOP": "a%{LOOP}"}); err == nil {
+			t.Errorf("ExpandGrok(%v): expected an error", expression)
+		}
+	}
+}
+
+func TestExpandGrokFields(t *testing.T) {
+	regex, renamed, err := worker.ExpandGrokFields(`%{WORD:[http][verb]} %{INT:status} %{INT:status}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []worker.GrokRename{{Field: "[http][verb]", Name: "http_verb"}, {Field: "status", Name: "status_2"}}
+	if !reflect.DeepEqual(renamed, expected) {
+		t.Errorf("expected %v, actual %v", expected, renamed)
+	}
+	names := regexp.MustCompile(regex).SubexpNames()
+	if !reflect.DeepEqual(names, []string{"", "http_verb", "status", "status_2"}) {
+		t.Errorf("expected unique group names, actual %v", names)
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	viper.Reset()
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: kind.Enum(),
+			Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("audit.proto"),
+		Package: proto.String("audit"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Entry"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("user", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("status_code", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+			},
+		}},
+	}}}
+	bs, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.pb")
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("input.codec", "protobuf")
+	viper.Set("codec.protobuf.descriptor_set", path)
+	viper.Set("codec.protobuf.message", "audit.Entry")
+	w := &worker.LogParser{}
+	// a status code of 13 is a carriage return, which must not be trimmed
+	record := protowire.AppendTag(nil, 1, protowire.BytesType)
+	record = protowire.AppendString(record, "alice")
+	record = protowire.AppendTag(record, 2, protowire.VarintType)
+	record = protowire.AppendVarint(record, 13)
+	actual, err := w.ParseEvents(string(record))
+	expected := map[string]interface{}{"user": "alice", "status_code": int64(13)}
+	if err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, actual %v (%v)", expected, actual, err)
+	}
+}
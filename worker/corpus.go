@@ -0,0 +1,146 @@
+package worker
+
+/*
+	corpus.go checks the parser against a golden corpus
+
+	A corpus is a directory of cases, each made of three files sharing a
+	name:
+
+		<name>.toml    the configuration the lines are parsed with
+		<name>.log     the input lines
+		<name>.json    the expected events, one JSON object per input
+		               line (null for lines that don't match)
+
+	VerifyCorpus parses the lines of every case, and reports where the
+	events differ from the expected ones, so that parser changes can't
+	silently change what translog produces. When a change is intended,
+	VerifyCorpus can update the expected events instead.
+
+	Each case is parsed with only its own configuration: the global
+	configuration is reset for every case.
+*/
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// CorpusMismatch is an input line whose event isn't the expected one
+type CorpusMismatch struct {
+	Case     string
+	Line     int
+	Input    string
+	Expected string
+	Actual   string
+}
+
+func (m CorpusMismatch) String() string {
+	return fmt.Sprintf("%s:%d: %s\n  expected %s\n  actual   %s", m.Case, m.Line, m.Input, m.Expected, m.Actual)
+}
+
+// readLines reads the lines of a file
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// parseCorpusCase parses the lines of a case, returning the events as JSON
+func parseCorpusCase(config string, lines []string) ([]string, error) {
+	viper.Reset()
+	viper.SetConfigFile(config)
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	w := &LogParser{}
+	w.Init()
+	events := make([]string, len(lines))
+	for i, line := range lines {
+		v, err := w.ParseEvents(line)
+		if err != nil {
+			events[i] = "null"
+			continue
+		}
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		events[i] = string(bs)
+		ReleaseEvent(v)
+	}
+	return events, nil
+}
+
+// VerifyCorpus parses the cases of the corpus in dir, returning the number
+// of cases and the lines whose events aren't the expected ones. With update,
+// the expected events are replaced by the actual ones instead.
+func VerifyCorpus(dir string, update bool) (int, []CorpusMismatch, error) {
+	configs, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return 0, nil, err
+	}
+	sort.Strings(configs)
+	var mismatches []CorpusMismatch
+	for _, config := range configs {
+		base := strings.TrimSuffix(config, ".toml")
+		name := filepath.Base(base)
+		lines, err := readLines(base + ".log")
+		if err != nil {
+			return 0, nil, err
+		}
+		actual, err := parseCorpusCase(config, lines)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if update {
+			if err := ioutil.WriteFile(base+".json", []byte(strings.Join(actual, "\n")+"\n"), 0644); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		expected, err := readLines(base + ".json")
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, line := range lines {
+			want := "(missing)"
+			if i < len(expected) {
+				want = expected[i]
+			}
+			if !sameJSON(want, actual[i]) {
+				mismatches = append(mismatches, CorpusMismatch{Case: name, Line: i + 1, Input: line, Expected: want, Actual: actual[i]})
+			}
+		}
+		if len(expected) > len(lines) {
+			mismatches = append(mismatches, CorpusMismatch{Case: name, Line: len(lines) + 1, Expected: expected[len(lines)], Actual: "(no line)"})
+		}
+	}
+	return len(configs), mismatches, nil
+}
+
+// sameJSON returns true if a and b are the same JSON value, however they
+// are laid out
+func sameJSON(a string, b string) bool {
+	var x, y bytes.Buffer
+	if json.Compact(&x, []byte(a)) != nil || json.Compact(&y, []byte(b)) != nil {
+		return a == b
+	}
+	return x.String() == y.String()
+}
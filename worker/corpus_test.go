@@ -0,0 +1,23 @@
+package worker_test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/willf/translog/worker"
+)
+
+var updateCorpus = flag.Bool("update", false, "update the expected events of the golden corpus")
+
+func TestCorpus(t *testing.T) {
+	cases, mismatches, err := worker.VerifyCorpus("testdata/corpus", *updateCorpus)
+	if err != nil {
+		t.Fatalf("VerifyCorpus(): unexpected error %v", err)
+	}
+	if cases == 0 {
+		t.Errorf("VerifyCorpus(): expected cases in testdata/corpus")
+	}
+	for _, m := range mismatches {
+		t.Errorf("%v", m)
+	}
+}
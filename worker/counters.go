@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+)
+
+// CounterSet is a set of named counters, safe for concurrent use
+type CounterSet struct {
+	lock     sync.Mutex
+	counters map[string]int64
+}
+
+// Counters counts notable occurrences (dropped lines, dead letters, ...)
+// across the pipeline
+var Counters = &CounterSet{}
+
+// Add adds n to the named counter
+func (c *CounterSet) Add(name string, n int64) {
+	c.lock.Lock()
+	if c.counters == nil {
+		c.counters = make(map[string]int64)
+	}
+	c.counters[name] += n
+	c.lock.Unlock()
+}
+
+// Inc increments the named counter
+func (c *CounterSet) Inc(name string) {
+	c.Add(name, 1)
+}
+
+// Get returns the value of the named counter
+func (c *CounterSet) Get(name string) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.counters[name]
+}
+
+// Names returns the sorted names of the counters
+func (c *CounterSet) Names() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	names := make([]string, 0, len(c.counters))
+	for name := range c.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot returns a copy of all the counters
+func (c *CounterSet) Snapshot() map[string]int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	snapshot := make(map[string]int64, len(c.counters))
+	for name, value := range c.counters {
+		snapshot[name] = value
+	}
+	return snapshot
+}
@@ -0,0 +1,108 @@
+package worker
+
+/*
+	dead_letter.go records input that could not be processed
+
+	Dead letters are appended, one JSON object per line, to the file named by
+	dead_letter.file, along with the reason they were rejected, and stored
+	in the SQLite database named by dead_letter.sqlite (see
+	dead_letter_sqlite.go). If neither is configured, dead letters are only
+	counted and logged.
+*/
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configDeadLetterFile = "dead_letter.file"
+
+// recentDeadLetterCount is how many dead letters are kept in memory, for
+// the admin dashboard
+const recentDeadLetterCount = 20
+
+// DeadLetterRecord is a single dead letter
+type DeadLetterRecord struct {
+	Time   time.Time              `json:"time"`
+	Reason string                 `json:"reason"`
+	Line   string                 `json:"line,omitempty"`
+	Event  map[string]interface{} `json:"event,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+var deadLetterLock sync.Mutex
+var deadLetterFileName string
+var deadLetterOut *os.File
+var recentDeadLetters []DeadLetterRecord
+
+// cachedDeadLetterHandle returns the (possibly reopened) dead letter file,
+// or nil if there is none. It must be called with deadLetterLock held.
+func cachedDeadLetterHandle() *os.File {
+	fileName := viper.GetString(configDeadLetterFile)
+	if fileName != deadLetterFileName {
+		if deadLetterOut != nil {
+			deadLetterOut.Close()
+			deadLetterOut = nil
+		}
+		deadLetterFileName = fileName
+		if fileName != "" {
+			handle, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+			if err != nil {
+				logs.Warn("Unable to create dead letter file %s because of %s", fileName, err)
+			} else {
+				deadLetterOut = handle
+			}
+		}
+	}
+	return deadLetterOut
+}
+
+// DeadLetter records a rejected record
+func DeadLetter(record DeadLetterRecord) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	Counters.Inc("dead_letters")
+	deadLetterLock.Lock()
+	defer deadLetterLock.Unlock()
+	recentDeadLetters = append(recentDeadLetters, record)
+	if len(recentDeadLetters) > recentDeadLetterCount {
+		recentDeadLetters = recentDeadLetters[1:]
+	}
+	storeDeadLetter(record)
+	out := cachedDeadLetterHandle()
+	if out == nil {
+		logs.Debug("Dead letter (%s): %v", record.Reason, record.Line)
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		logs.Warn("Unable to marshal dead letter %v", record)
+		return
+	}
+	out.Write(append(line, '\n'))
+}
+
+// RecentDeadLetters returns the most recent dead letters, oldest first
+func RecentDeadLetters() []DeadLetterRecord {
+	deadLetterLock.Lock()
+	defer deadLetterLock.Unlock()
+	recent := make([]DeadLetterRecord, len(recentDeadLetters))
+	copy(recent, recentDeadLetters)
+	return recent
+}
+
+// deadLetterDocument records a JSON document rejected by an output
+func deadLetterDocument(document string, reason string, errorText string) {
+	record := DeadLetterRecord{Reason: reason, Line: document, Error: errorText}
+	var event map[string]interface{}
+	if json.Unmarshal([]byte(document), &event) == nil {
+		record.Event = event
+		record.Line = ""
+	}
+	DeadLetter(record)
+}
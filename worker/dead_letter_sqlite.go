@@ -0,0 +1,270 @@
+package worker
+
+/*
+	dead_letter_sqlite.go keeps dead letters in a SQLite database
+
+	With dead_letter.sqlite set to the path of a database, dead letters are
+	also stored in its dead_letters table (created if it doesn't exist),
+	with their reason and the time they were rejected, so that they can be
+	inspected, and replayed once the configuration is fixed, with translog
+	dlq list, requeue and purge. As with the SQL input, the database is used
+	through its command line client, sqlite3 (dead_letter.sqlite_client
+	overrides it), so translog needs no database driver.
+
+	Dead letters are inserted in batches, in one transaction, about every
+	second; up to deadLetterPending of them wait for their batch, and any
+	more are only counted, as dead_letters_dropped.
+
+	When requeued, lines the parser rejected (see parse.oversized_policy,
+	parse.binary_policy and parse.unmatched_policy) are parsed again, and
+	events the output rejected, which were parsed already, are sent to the
+	output again. Requeued dead letters are deleted; those rejected again
+	are stored again, as new dead letters.
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configDeadLetterSQLite = "dead_letter.sqlite"
+const configDeadLetterSQLiteClient = "dead_letter.sqlite_client"
+
+// deadLetterPending is how many dead letters wait to be inserted, at most
+const deadLetterPending = 10000
+
+// deadLetterInsertDelay is how long dead letters wait to be inserted
+const deadLetterInsertDelay = time.Second
+
+// deadLetterTimeLayout is the layout of the time column, fixed width so
+// that times compare as strings
+const deadLetterTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// deadLetterTable creates the dead_letters table, if it doesn't exist
+const deadLetterTable = "CREATE TABLE IF NOT EXISTS dead_letters (id INTEGER PRIMARY KEY AUTOINCREMENT, time TEXT NOT NULL, reason TEXT NOT NULL, line TEXT, event TEXT, error TEXT);\n"
+
+// parseDeadLetterReasons are the reasons of dead letters rejected by the
+// parser, rather than by the output
+var parseDeadLetterReasons = map[string]bool{"oversized": true, "binary": true, "unmatched": true}
+
+// ConfiguredDeadLetterSQLiteClient returns the client program the database
+// is used with
+func ConfiguredDeadLetterSQLiteClient() string {
+	if viper.IsSet(configDeadLetterSQLiteClient) {
+		return viper.GetString(configDeadLetterSQLiteClient)
+	}
+	return "sqlite3"
+}
+
+// StoredDeadLetter is a dead letter read from the database
+type StoredDeadLetter struct {
+	ID int64 `json:"id"`
+	DeadLetterRecord
+}
+
+// Requeued returns the event to send to the output again, or nil if the
+// dead letter's line is to be parsed again
+func (d StoredDeadLetter) Requeued() map[string]interface{} {
+	if d.Event != nil || parseDeadLetterReasons[d.Reason] {
+		return d.Event
+	}
+	// outputs that send events as JSON documents reject the document
+	var event map[string]interface{}
+	if json.Unmarshal([]byte(d.Line), &event) == nil {
+		return event
+	}
+	return nil
+}
+
+// DeadLetterFilter selects stored dead letters
+type DeadLetterFilter struct {
+	Reason string    // only those with this reason, if set
+	Before time.Time // only those rejected before, if set
+	Limit  int       // at most this many, oldest first, if positive
+}
+
+// where returns the SQL condition selecting the dead letters
+func (f DeadLetterFilter) where() string {
+	conditions := []string{"1"}
+	if f.Reason != "" {
+		conditions = append(conditions, "reason = "+sqliteLiteral(f.Reason))
+	}
+	if !f.Before.IsZero() {
+		conditions = append(conditions, "time < "+sqliteLiteral(f.Before.UTC().Format(deadLetterTimeLayout)))
+	}
+	where := "WHERE " + strings.Join(conditions, " AND ") + " ORDER BY id"
+	if f.Limit > 0 {
+		where += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	return where
+}
+
+// sqliteLiteral quotes a string literal, without the NUL characters SQLite
+// would end it at
+func sqliteLiteral(s string) string {
+	return quoteLiteral(strings.Replace(s, "\x00", "", -1))
+}
+
+// runSQLite runs statements against the database, and returns their output
+func runSQLite(statements string, args ...string) ([]byte, error) {
+	path := viper.GetString(configDeadLetterSQLite)
+	if path == "" {
+		return nil, fmt.Errorf("%s is not set", configDeadLetterSQLite)
+	}
+	args = append([]string{"-bail", "-cmd", ".timeout 5000"}, append(args, path)...)
+	cmd := exec.Command(ConfiguredDeadLetterSQLiteClient(), args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(deadLetterTable+statements), &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	timer := time.AfterFunc(sqlTimeout, func() { cmd.Process.Kill() })
+	err := cmd.Wait()
+	timer.Stop()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+var pendingDeadLetters []DeadLetterRecord
+var deadLetterInsertLock sync.Mutex
+
+// storeDeadLetter queues a dead letter to be inserted into the database, if
+// there is one. It must be called with deadLetterLock held.
+func storeDeadLetter(record DeadLetterRecord) {
+	if viper.GetString(configDeadLetterSQLite) == "" {
+		return
+	}
+	if len(pendingDeadLetters) >= deadLetterPending {
+		Counters.Inc("dead_letters_dropped")
+		return
+	}
+	if len(pendingDeadLetters) == 0 {
+		time.AfterFunc(deadLetterInsertDelay, FlushDeadLetters)
+	}
+	pendingDeadLetters = append(pendingDeadLetters, record)
+}
+
+// FlushDeadLetters inserts the dead letters waiting for their batch
+func FlushDeadLetters() {
+	deadLetterInsertLock.Lock()
+	defer deadLetterInsertLock.Unlock()
+	deadLetterLock.Lock()
+	records := pendingDeadLetters
+	pendingDeadLetters = nil
+	deadLetterLock.Unlock()
+	if len(records) == 0 {
+		return
+	}
+	var statements strings.Builder
+	statements.WriteString("BEGIN;\n")
+	for _, record := range records {
+		event := "NULL"
+		if record.Event != nil {
+			bs, err := json.Marshal(record.Event)
+			if err != nil {
+				logs.Warn("Unable to marshal dead letter %v", record)
+				continue
+			}
+			event = sqliteLiteral(string(bs))
+		}
+		fmt.Fprintf(&statements, "INSERT INTO dead_letters (time, reason, line, event, error) VALUES (%s, %s, %s, %s, %s);\n",
+			sqliteLiteral(record.Time.UTC().Format(deadLetterTimeLayout)), sqliteLiteral(record.Reason), sqliteLiteral(record.Line), event, sqliteLiteral(record.Error))
+	}
+	statements.WriteString("COMMIT;\n")
+	if _, err := runSQLite(statements.String()); err != nil {
+		logs.Warn("Unable to store %d dead letters in %s: %v", len(records), viper.GetString(configDeadLetterSQLite), err)
+		Counters.Add("dead_letters_dropped", int64(len(records)))
+		return
+	}
+	Counters.Add("dead_letters_stored", int64(len(records)))
+}
+
+// storedDeadLetterRow is a row of the dead_letters table, as sqlite3 -json
+// prints it
+type storedDeadLetterRow struct {
+	ID     int64  `json:"id"`
+	Time   string `json:"time"`
+	Reason string `json:"reason"`
+	Line   string `json:"line"`
+	Event  string `json:"event"`
+	Error  string `json:"error"`
+}
+
+// ListDeadLetters returns the stored dead letters selected by the filter,
+// oldest first
+func ListDeadLetters(filter DeadLetterFilter) ([]StoredDeadLetter, error) {
+	out, err := runSQLite("SELECT id, time, reason, line, event, error FROM dead_letters "+filter.where()+";\n", "-json")
+	if err != nil {
+		return nil, err
+	}
+	var rows []storedDeadLetterRow
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &rows); err != nil {
+			return nil, err
+		}
+	}
+	letters := make([]StoredDeadLetter, 0, len(rows))
+	for _, row := range rows {
+		letter := StoredDeadLetter{ID: row.ID, DeadLetterRecord: DeadLetterRecord{Reason: row.Reason, Line: row.Line, Error: row.Error}}
+		letter.Time, _ = time.Parse(deadLetterTimeLayout, row.Time)
+		if row.Event != "" {
+			if err := json.Unmarshal([]byte(row.Event), &letter.Event); err != nil {
+				return nil, fmt.Errorf("dead letter %d: %v", row.ID, err)
+			}
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// PurgeDeadLetters deletes the stored dead letters selected by the filter,
+// and returns how many it deleted
+func PurgeDeadLetters(filter DeadLetterFilter) (int64, error) {
+	out, err := runSQLite("DELETE FROM dead_letters WHERE id IN (SELECT id FROM dead_letters " + filter.where() + ");\nSELECT changes();\n")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// DeleteDeadLetters deletes stored dead letters by id
+func DeleteDeadLetters(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.FormatInt(id, 10)
+	}
+	_, err := runSQLite("DELETE FROM dead_letters WHERE id IN (" + strings.Join(list, ", ") + ");\n")
+	return err
+}
+
+// Requeue replays stored dead letters: lines the parser rejected are parsed
+// again, and events the output rejected are sent to the output again. It
+// returns once all of them have been handed to the output.
+func (w *LogParser) Requeue(letters []StoredDeadLetter) {
+	w.throttle = ConfiguredThrottle()
+	for _, letter := range letters {
+		if event := letter.Requeued(); event != nil {
+			w.publish(event)
+			atomic.AddInt64(&w.linesRead, 1)
+		} else {
+			w.readLine(letter.Line)
+		}
+	}
+	for w.Pending() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,83 @@
+package worker_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var requeuedTestCases = []struct {
+	letter   worker.StoredDeadLetter
+	expected map[string]interface{}
+}{
+	{worker.StoredDeadLetter{DeadLetterRecord: worker.DeadLetterRecord{Reason: "unmatched", Line: `{"status": 500}`}}, nil},
+	{worker.StoredDeadLetter{DeadLetterRecord: worker.DeadLetterRecord{Reason: "oversized", Line: "x"}}, nil},
+	{worker.StoredDeadLetter{DeadLetterRecord: worker.DeadLetterRecord{Reason: "late", Event: map[string]interface{}{"a": "b"}}}, map[string]interface{}{"a": "b"}},
+	{worker.StoredDeadLetter{DeadLetterRecord: worker.DeadLetterRecord{Reason: "rejected", Line: `{"status": 500}`}}, map[string]interface{}{"status": float64(500)}},
+	{worker.StoredDeadLetter{DeadLetterRecord: worker.DeadLetterRecord{Reason: "rejected", Line: "Subject: digest"}}, nil},
+}
+
+func TestStoredDeadLetterRequeued(t *testing.T) {
+	for i, testCase := range requeuedTestCases {
+		actual := testCase.letter.Requeued()
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("In test %d, Requeued(%v): expected %v, actual %v", i+1, testCase.letter, testCase.expected, actual)
+		}
+	}
+}
+
+func TestDeadLetterSQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	viper.Reset()
+	viper.Set("dead_letter.sqlite", filepath.Join(t.TempDir(), "dlq.db"))
+	defer viper.Reset()
+
+	worker.DeadLetter(worker.DeadLetterRecord{Time: time.Now().Add(-time.Hour), Reason: "unmatched", Line: "it's\x00 bad", Error: "no match"})
+	worker.DeadLetter(worker.DeadLetterRecord{Reason: "rejected", Event: map[string]interface{}{"status": "500"}, Error: "mapping"})
+	worker.DeadLetter(worker.DeadLetterRecord{Reason: "unmatched", Line: "worse"})
+	worker.FlushDeadLetters()
+
+	letters, err := worker.ListDeadLetters(worker.DeadLetterFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 3 {
+		t.Fatalf("expected 3 dead letters, actual %v", letters)
+	}
+	if letters[0].Line != "it's bad" || letters[0].Error != "no match" || time.Since(letters[0].Time) < time.Hour {
+		t.Errorf("expected the first line, actual %v", letters[0])
+	}
+	if !reflect.DeepEqual(letters[1].Event, map[string]interface{}{"status": "500"}) {
+		t.Errorf("expected the rejected event, actual %v", letters[1])
+	}
+
+	unmatched, err := worker.ListDeadLetters(worker.DeadLetterFilter{Reason: "unmatched", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmatched) != 1 || unmatched[0].ID != letters[0].ID {
+		t.Errorf("expected the oldest unmatched line, actual %v", unmatched)
+	}
+
+	n, err := worker.PurgeDeadLetters(worker.DeadLetterFilter{Before: time.Now().Add(-time.Minute)})
+	if err != nil || n != 1 {
+		t.Errorf("expected to purge 1 dead letter, actual %d (%v)", n, err)
+	}
+	if err := worker.DeleteDeadLetters([]int64{letters[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	letters, err = worker.ListDeadLetters(worker.DeadLetterFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Line != "worse" {
+		t.Errorf("expected the last line, actual %v", letters)
+	}
+}
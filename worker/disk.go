@@ -0,0 +1,167 @@
+package worker
+
+/*
+	disk.go keeps the file output from filling up the disk
+
+	With file.min_free set (a size, such as "1GB", or a percentage of the
+	filesystem, such as "5%"), the free space on the output filesystem is
+	checked every few seconds. While it is below file.min_free, the output
+	is reported unhealthy, and, depending on file.on_disk_full, either the
+	input stops reading ("pause", the default) until space is freed, or
+	events are dropped and counted in disk_full_dropped ("drop").
+*/
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configFileMinFree = "file.min_free"
+const configFileOnDiskFull = "file.on_disk_full"
+
+// diskCheckInterval is how often the free space is checked
+const diskCheckInterval = 5 * time.Second
+
+// inputPausedForDisk is set while the input waits for disk space
+var inputPausedForDisk int32
+
+// minFree is a threshold of free space, in bytes or as a percentage
+type minFree struct {
+	bytes   int64
+	percent float64
+}
+
+// ConfiguredFileMinFree returns the least free space the output filesystem
+// must keep; zero (the default) for no minimum
+func ConfiguredFileMinFree() (m minFree) {
+	if !viper.IsSet(configFileMinFree) {
+		return
+	}
+	setting := strings.TrimSpace(viper.GetString(configFileMinFree))
+	var err error
+	if strings.HasSuffix(setting, "%") {
+		m.percent, err = strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(setting, "%")), 64)
+	} else {
+		m.bytes, err = ParseByteSize(setting)
+	}
+	if err != nil {
+		reportError(&ConfigError{Key: configFileMinFree, Value: setting, Reason: err.Error()})
+		return minFree{}
+	}
+	return
+}
+
+// ConfiguredFileOnDiskFull returns what happens while the free space is below
+// file.min_free: "pause" the input, or "drop" events
+func ConfiguredFileOnDiskFull() string {
+	if viper.IsSet(configFileOnDiskFull) {
+		switch action := viper.GetString(configFileOnDiskFull); action {
+		case "pause", "drop":
+			return action
+		default:
+			reportError(&ConfigError{Key: configFileOnDiskFull, Value: action, Reason: "expected pause or drop; using pause"})
+		}
+	}
+	return "pause"
+}
+
+// enabled returns true if a minimum is set
+func (m minFree) enabled() bool {
+	return m.bytes > 0 || m.percent > 0
+}
+
+// below returns true if free bytes, out of total, are below the minimum
+func (m minFree) below(free int64, total int64) bool {
+	if m.percent > 0 {
+		return total > 0 && float64(free)*100/float64(total) < m.percent
+	}
+	return free < m.bytes
+}
+
+// diskSpace returns the bytes available to unprivileged users, and the size,
+// of the filesystem holding path, or its closest existing parent
+func diskSpace(path string) (free int64, total int64, err error) {
+	var stat syscall.Statfs_t
+	for {
+		if err = syscall.Statfs(path, &stat); err == nil {
+			return int64(stat.Bavail) * int64(stat.Bsize), int64(stat.Blocks) * int64(stat.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return
+		}
+		path = parent
+	}
+}
+
+// outputDir returns the directory holding the files an output file name
+// template produces
+func outputDir(template string) string {
+	if i := strings.Index(template, "{"); i >= 0 {
+		template = template[:i]
+	}
+	return filepath.Dir(template)
+}
+
+// checkDisk checks the free space, returning true if it is below the minimum
+func (w *FileWorker) checkDisk(dir string, m minFree, action string, full bool) bool {
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		logs.Warn("Unable to check the free space of %s: %v", dir, err)
+		return full
+	}
+	switch below := m.below(free, total); {
+	case below && !full:
+		logs.Warn("Only %d bytes free on %s; %s", free, dir, map[string]string{"pause": "pausing input", "drop": "dropping events"}[action])
+		w.failed(&OutputError{Destination: dir, Err: fmt.Errorf("Only %d bytes free, below %s", free, configFileMinFree)})
+		Counters.Inc("disk_full")
+	case !below && full:
+		logs.Info("%d bytes free on %s; resuming", free, dir)
+	}
+	return m.below(free, total)
+}
+
+// monitorDisk checks the free space every diskCheckInterval until done is
+// closed, pausing the input or dropping events while it is low
+func (w *FileWorker) monitorDisk(m minFree, action string, done chan bool) {
+	dir := outputDir(w.template)
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	full := false
+	for {
+		full = w.checkDisk(dir, m, action, full)
+		var flag int32
+		if full {
+			flag = 1
+		}
+		if action == "pause" {
+			atomic.StoreInt32(&inputPausedForDisk, flag)
+		} else {
+			atomic.StoreInt32(&w.diskFull, flag)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			atomic.StoreInt32(&inputPausedForDisk, 0)
+			return
+		}
+	}
+}
+
+// waitForDisk waits while the input is paused for disk space
+func waitForDisk() {
+	if atomic.LoadInt32(&inputPausedForDisk) == 0 {
+		return
+	}
+	Counters.Inc("disk_full_paused")
+	for atomic.LoadInt32(&inputPausedForDisk) != 0 {
+		time.Sleep(diskCheckInterval / 10)
+	}
+}
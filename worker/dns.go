@@ -0,0 +1,185 @@
+package worker
+
+/*
+	dns.go resolves IP addresses to host names
+
+	With transform.reverse_dns.field set, e.g. to "client", the IP address
+	in that field is resolved to a host name, which is added as
+	<field>_hostname (client_hostname). This makes internal networks, where
+	addresses alone say little, easier to read.
+
+	Lookups are cached, successful or not, for transform.reverse_dns.ttl;
+	the cache holds at most transform.reverse_dns.cache_size addresses,
+	forgetting the least recently used. A lookup that takes longer than
+	transform.reverse_dns.timeout is given up, and the event goes on
+	without a host name.
+*/
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configReverseDNSField = "transform.reverse_dns.field"
+const configReverseDNSCacheSize = "transform.reverse_dns.cache_size"
+const configReverseDNSTTL = "transform.reverse_dns.ttl"
+const configReverseDNSTimeout = "transform.reverse_dns.timeout"
+
+// ConfiguredReverseDNSField returns the field holding addresses to resolve,
+// if any
+func ConfiguredReverseDNSField() string {
+	if viper.IsSet(configReverseDNSField) {
+		return viper.GetString(configReverseDNSField)
+	}
+	return ""
+}
+
+// ConfiguredReverseDNSCacheSize returns how many addresses are cached
+func ConfiguredReverseDNSCacheSize() int {
+	if viper.IsSet(configReverseDNSCacheSize) {
+		size := viper.GetInt(configReverseDNSCacheSize)
+		if size > 0 {
+			return size
+		}
+		reportError(&ConfigError{Key: configReverseDNSCacheSize, Value: viper.Get(configReverseDNSCacheSize), Reason: "using 10000"})
+	}
+	return 10000
+}
+
+// ConfiguredReverseDNSTTL returns how long lookups are cached
+func ConfiguredReverseDNSTTL() time.Duration {
+	if viper.IsSet(configReverseDNSTTL) {
+		ttl := viper.GetDuration(configReverseDNSTTL)
+		if ttl > 0 {
+			return ttl
+		}
+		reportError(&ConfigError{Key: configReverseDNSTTL, Value: viper.Get(configReverseDNSTTL), Reason: "using 10m"})
+	}
+	return 10 * time.Minute
+}
+
+// ConfiguredReverseDNSTimeout returns how long a lookup may take
+func ConfiguredReverseDNSTimeout() time.Duration {
+	if viper.IsSet(configReverseDNSTimeout) {
+		timeout := viper.GetDuration(configReverseDNSTimeout)
+		if timeout > 0 {
+			return timeout
+		}
+		reportError(&ConfigError{Key: configReverseDNSTimeout, Value: viper.Get(configReverseDNSTimeout), Reason: "using 500ms"})
+	}
+	return 500 * time.Millisecond
+}
+
+// LookupAddrFunc resolves an address to host names, like
+// net.Resolver.LookupAddr
+type LookupAddrFunc func(ctx context.Context, addr string) ([]string, error)
+
+// dnsEntry is a cached lookup
+type dnsEntry struct {
+	addr    string
+	host    string // empty if the lookup failed
+	expires time.Time
+}
+
+// DNSCache resolves addresses to host names, remembering the answers
+type DNSCache struct {
+	lookup  LookupAddrFunc
+	size    int
+	ttl     time.Duration
+	timeout time.Duration
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	recent  *list.List // of *dnsEntry, most recently used first
+}
+
+// NewDNSCache returns a cache of at most size lookups, each kept for ttl;
+// lookups taking longer than timeout are given up
+func NewDNSCache(lookup LookupAddrFunc, size int, ttl time.Duration, timeout time.Duration) *DNSCache {
+	return &DNSCache{
+		lookup:  lookup,
+		size:    size,
+		ttl:     ttl,
+		timeout: timeout,
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+// Hostname returns the host name of addr, or "" if it has none
+func (c *DNSCache) Hostname(addr string) string {
+	c.lock.Lock()
+	if element, ok := c.entries[addr]; ok {
+		entry := element.Value.(*dnsEntry)
+		if time.Now().Before(entry.expires) {
+			c.recent.MoveToFront(element)
+			c.lock.Unlock()
+			Counters.Inc("reverse_dns_hits")
+			return entry.host
+		}
+	}
+	c.lock.Unlock()
+	Counters.Inc("reverse_dns_lookups")
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	var host string
+	names, err := c.lookup(ctx, addr)
+	if err != nil || len(names) == 0 {
+		Counters.Inc("reverse_dns_failures")
+	} else {
+		host = strings.TrimSuffix(names[0], ".")
+	}
+	c.store(addr, host)
+	return host
+}
+
+// store caches a lookup, forgetting the least recently used one if the
+// cache is full
+func (c *DNSCache) store(addr string, host string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := &dnsEntry{addr: addr, host: host, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[addr]; ok {
+		element.Value = entry
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.entries[addr] = c.recent.PushFront(entry)
+	for c.recent.Len() > c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsEntry).addr)
+	}
+}
+
+// ConfiguredDNSCache returns the cache for reverse DNS lookups, or nil if
+// they're not configured
+func ConfiguredDNSCache() *DNSCache {
+	if ConfiguredReverseDNSField() == "" {
+		return nil
+	}
+	return NewDNSCache(net.DefaultResolver.LookupAddr, ConfiguredReverseDNSCacheSize(), ConfiguredReverseDNSTTL(), ConfiguredReverseDNSTimeout())
+}
+
+// resolve adds the host name of the event's address field
+func (w *LogParser) resolve(v map[string]interface{}) {
+	if w.dns == nil {
+		return
+	}
+	value, found := lookupField(v, w.dnsField)
+	if !found || value == nil {
+		return
+	}
+	addr := fmt.Sprint(value)
+	if net.ParseIP(addr) == nil {
+		return
+	}
+	if host := w.dns.Hostname(addr); host != "" {
+		v[w.dnsField+"_hostname"] = host
+	}
+}
@@ -0,0 +1,47 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/willf/translog/worker"
+)
+
+var dnsCacheTestCases = []struct {
+	addr     string
+	expected string
+	lookups  int
+}{
+	{"10.0.0.1", "web-1.internal", 1},
+	{"10.0.0.1", "web-1.internal", 1},
+	{"10.0.0.2", "", 2},
+	{"10.0.0.2", "", 2},
+	{"10.0.0.3", "db-1.internal", 3},
+	{"10.0.0.1", "web-1.internal", 4}, // forgotten, as the cache holds two
+	{"10.0.0.9", "", 5},               // timed out
+}
+
+func TestDNSCache(t *testing.T) {
+	names := map[string]string{"10.0.0.1": "web-1.internal.", "10.0.0.3": "db-1.internal."}
+	lookups := 0
+	lookup := func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if addr == "10.0.0.9" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		if name, ok := names[addr]; ok {
+			return []string{name}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	c := worker.NewDNSCache(lookup, 2, time.Minute, 10*time.Millisecond)
+	for i, tt := range dnsCacheTestCases {
+		actual := c.Hostname(tt.addr)
+		if actual != tt.expected || lookups != tt.lookups {
+			t.Errorf("In test %d, Hostname(%v): expected %v after %d lookups, actual %v after %d", i, tt.addr, tt.expected, tt.lookups, actual, lookups)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package worker
+
+/*
+	ecs.go maps events to the Elastic Common Schema
+
+	If output.schema is "ecs", common field names are renamed to their ECS
+	equivalents (e.g. status becomes http.response.status_code), as nested
+	objects, so that Kibana's built-in dashboards work with translog's
+	events. The first time value of a timestamp field becomes @timestamp.
+	Fields without an ECS equivalent are kept as they are.
+*/
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configOutputSchema = "output.schema"
+
+// ECSVersion is the version of the Elastic Common Schema that events are
+// mapped to
+const ECSVersion = "8.11.0"
+
+// ecsFieldNames maps common field names to their ECS names
+var ecsFieldNames = map[string]string{
+	"ip":              "source.ip",
+	"host":            "source.ip", // the remote host of the common log format
+	"client_ip":       "source.ip",
+	"clientip":        "source.ip",
+	"remote_addr":     "source.ip",
+	"remote_ip":       "source.ip",
+	"status":          "http.response.status_code",
+	"status_code":     "http.response.status_code",
+	"response":        "http.response.status_code",
+	"bytes":           "http.response.body.bytes",
+	"body_bytes_sent": "http.response.body.bytes",
+	"size":            "http.response.body.bytes",
+	"user_agent":      "user_agent.original",
+	"agent":           "user_agent.original",
+	"http_user_agent": "user_agent.original",
+	"method":          "http.request.method",
+	"verb":            "http.request.method",
+	"referer":         "http.request.referrer",
+	"referrer":        "http.request.referrer",
+	"http_referer":    "http.request.referrer",
+	"http_version":    "http.version",
+	"httpversion":     "http.version",
+	"uri":             "url.original",
+	"request":         "url.original",
+	"uri_path":        "url.path",
+	"uri_query_raw":   "url.query",
+	"uri_extension":   "url.extension",
+	"user":            "user.name",
+	"remote_user":     "user.name",
+	"auth":            "user.name",
+	"level":           "log.level",
+	"message":         "message",
+	"created":         "@timestamp",
+	"timestamp":       "@timestamp",
+	"time":            "@timestamp",
+	"time_local":      "@timestamp",
+	"@timestamp":      "@timestamp",
+	"datetime":        "@timestamp",
+	"date":            "@timestamp",
+}
+
+// ConfiguredOutputSchema returns the schema events are mapped to before
+// output: "" (none) or "ecs"
+func ConfiguredOutputSchema() string {
+	if viper.IsSet(configOutputSchema) {
+		return viper.GetString(configOutputSchema)
+	}
+	return ""
+}
+
+// setECSField sets the value at a dotted ECS path, creating nested objects;
+// it returns false if the path conflicts with an existing value
+func setECSField(v map[string]interface{}, path string, value interface{}) bool {
+	parts := strings.Split(path, ".")
+	m := v
+	for _, part := range parts[:len(parts)-1] {
+		next, found := m[part]
+		if !found {
+			next = make(map[string]interface{})
+			m[part] = next
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		m = nested
+	}
+	last := parts[len(parts)-1]
+	if _, found := m[last]; found {
+		return false
+	}
+	m[last] = value
+	return true
+}
+
+// ToECS maps an event to the Elastic Common Schema
+func ToECS(v map[string]interface{}) map[string]interface{} {
+	ecs := make(map[string]interface{}, len(v))
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	// sorted, so that which of two fields with the same ECS name wins is stable
+	sort.Strings(keys)
+	unmapped := make([]string, 0)
+	for _, key := range keys {
+		value := v[key]
+		path, found := ecsFieldNames[key]
+		if !found {
+			unmapped = append(unmapped, key)
+			continue
+		}
+		if path == "@timestamp" {
+			// only times are timestamps; e.g. a request duration named time is not
+			t, ok := value.(time.Time)
+			if !ok {
+				unmapped = append(unmapped, key)
+				continue
+			}
+			value = t.UTC().Format(time.RFC3339Nano)
+		}
+		if !setECSField(ecs, path, value) {
+			unmapped = append(unmapped, key)
+		}
+	}
+	for _, key := range unmapped {
+		ecs[newKeyName(key, ecs)] = v[key]
+	}
+	setECSField(ecs, "ecs.version", ECSVersion)
+	return ecs
+}
+
+// applySchema maps an event to the configured output schema
+func applySchema(v map[string]interface{}) map[string]interface{} {
+	if ConfiguredOutputSchema() == "ecs" {
+		return ToECS(v)
+	}
+	return v
+}
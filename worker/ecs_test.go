@@ -0,0 +1,64 @@
+package worker_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestToECS(t *testing.T) {
+	created := time.Date(2016, 4, 1, 11, 0, 0, 0, time.UTC)
+	event := map[string]interface{}{
+		"ip":         "8.8.8.8",
+		"status":     int64(404),
+		"bytes":      int64(512),
+		"user_agent": "curl/7.47.0",
+		"created":    created,
+		"time":       0.25, // a duration, not a timestamp
+		"source":     "web1",
+		"region":     "us-east",
+	}
+	expected := map[string]interface{}{
+		"@timestamp": "2016-04-01T11:00:00Z",
+		"source":     map[string]interface{}{"ip": "8.8.8.8"},
+		"_source":    "web1",
+		"http": map[string]interface{}{
+			"response": map[string]interface{}{
+				"status_code": int64(404),
+				"body":        map[string]interface{}{"bytes": int64(512)},
+			},
+		},
+		"user_agent": map[string]interface{}{"original": "curl/7.47.0"},
+		"time":       0.25,
+		"region":     "us-east",
+		"ecs":        map[string]interface{}{"version": worker.ECSVersion},
+	}
+	actual := worker.ToECS(event)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ToECS(%v): expected %v, actual %v", event, expected, actual)
+	}
+}
+
+func TestToECSCommonLogFormat(t *testing.T) {
+	viper.Reset()
+	// the pattern in DefaultParseLogPattern's comment: client is the identd
+	// field, and host the remote address
+	pattern := `(?P<host>\S+) (?P<client>\S+) (?P<user>\S+) \[(?P<created>[^\]]+)\] "((?P<method>[A-Z]+) )?(?P<uri>\S+).*"`
+	viper.Set("parse.pattern", pattern)
+	w := &worker.LogParser{}
+	w.Init()
+	event, err := w.ParseEvents(`10.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.0"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := worker.ToECS(event)
+	if source, _ := actual["source"].(map[string]interface{}); source["ip"] != "10.0.0.1" {
+		t.Errorf("expected source.ip to be 10.0.0.1, actual %v", actual["source"])
+	}
+	if actual["client"] != "-" {
+		t.Errorf("expected client to be kept as it is, actual %v", actual["client"])
+	}
+}
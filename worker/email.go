@@ -0,0 +1,318 @@
+package worker
+
+/*
+	email.go emails digests of events
+
+	translog email collects the events matching email.where, a condition as
+	in alert rules (see alert.go; every event, if it is empty), formats
+	each with email.template, a Go template as in chat.go, and emails them
+	as one digest every email.interval (1h by default) to email.to, from
+	email.from. A digest lists at most email.max_events events (100 by
+	default), and how many more there were. No digest is sent for an
+	interval without events. Events matching email.immediate, e.g.
+	"level_value <= 2", are emailed on their own, at once, rather than in
+	the next digest.
+
+	Mail is sent through email.smtp_address, authenticating with
+	email.username and email.password, if set. email.security is starttls
+	(the default: upgrade to TLS if the server offers it), tls (connect
+	with TLS, as on port 465), or none; email.tls configures the
+	certificate authority and client certificate (see tls.go). Digests
+	that can't be sent are dead-lettered.
+*/
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configEmailSMTPAddress = "email.smtp_address"
+const configEmailSecurity = "email.security"
+const configEmailUsername = "email.username"
+const configEmailPassword = "email.password"
+const configEmailFrom = "email.from"
+const configEmailTo = "email.to"
+const configEmailSubject = "email.subject"
+const configEmailWhere = "email.where"
+const configEmailImmediate = "email.immediate"
+const configEmailTemplate = "email.template"
+const configEmailInterval = "email.interval"
+const configEmailMaxEvents = "email.max_events"
+
+// ConfiguredEmailSMTPAddress returns the address of the SMTP server
+func ConfiguredEmailSMTPAddress() string {
+	if viper.IsSet(configEmailSMTPAddress) {
+		return viper.GetString(configEmailSMTPAddress)
+	}
+	return "localhost:25"
+}
+
+// ConfiguredEmailSecurity returns how the connection to the SMTP server is
+// secured: starttls, tls, or none
+func ConfiguredEmailSecurity() string {
+	switch security := strings.ToLower(viper.GetString(configEmailSecurity)); security {
+	case "", "starttls":
+		return "starttls"
+	case "tls", "none":
+		return security
+	default:
+		reportError(&ConfigError{Key: configEmailSecurity, Value: security, Reason: "expected starttls, tls, or none; using starttls"})
+		return "starttls"
+	}
+}
+
+// ConfiguredEmailSubject returns the subject of digests, which may use
+// {count} for the number of events
+func ConfiguredEmailSubject() string {
+	if viper.IsSet(configEmailSubject) {
+		return viper.GetString(configEmailSubject)
+	}
+	return "[translog] {count} events"
+}
+
+// ConfiguredEmailInterval returns how often digests are sent
+func ConfiguredEmailInterval() time.Duration {
+	if viper.IsSet(configEmailInterval) {
+		if interval := viper.GetDuration(configEmailInterval); interval > 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configEmailInterval, Value: viper.Get(configEmailInterval), Reason: "using 1h"})
+	}
+	return time.Hour
+}
+
+// ConfiguredEmailMaxEvents returns the most events listed in a digest
+func ConfiguredEmailMaxEvents() int {
+	if viper.IsSet(configEmailMaxEvents) {
+		if max := viper.GetInt(configEmailMaxEvents); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configEmailMaxEvents, Value: viper.Get(configEmailMaxEvents), Reason: "using 100"})
+	}
+	return 100
+}
+
+// EmailMessage returns an email, with its headers, listing messages, and
+// how many more were suppressed
+func EmailMessage(from string, to []string, subject string, messages []string, suppressed int, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, message := range messages {
+		b.WriteString(strings.Replace(message, "\n", "\r\n", -1))
+		b.WriteString("\r\n")
+	}
+	if suppressed > 0 {
+		fmt.Fprintf(&b, "(and %d more)\r\n", suppressed)
+	}
+	return b.Bytes()
+}
+
+// sendEmail sends an email through the configured SMTP server
+func sendEmail(from string, to []string, message []byte) error {
+	address := ConfiguredEmailSMTPAddress()
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	security := ConfiguredEmailSecurity()
+	config, err := configuredTLS("email")
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	var conn net.Conn
+	if security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if security == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(config); err != nil {
+				return err
+			}
+		}
+	}
+	if username := refreshedString(configEmailUsername); username != "" {
+		if err := c.Auth(smtp.PlainAuth("", username, refreshedString(configEmailPassword), host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := c.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// EmailWorker emails digests of events
+type EmailWorker struct {
+	WorkChannel  chan map[string]interface{}
+	QuitChannel  chan bool
+	FlushChannel chan bool
+	where        func(v map[string]interface{}) bool
+	immediate    func(v map[string]interface{}) bool
+	template     *template.Template
+	maxEvents    int
+	messages     []string
+	suppressed   int
+	startTime    time.Time
+	healthTracker
+}
+
+func (w *EmailWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *EmailWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	w.where, err = CompileCondition(viper.GetString(configEmailWhere))
+	if err != nil {
+		reportError(&ConfigError{Key: configEmailWhere, Value: viper.Get(configEmailWhere), Reason: err.Error() + "; emailing every event"})
+		w.where, _ = CompileCondition("")
+	}
+	if expr := viper.GetString(configEmailImmediate); expr != "" {
+		w.immediate, err = CompileCondition(expr)
+		if err != nil {
+			reportError(&ConfigError{Key: configEmailImmediate, Value: expr, Reason: err.Error() + "; emailing every event in digests"})
+		}
+	}
+	w.template = configuredEventTemplate(configEmailTemplate)
+	w.maxEvents = ConfiguredEmailMaxEvents()
+	return nil
+}
+
+// Start the work
+func (w *EmailWorker) Start() {
+	go Supervise("EmailWorker", w.Work)
+}
+
+// send emails messages, with a subject for count events
+func (w *EmailWorker) send(messages []string, suppressed int) {
+	from, to := viper.GetString(configEmailFrom), viper.GetStringSlice(configEmailTo)
+	subject := FillTemplate(ConfiguredEmailSubject(), map[string]interface{}{"count": len(messages) + suppressed})
+	message := EmailMessage(from, to, subject, messages, suppressed, time.Now())
+	start := time.Now()
+	if err := sendEmail(from, to, message); err != nil {
+		w.failed(&OutputError{Destination: ConfiguredEmailSMTPAddress(), Err: err})
+		DeadLetter(DeadLetterRecord{Reason: "rejected", Line: string(message), Error: err.Error()})
+		return
+	}
+	Counters.Add("email_events", int64(len(messages)))
+	Counters.Inc("emails")
+	w.succeeded(time.Since(start))
+}
+
+// add formats an event, if it matches email.where, and keeps it for the
+// next digest, or emails it at once if it matches email.immediate
+func (w *EmailWorker) add(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	if !w.where(obj) {
+		Counters.Inc("email_skipped")
+		return
+	}
+	immediate := w.immediate != nil && w.immediate(obj)
+	if !immediate && len(w.messages) >= w.maxEvents {
+		w.suppressed++
+		Counters.Inc("email_suppressed")
+		return
+	}
+	var message bytes.Buffer
+	if err := w.template.Execute(&message, obj); err != nil {
+		logs.Info("Unable to format %v for email: %v", obj, err)
+		Counters.Inc("email_template_errors")
+		return
+	}
+	if immediate {
+		w.send([]string{message.String()}, 0)
+		return
+	}
+	w.messages = append(w.messages, message.String())
+}
+
+// flush emails the digest of the events collected since the last one
+func (w *EmailWorker) flush() {
+	if len(w.messages) == 0 {
+		return
+	}
+	w.send(w.messages, w.suppressed)
+	w.messages, w.suppressed = nil, 0
+}
+
+// Work the queue
+func (w *EmailWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("EmailWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredEmailInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.add(obj)
+
+		case <-ticker.C:
+			w.flush()
+
+		case <-w.FlushChannel:
+			w.flush()
+
+		case <-w.QuitChannel:
+			logs.Info("EmailWorker received quit")
+			w.flush()
+			return
+		}
+	}
+}
+
+// Flush asks the worker to email the digest of the events it has collected
+func (w *EmailWorker) Flush() {
+	w.FlushChannel <- true
+}
+
+// Stop stops the worker, after emailing the events it has collected
+func (w *EmailWorker) Stop() {
+	w.QuitChannel <- true
+}
@@ -0,0 +1,109 @@
+package worker_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// fakeSMTPServer accepts mail without authentication or TLS, and sends
+// the data of each message to the channel it returns
+func fakeSMTPServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				conn.Write([]byte("220 localhost ESMTP\r\n"))
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch command := strings.ToUpper(strings.TrimSpace(line)); {
+					case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+						conn.Write([]byte("250 localhost\r\n"))
+					case command == "DATA":
+						conn.Write([]byte("354 go ahead\r\n"))
+						var data strings.Builder
+						for {
+							line, err := r.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							data.WriteString(line)
+						}
+						messages <- data.String()
+						conn.Write([]byte("250 ok\r\n"))
+					case command == "QUIT":
+						conn.Write([]byte("221 bye\r\n"))
+						return
+					default:
+						conn.Write([]byte("250 ok\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestEmailMessage(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	message := string(worker.EmailMessage("a@example.com", []string{"b@example.com", "c@example.com"}, "[translog] 3 events", []string{"one", "two"}, 1, now))
+	for _, expected := range []string{"From: a@example.com\r\n", "To: b@example.com, c@example.com\r\n", "Subject: [translog] 3 events\r\n", "\r\n\r\none\r\ntwo\r\n(and 1 more)\r\n"} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("EmailMessage: expected %q in %q", expected, message)
+		}
+	}
+}
+
+func TestEmailWorker(t *testing.T) {
+	viper.Reset()
+	address, messages := fakeSMTPServer(t)
+	viper.Set("email.smtp_address", address)
+	viper.Set("email.from", "translog@example.com")
+	viper.Set("email.to", []string{"ops@example.com"})
+	viper.Set("email.where", "status >= 500")
+	viper.Set("email.immediate", "status >= 503")
+	viper.Set("email.template", "{{.status}} on {{.uri}}")
+
+	w := &worker.EmailWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"status": int64(200), "uri": "/"}
+	w.WorkChannel <- map[string]interface{}{"status": int64(500), "uri": "/a"}
+	w.WorkChannel <- map[string]interface{}{"status": int64(503), "uri": "/b"}
+	w.WorkChannel <- map[string]interface{}{"status": int64(502), "uri": "/c"}
+
+	expectEmail := func(subject string, body string) {
+		select {
+		case message := <-messages:
+			if !strings.Contains(message, "Subject: "+subject+"\r\n") || !strings.HasSuffix(message, "\r\n\r\n"+body) {
+				t.Errorf("EmailWorker: expected subject %q and body %q, actual %q", subject, body, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("EmailWorker: timed out waiting for %q", subject)
+		}
+	}
+	expectEmail("[translog] 1 events", "503 on /b\r\n")
+	w.Flush()
+	expectEmail("[translog] 2 events", "500 on /a\r\n502 on /c\r\n")
+}
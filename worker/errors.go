@@ -0,0 +1,76 @@
+package worker
+
+/*
+	errors.go defines the errors workers report
+
+	Rather than formatting errors into log messages, workers report typed
+	errors (ParseError, OutputError, ConfigError) on the channel returned
+	by Errors, so that the caller (see run.Run) can decide how to log,
+	count, or act on them. Reporting never blocks: if nobody keeps up with
+	the channel, errors are dropped, and counted as errors_dropped.
+*/
+import (
+	"fmt"
+)
+
+// errorBuffer is how many errors are buffered for the consumer
+const errorBuffer = 1000
+
+var errorChannel = make(chan error, errorBuffer)
+
+// ParseError reports a line that did not match the pattern, or that the
+// codec could not decode
+type ParseError struct {
+	Line    string
+	Pattern string
+	Codec   string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Line %q could not be decoded as %s: %v", e.Line, e.Codec, e.Err)
+	}
+	return fmt.Sprintf("Line %s did not match pattern %s", e.Line, e.Pattern)
+}
+
+// OutputError reports a failure to send events to a destination (a host, a
+// file, a stream, ...)
+type OutputError struct {
+	Destination string
+	Err         error
+}
+
+func (e *OutputError) Error() string {
+	return fmt.Sprintf("Unable to send to %s: %v", e.Destination, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError reports an invalid setting
+type ConfigError struct {
+	Key    string
+	Value  interface{}
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("Invalid %s %v: %s", e.Key, e.Value, e.Reason)
+}
+
+// Errors returns the channel errors are reported on
+func Errors() <-chan error {
+	return errorChannel
+}
+
+// reportError reports an error on the error channel, without blocking
+func reportError(err error) {
+	select {
+	case errorChannel <- err:
+	default:
+		Counters.Inc("errors_dropped")
+	}
+}
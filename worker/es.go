@@ -2,31 +2,41 @@ package worker
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/fizx/logs"
 	"github.com/spf13/viper"
 )
 
+// bulkAction is the metadata of a document in a bulk request
+type bulkAction struct {
+	Index    string `json:"_index"`
+	Type     string `json:"_type,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+	Routing  string `json:"routing,omitempty"`
+}
+
 // ElasticSearchWorker bulk uploads to ElasticSearch
 type ElasticSearchWorker struct {
 	WorkChannel  chan map[string]interface{}
+	BatchChannel chan []map[string]interface{}
 	QuitChannel  chan bool
-	robinIndex   int
-	robinLock    sync.Mutex
+	FlushChannel chan bool
+	nodes        nodePool
 	counter      int
 	totalCounter int64
 	items        []string
 	startTime    time.Time
 	lastTime     time.Time
 	lastCount    int64
+	requests     *requestPool
+	healthTracker
 }
 
 func ConfiguredElasticSearchHosts() []string {
@@ -79,6 +89,26 @@ func ConfiguredElasticSearchDocumentType() string {
 	return "event"
 }
 
+// ConfiguredElasticSearchPipeline returns the ingest pipeline documents are
+// indexed through, if any; it may use placeholders, like es.index
+func ConfiguredElasticSearchPipeline() string {
+	key := "es.pipeline"
+	if viper.IsSet(key) {
+		return viper.GetString(key)
+	}
+	return ""
+}
+
+// ConfiguredElasticSearchRouting returns the routing value of documents, if
+// any; it may use placeholders, like es.index
+func ConfiguredElasticSearchRouting() string {
+	key := "es.routing"
+	if viper.IsSet(key) {
+		return viper.GetString(key)
+	}
+	return ""
+}
+
 func ConfiguredElasticSearchFlushEvery() int64 {
 	key := "es.flush_every"
 	if viper.IsSet(key) {
@@ -103,33 +133,47 @@ func ConfiguredElasticSearchUseDateSuffix() bool {
 	return false
 }
 
+// ConfiguredElasticSearchCompress returns true if bulk requests are gzipped
+func ConfiguredElasticSearchCompress() bool {
+	key := "es.compress"
+	if viper.IsSet(key) {
+		return viper.GetBool(key)
+	}
+	return false
+}
+
 func (w *ElasticSearchWorker) Init() (err error) {
-	w.QuitChannel = make(chan bool)
-	_, err = url.Parse(w.Endpoint())
+	// Init is also called to reset the worker after each flush, so the
+	// channels must not be replaced while Stop or Flush may be using them
+	if w.QuitChannel == nil {
+		w.QuitChannel = make(chan bool)
+	}
+	if w.FlushChannel == nil {
+		w.FlushChannel = make(chan bool)
+	}
+	err = w.nodes.validate()
 	if err != nil {
-		logs.Fatal("Invalid Elastic Search endpoint: %v", w.Endpoint())
-		err = fmt.Errorf("Invalid Elastic Search endpoint: %v", w.Endpoint())
+		logs.Fatal("%v", err)
 		return
 	}
 	w.counter = 0
-	w.robinIndex = 0
 	w.items = make([]string, ConfiguredElasticSearchMax()*2) // need to make room for create commands
 	return
 }
 
+// NextHost returns the next live host to send a request to
 func (w *ElasticSearchWorker) NextHost() string {
-	hosts := ConfiguredElasticSearchHosts()
-	w.robinLock.Lock()
-	host := hosts[w.robinIndex]
-	w.robinIndex = (w.robinIndex + 1) % len(hosts)
-	w.robinLock.Unlock()
-	return host
+	return w.nodes.next().Host
 }
 
+// Endpoint returns the bulk endpoint of the next live host
 func (w *ElasticSearchWorker) Endpoint() string {
-	host := w.NextHost()
-	endpoint := fmt.Sprintf("%s://%s:%d/_bulk", ConfiguredElasticSearchScheme(), host, ConfiguredElasticSearchPort())
-	return endpoint
+	return w.nodes.next().URL + "/_bulk"
+}
+
+// Nodes returns the state of the Elastic Search nodes
+func (w *ElasticSearchWorker) Nodes() []ESNode {
+	return w.nodes.Nodes()
 }
 
 func (w *ElasticSearchWorker) CurrentCount() int {
@@ -141,7 +185,7 @@ func (w *ElasticSearchWorker) CurrentItems() []string {
 }
 
 func (w *ElasticSearchWorker) Index() string {
-	return ConfiguredElasticSearchIndex()
+	return TenantIndex(ConfiguredElasticSearchIndex())
 }
 
 func (w *ElasticSearchWorker) DocumentType() string {
@@ -164,9 +208,16 @@ func (w *ElasticSearchWorker) SetWorkChannel(channel chan map[string]interface{}
 	w.WorkChannel = channel
 }
 
+// SetBatchChannel sets the channel batches of events are received on
+func (w *ElasticSearchWorker) SetBatchChannel(channel chan []map[string]interface{}) {
+	w.BatchChannel = channel
+}
+
 // Start the work
 func (w *ElasticSearchWorker) Start() {
-	go w.Work()
+	w.nodes.start()
+	w.requests = ConfiguredRequestPool("ElasticSearchWorker")
+	go Supervise("ElasticSearchWorker", w.Work)
 }
 
 // Work the queue
@@ -177,25 +228,16 @@ func (w *ElasticSearchWorker) Work() {
 	for {
 		select {
 		case obj := <-w.WorkChannel:
-			logs.Debug("worker received: %v; current count is %v", obj, w.counter)
-			if w.counter >= ConfiguredElasticSearchMax()*2 || w.Mocking() {
-				w.flush(false)
-			}
-			line, err := json.Marshal(obj)
-			if err != nil {
-				logs.Info("Unable to marshal object %v", obj)
-				break
-			}
-			docType := w.DocumentType()
-			index := w.Index()
-			if w.UseDateSuffix() {
-				index += time.Now().Format("2006.01.02")
+			w.add(obj)
+
+		case batch := <-w.BatchChannel:
+			for _, obj := range batch {
+				w.add(obj)
 			}
-			createDoc := fmt.Sprintf(`{"create": { "_index": "%s", "_type": "%s"}}`,
-				index, docType)
-			w.items[w.counter] = createDoc
-			w.items[w.counter+1] = string(line)
-			w.counter += 2
+
+		case <-w.FlushChannel:
+			w.flush(true)
+			w.requests.Wait()
 
 		case <-w.QuitChannel:
 			logs.Info("w received quit")
@@ -204,10 +246,119 @@ func (w *ElasticSearchWorker) Work() {
 	}
 }
 
+// add adds the action and document lines of an event to the bulk request,
+// flushing first if the request is full
+func (w *ElasticSearchWorker) add(obj map[string]interface{}) {
+	logs.Debug("worker received: %v; current count is %v", obj, w.counter)
+	if w.counter >= ConfiguredElasticSearchMax()*2 || w.Mocking() {
+		w.flush(false)
+	}
+	line, err := json.Marshal(obj)
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	action := bulkAction{
+		Index:    IndexName(w.Index(), obj),
+		Type:     w.DocumentType(),
+		Pipeline: FillTemplate(ConfiguredElasticSearchPipeline(), obj),
+		Routing:  FillTemplate(ConfiguredElasticSearchRouting(), obj),
+	}
+	ReleaseEvent(obj)
+	if w.UseDateSuffix() {
+		action.Index += time.Now().Format("2006.01.02")
+	}
+	createDoc, _ := json.Marshal(map[string]bulkAction{"create": action})
+	w.items[w.counter] = string(createDoc)
+	w.items[w.counter+1] = string(line)
+	w.counter += 2
+}
+
+// Flush asks the worker to bulk upload the documents it has collected
+func (w *ElasticSearchWorker) Flush() {
+	w.FlushChannel <- true
+}
+
 // Stop stops the w by send a message on its quit channel
 func (w *ElasticSearchWorker) Stop() {
 	w.QuitChannel <- true
+	w.nodes.stop()
 	w.flush(true)
+	w.requests.Wait()
+}
+
+// bulkUpload posts items (pairs of action and document lines), retrying the
+// documents that were rejected because the cluster is overloaded
+func (w *ElasticSearchWorker) bulkUpload(items []string) {
+	backoff := ConfiguredElasticSearchRetryBackoff()
+	for attempt := 0; len(items) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > ConfiguredElasticSearchMaxRetries() {
+				logs.Warn("Giving up on %d documents after %d retries", len(items)/2, attempt-1)
+				for i := 1; i < len(items); i += 2 {
+					deadLetterDocument(items[i], "retries_exhausted", fmt.Sprintf("still rejected after %d retries", attempt-1))
+				}
+				return
+			}
+			logs.Info("Retrying %d rejected documents in %v", len(items)/2, backoff)
+			Counters.Add("es_retries", int64(len(items)/2))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		items = w.post(items)
+	}
+}
+
+// post sends one bulk request, and returns the items to retry
+func (w *ElasticSearchWorker) post(items []string) (retry []string) {
+	bs := []byte(strings.Join(items, "\n") + "\n")
+	logs.Debug("--START BULK DATA--")
+	logs.Debug("%s", string(bs))
+	logs.Debug("--END BULK DATA--")
+	compress := ConfiguredElasticSearchCompress()
+	if compress {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(bs)
+		zw.Close()
+		logs.Debug("Compressed bulk data from %d to %d bytes", len(bs), b.Len())
+		bs = b.Bytes()
+	}
+	node := w.nodes.next()
+	req, _ := http.NewRequest("POST", node.URL+"/_bulk", bytes.NewBuffer(bs)) // endpoint has already been vetted
+	req.Header.Set("Content-Type", "application/json")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	client := w.nodes.client(0)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		w.failed(&OutputError{Destination: node.URL, Err: err})
+		w.nodes.markDead(node, err)
+		// another node may be alive
+		return items
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		w.failed(&OutputError{Destination: node.URL, Err: fmt.Errorf("Post failed with status: %v; response body: %s", resp.Status, body)})
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return items
+		}
+		return nil
+	}
+	w.succeeded(time.Since(start))
+	logs.Debug("POST succeeded")
+	logs.Debug("response Status: %v", resp.Status)
+	logs.Debug("response Body: %v", string(body))
+	retry, err = inspectBulkResponse(items, body)
+	if err != nil {
+		logs.Warn("%v", err)
+	}
+	logs.Debug("Bulk upload is complete")
+	return retry
 }
 
 func (w *ElasticSearchWorker) flush(forceReport bool) {
@@ -215,33 +366,8 @@ func (w *ElasticSearchWorker) flush(forceReport bool) {
 	w.totalCounter++
 	if w.counter > 0 {
 		if !w.Mocking() {
-			str := strings.Join(w.items[0:w.counter], "\n") + "\n"
-			bs := []byte(str)
-			req, _ := http.NewRequest("POST", w.Endpoint(), bytes.NewBuffer(bs)) // endpoint has already been vetted
-			req.Header.Set("Content-Type", "application/json")
-			logs.Debug("--START BULK DATA--")
-			logs.Debug("%s", string(bs))
-			logs.Debug("--END BULK DATA--")
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				logs.Warn("POST failed: %s", err)
-			} else {
-				if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-					logs.Debug("POST succeeded on flush %v", w.totalCounter)
-					logs.Debug("response Status: %v", resp.Status)
-					body, _ := ioutil.ReadAll(resp.Body)
-					logs.Debug("response Body: %v", string(body))
-				} else {
-					logs.Warn("On flush %v, Post failed with status: %v", w.totalCounter, resp.StatusCode)
-					logs.Warn("response Status: %v", resp.Status)
-					body, _ := ioutil.ReadAll(resp.Body)
-					logs.Warn("response Body: %v", string(body))
-				}
-				defer resp.Body.Close()
-			}
-			logs.Debug("Bulk upload is complete")
+			items := w.items[0:w.counter] // Init makes new items for the next request
+			w.requests.Do(func() { w.bulkUpload(items) })
 		} else { // test mode: send to standout
 			str := strings.Join(w.items[0:w.counter], "\n") + "\n"
 			fmt.Print(str)
@@ -0,0 +1,99 @@
+package worker
+
+/*
+	es_bulk.go inspects Elastic Search bulk responses
+
+	A bulk request can succeed (with status 200) while some of its documents
+	are rejected. Documents rejected because the cluster is overloaded (status
+	429, or es_rejected_execution_exception) are retried, up to es.max_retries
+	times with exponential backoff; other rejections, such as mapping
+	conflicts, are sent to the dead letter sink with the Elastic Search error.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configElasticSearchMaxRetries = "es.max_retries"
+const configElasticSearchRetryBackoff = "es.retry_backoff"
+
+// bulkItemResult is the result of one document in a bulk response
+type bulkItemResult struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error,omitempty"`
+}
+
+// bulkResponse is the response to a bulk request; each item maps the action
+// (e.g. create) to its result
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+// ConfiguredElasticSearchMaxRetries returns how many times rejected
+// documents are retried
+func ConfiguredElasticSearchMaxRetries() int {
+	if viper.IsSet(configElasticSearchMaxRetries) {
+		return viper.GetInt(configElasticSearchMaxRetries)
+	}
+	return 3
+}
+
+// ConfiguredElasticSearchRetryBackoff returns how long to wait before the
+// first retry; the wait doubles with each retry
+func ConfiguredElasticSearchRetryBackoff() time.Duration {
+	if viper.IsSet(configElasticSearchRetryBackoff) {
+		return viper.GetDuration(configElasticSearchRetryBackoff)
+	}
+	return 500 * time.Millisecond
+}
+
+// retryable returns true if the document was rejected because the cluster is
+// (temporarily) overloaded
+func (r bulkItemResult) retryable() bool {
+	return r.Status == 429 || (r.Error != nil && r.Error.Type == "es_rejected_execution_exception")
+}
+
+// inspectBulkResponse checks the results of a bulk request of items (pairs of
+// action and document lines), dead-lettering rejected documents, and returns
+// the items to retry
+func inspectBulkResponse(items []string, body []byte) (retry []string, err error) {
+	var response bulkResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("Invalid bulk response: %v", err)
+	}
+	if !response.Errors {
+		return nil, nil
+	}
+	if len(response.Items)*2 != len(items) {
+		return nil, fmt.Errorf("Bulk response has %d items for %d documents", len(response.Items), len(items)/2)
+	}
+	for i, item := range response.Items {
+		for _, result := range item {
+			if result.Error == nil && result.Status < 300 {
+				continue
+			}
+			if result.retryable() {
+				retry = append(retry, items[2*i], items[2*i+1])
+				continue
+			}
+			Counters.Inc("es_rejected")
+			deadLetterDocument(items[2*i+1], "rejected", result.errorText())
+		}
+	}
+	return retry, nil
+}
+
+// errorText describes why a document was rejected
+func (r bulkItemResult) errorText() string {
+	if r.Error != nil {
+		return fmt.Sprintf("%d %s: %s", r.Status, r.Error.Type, r.Error.Reason)
+	}
+	return fmt.Sprintf("status %d", r.Status)
+}
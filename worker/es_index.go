@@ -0,0 +1,102 @@
+package worker
+
+/*
+	es_index.go fills in templated Elastic Search index names
+
+	es.index may contain placeholders in braces: a placeholder made only of
+	digits and punctuation is a Go time layout, filled from the event's
+	timestamp (or the current time, if the event has none); any other
+	placeholder is the name of an event field (dotted names reach into nested
+	objects, e.g. {source.ip}). For example,
+
+		index = "logs-{service}-{2006.01.02}"
+
+	puts each service's events into a daily index. es.pipeline and
+	es.routing may use the same placeholders.
+*/
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var indexPlaceholderRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+var indexLayoutRegex = regexp.MustCompile(`^[0-9._:/ -]+$`)
+
+// indexInvalidChars are replaced in field values, as they are not allowed in
+// index names
+var indexInvalidChars = strings.NewReplacer(`\`, "_", "/", "_", "*", "_", "?", "_", `"`, "_",
+	"<", "_", ">", "_", "|", "_", " ", "_", ",", "_", "#", "_", ":", "_")
+
+// timestampFields are the fields an event's timestamp is taken from, in order
+var timestampFields = []string{"@timestamp", "created", "timestamp", "time", "time_local", "datetime", "date"}
+
+// EventTime returns the event's timestamp, or the current time if it has none
+func EventTime(v map[string]interface{}) time.Time {
+	if t, found := eventTimestamp(v); found {
+		return t
+	}
+	return time.Now()
+}
+
+// eventTimestamp returns the event's timestamp, if it has one
+func eventTimestamp(v map[string]interface{}) (time.Time, bool) {
+	for _, key := range timestampFields {
+		switch t := v[key].(type) {
+		case time.Time:
+			return t, true
+		case string:
+			if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// lookupField returns the value of a (possibly dotted) field name
+func lookupField(v map[string]interface{}, name string) (interface{}, bool) {
+	if value, found := v[name]; found {
+		return value, true
+	}
+	parts := strings.SplitN(name, ".", 2)
+	if nested, ok := v[parts[0]].(map[string]interface{}); ok && len(parts) == 2 {
+		return lookupField(nested, parts[1])
+	}
+	return nil, false
+}
+
+// fillTemplate fills in the placeholders of a template from an event,
+// passing field values through clean; missing fields are filled in as
+// missing
+func fillTemplate(template string, v map[string]interface{}, missing string, clean func(string) string) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	return indexPlaceholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if indexLayoutRegex.MatchString(name) {
+			return EventTime(v).UTC().Format(name)
+		}
+		value, found := lookupField(v, name)
+		if !found || value == nil {
+			return missing
+		}
+		return clean(fmt.Sprint(value))
+	})
+}
+
+// FillTemplate fills in the placeholders of a template (such as es.routing)
+// from an event. Missing fields are filled in as "".
+func FillTemplate(template string, v map[string]interface{}) string {
+	return fillTemplate(template, v, "", func(s string) string { return s })
+}
+
+// IndexName fills in the placeholders of an index name template from an
+// event. Missing fields are filled in as "unknown".
+func IndexName(template string, v map[string]interface{}) string {
+	return fillTemplate(template, v, "unknown", func(s string) string {
+		return strings.ToLower(indexInvalidChars.Replace(s))
+	})
+}
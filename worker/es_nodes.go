@@ -0,0 +1,341 @@
+package worker
+
+/*
+	es_nodes.go balances requests across Elastic Search nodes
+
+	es.hosts lists host names (combined with es.scheme and es.port) or full
+	URLs, such as https://es1.example.com:9243. Requests are sent round-robin
+	to the nodes that are alive. A node that cannot be reached is marked dead,
+	and probed every es.resurrect_interval until it answers again. If all
+	nodes are dead, the one that has been dead the longest is tried anyway.
+
+	If es.sniff is true, the cluster is asked for its data nodes (at startup
+	and every es.sniff_interval), and requests are sent to them instead of
+	the configured hosts.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configElasticSearchResurrectInterval = "es.resurrect_interval"
+const configElasticSearchSniff = "es.sniff"
+const configElasticSearchSniffInterval = "es.sniff_interval"
+
+// probeTimeout limits resurrection probes and sniffing requests
+const probeTimeout = 5 * time.Second
+
+// ESNode is the state of an Elastic Search node
+type ESNode struct {
+	Host      string    `json:"host"`
+	URL       string    `json:"url"`
+	Alive     bool      `json:"alive"`
+	DeadSince time.Time `json:"dead_since,omitempty"`
+	Failures  int64     `json:"failures"`
+}
+
+// nodePool keeps track of the nodes requests can be sent to
+type nodePool struct {
+	lock       sync.Mutex
+	configured []string
+	nodes      []*ESNode
+	index      int
+	done       chan bool
+	watching   sync.WaitGroup
+	transport  http.RoundTripper // with es.tls and es.proxy, if configured
+}
+
+// ConfiguredElasticSearchResurrectInterval returns how often dead nodes are
+// probed
+func ConfiguredElasticSearchResurrectInterval() time.Duration {
+	if viper.IsSet(configElasticSearchResurrectInterval) {
+		return viper.GetDuration(configElasticSearchResurrectInterval)
+	}
+	return 30 * time.Second
+}
+
+// ConfiguredElasticSearchSniff returns true if the cluster's data nodes are
+// discovered automatically
+func ConfiguredElasticSearchSniff() bool {
+	return viper.GetBool(configElasticSearchSniff)
+}
+
+// ConfiguredElasticSearchSniffInterval returns how often the cluster is
+// sniffed for data nodes
+func ConfiguredElasticSearchSniffInterval() time.Duration {
+	if viper.IsSet(configElasticSearchSniffInterval) {
+		return viper.GetDuration(configElasticSearchSniffInterval)
+	}
+	return 5 * time.Minute
+}
+
+// nodeURL returns the base URL of a configured host
+func nodeURL(host string) string {
+	if strings.Contains(host, "://") {
+		return strings.TrimSuffix(host, "/")
+	}
+	return fmt.Sprintf("%s://%s:%d", ConfiguredElasticSearchScheme(), host, ConfiguredElasticSearchPort())
+}
+
+func newNode(host string, baseURL string) *ESNode {
+	return &ESNode{Host: host, URL: baseURL, Alive: true}
+}
+
+// refresh rebuilds the nodes if the configured hosts have changed. It must
+// be called with the lock held.
+func (p *nodePool) refresh() {
+	hosts := ConfiguredElasticSearchHosts()
+	if p.nodes != nil && strings.Join(hosts, ",") == strings.Join(p.configured, ",") {
+		return
+	}
+	p.configured = hosts
+	p.nodes = make([]*ESNode, len(hosts))
+	for i, host := range hosts {
+		p.nodes[i] = newNode(host, nodeURL(host))
+	}
+	p.index = 0
+}
+
+// validate checks the URLs of the nodes, and the TLS configuration
+func (p *nodePool) validate() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.refresh()
+	if len(p.nodes) == 0 {
+		return fmt.Errorf("No Elastic Search hosts configured")
+	}
+	if p.transport == nil {
+		transport, err := configuredTransport("es")
+		if err != nil {
+			return fmt.Errorf("Invalid Elastic Search TLS or proxy configuration: %v", err)
+		}
+		p.transport = transport
+	}
+	for _, node := range p.nodes {
+		if _, err := url.Parse(node.URL); err != nil {
+			return fmt.Errorf("Invalid Elastic Search endpoint: %v", node.URL)
+		}
+	}
+	return nil
+}
+
+// client returns an HTTP client sending requests with the transport of the
+// pool
+func (p *nodePool) client(timeout time.Duration) *http.Client {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return &http.Client{Timeout: timeout, Transport: p.transport}
+}
+
+// next returns the next node to send a request to
+func (p *nodePool) next() *ESNode {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.refresh()
+	return p.pick()
+}
+
+// pick returns the next node to send a request to, without refreshing the
+// nodes. It must be called with the lock held.
+func (p *nodePool) pick() *ESNode {
+	var oldest *ESNode
+	for range p.nodes {
+		node := p.nodes[p.index]
+		p.index = (p.index + 1) % len(p.nodes)
+		if node.Alive {
+			return node
+		}
+		if oldest == nil || node.DeadSince.Before(oldest.DeadSince) {
+			oldest = node
+		}
+	}
+	return oldest
+}
+
+// markDead records that a node could not be reached
+func (p *nodePool) markDead(node *ESNode, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if node.Alive {
+		logs.Warn("Elastic Search node %s is dead: %v", node.URL, err)
+		node.DeadSince = time.Now()
+	}
+	node.Alive = false
+	node.Failures++
+}
+
+// markAlive records that a node could be reached
+func (p *nodePool) markAlive(node *ESNode) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !node.Alive {
+		logs.Info("Elastic Search node %s is alive again", node.URL)
+	}
+	node.Alive = true
+	node.DeadSince = time.Time{}
+}
+
+// Nodes returns the state of the nodes
+func (p *nodePool) Nodes() []ESNode {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.refresh()
+	nodes := make([]ESNode, len(p.nodes))
+	for i, node := range p.nodes {
+		nodes[i] = *node
+	}
+	return nodes
+}
+
+// resurrect probes the dead nodes
+func (p *nodePool) resurrect() {
+	p.lock.Lock()
+	dead := make([]*ESNode, 0)
+	for _, node := range p.nodes {
+		if !node.Alive {
+			dead = append(dead, node)
+		}
+	}
+	p.lock.Unlock()
+	client := p.client(probeTimeout)
+	for _, node := range dead {
+		resp, err := client.Get(node.URL + "/")
+		if err != nil {
+			logs.Debug("Elastic Search node %s is still dead: %v", node.URL, err)
+			continue
+		}
+		resp.Body.Close()
+		p.markAlive(node)
+	}
+}
+
+// sniffResponse is the part of the /_nodes/http response used for sniffing
+type sniffResponse struct {
+	Nodes map[string]struct {
+		Roles []string `json:"roles"`
+		HTTP  struct {
+			PublishAddress string `json:"publish_address"`
+		} `json:"http"`
+	} `json:"nodes"`
+}
+
+// sniff replaces the nodes with the cluster's data nodes
+func (p *nodePool) sniff() error {
+	p.lock.Lock()
+	node := p.pick()
+	p.lock.Unlock()
+	client := p.client(probeTimeout)
+	resp, err := client.Get(node.URL + "/_nodes/http")
+	if err != nil {
+		p.markDead(node, err)
+		return err
+	}
+	defer resp.Body.Close()
+	var response sniffResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("Invalid sniffing response from %s: %v", node.URL, err)
+	}
+	scheme := strings.SplitN(node.URL, "://", 2)[0]
+	addresses := make([]string, 0)
+	for _, n := range response.Nodes {
+		isData := false
+		for _, role := range n.Roles {
+			isData = isData || role == "data" || strings.HasPrefix(role, "data_")
+		}
+		if !isData || n.HTTP.PublishAddress == "" {
+			continue
+		}
+		// the publish address may be hostname/ip:port
+		address := n.HTTP.PublishAddress
+		if i := strings.LastIndex(address, "/"); i >= 0 {
+			address = address[i+1:]
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("Sniffing %s found no data nodes", node.URL)
+	}
+	sort.Strings(addresses)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	previous := make(map[string]*ESNode)
+	for _, n := range p.nodes {
+		previous[n.URL] = n
+	}
+	p.nodes = make([]*ESNode, len(addresses))
+	for i, address := range addresses {
+		baseURL := scheme + "://" + address
+		if n, found := previous[baseURL]; found {
+			p.nodes[i] = n
+		} else {
+			p.nodes[i] = newNode(address, baseURL)
+		}
+	}
+	p.index = 0
+	logs.Info("Sniffed Elastic Search data nodes: %v", addresses)
+	return nil
+}
+
+// start probes dead nodes, and sniffs the cluster if configured, until stop
+// is called; the configuration is read before, not while, doing so
+func (p *nodePool) start() {
+	p.lock.Lock()
+	p.done = make(chan bool)
+	done := p.done
+	p.lock.Unlock()
+	sniff := ConfiguredElasticSearchSniff()
+	if sniff {
+		if err := p.sniff(); err != nil {
+			logs.Warn("Unable to sniff Elastic Search nodes: %v", err)
+		}
+	}
+	resurrectTicker := time.NewTicker(ConfiguredElasticSearchResurrectInterval())
+	sniffTicker := time.NewTicker(ConfiguredElasticSearchSniffInterval())
+	p.watching.Add(1)
+	go func() {
+		defer p.watching.Done()
+		defer resurrectTicker.Stop()
+		defer sniffTicker.Stop()
+		Supervise("ElasticSearch node pool", func() {
+			p.watch(sniff, resurrectTicker, sniffTicker, done)
+		})
+	}()
+}
+
+// watch resurrects dead nodes, and sniffs for new ones, until done
+func (p *nodePool) watch(sniff bool, resurrectTicker *time.Ticker, sniffTicker *time.Ticker, done chan bool) {
+	for {
+		select {
+		case <-resurrectTicker.C:
+			p.resurrect()
+		case <-sniffTicker.C:
+			if sniff {
+				if err := p.sniff(); err != nil {
+					logs.Warn("Unable to sniff Elastic Search nodes: %v", err)
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// stop stops probing and sniffing, and waits for it to return
+func (p *nodePool) stop() {
+	p.lock.Lock()
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+	p.lock.Unlock()
+	p.watching.Wait()
+}
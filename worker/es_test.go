@@ -1,8 +1,16 @@
 package worker_test
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/willf/translog/worker"
@@ -213,3 +221,187 @@ func TestInit(t *testing.T) {
 		}
 	}
 }
+
+var indexNameTestCases = []struct {
+	template string
+	event    map[string]interface{}
+	expected string
+}{
+	{"analytics", map[string]interface{}{}, "analytics"},
+	{"logs-{service}-{2006.01.02}", map[string]interface{}{"service": "Web API", "created": time.Date(2016, 4, 1, 11, 0, 0, 0, time.UTC)}, "logs-web_api-2016.04.01"},
+	{"logs-{service}", map[string]interface{}{}, "logs-unknown"},
+	{"logs-{source.ip}-{2006.01}", map[string]interface{}{"source": map[string]interface{}{"ip": "8.8.8.8"}, "@timestamp": "2016-04-01T11:00:00Z"}, "logs-8.8.8.8-2016.04"},
+}
+
+func TestIndexName(t *testing.T) {
+	for i, tt := range indexNameTestCases {
+		actual := worker.IndexName(tt.template, tt.event)
+		if actual != tt.expected {
+			t.Errorf("In test %d, IndexName(%v, %v): expected %v, actual %v", i+1, tt.template, tt.event, tt.expected, actual)
+		}
+	}
+}
+
+func TestBulkActionPipelineAndRouting(t *testing.T) {
+	viper.Reset()
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(bs)
+		fmt.Fprint(rw, `{"errors": false, "items": []}`)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	viper.Set("es.hosts", []string{u.Hostname()})
+	viper.Set("es.port", port)
+	viper.Set("es.pipeline", "geoip")
+	viper.Set("es.routing", "{customer}")
+
+	w := &worker.ElasticSearchWorker{}
+	w.Init()
+	channel := make(chan map[string]interface{})
+	w.SetWorkChannel(channel)
+	w.Start()
+	defer w.Stop()
+	channel <- map[string]interface{}{"customer": "Acme"}
+	channel <- map[string]interface{}{"ip": "8.8.8.8"}
+	w.Flush()
+	select {
+	case body := <-bodies:
+		expected := `{"create":{"_index":"analytics","_type":"event","pipeline":"geoip","routing":"Acme"}}` + "\n" +
+			`{"customer":"Acme"}` + "\n" +
+			`{"create":{"_index":"analytics","_type":"event","pipeline":"geoip"}}` + "\n" +
+			`{"ip":"8.8.8.8"}` + "\n"
+		if body != expected {
+			t.Errorf("expected bulk request %v, got %v", expected, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected a bulk request")
+	}
+}
+
+func TestBulkResponseRetryAndDeadLetter(t *testing.T) {
+	viper.Reset()
+	bodies := make(chan string, 2)
+	var requests int32
+	responses := []string{
+		`{"errors": true, "items": [
+			{"create": {"status": 429, "error": {"type": "es_rejected_execution_exception", "reason": "queue is full"}}},
+			{"create": {"status": 400, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse field [status]"}}},
+			{"create": {"status": 201}}]}`,
+		`{"errors": false, "items": [{"create": {"status": 201}}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprint(rw, responses[atomic.AddInt32(&requests, 1)-1])
+		bodies <- string(bs)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	viper.Set("es.hosts", []string{u.Hostname()})
+	viper.Set("es.port", port)
+	viper.Set("es.retry_backoff", "1ms")
+
+	w := &worker.ElasticSearchWorker{}
+	w.Init()
+	channel := make(chan map[string]interface{})
+	w.SetWorkChannel(channel)
+	w.Start()
+	defer w.Stop()
+	channel <- map[string]interface{}{"id": 1}
+	channel <- map[string]interface{}{"id": 2, "status": "OK"}
+	channel <- map[string]interface{}{"id": 3}
+	w.Flush()
+	for i := 0; i < 2; i++ {
+		select {
+		case body := <-bodies:
+			if i == 1 && body != `{"create":{"_index":"analytics","_type":"event"}}`+"\n"+`{"id":1}`+"\n" {
+				t.Errorf("expected only the rejected document to be retried, got %v", body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected bulk request %d", i+1)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	deadLetters := worker.RecentDeadLetters()
+	last := deadLetters[len(deadLetters)-1]
+	if last.Reason != "rejected" || last.Event["status"] != "OK" || last.Error != "400 mapper_parsing_exception: failed to parse field [status]" {
+		t.Errorf("expected the mapping conflict to be dead-lettered, got %+v", last)
+	}
+}
+
+func TestDeadNodeIsSkipped(t *testing.T) {
+	viper.Reset()
+	bodies := make(chan string, 2)
+	live := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprint(rw, `{"errors": false, "items": []}`)
+		bodies <- string(bs)
+	}))
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	viper.Set("es.hosts", []string{dead.URL, live.URL})
+	viper.Set("es.retry_backoff", "1ms")
+
+	w := &worker.ElasticSearchWorker{}
+	w.Init()
+	channel := make(chan map[string]interface{})
+	w.SetWorkChannel(channel)
+	w.Start()
+	defer w.Stop()
+	for i := 0; i < 2; i++ {
+		channel <- map[string]interface{}{"id": i}
+		w.Flush()
+		select {
+		case <-bodies:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected bulk request %d to reach the live node", i+1)
+		}
+	}
+	nodes := w.Nodes()
+	if len(nodes) != 2 || nodes[0].Alive || nodes[0].Failures != 1 || !nodes[1].Alive {
+		t.Errorf("expected only the first node to be dead, after one failure, got %+v", nodes)
+	}
+}
+
+func TestCompressedBulkRequest(t *testing.T) {
+	viper.Reset()
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("expected a gzipped request, got Content-Encoding %q", r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("expected a gzipped request, got %v", err)
+			bodies <- ""
+			return
+		}
+		bs, _ := ioutil.ReadAll(zr)
+		fmt.Fprint(rw, `{"errors": false, "items": []}`)
+		bodies <- string(bs)
+	}))
+	defer server.Close()
+	viper.Set("es.hosts", []string{server.URL})
+	viper.Set("es.compress", true)
+
+	w := &worker.ElasticSearchWorker{}
+	w.Init()
+	channel := make(chan map[string]interface{})
+	w.SetWorkChannel(channel)
+	w.Start()
+	defer w.Stop()
+	channel <- map[string]interface{}{"id": 1}
+	w.Flush()
+	select {
+	case body := <-bodies:
+		if body != `{"create":{"_index":"analytics","_type":"event"}}`+"\n"+`{"id":1}`+"\n" {
+			t.Errorf("expected the bulk request to be decompressed, got %v", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected a bulk request")
+	}
+}
@@ -0,0 +1,295 @@
+package worker
+
+/*
+	event.go is the typed alternative to map[string]interface{} events
+
+	For high-volume, fixed-format logs, a schema can be declared:
+
+		[schema]
+		fields = { ip = "string", status = "int", bytes = "int",
+		           duration = "float", cached = "bool", created = "time" }
+		time_layout = "02/Jan/2006:15:04:05 -0700"
+
+	Each field must be a named group of parse.pattern; other groups are
+	ignored. The schema is compiled into a layout of typed slots, and lines
+	are parsed straight into Events, without guessing types (no time
+	layouts are tried, see ParseStringForValue) and without boxing values
+	in interfaces. Values that can't be converted to their type are left
+	out, and counted as schema_conversion_errors.
+
+	Sinks that implement EventWorker (file and stdout) receive the Events
+	themselves, and serialize them with AppendJSON; other sinks receive
+	them converted with Map. Transforms (transform.derive, ...) and the
+	output schema do not apply to typed events, and they have no room for
+	a sequence number, event id or tenant: with parse.sequence,
+	parse.event_id or tenant.id or tenant.field set, the schema is ignored. So it is with the settings that only apply
+	to events as maps (see mapOnlySettings), such as transform.lookups,
+	alerts.rules or drift.warmup.
+*/
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+const configSchemaFields = "schema.fields"
+const configSchemaTimeLayout = "schema.time_layout"
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindTime
+)
+
+var fieldKinds = map[string]fieldKind{
+	"string": kindString,
+	"int":    kindInt,
+	"float":  kindFloat,
+	"bool":   kindBool,
+	"time":   kindTime,
+}
+
+// schemaField is a field of a schema, and where its value is kept
+type schemaField struct {
+	name  string
+	key   []byte // the JSON key, including the colon
+	kind  fieldKind
+	group int // the submatch of the pattern
+	slot  int // the index in the slice of its kind
+}
+
+// A Schema is the compiled layout of typed events
+type Schema struct {
+	fields     []schemaField
+	slots      [5]int // the number of slots of each kind
+	timeLayout string
+	pool       sync.Pool
+}
+
+// An Event is a parsed line of a Schema
+type Event struct {
+	schema  *Schema
+	present []bool
+	strings []string
+	ints    []int64
+	floats  []float64
+	bools   []bool
+	times   []time.Time
+}
+
+// ConfiguredSchemaTimeLayout returns the layout of time fields
+func ConfiguredSchemaTimeLayout() string {
+	if viper.IsSet(configSchemaTimeLayout) {
+		return viper.GetString(configSchemaTimeLayout)
+	}
+	return time.RFC3339Nano
+}
+
+// ConfiguredSchema compiles the configured schema for the pattern; it
+// returns nil if there is no schema configured
+func ConfiguredSchema(regex *regexp.Regexp) (*Schema, error) {
+	if !viper.IsSet(configSchemaFields) || regex == nil {
+		return nil, nil
+	}
+	return CompileSchema(viper.GetStringMapString(configSchemaFields), ConfiguredSchemaTimeLayout(), regex)
+}
+
+// CompileSchema compiles a schema (field names and types) for the pattern
+func CompileSchema(fields map[string]string, timeLayout string, regex *regexp.Regexp) (*Schema, error) {
+	s := &Schema{timeLayout: timeLayout}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		kind, found := fieldKinds[fields[name]]
+		if !found {
+			return nil, &ConfigError{Key: configSchemaFields + "." + name, Value: fields[name], Reason: "expected string, int, float, bool, or time"}
+		}
+		group := regex.SubexpIndex(name)
+		if group < 0 {
+			return nil, &ConfigError{Key: configSchemaFields + "." + name, Value: fields[name], Reason: "not a named group of the pattern"}
+		}
+		key := appendJSONString(nil, name)
+		s.fields = append(s.fields, schemaField{name: name, key: append(key, ':'), kind: kind, group: group, slot: s.slots[kind]})
+		s.slots[kind]++
+	}
+	return s, nil
+}
+
+// newEvent returns an empty event, from the pool if possible
+func (s *Schema) newEvent() *Event {
+	if e, ok := s.pool.Get().(*Event); ok {
+		return e
+	}
+	return &Event{
+		schema:  s,
+		present: make([]bool, len(s.fields)),
+		strings: make([]string, s.slots[kindString]),
+		ints:    make([]int64, s.slots[kindInt]),
+		floats:  make([]float64, s.slots[kindFloat]),
+		bools:   make([]bool, s.slots[kindBool]),
+		times:   make([]time.Time, s.slots[kindTime]),
+	}
+}
+
+// Parse converts the submatches of the pattern into an event
+func (s *Schema) Parse(match []string) *Event {
+	e := s.newEvent()
+	for i, f := range s.fields {
+		value := match[f.group]
+		var err error
+		switch f.kind {
+		case kindString:
+			e.strings[f.slot] = value
+		case kindInt:
+			e.ints[f.slot], err = strconv.ParseInt(value, 10, 64)
+		case kindFloat:
+			e.floats[f.slot], err = strconv.ParseFloat(value, 64)
+		case kindBool:
+			e.bools[f.slot], err = strconv.ParseBool(value)
+		case kindTime:
+			e.times[f.slot], err = time.Parse(s.timeLayout, value)
+		}
+		e.present[i] = err == nil
+		if err != nil && value != "" {
+			Counters.Inc("schema_conversion_errors")
+		}
+	}
+	return e
+}
+
+// Release returns the event to its schema's pool; the event must not be
+// used afterwards
+func (e *Event) Release() {
+	e.schema.pool.Put(e)
+}
+
+// value returns the value of the i-th field of the schema
+func (e *Event) value(i int) interface{} {
+	f := e.schema.fields[i]
+	switch f.kind {
+	case kindInt:
+		return e.ints[f.slot]
+	case kindFloat:
+		return e.floats[f.slot]
+	case kindBool:
+		return e.bools[f.slot]
+	case kindTime:
+		return e.times[f.slot]
+	}
+	return e.strings[f.slot]
+}
+
+// Get returns the value of a field, if it is present
+func (e *Event) Get(name string) (interface{}, bool) {
+	for i, f := range e.schema.fields {
+		if f.name == name && e.present[i] {
+			return e.value(i), true
+		}
+	}
+	return nil, false
+}
+
+// Map converts the event into a map, as produced without a schema
+func (e *Event) Map() map[string]interface{} {
+	v := make(map[string]interface{}, len(e.schema.fields))
+	for i, f := range e.schema.fields {
+		if e.present[i] {
+			v[f.name] = e.value(i)
+		}
+	}
+	return v
+}
+
+// AppendJSON appends the event as a JSON object, with the same fields as
+// json.Marshal(e.Map())
+func (e *Event) AppendJSON(b []byte) []byte {
+	b = append(b, '{')
+	first := true
+	for i, f := range e.schema.fields {
+		if !e.present[i] {
+			continue
+		}
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		b = append(b, f.key...)
+		switch f.kind {
+		case kindString:
+			b = appendJSONString(b, e.strings[f.slot])
+		case kindInt:
+			b = strconv.AppendInt(b, e.ints[f.slot], 10)
+		case kindFloat:
+			if x := e.floats[f.slot]; math.IsNaN(x) || math.IsInf(x, 0) {
+				b = append(b, "null"...)
+			} else {
+				b = strconv.AppendFloat(b, x, 'g', -1, 64)
+			}
+		case kindBool:
+			b = strconv.AppendBool(b, e.bools[f.slot])
+		case kindTime:
+			b = append(b, '"')
+			b = e.times[f.slot].AppendFormat(b, time.RFC3339Nano)
+			b = append(b, '"')
+		}
+	}
+	return append(b, '}')
+}
+
+func (e *Event) String() string {
+	return fmt.Sprint(e.Map())
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string; invalid UTF-8 is replaced,
+// as encoding/json does
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				b = append(b, '\\', c)
+			case c == '\n':
+				b = append(b, '\\', 'n')
+			case c == '\r':
+				b = append(b, '\\', 'r')
+			case c == '\t':
+				b = append(b, '\\', 't')
+			case c < 0x20 || c == '<' || c == '>' || c == '&':
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			default:
+				b = append(b, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, "\ufffd"...)
+		case r == '\u2028' || r == '\u2029':
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+		default:
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(b, '"')
+}
@@ -0,0 +1,109 @@
+package worker
+
+/*
+	event_id.go stamps events with sequence numbers and unique ids
+
+	With parse.sequence set, each event gets a "seq" field: 1 for the first
+	event emitted from the input, and one more for each event after it, so
+	that a consumer can spot missing events (the sequence starts over when
+	translog restarts). With parse.event_id set to "uuid" (a random UUID) or
+	"ulid" (a ULID, which sorts by time), each event gets a unique
+	"event_id", so that a consumer can drop events it has already seen.
+	Typed events sent straight to an output are not stamped.
+*/
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configParseSequence = "parse.sequence"
+const configParseEventID = "parse.event_id"
+
+// crockford is the alphabet of ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// randomSource buffers random bytes, as reading them one id at a time is slow
+var randomSource = struct {
+	sync.Mutex
+	io.Reader
+}{Reader: bufio.NewReaderSize(rand.Reader, 4096)}
+
+// ConfiguredParseSequence returns true if events are stamped with a
+// sequence number
+func ConfiguredParseSequence() bool {
+	if viper.IsSet(configParseSequence) {
+		return viper.GetBool(configParseSequence)
+	}
+	return false
+}
+
+// ConfiguredParseEventID returns the kind of unique id events are stamped
+// with: "uuid", "ulid", or "" for none
+func ConfiguredParseEventID() string {
+	if viper.IsSet(configParseEventID) {
+		switch kind := viper.GetString(configParseEventID); kind {
+		case "uuid", "ulid", "":
+			return kind
+		default:
+			reportError(&ConfigError{Key: configParseEventID, Value: kind, Reason: "expected uuid or ulid; not stamping event ids"})
+		}
+	}
+	return ""
+}
+
+// randomBytes fills b with random bytes
+func randomBytes(b []byte) {
+	randomSource.Lock()
+	io.ReadFull(randomSource.Reader, b)
+	randomSource.Unlock()
+}
+
+// NewUUID returns a random (version 4) UUID
+func NewUUID() string {
+	var b [16]byte
+	randomBytes(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// NewULID returns a ULID for the time t: 48 bits of milliseconds followed by
+// 80 random bits, in Crockford's base 32
+func NewULID(t time.Time) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixNano()/int64(time.Millisecond))<<16)
+	randomBytes(b[6:])
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	// 128 bits make 26 characters of 5 bits, the first of only 3
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// stamp adds the sequence number, event id and tenant to an event, as
+// configured
+func (w *LogParser) stamp(v map[string]interface{}) {
+	w.tenants.stamp(v)
+	if w.sequence {
+		v["seq"] = atomic.AddInt64(&w.seq, 1)
+	}
+	switch w.eventIDs {
+	case "uuid":
+		v["event_id"] = NewUUID()
+	case "ulid":
+		v["event_id"] = NewULID(time.Now())
+	}
+}
@@ -0,0 +1,50 @@
+package worker_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestNewUUID(t *testing.T) {
+	uuidRegex := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := worker.NewUUID(), worker.NewUUID()
+	if !uuidRegex.MatchString(a) || a == b {
+		t.Errorf("expected distinct version 4 UUIDs, actual %s and %s", a, b)
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := worker.NewULID(start)
+	b := worker.NewULID(start.Add(time.Millisecond))
+	if len(a) != 26 || a[:10] != "01HQWY5CG0" {
+		t.Errorf("expected a ULID starting with the time, actual %s", a)
+	}
+	if a >= b {
+		t.Errorf("expected ULIDs to sort by time, actual %s and %s", a, b)
+	}
+}
+
+func TestProcessLineStamps(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.sequence", true)
+	viper.Set("parse.event_id", "ulid")
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.ProcessLine("a")
+	v := <-channel
+	w.ProcessLine("b")
+	v2 := <-channel
+	if v["seq"] != int64(1) || v2["seq"] != int64(2) {
+		t.Errorf("expected sequence numbers 1 and 2, actual %v and %v", v["seq"], v2["seq"])
+	}
+	if id, ok := v["event_id"].(string); !ok || len(id) != 26 || id == v2["event_id"] {
+		t.Errorf("expected distinct ULIDs, actual %v and %v", v["event_id"], v2["event_id"])
+	}
+}
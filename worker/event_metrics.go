@@ -0,0 +1,242 @@
+package worker
+
+/*
+	event_metrics.go derives Prometheus metrics from events
+
+	Metrics are declared in metrics, e.g.
+
+		[metrics.http_requests_total]
+		type = "counter"
+		labels = ["status", "method"]
+
+		[metrics.request_duration_seconds]
+		type = "histogram"
+		field = "latency"
+		labels = ["method"]
+		buckets = [0.01, 0.1, 1, 10]
+
+	and served, with translog's own metrics, on the admin server's
+	/metrics. A counter counts the events (or, with field set, adds up the
+	field's values); a histogram observes the values of field. Labels take
+	their values from the event fields of the same names, "" when missing.
+	With where set (see alert.go for conditions), only matching events are
+	counted.
+*/
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const configMetrics = "metrics"
+
+// defaultHistogramBuckets are the upper bounds of histogram buckets, as in
+// the Prometheus client libraries
+var defaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricNameRegex matches valid Prometheus metric and label names
+var metricNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricSeries is the value of a metric for one set of label values
+type metricSeries struct {
+	labels []string
+	sum    float64
+	count  int64
+	counts []int64 // per bucket, for histograms
+}
+
+// eventMetric is a metric derived from events
+type eventMetric struct {
+	name    string
+	kind    string // counter or histogram
+	field   string
+	labels  []string
+	buckets []float64
+	where   func(v map[string]interface{}) bool
+	lock    sync.Mutex
+	series  map[string]*metricSeries
+}
+
+// MetricSet holds the metrics derived from events
+type MetricSet struct {
+	lock    sync.RWMutex
+	metrics []*eventMetric
+}
+
+// EventMetrics are the metrics derived from events
+var EventMetrics = &MetricSet{}
+
+// ConfiguredEventMetrics returns the metrics derived from events
+func ConfiguredEventMetrics() []*eventMetric {
+	config := viper.GetStringMap(configMetrics)
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]*eventMetric, 0, len(names))
+	for _, name := range names {
+		key := configMetrics + "." + name
+		m := &eventMetric{
+			name:   name,
+			kind:   viper.GetString(key + ".type"),
+			field:  viper.GetString(key + ".field"),
+			labels: viper.GetStringSlice(key + ".labels"),
+			series: make(map[string]*metricSeries),
+		}
+		if !metricNameRegex.MatchString(name) {
+			reportError(&ConfigError{Key: key, Value: name, Reason: "not a valid metric name; ignoring it"})
+			continue
+		}
+		invalid := false
+		for _, label := range m.labels {
+			if !metricNameRegex.MatchString(label) {
+				reportError(&ConfigError{Key: key + ".labels", Value: label, Reason: "not a valid label name; ignoring the metric"})
+				invalid = true
+			}
+		}
+		if invalid {
+			continue
+		}
+		switch m.kind {
+		case "":
+			m.kind = "counter"
+		case "counter":
+		case "histogram":
+			if m.field == "" {
+				reportError(&ConfigError{Key: key + ".field", Value: m.field, Reason: "a histogram needs a field; ignoring it"})
+				continue
+			}
+			m.buckets = defaultHistogramBuckets
+			if viper.IsSet(key + ".buckets") {
+				m.buckets = nil
+				for _, bound := range viper.GetStringSlice(key + ".buckets") {
+					b, err := strconv.ParseFloat(bound, 64)
+					if err != nil {
+						reportError(&ConfigError{Key: key + ".buckets", Value: bound, Reason: "not a number; ignoring it"})
+						continue
+					}
+					m.buckets = append(m.buckets, b)
+				}
+				sort.Float64s(m.buckets)
+			}
+		default:
+			reportError(&ConfigError{Key: key + ".type", Value: m.kind, Reason: "expected counter or histogram; ignoring the metric"})
+			continue
+		}
+		where, err := CompileCondition(viper.GetString(key + ".where"))
+		if err != nil {
+			reportError(&ConfigError{Key: key + ".where", Value: viper.Get(key + ".where"), Reason: err.Error() + "; ignoring the metric"})
+			continue
+		}
+		m.where = where
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// Configure replaces the metrics with the configured ones
+func (s *MetricSet) Configure() {
+	metrics := ConfiguredEventMetrics()
+	s.lock.Lock()
+	s.metrics = metrics
+	s.lock.Unlock()
+}
+
+// Observe updates the metrics from an event
+func (s *MetricSet) Observe(v map[string]interface{}) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, m := range s.metrics {
+		m.observe(v)
+	}
+}
+
+// observe updates the metric from an event
+func (m *eventMetric) observe(v map[string]interface{}) {
+	if !m.where(v) {
+		return
+	}
+	value := 1.0
+	if m.field != "" {
+		field, found := lookupField(v, m.field)
+		if !found {
+			return
+		}
+		var ok bool
+		if value, ok = toFloat(field); !ok {
+			return
+		}
+	}
+	labels := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		if field, found := lookupField(v, label); found && field != nil {
+			labels[i] = fmt.Sprint(field)
+		}
+	}
+	key := strings.Join(labels, "\xff")
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	series := m.series[key]
+	if series == nil {
+		series = &metricSeries{labels: labels, counts: make([]int64, len(m.buckets))}
+		m.series[key] = series
+	}
+	series.sum += value
+	series.count++
+	for i, bound := range m.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+}
+
+// labelPairs formats label names and values, with extra pairs appended
+func labelPairs(names []string, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format
+func (s *MetricSet) WritePrometheus(out io.Writer) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, m := range s.metrics {
+		m.lock.Lock()
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(out, "# TYPE %s %s\n", m.name, m.kind)
+		for _, key := range keys {
+			series := m.series[key]
+			if m.kind == "counter" {
+				fmt.Fprintf(out, "%s%s %g\n", m.name, labelPairs(m.labels, series.labels), series.sum)
+				continue
+			}
+			for i, bound := range m.buckets {
+				fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, labelPairs(m.labels, series.labels, "le", fmt.Sprint(bound)), series.counts[i])
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, labelPairs(m.labels, series.labels, "le", "+Inf"), series.count)
+			fmt.Fprintf(out, "%s_sum%s %g\n", m.name, labelPairs(m.labels, series.labels), series.sum)
+			fmt.Fprintf(out, "%s_count%s %d\n", m.name, labelPairs(m.labels, series.labels), series.count)
+		}
+		m.lock.Unlock()
+	}
+}
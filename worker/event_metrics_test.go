@@ -0,0 +1,46 @@
+package worker_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+const expectedEventMetrics = `# TYPE http_requests_total counter
+http_requests_total{status="200",method="GET"} 2
+http_requests_total{status="500",method="POST"} 1
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{method="GET",le="0.1"} 1
+request_duration_seconds_bucket{method="GET",le="1"} 2
+request_duration_seconds_bucket{method="GET",le="+Inf"} 2
+request_duration_seconds_sum{method="GET"} 0.55
+request_duration_seconds_count{method="GET"} 2
+request_duration_seconds_bucket{method="POST",le="0.1"} 0
+request_duration_seconds_bucket{method="POST",le="1"} 0
+request_duration_seconds_bucket{method="POST",le="+Inf"} 1
+request_duration_seconds_sum{method="POST"} 2.5
+request_duration_seconds_count{method="POST"} 1
+`
+
+func TestEventMetrics(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<method>\w+) (?P<status>\d+) (?P<latency>[\d.]+)$`)
+	viper.Set("metrics", map[string]interface{}{
+		"http_requests_total":      map[string]interface{}{"type": "counter", "labels": []string{"status", "method"}},
+		"request_duration_seconds": map[string]interface{}{"type": "histogram", "field": "latency", "labels": []string{"method"}, "buckets": []float64{0.1, 1}},
+	})
+	channel := make(chan map[string]interface{}, 10)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	for _, line := range []string{"GET 200 0.05", "GET 200 0.5", "POST 500 2.5"} {
+		w.ProcessLine(line)
+	}
+	var out bytes.Buffer
+	worker.EventMetrics.WritePrometheus(&out)
+	if actual := out.String(); actual != expectedEventMetrics {
+		t.Errorf("WritePrometheus(): expected\n%v\nactual\n%v", expectedEventMetrics, actual)
+	}
+}
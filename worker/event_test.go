@@ -0,0 +1,143 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+const eventTestPattern = `^(?P<ip>\S+) \[(?P<created>[^\]]+)\] "(?P<request>[^"]*)" (?P<status>\S+) (?P<duration>\S+) (?P<cached>\S+)$`
+
+var eventTestFields = map[string]string{
+	"ip": "string", "created": "time", "request": "string", "status": "int", "duration": "float", "cached": "bool",
+}
+
+var eventTestCases = []struct {
+	line     string
+	expected string
+}{
+	{
+		`10.0.0.1 [01/Mar/2024:12:00:00 +0000] "GET / HTTP/1.1" 200 0.25 true`,
+		`{"cached":true,"created":"2024-03-01T12:00:00Z","duration":0.25,"ip":"10.0.0.1","request":"GET / HTTP/1.1","status":200}`,
+	},
+	{
+		"10.0.0.2 [01/Mar/2024:12:00:00 +0000] \"<a>&\\\x01\xff \" - 1e21 maybe",
+		"", // as json.Marshal
+	},
+}
+
+func TestSchemaEvents(t *testing.T) {
+	schema, err := worker.CompileSchema(eventTestFields, "02/Jan/2006:15:04:05 -0700", regexp.MustCompile(eventTestPattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range eventTestCases {
+		e := schema.Parse(regexp.MustCompile(eventTestPattern).FindStringSubmatch(tt.line))
+		expected, _ := json.Marshal(e.Map())
+		actual := string(e.AppendJSON(nil))
+		if actual != string(expected) {
+			t.Errorf("In test %d, AppendJSON(): expected json.Marshal's %s, actual %s", i, expected, actual)
+		}
+		if tt.expected != "" && actual != tt.expected {
+			t.Errorf("In test %d, AppendJSON(): expected %s, actual %s", i, tt.expected, actual)
+		}
+		if i == 1 {
+			if _, found := e.Get("status"); found {
+				t.Errorf("In test %d, expected an unconvertible status to be left out, got %v", i, e)
+			}
+		}
+		e.Release()
+	}
+	if _, err := worker.CompileSchema(map[string]string{"missing": "int"}, "", regexp.MustCompile(eventTestPattern)); err == nil {
+		t.Errorf("expected an error for a field that is not in the pattern")
+	}
+	if _, err := worker.CompileSchema(map[string]string{"ip": "inet"}, "", regexp.MustCompile(eventTestPattern)); err == nil {
+		t.Errorf("expected an error for an unknown type")
+	}
+}
+
+func TestProcessLineTyped(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", eventTestPattern)
+	viper.Set("schema.fields", eventTestFields)
+	viper.Set("schema.time_layout", "02/Jan/2006:15:04:05 -0700")
+	events := make(chan *worker.Event)
+	w := &worker.LogParser{}
+	w.SetEventChannel(events)
+	w.Init()
+	w.ProcessLine(eventTestCases[0].line)
+	select {
+	case e := <-events:
+		if status, _ := e.Get("status"); status != int64(200) {
+			t.Errorf("expected status 200, got %v", e)
+		}
+		if created, _ := e.Get("created"); !created.(time.Time).Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected a time, got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a typed event")
+	}
+
+	// sinks that don't take typed events get maps
+	channel := make(chan map[string]interface{})
+	w = &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.ProcessLine(eventTestCases[0].line)
+	if v := <-channel; v["status"] != int64(200) || v["cached"] != true {
+		t.Errorf("expected a converted event, got %v", v)
+	}
+}
+
+func TestProcessLineTypedStamped(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", eventTestPattern)
+	viper.Set("schema.fields", eventTestFields)
+	viper.Set("parse.sequence", true)
+	viper.Set("tenant.id", "acme")
+	events := make(chan *worker.Event, 1)
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetEventChannel(events)
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.ProcessLine(eventTestCases[0].line)
+	select {
+	case v := <-channel:
+		if v["seq"] != int64(1) || v["tenant"] != "acme" {
+			t.Errorf("expected sequence number 1 and tenant acme, actual %v", v)
+		}
+	case e := <-events:
+		t.Errorf("expected the schema to be ignored, as typed events can't be stamped, actual %v", e)
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+}
+
+func TestProcessLineTypedWithMapOnlySettings(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", eventTestPattern)
+	viper.Set("schema.fields", eventTestFields)
+	viper.Set("drift.warmup", 1000)
+	events := make(chan *worker.Event, 1)
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetEventChannel(events)
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.ProcessLine(eventTestCases[0].line)
+	select {
+	case v := <-channel:
+		if v["status"] != int64(200) {
+			t.Errorf("expected status 200, actual %v", v)
+		}
+	case e := <-events:
+		t.Errorf("expected the schema to be ignored, as typed events skip drift.warmup, actual %v", e)
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+}
@@ -0,0 +1,84 @@
+package worker
+
+/*
+	event_ttl.go handles events that arrive too late
+
+	Events whose timestamp (see timestampFields) is more than time.ttl
+	behind the wall clock, such as the old lines of a backfill mixed with
+	new ones, are counted as events_late, and handled according to
+	time.late_policy:
+
+		tag          (the default) add late: true to the event
+		drop         drop the event
+		dead_letter  send the event to the dead letter file
+
+	so that windowed aggregations and indices whose lifecycle is managed
+	by age aren't polluted by ancient data. time.ttl is 0 (no limit) by
+	default. The check comes after the clock guard (see clock.go), so
+	timestamps that are clamped are never late. Typed events (see event.go)
+	are not checked.
+*/
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configTimeTTL = "time.ttl"
+const configTimeLatePolicy = "time.late_policy"
+
+// eventTTL is how old events may be, and what happens to older ones
+type eventTTL struct {
+	ttl    time.Duration // 0 for no limit
+	policy string
+}
+
+// ConfiguredEventTTL returns how late events are handled
+func ConfiguredEventTTL() (t eventTTL) {
+	t.ttl = viper.GetDuration(configTimeTTL)
+	if t.ttl < 0 {
+		reportError(&ConfigError{Key: configTimeTTL, Value: viper.Get(configTimeTTL), Reason: "not checking timestamps"})
+		t.ttl = 0
+	}
+	switch policy := strings.ToLower(viper.GetString(configTimeLatePolicy)); policy {
+	case "", "tag":
+		t.policy = "tag"
+	case "drop", "dead_letter":
+		t.policy = policy
+	default:
+		reportError(&ConfigError{Key: configTimeLatePolicy, Value: policy, Reason: "expected tag, drop, or dead_letter; using tag"})
+		t.policy = "tag"
+	}
+	return
+}
+
+// apply handles an event if it is late, as of now; it returns false if the
+// event is to be dropped
+func (t eventTTL) apply(v map[string]interface{}, now time.Time) bool {
+	if t.ttl == 0 {
+		return true
+	}
+	timestamp, found := eventTimestamp(v)
+	if !found || now.Sub(timestamp) <= t.ttl {
+		return true
+	}
+	Counters.Inc("events_late")
+	switch t.policy {
+	case "drop":
+		logs.Debug("Dropping event timestamped %v", timestamp)
+		Counters.Inc("late_dropped")
+		return false
+	case "dead_letter":
+		DeadLetter(DeadLetterRecord{
+			Reason: "late",
+			Event:  copyEvent(v),
+			Error:  fmt.Sprintf("timestamp %v is more than %v old", timestamp.Format(time.RFC3339), t.ttl),
+		})
+		return false
+	}
+	v["late"] = true
+	return true
+}
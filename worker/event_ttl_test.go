@@ -0,0 +1,52 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var eventTTLTestCases = []struct {
+	policy  string
+	offset  time.Duration // of the event's timestamp from now
+	emitted bool
+	late    interface{}
+}{
+	{"", -time.Hour, true, nil},
+	{"", -48 * time.Hour, true, true},
+	{"drop", -48 * time.Hour, false, nil},
+	{"dead_letter", -48 * time.Hour, false, nil},
+	{"dead_letter", time.Hour, true, nil},
+}
+
+func TestEventTTL(t *testing.T) {
+	for i, tt := range eventTTLTestCases {
+		viper.Reset()
+		viper.Set("parse.pattern", `^(?P<created>\S+)$`)
+		viper.Set("parse.time_patterns", []string{time.RFC3339})
+		viper.Set("time.ttl", "24h")
+		viper.Set("time.late_policy", tt.policy)
+		channel := make(chan map[string]interface{}, 1)
+		w := &worker.LogParser{}
+		w.SetWorkChannel(channel)
+		w.Init()
+		deadLetters := worker.Counters.Get("dead_letters")
+		w.ProcessLine(time.Now().Add(tt.offset).Format(time.RFC3339))
+		var v map[string]interface{}
+		select {
+		case v = <-channel:
+		case <-time.After(100 * time.Millisecond):
+		}
+		if (v != nil) != tt.emitted || v != nil && v["late"] != tt.late {
+			t.Errorf("In test %d, with policy %q and offset %v: expected emitted %v (late %v), actual %v", i, tt.policy, tt.offset, tt.emitted, tt.late, v)
+		}
+		if tt.policy == "dead_letter" && !tt.emitted {
+			recent := worker.RecentDeadLetters()
+			if worker.Counters.Get("dead_letters") != deadLetters+1 || recent[len(recent)-1].Reason != "late" {
+				t.Errorf("In test %d: expected the event to be dead-lettered as late", i)
+			}
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package worker
 
 import (
+	"container/list"
 	"encoding/json"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fizx/logs"
@@ -10,17 +14,36 @@ import (
 )
 
 type FileWorker struct {
-	WorkChannel chan map[string]interface{}
-	QuitChannel chan bool
-	startTime   time.Time
-	outFileName string
-	out         *os.File
+	WorkChannel   chan map[string]interface{}
+	EventChannel  chan *Event
+	QuitChannel   chan bool
+	ReopenChannel chan bool
+	FlushChannel  chan bool
+	startTime     time.Time
+	template      string
+	files         map[string]*outputFile
+	recent        *list.List // of the open files, most recently written first
+	sync          string
+	atomic        bool
+	manifest      bool
+	latest        string
+	perm          filePerm
+	openLock      sync.Mutex
+	openPaths     map[string]bool // the open files, for the janitor
+	done          chan bool       // closed when the worker stops, for the janitor and disk monitor
+	diskFull      int32           // set while events are dropped for lack of disk space
+	healthTracker
 }
 
 func (w *FileWorker) SetWorkChannel(channel chan map[string]interface{}) {
 	w.WorkChannel = channel
 }
 
+// SetEventChannel sets the channel typed events are received on
+func (w *FileWorker) SetEventChannel(channel chan *Event) {
+	w.EventChannel = channel
+}
+
 func ConfiguredFileOutputName() string {
 	key := "file.output"
 	if viper.IsSet(key) {
@@ -29,38 +52,173 @@ func ConfiguredFileOutputName() string {
 	return "output.jsonl"
 }
 
-func (w *FileWorker) CachedFileHandle() *os.File {
-	fileName := ConfiguredFileOutputName()
-	if fileName != w.outFileName {
-		if w.out != nil {
-			w.out.Close()
+// ConfiguredFileSync returns when the output file is synced to disk: after
+// every write ("always"), every file.sync_interval ("interval"), or only
+// when asked to, or when reopened ("never")
+func ConfiguredFileSync() string {
+	key := "file.sync"
+	if viper.IsSet(key) {
+		switch sync := viper.GetString(key); sync {
+		case "always", "interval", "never":
+			return sync
+		default:
+			reportError(&ConfigError{Key: key, Value: sync, Reason: "expected always, interval, or never; using never"})
 		}
-		handle, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-		if err != nil {
-			logs.Warn("Unable to create output file %s because of %s", fileName, err)
-		} else {
-			w.outFileName = fileName
-			w.out = handle
+	}
+	return "never"
+}
+
+// ConfiguredFileSyncInterval returns how often the output file is synced,
+// with file.sync = "interval"
+func ConfiguredFileSyncInterval() time.Duration {
+	key := "file.sync_interval"
+	if viper.IsSet(key) {
+		if interval := viper.GetDuration(key); interval > 0 {
+			return interval
 		}
+		reportError(&ConfigError{Key: key, Value: viper.Get(key), Reason: "using 1s"})
+	}
+	return time.Second
+}
+
+// CachedFileHandle returns the output file, opening it if needed; time
+// layouts in the output name are filled from the current time
+func (w *FileWorker) CachedFileHandle() *os.File {
+	f := w.file(FillTemplate(w.template, nil))
+	if f == nil {
+		return nil
 	}
-	return w.out
+	return f.out
+}
+
+// outputName returns the name of the file an event is written to
+func (w *FileWorker) outputName(v map[string]interface{}) string {
+	if !strings.Contains(w.template, "{") {
+		return w.template
+	}
+	// the built-in placeholders have already been replaced
+	return fillTemplate(w.template, v, "unknown", cleanPathElement)
+}
+
+// reopen syncs and closes the output files; the output file is opened again
+// if its name has no placeholders
+func (w *FileWorker) reopen() {
+	logs.Info("Reopening output files")
+	w.closeFiles()
+	if !strings.Contains(w.template, "{") {
+		_ = w.CachedFileHandle()
+	}
+}
+
+// Reopen asks the worker to reopen its output file, e.g. after it has been
+// rotated
+func (w *FileWorker) Reopen() {
+	w.ReopenChannel <- true
+}
+
+// Flush asks the worker to sync its output file to disk
+func (w *FileWorker) Flush() {
+	w.FlushChannel <- true
 }
 
-//
 func (w *FileWorker) Init() (err error) {
 	w.QuitChannel = make(chan bool)
-	_ = w.CachedFileHandle()
+	w.ReopenChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	w.done = make(chan bool)
+	w.template = fileNameTemplate(ConfiguredFileOutputName())
+	w.files = make(map[string]*outputFile)
+	w.recent = list.New()
+	w.openPaths = make(map[string]bool)
+	w.sync = ConfiguredFileSync()
+	w.atomic = ConfiguredFileAtomic()
+	w.manifest = ConfiguredFileManifest()
+	w.perm = ConfiguredFilePerm()
+	if strings.Contains(w.template, "{") {
+		// without placeholders, there is only one file to point at
+		w.latest = ConfiguredFileLatest()
+	}
+	if !strings.Contains(w.template, "{") {
+		_ = w.CachedFileHandle()
+	}
 	return
 }
 
 // Start the work
 func (w *FileWorker) Start() {
 	logs.Debug("Worker is %v", w)
-	go w.Work()
+	go Supervise("FileWorker", w.Work)
+	if r := ConfiguredFileRetention(); r.enabled() && strings.Contains(w.template, "{") {
+		go Supervise("file janitor", func() { w.janitor(r, w.done) })
+	}
+	if m := ConfiguredFileMinFree(); m.enabled() {
+		action := ConfiguredFileOnDiskFull()
+		go Supervise("disk monitor", func() { w.monitorDisk(m, action, w.done) })
+	}
+}
+
+// write writes a line to the named output file
+func (w *FileWorker) write(name string, line []byte) {
+	if atomic.LoadInt32(&w.diskFull) != 0 {
+		Counters.Inc("disk_full_dropped")
+		return
+	}
+	f := w.file(name)
+	if f == nil {
+		return
+	}
+	start := time.Now()
+	if _, err := f.out.Write(line); err != nil {
+		w.failed(&OutputError{Destination: f.path, Err: err})
+		return
+	}
+	f.dirty = true
+	f.lastWrite = start
+	if w.atomic {
+		f.track(line)
+	}
+	if w.sync == "always" {
+		w.flush(f)
+	}
+	w.succeeded(time.Since(start))
+}
+
+// flush syncs an output file to disk, if it has been written to
+func (w *FileWorker) flush(f *outputFile) {
+	if !f.dirty {
+		return
+	}
+	if err := f.out.Sync(); err != nil {
+		w.failed(&OutputError{Destination: f.path, Err: err})
+		return
+	}
+	f.dirty = false
+}
+
+// flushFiles syncs the output files to disk
+func (w *FileWorker) flushFiles() {
+	for _, f := range w.files {
+		w.flush(f)
+	}
 }
 
 // Work the queue
 func (w *FileWorker) Work() {
+	var buffer []byte
+	var syncTick <-chan time.Time
+	if w.sync == "interval" {
+		ticker := time.NewTicker(ConfiguredFileSyncInterval())
+		defer ticker.Stop()
+		syncTick = ticker.C
+	}
+	var idleTick <-chan time.Time
+	if strings.Contains(w.template, "{") {
+		// files whose partition (or hour, or day) has passed are closed
+		// once they haven't been written to for a while
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		idleTick = ticker.C
+	}
 	w.startTime = time.Now()
 	logs.Info("FileWorker starting work at %v", w.startTime)
 	for {
@@ -72,9 +230,30 @@ func (w *FileWorker) Work() {
 				logs.Info("Unable to marshal object %v", obj)
 				break
 			}
-			out := w.CachedFileHandle()
-			out.WriteString(string(line))
-			out.WriteString("\n")
+			name := w.outputName(obj)
+			ReleaseEvent(obj)
+			w.write(name, append(line, '\n'))
+
+		case e := <-w.EventChannel:
+			name := w.template
+			if strings.Contains(name, "{") {
+				name = w.outputName(e.Map())
+			}
+			buffer = append(e.AppendJSON(buffer[:0]), '\n')
+			e.Release()
+			w.write(name, buffer)
+
+		case <-w.ReopenChannel:
+			w.reopen()
+
+		case <-w.FlushChannel:
+			w.flushFiles()
+
+		case <-syncTick:
+			w.flushFiles()
+
+		case <-idleTick:
+			w.closeIdleFiles(ConfiguredFileCloseIdle())
 
 		case <-w.QuitChannel:
 			logs.Info("Worker received quit")
@@ -85,7 +264,7 @@ func (w *FileWorker) Work() {
 
 // Stop stops the worker by send a message on its quit channel
 func (w *FileWorker) Stop() {
-	out := w.CachedFileHandle()
-	out.Close()
 	w.QuitChannel <- true
+	close(w.done)
+	w.closeFiles()
 }
@@ -0,0 +1,167 @@
+package worker
+
+/*
+	file_finish.go hands finished output files over to downstream loaders
+
+	With file.atomic set, the file output writes to the output name with a
+	.tmp suffix, and renames it to the output name once the file is
+	finished, so that a loader watching for the output name never picks up
+	a half-written file. A file is finished when it is closed: when it
+	hasn't been written to for file.close_idle (if file.output has
+	placeholders, e.g. "out-{2006-01-02T15}.jsonl" for hourly files), when
+	too many files are open (see file_partition.go), when the worker is asked
+	to reopen its output, and when it stops. If the output name is already
+	taken, -1, -2, ... is added before its extension. A file that has had
+	nothing written to it is removed instead.
+
+	With file.manifest also set, a sidecar manifest (the output name with a
+	.manifest.json suffix) is written after the file has been renamed,
+	recording its line count, byte count and SHA-256 checksum.
+*/
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// tmpSuffix is added to the name of unfinished output files
+const tmpSuffix = ".tmp"
+
+// manifestSuffix is added to the name of a finished file for its manifest
+const manifestSuffix = ".manifest.json"
+
+// FileManifest describes a finished output file
+type FileManifest struct {
+	File     string    `json:"file"`
+	Lines    int64     `json:"lines"`
+	Bytes    int64     `json:"bytes"`
+	SHA256   string    `json:"sha256"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// ConfiguredFileAtomic returns true if output files are written under a
+// temporary name, and renamed when finished
+func ConfiguredFileAtomic() bool {
+	key := "file.atomic"
+	if viper.IsSet(key) {
+		return viper.GetBool(key)
+	}
+	return false
+}
+
+// ConfiguredFileManifest returns true if a manifest is written for each
+// finished output file
+func ConfiguredFileManifest() bool {
+	key := "file.manifest"
+	if viper.IsSet(key) {
+		return viper.GetBool(key)
+	}
+	return false
+}
+
+// finishedName returns name, or, if it is taken, name with the first free
+// number added before its extension
+func finishedName(name string) string {
+	ext := filepath.Ext(name)
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+}
+
+// startTracking starts counting what is written to the unfinished file,
+// including what an earlier run left in it
+func (f *outputFile) startTracking() {
+	f.opened = time.Now()
+	f.checksum = sha256.New()
+	in, err := os.Open(f.path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	r := bufio.NewReader(io.TeeReader(in, f.checksum))
+	for {
+		line, err := r.ReadSlice('\n')
+		f.bytes += int64(len(line))
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			f.lines++
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// track counts a line written to the unfinished file
+func (f *outputFile) track(line []byte) {
+	f.lines += int64(bytes.Count(line, []byte{'\n'}))
+	f.bytes += int64(len(line))
+	f.checksum.Write(line)
+}
+
+// finish renames the (closed) unfinished file to its output name, and, with
+// manifest, writes its manifest; it returns the name the file was finished
+// under, or "" if it was removed, as it was empty
+func (f *outputFile) finish(manifest bool, perm filePerm) (string, error) {
+	if f.bytes == 0 {
+		os.Remove(f.path)
+		return "", nil
+	}
+	final := finishedName(f.name)
+	if err := os.Rename(f.path, final); err != nil {
+		return "", &OutputError{Destination: final, Err: err}
+	}
+	if !manifest {
+		return final, nil
+	}
+	m := FileManifest{
+		File:     filepath.Base(final),
+		Lines:    f.lines,
+		Bytes:    f.bytes,
+		SHA256:   hex.EncodeToString(f.checksum.Sum(nil)),
+		Started:  f.opened,
+		Finished: time.Now(),
+	}
+	bs, _ := json.Marshal(m) // a FileManifest can always be marshaled
+	if err := writeFileAtomically(final+manifestSuffix, append(bs, '\n'), perm); err != nil {
+		return final, &OutputError{Destination: final + manifestSuffix, Err: err}
+	}
+	return final, nil
+}
+
+// writeFileAtomically writes bs to a temporary file, and renames it to path
+func writeFileAtomically(path string, bs []byte, perm filePerm) (err error) {
+	tmp := path + tmpSuffix
+	f, err := perm.open(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return
+	}
+	if _, err = f.Write(bs); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+	return os.Rename(tmp, path)
+}
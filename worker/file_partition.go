@@ -0,0 +1,250 @@
+package worker
+
+/*
+	file_partition.go writes events to several output files at once
+
+	file.output may contain the same placeholders as es.index: event fields,
+	such as {service}, and time layouts, such as {2006-01-02}, filled from
+	the event's timestamp. For example,
+
+		output = "out/{service}/{2006-01-02}.jsonl"
+
+	writes each service's events to a daily file in its own directory,
+	creating directories as needed. Field values can't reach outside the
+	output directory: path separators in them become "_", and missing
+	fields become "unknown". A few names are built in, and take precedence
+	over event fields of the same name: {date} (the event's date, as
+	2006-01-02), {hour} (the event's hour, as 15), and {host} (the name of
+	the host translog runs on), so
+
+		output = "archive/{host}/{date}/{hour}.jsonl"
+
+	makes archives that say where and when they come from.
+
+	The most recently written files are kept open, up to file.max_open;
+	opening one more closes the least recently written. Files that haven't
+	been written to for file.close_idle are closed too, so that yesterday's
+	file doesn't stay open forever.
+
+	With file.latest set, a symbolic link of that name is pointed at the
+	file opened last, so that programs tailing translog's output don't have
+	to work out the name of the current file. With file.atomic, that is the
+	unfinished file until it is finished, and then the finished one.
+*/
+import (
+	"container/list"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// partitionInvalidChars are replaced in field values, so that they stay
+// within one path element
+var partitionInvalidChars = strings.NewReplacer("/", "_", `\`, "_", "\x00", "_")
+
+// outputFile is an open output file
+type outputFile struct {
+	name      string // the output name
+	path      string // the file written to: the output name, or the unfinished name with file.atomic
+	out       *os.File
+	dirty     bool // written to since the last sync
+	lastWrite time.Time
+	opened    time.Time     // when the unfinished file was opened
+	lines     int64         // lines in the unfinished file
+	bytes     int64         // bytes in the unfinished file
+	checksum  hash.Hash     // of the unfinished file
+	element   *list.Element // in the worker's recent list
+}
+
+// ConfiguredFileMaxOpen returns how many output files may be open at once
+func ConfiguredFileMaxOpen() int {
+	key := "file.max_open"
+	if viper.IsSet(key) {
+		if max := viper.GetInt(key); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: key, Value: viper.Get(key), Reason: "using 100"})
+	}
+	return 100
+}
+
+// ConfiguredFileCloseIdle returns how long an output file may go without
+// being written to before it is closed, if file.output has placeholders
+func ConfiguredFileCloseIdle() time.Duration {
+	key := "file.close_idle"
+	if viper.IsSet(key) {
+		return viper.GetDuration(key)
+	}
+	return time.Minute
+}
+
+// fileNameTemplate replaces the built-in placeholders of an output file
+// name template
+func fileNameTemplate(template string) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return strings.NewReplacer(
+		"{date}", "{2006-01-02}",
+		"{hour}", "{15}",
+		"{host}", partitionInvalidChars.Replace(host),
+	).Replace(template)
+}
+
+// ConfiguredFileLatest returns the name of the symbolic link to the file
+// opened last, if any
+func ConfiguredFileLatest() string {
+	key := "file.latest"
+	if viper.IsSet(key) {
+		return viper.GetString(key)
+	}
+	return ""
+}
+
+// PartitionName fills in the placeholders of an output file name template
+// from an event. Missing fields are filled in as "unknown".
+func PartitionName(template string, v map[string]interface{}) string {
+	return fillTemplate(fileNameTemplate(template), v, "unknown", cleanPathElement)
+}
+
+// cleanPathElement keeps a field value within one element of a path
+func cleanPathElement(s string) string {
+	s = partitionInvalidChars.Replace(s)
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// file returns the named output file, opening it (and closing the least
+// recently written file, if too many are open) if needed
+func (w *FileWorker) file(name string) *outputFile {
+	if f, ok := w.files[name]; ok {
+		w.recent.MoveToFront(f.element)
+		return f
+	}
+	f := &outputFile{name: name, path: name}
+	if w.atomic {
+		f.path += tmpSuffix
+	}
+	if dir := filepath.Dir(f.path); dir != "." {
+		if err := w.perm.mkdirAll(dir); err != nil {
+			w.failed(&OutputError{Destination: f.path, Err: err})
+			return nil
+		}
+	}
+	out, err := w.perm.open(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		w.failed(&OutputError{Destination: f.path, Err: err})
+		return nil
+	}
+	f.out = out
+	if w.atomic {
+		f.startTracking()
+	}
+	if w.latest != "" && w.latest != f.path {
+		if err := updateLatest(w.latest, f.path); err != nil {
+			w.failed(&OutputError{Destination: w.latest, Err: err})
+		}
+	}
+	w.files[name] = f
+	f.element = w.recent.PushFront(f)
+	w.setOpen(f.path, true)
+	for max := ConfiguredFileMaxOpen(); w.recent.Len() > max; {
+		w.closeFile(w.recent.Back().Value.(*outputFile))
+	}
+	return f
+}
+
+// latestTarget returns the target of the symbolic link latest pointing at
+// path: relative to the link, if possible
+func latestTarget(latest string, path string) string {
+	target := path
+	if abs, err := filepath.Abs(path); err == nil {
+		target = abs
+		if absLatest, err := filepath.Abs(latest); err == nil {
+			if rel, err := filepath.Rel(filepath.Dir(absLatest), abs); err == nil {
+				target = rel
+			}
+		}
+	}
+	return target
+}
+
+// updateLatest atomically points the symbolic link latest at path
+func updateLatest(latest string, path string) error {
+	target := latestTarget(latest, path)
+	if current, err := os.Readlink(latest); err == nil && current == target {
+		return nil
+	}
+	tmp := latest + tmpSuffix
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, latest)
+}
+
+// repointLatest points the symbolic link latest, if it points at the
+// unfinished file path, at the name it was finished under, or removes it if
+// the file was removed instead (final is "")
+func repointLatest(latest string, path string, final string) error {
+	if current, err := os.Readlink(latest); err != nil || current != latestTarget(latest, path) {
+		return nil
+	}
+	if final == "" {
+		return os.Remove(latest)
+	}
+	return updateLatest(latest, final)
+}
+
+// closeFile closes an output file, finishing it with file.atomic
+func (w *FileWorker) closeFile(f *outputFile) {
+	if w.atomic {
+		w.flush(f)
+	}
+	f.out.Close()
+	if w.atomic {
+		final, err := f.finish(w.manifest, w.perm)
+		if err != nil {
+			w.failed(err)
+		}
+		if w.latest != "" {
+			if err := repointLatest(w.latest, f.path, final); err != nil {
+				w.failed(&OutputError{Destination: w.latest, Err: err})
+			}
+		}
+	}
+	w.setOpen(f.path, false)
+	delete(w.files, f.name)
+	w.recent.Remove(f.element)
+}
+
+// closeFiles closes all the output files
+func (w *FileWorker) closeFiles() {
+	for w.recent != nil && w.recent.Len() > 0 {
+		f := w.recent.Front().Value.(*outputFile)
+		w.flush(f)
+		w.closeFile(f)
+	}
+}
+
+// closeIdleFiles closes the output files that haven't been written to for
+// idle
+func (w *FileWorker) closeIdleFiles(idle time.Duration) {
+	for w.recent.Len() > 0 {
+		f := w.recent.Back().Value.(*outputFile)
+		if time.Since(f.lastWrite) < idle {
+			return
+		}
+		w.closeFile(f)
+	}
+}
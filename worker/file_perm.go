@@ -0,0 +1,164 @@
+package worker
+
+/*
+	file_perm.go sets the permissions and ownership of output files
+
+	Output files (and their manifests, and compressed copies) are created
+	with file.mode (0644 by default), and the directories made for them
+	with the same mode plus search permission wherever it has read
+	permission, both subject to the umask. When translog runs as root,
+	file.owner and file.group (names or numeric ids) set the owner and
+	group of the files and directories it creates.
+*/
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+const configFileMode = "file.mode"
+const configFileOwner = "file.owner"
+const configFileGroup = "file.group"
+
+// defaultFileMode is the mode of output files unless file.mode is set
+const defaultFileMode os.FileMode = 0644
+
+// filePerm is the mode and ownership of created output files
+type filePerm struct {
+	mode os.FileMode
+	uid  int // -1 to leave the owner alone
+	gid  int // -1 to leave the group alone
+}
+
+// ConfiguredFileMode returns the mode output files are created with
+func ConfiguredFileMode() os.FileMode {
+	if !viper.IsSet(configFileMode) {
+		return defaultFileMode
+	}
+	switch mode := viper.Get(configFileMode).(type) {
+	case int:
+		// a YAML or TOML octal number, such as 0640
+		if mode >= 0 && mode <= 0777 {
+			return os.FileMode(mode)
+		}
+	case int64:
+		if mode >= 0 && mode <= 0777 {
+			return os.FileMode(mode)
+		}
+	default:
+		if parsed, err := strconv.ParseUint(fmt.Sprint(mode), 8, 32); err == nil && parsed <= 0777 {
+			return os.FileMode(parsed)
+		}
+	}
+	reportError(&ConfigError{Key: configFileMode, Value: viper.Get(configFileMode), Reason: "expected an octal mode, such as 0640; using 0644"})
+	return defaultFileMode
+}
+
+// lookupID returns the id of a user or group, given its name or id
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// configuredID returns the id of the user or group set in key, or -1
+func configuredID(key string, lookup func(string) (string, error)) int {
+	name := viper.GetString(key)
+	if name == "" {
+		return -1
+	}
+	if os.Geteuid() != 0 {
+		reportError(&ConfigError{Key: key, Value: name, Reason: "ownership can only be set when running as root; ignoring"})
+		return -1
+	}
+	id, err := lookupID(name, lookup)
+	if err != nil {
+		reportError(&ConfigError{Key: key, Value: name, Reason: err.Error()})
+		return -1
+	}
+	return id
+}
+
+// ConfiguredFilePerm returns the mode and ownership of created output files
+func ConfiguredFilePerm() filePerm {
+	return filePerm{
+		mode: ConfiguredFileMode(),
+		uid: configuredID(configFileOwner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}),
+		gid: configuredID(configFileGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}),
+	}
+}
+
+// dirMode returns the mode of directories holding files of the given mode
+func (p filePerm) dirMode() os.FileMode {
+	return p.mode | (p.mode&0444)>>2
+}
+
+// chown sets the ownership of a created file or directory
+func (p filePerm) chown(path string) error {
+	if p.uid < 0 && p.gid < 0 {
+		return nil
+	}
+	return os.Chown(path, p.uid, p.gid)
+}
+
+// open opens a file, setting the ownership if it creates it
+func (p filePerm) open(path string, flag int) (*os.File, error) {
+	_, err := os.Lstat(path)
+	created := os.IsNotExist(err)
+	f, err := os.OpenFile(path, flag, p.mode)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := p.chown(path); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// mkdirAll makes a directory and its missing parents, setting the ownership
+// of those it makes
+func (p filePerm) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, p.dirMode()); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := p.chown(missing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
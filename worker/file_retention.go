@@ -0,0 +1,191 @@
+package worker
+
+/*
+	file_retention.go cleans up old output files
+
+	When file.output has placeholders, so that output files are rotated, a
+	janitor goroutine looks at the output files (those matching file.output,
+	with each placeholder matching anything) every minute. Files that are
+	still open are left alone. Closed files older than
+	file.retention.compress_after are gzipped. Then the oldest files (and
+	their manifests) are deleted while there are more than
+	file.retention.max_count of them, while they take up more than
+	file.retention.max_size, and while the oldest is older than
+	file.retention.max_age. Limits that aren't set aren't enforced.
+*/
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configFileRetentionMaxAge = "file.retention.max_age"
+const configFileRetentionMaxSize = "file.retention.max_size"
+const configFileRetentionMaxCount = "file.retention.max_count"
+const configFileRetentionCompressAfter = "file.retention.compress_after"
+
+// janitorInterval is how often the janitor looks at the output files
+const janitorInterval = time.Minute
+
+// retention limits the output files that are kept
+type retention struct {
+	maxAge        time.Duration
+	maxSize       int64
+	maxCount      int
+	compressAfter time.Duration
+}
+
+// ConfiguredFileRetention returns the limits on the output files that are
+// kept; zero values are not enforced
+func ConfiguredFileRetention() (r retention) {
+	r.maxAge = viper.GetDuration(configFileRetentionMaxAge)
+	r.maxCount = viper.GetInt(configFileRetentionMaxCount)
+	r.compressAfter = viper.GetDuration(configFileRetentionCompressAfter)
+	if viper.IsSet(configFileRetentionMaxSize) {
+		size, err := ParseByteSize(viper.GetString(configFileRetentionMaxSize))
+		if err != nil {
+			reportError(&ConfigError{Key: configFileRetentionMaxSize, Value: viper.Get(configFileRetentionMaxSize), Reason: err.Error()})
+		}
+		r.maxSize = size
+	}
+	return
+}
+
+// enabled returns true if any limit is set
+func (r retention) enabled() bool {
+	return r.maxAge > 0 || r.maxSize > 0 || r.maxCount > 0 || r.compressAfter > 0
+}
+
+// retentionGlob returns a pattern matching the files an output file name
+// template produces
+func retentionGlob(template string) string {
+	return indexPlaceholderRegex.ReplaceAllString(template, "*")
+}
+
+// isOpen returns true if path is an open output file
+func (w *FileWorker) isOpen(path string) bool {
+	w.openLock.Lock()
+	defer w.openLock.Unlock()
+	return w.openPaths[path]
+}
+
+// setOpen records whether path is an open output file
+func (w *FileWorker) setOpen(path string, open bool) {
+	w.openLock.Lock()
+	defer w.openLock.Unlock()
+	if open {
+		w.openPaths[path] = true
+	} else {
+		delete(w.openPaths, path)
+	}
+}
+
+// janitor enforces the retention limits until done is closed
+func (w *FileWorker) janitor(r retention, done chan bool) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		w.cleanUp(r)
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// cleanUp compresses and deletes the closed output files, as the retention
+// limits say
+func (w *FileWorker) cleanUp(r retention) {
+	pattern := retentionGlob(w.template)
+	paths, _ := filepath.Glob(pattern)
+	compressed, _ := filepath.Glob(pattern + ".gz")
+	var files []os.FileInfo
+	var names []string
+	for _, path := range append(paths, compressed...) {
+		if strings.HasSuffix(path, tmpSuffix) || strings.HasSuffix(path, manifestSuffix) || w.isOpen(path) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if r.compressAfter > 0 && !strings.HasSuffix(path, ".gz") && time.Since(info.ModTime()) > r.compressAfter {
+			if err := compressFile(path, info.ModTime(), w.perm); err != nil {
+				w.failed(&OutputError{Destination: path + ".gz", Err: err})
+				continue
+			}
+			Counters.Inc("output_files_compressed")
+			path += ".gz"
+			if info, err = os.Lstat(path); err != nil {
+				continue
+			}
+		}
+		files = append(files, info)
+		names = append(names, path)
+	}
+	order := make([]int, len(files))
+	var total int64
+	for i := range order {
+		order[i] = i
+		total += files[i].Size()
+	}
+	sort.Slice(order, func(i, j int) bool { return files[order[i]].ModTime().Before(files[order[j]].ModTime()) })
+	count := len(files)
+	for _, i := range order {
+		tooOld := r.maxAge > 0 && time.Since(files[i].ModTime()) > r.maxAge
+		if !tooOld && (r.maxCount <= 0 || count <= r.maxCount) && (r.maxSize <= 0 || total <= r.maxSize) {
+			break
+		}
+		logs.Info("Deleting old output file %s", names[i])
+		if err := os.Remove(names[i]); err != nil {
+			w.failed(&OutputError{Destination: names[i], Err: err})
+			continue
+		}
+		os.Remove(strings.TrimSuffix(names[i], ".gz") + manifestSuffix)
+		Counters.Inc("output_files_deleted")
+		count--
+		total -= files[i].Size()
+	}
+}
+
+// compressFile gzips a file, replacing it with the compressed file, which
+// keeps the modification time of the original
+func compressFile(path string, modTime time.Time, perm filePerm) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	tmp := path + ".gz" + tmpSuffix
+	out, err := perm.open(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+	os.Chtimes(tmp, modTime, modTime)
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,265 @@
+package worker_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var fileSyncTestCases = []struct {
+	setting  interface{}
+	expected string
+}{
+	{nil, "never"},
+	{"always", "always"},
+	{"interval", "interval"},
+	{"sometimes", "never"},
+}
+
+func TestConfiguredFileSync(t *testing.T) {
+	for i, tt := range fileSyncTestCases {
+		viper.Reset()
+		if tt.setting != nil {
+			viper.Set("file.sync", tt.setting)
+		}
+		actual := worker.ConfiguredFileSync()
+		if actual != tt.expected {
+			t.Errorf("In test %d, ConfiguredFileSync(%v): expected %v, actual %v", i, tt.setting, tt.expected, actual)
+		}
+	}
+}
+
+// writeEvents writes events with a new file worker, and stops it
+func writeEvents(events ...map[string]interface{}) {
+	channel := make(chan map[string]interface{})
+	w := &worker.FileWorker{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.Start()
+	for _, v := range events {
+		channel <- v
+	}
+	w.Stop()
+}
+
+func TestFileAtomicManifest(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.jsonl")
+	viper.Set("file.output", name)
+	viper.Set("file.atomic", true)
+	viper.Set("file.manifest", true)
+	writeEvents(map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2})
+	writeEvents(map[string]interface{}{"a": 3})
+	writeEvents()
+
+	for i, tt := range []struct {
+		name  string
+		lines int64
+	}{{"out.jsonl", 2}, {"out-1.jsonl", 1}} {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, tt.name))
+		if err != nil {
+			t.Fatalf("In test %d, expected %s to be finished, got %v", i, tt.name, err)
+		}
+		var manifest worker.FileManifest
+		bs2, err := ioutil.ReadFile(filepath.Join(dir, tt.name+".manifest.json"))
+		if err != nil {
+			t.Fatalf("In test %d, expected a manifest for %s, got %v", i, tt.name, err)
+		}
+		json.Unmarshal(bs2, &manifest)
+		sum := sha256.Sum256(bs)
+		if manifest.File != tt.name || manifest.Lines != tt.lines || manifest.Bytes != int64(len(bs)) || manifest.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("In test %d, manifest of %s (%d lines, %d bytes): got %+v", i, tt.name, tt.lines, len(bs), manifest)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out-2.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("expected an empty file not to be finished, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(matches) != 0 {
+		t.Errorf("expected no unfinished files, got %v", matches)
+	}
+}
+
+var partitionNameTestCases = []struct {
+	template string
+	event    map[string]interface{}
+	expected string
+}{
+	{"out.jsonl", map[string]interface{}{"service": "api"}, "out.jsonl"},
+	{"out/{service}/{2006-01-02}.jsonl", map[string]interface{}{"service": "api", "created": "2024-03-01T12:00:00Z"}, "out/api/2024-03-01.jsonl"},
+	{"out/{service}.jsonl", map[string]interface{}{"service": "../etc/passwd"}, "out/.._etc_passwd.jsonl"},
+	{"out/{service}.jsonl", map[string]interface{}{"service": ".."}, "out/_.jsonl"},
+	{"out/{service}.jsonl", map[string]interface{}{}, "out/unknown.jsonl"},
+	{"{date}/{hour}.jsonl", map[string]interface{}{"created": "2024-03-01T12:00:00Z", "date": "today"}, "2024-03-01/12.jsonl"},
+}
+
+func TestPartitionName(t *testing.T) {
+	for i, tt := range partitionNameTestCases {
+		actual := worker.PartitionName(tt.template, tt.event)
+		if actual != tt.expected {
+			t.Errorf("In test %d, PartitionName(%v, %v): expected %v, actual %v", i, tt.template, tt.event, tt.expected, actual)
+		}
+	}
+}
+
+func TestPartitionNameHost(t *testing.T) {
+	host, _ := os.Hostname()
+	actual := worker.PartitionName("{host}.jsonl", nil)
+	if actual != host+".jsonl" {
+		t.Errorf("PartitionName({host}.jsonl): expected %v, actual %v", host+".jsonl", actual)
+	}
+}
+
+func TestFilePartitions(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	viper.Set("file.output", filepath.Join(dir, "{service}", "out.jsonl"))
+	viper.Set("file.max_open", 1)
+	writeEvents(
+		map[string]interface{}{"service": "api", "n": 1},
+		map[string]interface{}{"service": "web", "n": 2},
+		map[string]interface{}{"service": "api", "n": 3},
+	)
+	for service, expected := range map[string]string{"api": "{\"n\":1,\"service\":\"api\"}\n{\"n\":3,\"service\":\"api\"}\n", "web": "{\"n\":2,\"service\":\"web\"}\n"} {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, service, "out.jsonl"))
+		if err != nil || string(bs) != expected {
+			t.Errorf("expected %s to be %q, actual %q (%v)", service, expected, bs, err)
+		}
+	}
+}
+
+func TestFileLatest(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	viper.Set("file.output", filepath.Join(dir, "out-{n}.jsonl"))
+	viper.Set("file.latest", filepath.Join(dir, "output.jsonl"))
+	writeEvents(map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2})
+	target, err := os.Readlink(filepath.Join(dir, "output.jsonl"))
+	if err != nil || target != "out-2.jsonl" {
+		t.Errorf("expected output.jsonl to point at out-2.jsonl, actual %q (%v)", target, err)
+	}
+}
+
+func TestFileLatestAtomic(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	viper.Set("file.output", filepath.Join(dir, "out-{n}.jsonl"))
+	viper.Set("file.latest", filepath.Join(dir, "output.jsonl"))
+	viper.Set("file.atomic", true)
+	writeEvents(map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2})
+	target, err := os.Readlink(filepath.Join(dir, "output.jsonl"))
+	if err != nil || target != "out-2.jsonl" {
+		t.Errorf("expected output.jsonl to point at the finished out-2.jsonl, actual %q (%v)", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "output.jsonl")); err != nil {
+		t.Errorf("expected output.jsonl not to dangle, actual %v", err)
+	}
+}
+
+func TestFileRetention(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	for i, name := range []string{"out-1.jsonl", "out-2.jsonl", "out-3.jsonl", "out-4.jsonl"} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte("{}\n"), 0666)
+		modTime := time.Now().Add(time.Duration(i-4) * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "out-1.jsonl.manifest.json"), []byte("{}\n"), 0666)
+	viper.Set("file.output", filepath.Join(dir, "out-{n}.jsonl"))
+	viper.Set("file.retention.max_count", 2)
+	viper.Set("file.retention.compress_after", "90m")
+	w := &worker.FileWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	expected := []string{filepath.Join(dir, "out-3.jsonl.gz"), filepath.Join(dir, "out-4.jsonl")}
+	var actual []string
+	for i := 0; i < 200; i++ {
+		actual, _ = filepath.Glob(filepath.Join(dir, "out-*"))
+		if len(actual) == 2 && actual[0] == expected[0] && actual[1] == expected[1] {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected %v to be kept, actual %v", expected, actual)
+}
+
+func TestFileDiskFullDrops(t *testing.T) {
+	viper.Reset()
+	name := filepath.Join(t.TempDir(), "out.jsonl")
+	viper.Set("file.output", name)
+	viper.Set("file.min_free", "100%")
+	viper.Set("file.on_disk_full", "drop")
+	channel := make(chan map[string]interface{})
+	w := &worker.FileWorker{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.Start()
+	for i := 0; i < 200 && w.Health().Healthy; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.Health().Healthy {
+		t.Fatal("expected the output to be unhealthy while the disk is full")
+	}
+	dropped := worker.Counters.Get("disk_full_dropped")
+	channel <- map[string]interface{}{"a": 1}
+	w.Stop()
+	if bs, _ := ioutil.ReadFile(name); len(bs) != 0 {
+		t.Errorf("expected nothing to be written while the disk is full, actual %q", bs)
+	}
+	if worker.Counters.Get("disk_full_dropped") != dropped+1 {
+		t.Errorf("expected the event to be counted as dropped")
+	}
+}
+
+var fileModeTestCases = []struct {
+	setting  interface{}
+	expected os.FileMode
+}{
+	{nil, 0644},
+	{"0640", 0640},
+	{"600", 0600},
+	{0640, 0640},
+	{"rw-r-----", 0644},
+	{"01777", 0644},
+}
+
+func TestConfiguredFileMode(t *testing.T) {
+	for i, tt := range fileModeTestCases {
+		viper.Reset()
+		if tt.setting != nil {
+			viper.Set("file.mode", tt.setting)
+		}
+		actual := worker.ConfiguredFileMode()
+		if actual != tt.expected {
+			t.Errorf("In test %d, ConfiguredFileMode(%v): expected %v, actual %v", i, tt.setting, tt.expected, actual)
+		}
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	viper.Set("file.output", filepath.Join(dir, "{service}", "out.jsonl"))
+	viper.Set("file.mode", "0600")
+	writeEvents(map[string]interface{}{"service": "api"})
+	for path, expected := range map[string]os.FileMode{filepath.Join(dir, "api"): os.ModeDir | 0700, filepath.Join(dir, "api", "out.jsonl"): 0600} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != expected {
+			t.Errorf("expected %s to have mode %v, actual %v", path, expected, info.Mode())
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package worker
+
+/*
+	forward.go forwards events to another translog, over gRPC
+
+	Events are sent in batches of up to forward.max events, at least every
+	forward.flush_interval, to the forward input (input.type = "forward",
+	see input_forward.go) of the translog at forward.address, over a gRPC
+	stream (see forward.proto), compressed with gzip unless forward.compress
+	is false. Each batch is acknowledged once the receiver has handed its
+	events to its pipeline; a batch that isn't acknowledged within
+	forward.timeout is sent again, on a new stream, up to
+	forward.max_retries times with exponential backoff, and then
+	dead-lettered. Delivery is at least once: a batch whose acknowledgement
+	is lost is received twice.
+
+	With forward.secure, the connection uses TLS, configured in forward.tls
+	or the shared tls block (see tls.go). forward.token, if set, must match
+	the receiver's input.forward.token.
+
+	Events are converted to protobuf Structs: numbers become doubles (whole
+	numbers come back as integers), and times become RFC 3339 strings.
+*/
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const configForwardAddress = "forward.address"
+const configForwardCompress = "forward.compress"
+const configForwardSecure = "forward.secure"
+const configForwardToken = "forward.token"
+const configForwardMax = "forward.max"
+const configForwardFlushInterval = "forward.flush_interval"
+const configForwardTimeout = "forward.timeout"
+const configForwardMaxRetries = "forward.max_retries"
+const configForwardRetryBackoff = "forward.retry_backoff"
+
+// forwardMethod is the full name of the streaming method
+const forwardMethod = "/translog.Forward/Send"
+
+// forwardStreamDesc describes the Send stream, for clients
+var forwardStreamDesc = grpc.StreamDesc{StreamName: "Send", ServerStreams: true, ClientStreams: true}
+
+// ForwardWorker sends events to another translog
+type ForwardWorker struct {
+	WorkChannel  chan map[string]interface{}
+	BatchChannel chan []map[string]interface{}
+	QuitChannel  chan bool
+	FlushChannel chan bool
+	events       []*structpb.Value
+	seq          int64
+	conn         *grpc.ClientConn
+	stream       grpc.ClientStream
+	cancel       context.CancelFunc
+	startTime    time.Time
+	healthTracker
+}
+
+// ConfiguredForwardAddress returns the address of the receiving translog
+func ConfiguredForwardAddress() string {
+	if viper.IsSet(configForwardAddress) {
+		return viper.GetString(configForwardAddress)
+	}
+	return "localhost:9520"
+}
+
+// ConfiguredForwardCompress returns true if batches are compressed
+func ConfiguredForwardCompress() bool {
+	if viper.IsSet(configForwardCompress) {
+		return viper.GetBool(configForwardCompress)
+	}
+	return true
+}
+
+// ConfiguredForwardMax returns the largest batch
+func ConfiguredForwardMax() int {
+	if viper.IsSet(configForwardMax) {
+		if max := viper.GetInt(configForwardMax); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configForwardMax, Value: viper.Get(configForwardMax), Reason: "using 500"})
+	}
+	return 500
+}
+
+// ConfiguredForwardFlushInterval returns the longest events wait for their
+// batch to be sent
+func ConfiguredForwardFlushInterval() time.Duration {
+	if viper.IsSet(configForwardFlushInterval) {
+		if interval := viper.GetDuration(configForwardFlushInterval); interval > 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configForwardFlushInterval, Value: viper.Get(configForwardFlushInterval), Reason: "using 1s"})
+	}
+	return time.Second
+}
+
+// ConfiguredForwardTimeout returns how long to wait for a batch to be
+// acknowledged
+func ConfiguredForwardTimeout() time.Duration {
+	if viper.IsSet(configForwardTimeout) {
+		if timeout := viper.GetDuration(configForwardTimeout); timeout > 0 {
+			return timeout
+		}
+		reportError(&ConfigError{Key: configForwardTimeout, Value: viper.Get(configForwardTimeout), Reason: "using 30s"})
+	}
+	return 30 * time.Second
+}
+
+// ConfiguredForwardMaxRetries returns how many times a batch is sent again
+// before it is dead-lettered
+func ConfiguredForwardMaxRetries() int {
+	if viper.IsSet(configForwardMaxRetries) {
+		return viper.GetInt(configForwardMaxRetries)
+	}
+	return 5
+}
+
+// ConfiguredForwardRetryBackoff returns the wait before the first retry
+func ConfiguredForwardRetryBackoff() time.Duration {
+	if viper.IsSet(configForwardRetryBackoff) {
+		return viper.GetDuration(configForwardRetryBackoff)
+	}
+	return time.Second
+}
+
+// structValue converts an event to a protobuf value
+func structValue(v map[string]interface{}) (*structpb.Value, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := &structpb.Struct{}
+	if err := protojson.Unmarshal(bs, s); err != nil {
+		return nil, err
+	}
+	return structpb.NewStructValue(s), nil
+}
+
+// forwardedValue converts whole numbers in a value received from another
+// translog back to int64s
+func forwardedValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return int64(value)
+		}
+	case map[string]interface{}:
+		for k, e := range value {
+			value[k] = forwardedValue(e)
+		}
+	case []interface{}:
+		for i, e := range value {
+			value[i] = forwardedValue(e)
+		}
+	}
+	return value
+}
+
+func (w *ForwardWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+// SetBatchChannel sets the channel batches of events are received on
+func (w *ForwardWorker) SetBatchChannel(channel chan []map[string]interface{}) {
+	w.BatchChannel = channel
+}
+
+func (w *ForwardWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	return
+}
+
+// Start the work
+func (w *ForwardWorker) Start() {
+	go Supervise("ForwardWorker", w.Work)
+}
+
+// connect opens the connection and the stream, if they are not open
+func (w *ForwardWorker) connect() error {
+	if w.stream != nil {
+		return nil
+	}
+	if w.conn == nil {
+		creds := insecure.NewCredentials()
+		if viper.GetBool(configForwardSecure) {
+			config, err := configuredTLS("forward")
+			if err != nil {
+				return err
+			}
+			if config == nil {
+				config = &tls.Config{}
+			}
+			creds = credentials.NewTLS(config)
+		}
+		conn, err := grpc.NewClient(ConfiguredForwardAddress(), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if token := viper.GetString(configForwardToken); token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	var options []grpc.CallOption
+	if ConfiguredForwardCompress() {
+		options = append(options, grpc.UseCompressor(gzip.Name))
+	}
+	stream, err := w.conn.NewStream(ctx, &forwardStreamDesc, forwardMethod, options...)
+	if err != nil {
+		cancel()
+		return err
+	}
+	w.stream, w.cancel = stream, cancel
+	return nil
+}
+
+// disconnect closes the stream, after a failure
+func (w *ForwardWorker) disconnect() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.stream, w.cancel = nil, nil
+}
+
+// send sends a batch, and waits for its acknowledgement
+func (w *ForwardWorker) send(batch *structpb.Struct, seq int64) error {
+	if err := w.connect(); err != nil {
+		return err
+	}
+	// an acknowledgement that doesn't come in time cancels the stream
+	timer := time.AfterFunc(ConfiguredForwardTimeout(), w.cancel)
+	defer timer.Stop()
+	if err := w.stream.SendMsg(batch); err != nil {
+		w.disconnect()
+		return err
+	}
+	ack := &structpb.Struct{}
+	if err := w.stream.RecvMsg(ack); err != nil {
+		w.disconnect()
+		return err
+	}
+	if acked := int64(ack.Fields["seq"].GetNumberValue()); acked != seq {
+		w.disconnect()
+		return fmt.Errorf("Batch %d acknowledged as %d", seq, acked)
+	}
+	return nil
+}
+
+// add adds an event to the pending batch
+func (w *ForwardWorker) add(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	value, err := structValue(obj)
+	if err != nil {
+		logs.Info("Unable to convert object %v: %v", obj, err)
+		return
+	}
+	w.events = append(w.events, value)
+	if len(w.events) >= ConfiguredForwardMax() {
+		w.flush()
+	}
+}
+
+// flush sends the pending batch, retrying until it is acknowledged, or
+// dead-lettering it
+func (w *ForwardWorker) flush() {
+	events := w.events
+	w.events = nil
+	if len(events) == 0 {
+		return
+	}
+	w.seq++
+	batch := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"seq":    structpb.NewNumberValue(float64(w.seq)),
+		"events": structpb.NewListValue(&structpb.ListValue{Values: events}),
+	}}
+	backoff := ConfiguredForwardRetryBackoff()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			Counters.Add("forward_retries", int64(len(events)))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		start := time.Now()
+		err := w.send(batch, w.seq)
+		if err == nil {
+			w.succeeded(time.Since(start))
+			Counters.Inc("forward_batches")
+			Counters.Add("forward_events", int64(len(events)))
+			return
+		}
+		w.failed(&OutputError{Destination: ConfiguredForwardAddress(), Err: err})
+		if attempt >= ConfiguredForwardMaxRetries() {
+			logs.Warn("Giving up on a batch of %d events after %d retries", len(events), attempt)
+			Counters.Add("forward_rejected", int64(len(events)))
+			for _, event := range events {
+				document, _ := protojson.Marshal(event)
+				deadLetterDocument(string(document), "retries_exhausted", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// close closes the connection
+func (w *ForwardWorker) close() {
+	if w.stream != nil {
+		w.stream.CloseSend()
+	}
+	w.disconnect()
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// Work the queue
+func (w *ForwardWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("ForwardWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredForwardFlushInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.add(obj)
+
+		case batch := <-w.BatchChannel:
+			for _, obj := range batch {
+				w.add(obj)
+			}
+
+		case <-ticker.C:
+			w.flush()
+
+		case <-w.FlushChannel:
+			w.flush()
+
+		case <-w.QuitChannel:
+			logs.Info("ForwardWorker received quit")
+			w.flush()
+			w.close()
+			return
+		}
+	}
+}
+
+// Flush asks the worker to send the events it has collected
+func (w *ForwardWorker) Flush() {
+	w.FlushChannel <- true
+}
+
+// Stop stops the worker, after sending the events it has collected
+func (w *ForwardWorker) Stop() {
+	w.QuitChannel <- true
+}
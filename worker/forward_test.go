@@ -0,0 +1,73 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// forward sends the events numbered 1 to n with a forward worker
+func forward(t *testing.T, n int) {
+	f := &worker.ForwardWorker{}
+	channel := make(chan map[string]interface{})
+	f.SetWorkChannel(channel)
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	f.Start()
+	for i := 1; i <= n; i++ {
+		channel <- map[string]interface{}{"n": i}
+	}
+	f.Stop()
+}
+
+// waitForCounter waits until a counter has grown beyond its previous value
+func waitForCounter(t *testing.T, name string, previous int64) {
+	for i := 0; i < 500; i++ {
+		if worker.Counters.Get(name) > previous {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %s to grow beyond %d", name, previous)
+}
+
+func TestForward(t *testing.T) {
+	viper.Reset()
+	address := freeAddress(t, "tcp")
+	viper.Set("input.type", "forward")
+	viper.Set("input.address", address)
+	viper.Set("input.forward.token", "secret")
+	viper.Set("forward.address", address)
+	viper.Set("forward.token", "secret")
+	viper.Set("forward.retry_backoff", "10ms")
+	w, channel := startParser(t)
+	defer w.Stop()
+	batches := worker.Counters.Get("forward_batches")
+	forward(t, 3)
+	expectEvents(t, channel, 3)
+	// the batch is acknowledged after its events are in the pipeline
+	waitForCounter(t, "forward_batches", batches)
+}
+
+func TestForwardInvalidToken(t *testing.T) {
+	viper.Reset()
+	address := freeAddress(t, "tcp")
+	viper.Set("input.type", "forward")
+	viper.Set("input.address", address)
+	viper.Set("input.forward.token", "secret")
+	viper.Set("forward.address", address)
+	viper.Set("forward.token", "guess")
+	viper.Set("forward.retry_backoff", "10ms")
+	viper.Set("forward.max_retries", 2)
+	w, channel := startParser(t)
+	defer w.Stop()
+	rejected := worker.Counters.Get("forward_rejected")
+	forward(t, 1)
+	waitForCounter(t, "forward_rejected", rejected)
+	if len(channel) != 0 {
+		t.Errorf("expected the event to be rejected, actual %d events", len(channel))
+	}
+}
@@ -0,0 +1,64 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// The fuzz targets check that the parser never panics, and that whatever it
+// parses can be marshaled, as every output must. The seed corpora run as
+// part of go test; fuzz with, e.g., go test ./worker -fuzz FuzzParseEvents
+
+const fuzzPattern = `^(?P<ip>\S+) \S+ \S+ \[(?P<created>[^\]]+)\] "(?P<method>[A-Z]+) (?P<uri>\S+) [^"]*" (?P<status>\S+) (?P<bytes>\S+)`
+
+func FuzzParseEvents(f *testing.F) {
+	for _, line := range worker.GenerateBenchLines(5) {
+		f.Add(line)
+	}
+	f.Add(`10.0.0.1 - - [01/Mar/2024:12:00:00 +0000] "GET /a?x=1&x=2&y=Inf HTTP/1.1" NaN -`)
+	f.Add("\ufeff10.0.0.1 - - [] \"GET %zz HTTP/1.1\" 200 0\r")
+	viper.Reset()
+	viper.Set("parse.pattern", fuzzPattern)
+	w := &worker.LogParser{}
+	w.Init()
+	f.Fuzz(func(t *testing.T, line string) {
+		v, err := w.ParseEvents(line)
+		if err != nil {
+			return
+		}
+		if _, err := json.Marshal(v); err != nil {
+			t.Errorf("ParseEvents(%q) = %v, which can't be marshaled: %v", line, v, err)
+		}
+	})
+}
+
+func FuzzParseURI(f *testing.F) {
+	for _, uri := range []string{"/", "/api/v1/users?id=42&sort=asc", "/a/b.tar.gz?x=1&x=2", "http://example.com/%41?q=%zz", "?=&=", "/search?q=1e400"} {
+		f.Add(uri)
+	}
+	viper.Reset()
+	w := &worker.LogParser{}
+	f.Fuzz(func(t *testing.T, uri string) {
+		v := make(map[string]interface{})
+		w.ParseURI(uri, v)
+		if _, err := json.Marshal(v); err != nil {
+			t.Errorf("ParseURI(%q) = %v, which can't be marshaled: %v", uri, v, err)
+		}
+	})
+}
+
+func FuzzParseStringForValue(f *testing.F) {
+	for _, s := range []string{"", "42", "-1.5", "true", "NaN", "Inf", "-infinity", "2024-03-01T12:00:00Z", "01/Mar/2024:12:00:00 +0000", "9999999999999999999999"} {
+		f.Add(s)
+	}
+	viper.Reset()
+	f.Fuzz(func(t *testing.T, s string) {
+		value := worker.ParseStringForValue(s)
+		if _, err := json.Marshal(value); err != nil {
+			t.Errorf("ParseStringForValue(%q) = %v, which can't be marshaled: %v", s, value, err)
+		}
+	})
+}
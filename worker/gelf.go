@@ -0,0 +1,212 @@
+package worker
+
+/*
+	gelf.go sends events to Graylog (or anything else that accepts GELF)
+
+	Each event is sent as a GELF message to gelf.address over gelf.protocol:
+	"udp" (the default) compresses messages (gelf.compress = "gzip", "zlib"
+	or "none") and splits those larger than gelf.chunk_size into chunks;
+	"tcp" sends uncompressed, null-byte delimited messages over a connection
+	that is reopened when it fails. The short_message is the event's
+	gelf.message_field, the level comes from its level field, and every
+	other field is sent as an additional field.
+*/
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configGELFAddress = "gelf.address"
+const configGELFProtocol = "gelf.protocol"
+const configGELFCompress = "gelf.compress"
+const configGELFChunkSize = "gelf.chunk_size"
+const configGELFMessageField = "gelf.message_field"
+
+// gelfDialTimeout limits how long to wait for a connection
+const gelfDialTimeout = 10 * time.Second
+
+// GELFWorker sends events as GELF messages
+type GELFWorker struct {
+	WorkChannel chan map[string]interface{}
+	QuitChannel chan bool
+	conn        net.Conn
+	startTime   time.Time
+	healthTracker
+}
+
+// ConfiguredGELFAddress returns the address to send messages to
+func ConfiguredGELFAddress() string {
+	if viper.IsSet(configGELFAddress) {
+		return viper.GetString(configGELFAddress)
+	}
+	return "localhost:12201"
+}
+
+// ConfiguredGELFProtocol returns the protocol to send messages with, udp or
+// tcp
+func ConfiguredGELFProtocol() string {
+	if viper.IsSet(configGELFProtocol) {
+		return viper.GetString(configGELFProtocol)
+	}
+	return "udp"
+}
+
+// ConfiguredGELFCompress returns how UDP messages are compressed: gzip, zlib,
+// or none
+func ConfiguredGELFCompress() string {
+	if viper.IsSet(configGELFCompress) {
+		return viper.GetString(configGELFCompress)
+	}
+	return "gzip"
+}
+
+// ConfiguredGELFChunkSize returns the largest datagram sent over UDP
+func ConfiguredGELFChunkSize() int {
+	if viper.IsSet(configGELFChunkSize) {
+		if size := viper.GetInt(configGELFChunkSize); size > gelfChunkHeaderSize {
+			return size
+		}
+		reportError(&ConfigError{Key: configGELFChunkSize, Value: viper.Get(configGELFChunkSize), Reason: "using 8154"})
+	}
+	return 8154
+}
+
+// ConfiguredGELFMessageField returns the field sent as the short_message
+func ConfiguredGELFMessageField() string {
+	if viper.IsSet(configGELFMessageField) {
+		return viper.GetString(configGELFMessageField)
+	}
+	return "message"
+}
+
+// compressGELF compresses a message for UDP
+func compressGELF(payload []byte, compression string) ([]byte, error) {
+	var b bytes.Buffer
+	switch compression {
+	case "gzip":
+		zw := gzip.NewWriter(&b)
+		zw.Write(payload)
+		zw.Close()
+	case "zlib":
+		zw := zlib.NewWriter(&b)
+		zw.Write(payload)
+		zw.Close()
+	case "none", "":
+		return payload, nil
+	default:
+		return nil, &ConfigError{Key: configGELFCompress, Value: compression, Reason: "expected gzip, zlib, or none"}
+	}
+	return b.Bytes(), nil
+}
+
+func (w *GELFWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *GELFWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	switch protocol := ConfiguredGELFProtocol(); protocol {
+	case "udp", "tcp":
+	default:
+		err = &ConfigError{Key: configGELFProtocol, Value: protocol, Reason: "expected udp or tcp"}
+		reportError(err)
+	}
+	return
+}
+
+// Start the work
+func (w *GELFWorker) Start() {
+	go Supervise("GELFWorker", w.Work)
+}
+
+// connect opens the connection, if it is not already open
+func (w *GELFWorker) connect() error {
+	if w.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout(ConfiguredGELFProtocol(), ConfiguredGELFAddress(), gelfDialTimeout)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+// write sends a message over the connection
+func (w *GELFWorker) write(message []byte) error {
+	if err := w.connect(); err != nil {
+		return err
+	}
+	var err error
+	if ConfiguredGELFProtocol() == "tcp" {
+		_, err = w.conn.Write(append(message, 0))
+	} else {
+		var datagrams [][]byte
+		message, err = compressGELF(message, ConfiguredGELFCompress())
+		if err == nil {
+			datagrams, err = ChunkGELF(message, ConfiguredGELFChunkSize())
+		}
+		for _, datagram := range datagrams {
+			if _, err = w.conn.Write(datagram); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// reconnect for the next message
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// send sends an event as a GELF message
+func (w *GELFWorker) send(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	message, err := json.Marshal(EncodeGELF(obj, ConfiguredGELFMessageField()))
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	start := time.Now()
+	if err := w.write(message); err != nil {
+		w.failed(&OutputError{Destination: ConfiguredGELFAddress(), Err: err})
+		document, _ := json.Marshal(obj)
+		deadLetterDocument(string(document), "rejected", err.Error())
+		return
+	}
+	w.succeeded(time.Since(start))
+}
+
+// Work the queue
+func (w *GELFWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("GELFWorker starting work at %v", w.startTime)
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			logs.Debug("Worker received: %v", obj)
+			w.send(obj)
+
+		case <-w.QuitChannel:
+			logs.Info("GELFWorker received quit")
+			if w.conn != nil {
+				w.conn.Close()
+				w.conn = nil
+			}
+			return
+		}
+	}
+}
+
+// Stop stops the worker, and closes its connection
+func (w *GELFWorker) Stop() {
+	w.QuitChannel <- true
+}
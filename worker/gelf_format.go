@@ -0,0 +1,221 @@
+package worker
+
+/*
+	gelf_format.go encodes and decodes GELF, the Graylog Extended Log Format
+
+	A GELF message is a JSON object with the fields version, host,
+	short_message, full_message, timestamp (in seconds since the epoch),
+	and level (a syslog severity); any other field is prefixed with an
+	underscore. Over UDP, messages may be compressed (gzip or zlib), and
+	messages larger than a datagram are split into chunks. Over TCP,
+	messages are uncompressed, and terminated by a null byte.
+*/
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gelfChunkMagic starts each chunk of a chunked message
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// gelfChunkHeaderSize is the size of the magic bytes, message ID, sequence
+// number and sequence count that start each chunk
+const gelfChunkHeaderSize = 12
+
+// gelfMaxChunks is the most chunks a message may be split into
+const gelfMaxChunks = 128
+
+// gelfChunkTimeout is how long the chunks of an incomplete message are kept
+const gelfChunkTimeout = 5 * time.Second
+
+// gelfStandardFields are the fields that are not prefixed with an underscore
+var gelfStandardFields = map[string]bool{
+	"version": true, "host": true, "short_message": true, "full_message": true,
+	"timestamp": true, "level": true,
+}
+
+// EncodeGELF converts an event into a GELF message. The short message is
+// taken from messageField (or the whole event, as JSON, if it is missing).
+func EncodeGELF(v map[string]interface{}, messageField string) map[string]interface{} {
+	m := map[string]interface{}{"version": "1.1"}
+	if message, found := v[messageField]; found {
+		m["short_message"] = fmt.Sprint(message)
+	} else {
+		bs, _ := json.Marshal(v)
+		m["short_message"] = string(bs)
+	}
+	m["timestamp"] = float64(EventTime(v).UnixNano()) / float64(time.Second)
+	if level, ok := SyslogSeverity(v["level"]); ok {
+		m["level"] = level
+	}
+	if host, found := v["host"]; found {
+		m["host"] = fmt.Sprint(host)
+	} else {
+		m["host"], _ = os.Hostname()
+	}
+	for key, value := range v {
+		if key == messageField || key == "host" || key == "level" {
+			continue
+		}
+		switch value.(type) {
+		case string, int64, int, float64:
+		case time.Time:
+			value = value.(time.Time).Format(time.RFC3339Nano)
+		default:
+			// GELF only allows strings and numbers
+			bs, _ := json.Marshal(value)
+			value = string(bs)
+		}
+		if key == "id" { // _id is reserved
+			key = "id_"
+		}
+		m["_"+key] = value
+	}
+	return m
+}
+
+// DecodeGELF converts a GELF message into an event: additional fields lose
+// their underscore, and the timestamp becomes a time
+func DecodeGELF(m map[string]interface{}) map[string]interface{} {
+	v := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		switch {
+		case key == "version":
+		case key == "timestamp":
+			if seconds, ok := value.(float64); ok {
+				v[key] = time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+			}
+		case gelfStandardFields[key]:
+			v[key] = value
+		case strings.HasPrefix(key, "_"):
+			v[newKeyName(strings.TrimPrefix(key, "_"), v)] = value
+		default:
+			v[key] = value
+		}
+	}
+	if level, ok := value64(v["level"]); ok {
+		v["level"] = level
+	}
+	return v
+}
+
+// value64 converts JSON numbers (float64) that are integers to int64
+func value64(value interface{}) (int64, bool) {
+	f, ok := value.(float64)
+	if !ok || f != float64(int64(f)) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// decompressGELF decompresses a (possibly) compressed GELF payload
+func decompressGELF(payload []byte) ([]byte, error) {
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case len(payload) >= 2 && payload[0] == 0x78:
+		r, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+	return payload, nil
+}
+
+// ChunkGELF splits a payload into datagrams of at most size bytes; small
+// payloads are sent as they are
+func ChunkGELF(payload []byte, size int) ([][]byte, error) {
+	if len(payload) <= size {
+		return [][]byte{payload}, nil
+	}
+	dataSize := size - gelfChunkHeaderSize
+	count := (len(payload) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("GELF message of %d bytes needs more than %d chunks", len(payload), gelfMaxChunks)
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunks := make([][]byte, count)
+	for i := range chunks {
+		end := (i + 1) * dataSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := append(append([]byte{}, gelfChunkMagic...), id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks[i] = append(chunk, payload[i*dataSize:end]...)
+	}
+	return chunks, nil
+}
+
+// gelfPartial is a chunked message that is being reassembled
+type gelfPartial struct {
+	chunks   [][]byte
+	received int
+	first    time.Time
+}
+
+// A GELFAssembler reassembles chunked GELF messages
+type GELFAssembler struct {
+	lock     sync.Mutex
+	partials map[string]*gelfPartial
+}
+
+// Add adds a datagram, and returns the (decompressed) message when it is
+// complete, or nil if more chunks are needed
+func (a *GELFAssembler) Add(datagram []byte) ([]byte, error) {
+	if !bytes.HasPrefix(datagram, gelfChunkMagic) {
+		return decompressGELF(datagram)
+	}
+	if len(datagram) < gelfChunkHeaderSize {
+		return nil, fmt.Errorf("GELF chunk of %d bytes is too short", len(datagram))
+	}
+	id := string(datagram[2:10])
+	sequence, count := int(datagram[10]), int(datagram[11])
+	if count == 0 || count > gelfMaxChunks || sequence >= count {
+		return nil, fmt.Errorf("Invalid GELF chunk %d of %d", sequence, count)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.partials == nil {
+		a.partials = make(map[string]*gelfPartial)
+	}
+	now := time.Now()
+	for key, partial := range a.partials {
+		if now.Sub(partial.first) > gelfChunkTimeout {
+			delete(a.partials, key)
+			Counters.Inc("gelf_incomplete")
+		}
+	}
+	partial, found := a.partials[id]
+	if !found {
+		partial = &gelfPartial{chunks: make([][]byte, count), first: now}
+		a.partials[id] = partial
+	}
+	if len(partial.chunks) != count {
+		return nil, fmt.Errorf("GELF chunk count changed from %d to %d", len(partial.chunks), count)
+	}
+	if partial.chunks[sequence] == nil {
+		partial.chunks[sequence] = append([]byte{}, datagram[gelfChunkHeaderSize:]...)
+		partial.received++
+	}
+	if partial.received < count {
+		return nil, nil
+	}
+	delete(a.partials, id)
+	return decompressGELF(bytes.Join(partial.chunks, nil))
+}
@@ -0,0 +1,139 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestGELFOutput(t *testing.T) {
+	viper.Reset()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	viper.Set("gelf.address", conn.LocalAddr().String())
+	viper.Set("gelf.chunk_size", 100)
+	viper.Set("gelf.compress", "none")
+	message := strings.Repeat("x", 500)
+	channel := make(chan map[string]interface{})
+	w := &worker.GELFWorker{}
+	w.SetWorkChannel(channel)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	w.Start()
+	defer w.Stop()
+	channel <- map[string]interface{}{"message": message, "level": "warn", "host": "web1", "status": int64(404), "id": "abc"}
+
+	var assembler worker.GELFAssembler
+	var payload []byte
+	buffer := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for chunks := 0; payload == nil; chunks++ {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("after %d chunks: %v", chunks, err)
+		}
+		if n > 100 {
+			t.Errorf("expected datagrams of at most 100 bytes, got %d", n)
+		}
+		if payload, err = assembler.Add(buffer[:n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"version": "1.1", "short_message": message, "host": "web1", "level": float64(4), "_status": float64(404), "_id_": "abc"}
+	for key, value := range expected {
+		if m[key] != value {
+			t.Errorf("%s: expected %v, actual %v", key, value, m[key])
+		}
+	}
+	v := worker.DecodeGELF(m)
+	if v["status"] != float64(404) || v["id_"] != "abc" || v["level"] != int64(4) {
+		t.Errorf("DecodeGELF(%v): unexpected %v", m, v)
+	}
+}
+
+// freeAddress returns a local address that is not in use
+func freeAddress(t *testing.T, network string) string {
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestGELFInput(t *testing.T) {
+	for _, protocol := range []string{"udp", "tcp"} {
+		viper.Reset()
+		address := freeAddress(t, protocol)
+		viper.Set("input.type", "gelf")
+		viper.Set("input.address", address)
+		viper.Set("input.protocol", protocol)
+		w, channel := startParser(t)
+		var conn net.Conn
+		var err error
+		for i := 0; i < 100; i++ {
+			if conn, err = net.Dial(protocol, address); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, _ := json.Marshal(map[string]interface{}{"version": "1.1", "host": "web1", "short_message": strings.Repeat(" ", 200) + "1", "_app": "shop"})
+		send := func() {
+			if protocol == "tcp" {
+				conn.Write(append(message, 0))
+				return
+			}
+			chunks, err := worker.ChunkGELF(message, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, chunk := range chunks {
+				conn.Write(chunk)
+			}
+		}
+		send()
+		// datagrams sent before the input is listening are lost
+		var v map[string]interface{}
+		for i := 0; v == nil && i < 50; i++ {
+			select {
+			case v = <-channel:
+			case <-time.After(100 * time.Millisecond):
+				if protocol == "udp" {
+					send()
+				}
+			}
+		}
+		if v == nil {
+			t.Fatalf("%s: expected an event", protocol)
+		}
+		if v["app"] != "shop" || v["host"] != "web1" || v["n"] != int64(1) {
+			t.Errorf("%s: unexpected event %v", protocol, v)
+		}
+		conn.Close()
+		w.Stop()
+	}
+}
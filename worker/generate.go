@@ -0,0 +1,101 @@
+package worker
+
+/*
+	generate.go generates realistic synthetic log lines
+
+	GenerateLine makes a line of one of the presets, for load-testing the
+	pipeline and the outputs without production data:
+
+		nginx: the combined log format of nginx and Apache, e.g.
+		10.0.3.7 - - [01/Mar/2024:12:00:00 +0000] "GET /index.html HTTP/1.1" 200 5120 "-" "curl/7.68.0" 0.012
+
+		json: JSON application logs, e.g.
+		{"time":"2024-03-01T12:00:00Z","level":"info","service":"api","msg":"request handled","method":"GET","path":"/index.html","status":200,"duration_ms":12,"user_id":"u1042"}
+
+	Values are drawn with skewed frequencies (most requests succeed, a few
+	clients and paths are much busier than the rest), so that the top
+	values and error rates look like real traffic.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// generatePresets are the names of the presets GenerateLine knows
+var generatePresets = map[string]func(r *rand.Rand, t time.Time) string{
+	"nginx": generateNginxLine,
+	"json":  generateJSONLine,
+}
+
+// GeneratePresets returns the names of the presets, sorted
+func GeneratePresets() []string {
+	names := make([]string, 0, len(generatePresets))
+	for name := range generatePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var generateLevels = []string{"debug", "info", "info", "info", "info", "warn", "error"}
+var generateServices = []string{"api", "api", "web", "auth", "billing"}
+var generateMessages = []string{"request handled", "request handled", "cache miss", "slow query", "upstream timeout"}
+
+// skewed returns an index of n items, the first items being much more
+// likely than the last
+func skewed(r *rand.Rand, n int) int {
+	return int(float64(n) * r.Float64() * r.Float64() * r.Float64())
+}
+
+// generateLatency returns a request duration, mostly short, sometimes long
+func generateLatency(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64()*20*float64(time.Millisecond)) + time.Millisecond
+}
+
+func generateNginxLine(r *rand.Rand, t time.Time) string {
+	client := skewed(r, 1<<16)
+	return fmt.Sprintf(`10.%d.%d.%d - - [%s] "%s %s HTTP/1.1" %d %d "-" "%s" %.3f`,
+		client>>16, (client>>8)&255, client&255,
+		t.Format("02/Jan/2006:15:04:05 -0700"),
+		benchMethods[skewed(r, len(benchMethods))], benchPaths[skewed(r, len(benchPaths))],
+		benchStatuses[skewed(r, len(benchStatuses))], r.Intn(100000),
+		benchAgents[skewed(r, len(benchAgents))], generateLatency(r).Seconds())
+}
+
+func generateJSONLine(r *rand.Rand, t time.Time) string {
+	// fields in a fixed order, as loggers write them
+	bs, _ := json.Marshal(struct {
+		Time       string `json:"time"`
+		Level      string `json:"level"`
+		Service    string `json:"service"`
+		Msg        string `json:"msg"`
+		Method     string `json:"method"`
+		Path       string `json:"path"`
+		Status     int    `json:"status"`
+		DurationMS int64  `json:"duration_ms"`
+		UserID     string `json:"user_id"`
+	}{
+		Time:       t.UTC().Format(time.RFC3339Nano),
+		Level:      generateLevels[r.Intn(len(generateLevels))],
+		Service:    generateServices[skewed(r, len(generateServices))],
+		Msg:        generateMessages[skewed(r, len(generateMessages))],
+		Method:     benchMethods[skewed(r, len(benchMethods))],
+		Path:       benchPaths[skewed(r, len(benchPaths))],
+		Status:     benchStatuses[skewed(r, len(benchStatuses))],
+		DurationMS: int64(generateLatency(r) / time.Millisecond),
+		UserID:     fmt.Sprintf("u%d", skewed(r, 10000)),
+	})
+	return string(bs)
+}
+
+// GenerateLine returns a line of the preset, dated t
+func GenerateLine(preset string, r *rand.Rand, t time.Time) (string, error) {
+	generate, ok := generatePresets[preset]
+	if !ok {
+		return "", fmt.Errorf("unknown preset %s; expected one of %v", preset, GeneratePresets())
+	}
+	return generate(r, t), nil
+}
@@ -0,0 +1,43 @@
+package worker_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var generateTestCases = []struct {
+	preset  string
+	pattern string
+}{
+	{"nginx", `^(?P<ip>\S+) \S+ \S+ \[(?P<created>[^\]]+)\] "(?P<method>[A-Z]+) (?P<uri>\S+) [^"]*" (?P<status>\d+) (?P<bytes>\d+) "[^"]*" "[^"]*" (?P<latency>[\d.]+)$`},
+	{"json", `^\{"time":"(?P<created>[^"]+)".*"status":(?P<status>\d+),`},
+}
+
+func TestGenerateLine(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, tt := range generateTestCases {
+		viper.Reset()
+		viper.Set("parse.pattern", tt.pattern)
+		w := &worker.LogParser{}
+		w.Init()
+		r := rand.New(rand.NewSource(1))
+		for j := 0; j < 100; j++ {
+			line, err := worker.GenerateLine(tt.preset, r, created)
+			if err != nil {
+				t.Fatalf("In test %d, GenerateLine(%v): unexpected error %v", i, tt.preset, err)
+			}
+			v, err := w.ParseEvents(line)
+			if err != nil || v["status"] == nil || !worker.EventTime(v).Equal(created) {
+				t.Errorf("In test %d, GenerateLine(%v): expected a line with a status, dated %v, actual %v (%v)", i, tt.preset, created, line, v)
+				break
+			}
+		}
+	}
+	if _, err := worker.GenerateLine("syslog", rand.New(rand.NewSource(1)), created); err == nil {
+		t.Errorf("GenerateLine(syslog): expected an error")
+	}
+}
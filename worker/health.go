@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// Health describes the health of a worker's output
+type Health struct {
+	Healthy       bool      `json:"healthy"`
+	LastSuccess   time.Time `json:"last_success,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+	Failures      int64     `json:"failures"`
+	LastLatency   float64   `json:"last_latency_seconds"`
+}
+
+// healthTracker records the outcome of output operations; it is embedded in
+// workers to implement HealthReporter
+type healthTracker struct {
+	healthLock sync.Mutex
+	health     Health
+}
+
+// succeeded records a successful output operation, which took latency
+func (h *healthTracker) succeeded(latency time.Duration) {
+	h.healthLock.Lock()
+	h.health.Healthy = true
+	h.health.LastSuccess = time.Now()
+	h.health.LastLatency = latency.Seconds()
+	h.healthLock.Unlock()
+}
+
+// failed records a failed output operation, and reports the error
+func (h *healthTracker) failed(err error) {
+	reportError(err)
+	h.healthLock.Lock()
+	h.health.Healthy = false
+	h.health.LastError = err.Error()
+	h.health.LastErrorTime = time.Now()
+	h.health.Failures++
+	h.healthLock.Unlock()
+}
+
+// Health returns the current health. A worker that has not output anything
+// yet, nor failed, is considered healthy.
+func (h *healthTracker) Health() Health {
+	h.healthLock.Lock()
+	defer h.healthLock.Unlock()
+	health := h.health
+	if health.LastSuccess.IsZero() && health.Failures == 0 {
+		health.Healthy = true
+	}
+	return health
+}
@@ -0,0 +1,99 @@
+package worker
+
+/*
+	heartbeat.go emits heartbeat events while the input is idle
+
+	With heartbeat.interval set (e.g. "60s"), a synthetic event is emitted
+	whenever no line has been read for that long, and again every interval
+	while the input stays idle, so that downstream alerting can tell "no
+	traffic" from "translog is not running". A heartbeat looks like
+
+		{"heartbeat": true, "source": "/var/log/nginx/access.log",
+		 "offset": 12345, "lag": 0, "idle_seconds": 60, "lines_read": 100,
+		 "created": "2024-03-01T12:00:00Z"}
+
+	offset (the read position) and lag (the bytes of the file not read yet)
+	are only included when tailing a file.
+*/
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configHeartbeatInterval = "heartbeat.interval"
+
+// ConfiguredHeartbeatInterval returns how long the input must be idle before
+// a heartbeat is emitted; 0 (the default) disables heartbeats
+func ConfiguredHeartbeatInterval() time.Duration {
+	if viper.IsSet(configHeartbeatInterval) {
+		return viper.GetDuration(configHeartbeatInterval)
+	}
+	return 0
+}
+
+// inputSource describes the configured input
+func inputSource() string {
+	switch ConfiguredInputType() {
+	case "exec":
+		return strings.Join(ConfiguredInputCommand(), " ")
+	case "http":
+		return viper.GetString(configInputURL)
+	case "gelf":
+		return ConfiguredInputAddress()
+	}
+	return viper.GetString(configParseInputFile)
+}
+
+// touch records that the input is active
+func (w *LogParser) touch() {
+	atomic.StoreInt64(&w.lastRead, time.Now().UnixNano())
+}
+
+// Heartbeat returns a heartbeat event describing the input
+func (w *LogParser) Heartbeat() map[string]interface{} {
+	now := time.Now()
+	idle := now.Sub(time.Unix(0, atomic.LoadInt64(&w.lastRead)))
+	v := map[string]interface{}{
+		"heartbeat":    true,
+		"source":       inputSource(),
+		"idle_seconds": int64(idle / time.Second),
+		"lines_read":   w.LinesRead(),
+		"created":      now.UTC().Format(time.RFC3339),
+	}
+	if w.tailer != nil {
+		if offset, err := w.tailer.Tell(); err == nil {
+			v["offset"] = offset
+			if info, err := os.Stat(w.tailer.Filename); err == nil && info.Size() >= offset {
+				v["lag"] = info.Size() - offset
+			}
+		}
+	}
+	return v
+}
+
+// heartbeat emits heartbeats while the input is idle, until the parser is
+// stopped
+func (w *LogParser) heartbeat(interval time.Duration) {
+	logs.Info("Emitting heartbeats after %v without input", interval)
+	ticker := time.NewTicker(interval / 10)
+	defer ticker.Stop()
+	last := time.Now()
+	for range ticker.C {
+		if w.isStopped() {
+			return
+		}
+		now := time.Now()
+		lastRead := time.Unix(0, atomic.LoadInt64(&w.lastRead))
+		if now.Sub(lastRead) < interval || now.Sub(last) < interval {
+			continue
+		}
+		last = now
+		Counters.Inc("heartbeats")
+		w.emit(w.Heartbeat())
+	}
+}
@@ -0,0 +1,289 @@
+package worker
+
+/*
+	honeycomb.go sends events to Honeycomb
+
+	Parsed events are wide events already: each is sent, with its fields
+	as they are, to the Honeycomb dataset honeycomb.dataset, which may use
+	the same placeholders as es.index (e.g. "{service}"; missing fields
+	become "unknown"), with the API key honeycomb.api_key. Events are
+	timestamped with their timestamp (see timestampFields), or else the
+	time Honeycomb receives them.
+
+	Events that were sampled upstream carry their sample rate in
+	honeycomb.sample_rate_field (sample_rate by default): it is passed to
+	Honeycomb as the event's sample rate, and removed from its fields, so
+	that Honeycomb's counts are weighted back up.
+
+	Events are sent in batches of up to honeycomb.max events per dataset,
+	at least every honeycomb.flush_interval, to honeycomb.url (set it to
+	https://api.eu1.honeycomb.io for the EU). A batch that fails is sent
+	again, up to honeycomb.max_retries times with exponential backoff, and
+	then dead-lettered; events Honeycomb rejects one by one are
+	dead-lettered at once. Requests go through honeycomb.proxy, if set
+	(see proxy.go), and honeycomb.tls configures TLS (see tls.go).
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configHoneycombAPIKey = "honeycomb.api_key"
+const configHoneycombDataset = "honeycomb.dataset"
+const configHoneycombURL = "honeycomb.url"
+const configHoneycombSampleRateField = "honeycomb.sample_rate_field"
+const configHoneycombMax = "honeycomb.max"
+const configHoneycombFlushInterval = "honeycomb.flush_interval"
+const configHoneycombMaxRetries = "honeycomb.max_retries"
+const configHoneycombRetryBackoff = "honeycomb.retry_backoff"
+
+// honeycombTimeout is how long sending a batch may take
+const honeycombTimeout = 30 * time.Second
+
+// ConfiguredHoneycombDataset returns the dataset template
+func ConfiguredHoneycombDataset() string {
+	if viper.IsSet(configHoneycombDataset) {
+		return viper.GetString(configHoneycombDataset)
+	}
+	return "translog"
+}
+
+// ConfiguredHoneycombURL returns the URL of the Honeycomb API
+func ConfiguredHoneycombURL() string {
+	if viper.IsSet(configHoneycombURL) {
+		return strings.TrimRight(viper.GetString(configHoneycombURL), "/")
+	}
+	return "https://api.honeycomb.io"
+}
+
+// ConfiguredHoneycombSampleRateField returns the field sample rates are
+// read from
+func ConfiguredHoneycombSampleRateField() string {
+	if viper.IsSet(configHoneycombSampleRateField) {
+		return viper.GetString(configHoneycombSampleRateField)
+	}
+	return "sample_rate"
+}
+
+// ConfiguredHoneycombMax returns how many events are sent at a time
+func ConfiguredHoneycombMax() int {
+	if viper.IsSet(configHoneycombMax) {
+		if max := viper.GetInt(configHoneycombMax); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configHoneycombMax, Value: viper.Get(configHoneycombMax), Reason: "using 100"})
+	}
+	return 100
+}
+
+// ConfiguredHoneycombFlushInterval returns the longest events wait for
+// their batch to be sent
+func ConfiguredHoneycombFlushInterval() time.Duration {
+	if viper.IsSet(configHoneycombFlushInterval) {
+		if interval := viper.GetDuration(configHoneycombFlushInterval); interval > 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configHoneycombFlushInterval, Value: viper.Get(configHoneycombFlushInterval), Reason: "using 1s"})
+	}
+	return time.Second
+}
+
+// ConfiguredHoneycombMaxRetries returns how often a failed batch is sent
+// again
+func ConfiguredHoneycombMaxRetries() int {
+	if viper.IsSet(configHoneycombMaxRetries) {
+		return viper.GetInt(configHoneycombMaxRetries)
+	}
+	return 3
+}
+
+// ConfiguredHoneycombRetryBackoff returns the wait before the first retry
+func ConfiguredHoneycombRetryBackoff() time.Duration {
+	if viper.IsSet(configHoneycombRetryBackoff) {
+		return viper.GetDuration(configHoneycombRetryBackoff)
+	}
+	return time.Second
+}
+
+// HoneycombEvent converts an event into a Honeycomb batch event, passing
+// the value of sampleRateField, if it is a number, as the sample rate
+func HoneycombEvent(v map[string]interface{}, sampleRateField string) map[string]interface{} {
+	data := copyEvent(v)
+	e := map[string]interface{}{"data": data}
+	if timestamp, found := eventTimestamp(v); found {
+		e["time"] = timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if rate, found := data[sampleRateField]; found && sampleRateField != "" {
+		if n, ok := toFloat(rate); ok && n >= 1 {
+			e["samplerate"] = int64(n)
+			delete(data, sampleRateField)
+		}
+	}
+	return e
+}
+
+// honeycombResult is Honeycomb's response for an event of a batch
+type honeycombResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// HoneycombWorker sends events to Honeycomb
+type HoneycombWorker struct {
+	WorkChannel  chan map[string]interface{}
+	QuitChannel  chan bool
+	FlushChannel chan bool
+	client       *http.Client
+	batches      map[string][]map[string]interface{}
+	pending      int
+	startTime    time.Time
+	healthTracker
+}
+
+func (w *HoneycombWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *HoneycombWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	w.batches = make(map[string][]map[string]interface{})
+	transport, err := configuredTransport("honeycomb")
+	if err != nil {
+		logs.Warn("Invalid Honeycomb TLS or proxy configuration: %v", err)
+	}
+	w.client = &http.Client{Timeout: honeycombTimeout, Transport: transport}
+	return nil
+}
+
+// Start the work
+func (w *HoneycombWorker) Start() {
+	go Supervise("HoneycombWorker", w.Work)
+}
+
+// add adds an event to the pending batch of its dataset
+func (w *HoneycombWorker) add(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	dataset := fillTemplate(ConfiguredHoneycombDataset(), obj, "unknown", func(s string) string { return s })
+	w.batches[dataset] = append(w.batches[dataset], HoneycombEvent(obj, ConfiguredHoneycombSampleRateField()))
+	w.pending++
+	if w.pending >= ConfiguredHoneycombMax() {
+		w.flush()
+	}
+}
+
+// send sends a batch to a dataset, and returns the result for each event
+func (w *HoneycombWorker) send(dataset string, bs []byte) ([]honeycombResult, error) {
+	req, err := http.NewRequest("POST", ConfiguredHoneycombURL()+"/1/batch/"+url.PathEscape(dataset), bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Honeycomb-Team", viper.GetString(configHoneycombAPIKey))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var results []honeycombResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// flushDataset sends the batch of a dataset, retrying until it is
+// accepted, or dead-lettering it
+func (w *HoneycombWorker) flushDataset(dataset string, events []map[string]interface{}) {
+	bs, err := json.Marshal(events)
+	if err != nil {
+		logs.Info("Unable to marshal a batch of %d events: %v", len(events), err)
+		return
+	}
+	backoff := ConfiguredHoneycombRetryBackoff()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			Counters.Add("honeycomb_retries", int64(len(events)))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		start := time.Now()
+		results, err := w.send(dataset, bs)
+		if err == nil {
+			w.succeeded(time.Since(start))
+			for i, result := range results {
+				if i < len(events) && result.Status/100 != 2 {
+					Counters.Inc("honeycomb_rejected")
+					DeadLetter(DeadLetterRecord{Reason: "rejected", Event: events[i]["data"].(map[string]interface{}), Error: fmt.Sprintf("status %d: %s", result.Status, result.Error)})
+				}
+			}
+			Counters.Inc("honeycomb_batches")
+			Counters.Add("honeycomb_events", int64(len(events)))
+			return
+		}
+		w.failed(&OutputError{Destination: "Honeycomb dataset " + dataset, Err: err})
+		if attempt >= ConfiguredHoneycombMaxRetries() {
+			logs.Warn("Giving up on a batch of %d events after %d retries", len(events), attempt)
+			Counters.Add("honeycomb_rejected", int64(len(events)))
+			for _, e := range events {
+				DeadLetter(DeadLetterRecord{Reason: "retries_exhausted", Event: e["data"].(map[string]interface{}), Error: err.Error()})
+			}
+			return
+		}
+	}
+}
+
+// flush sends the pending batches
+func (w *HoneycombWorker) flush() {
+	batches := w.batches
+	w.batches, w.pending = make(map[string][]map[string]interface{}), 0
+	for dataset, events := range batches {
+		w.flushDataset(dataset, events)
+	}
+}
+
+// Work the queue
+func (w *HoneycombWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("HoneycombWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredHoneycombFlushInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.add(obj)
+
+		case <-ticker.C:
+			w.flush()
+
+		case <-w.FlushChannel:
+			w.flush()
+
+		case <-w.QuitChannel:
+			logs.Info("HoneycombWorker received quit")
+			w.flush()
+			return
+		}
+	}
+}
+
+// Flush asks the worker to send the events it has collected
+func (w *HoneycombWorker) Flush() {
+	w.FlushChannel <- true
+}
+
+// Stop stops the worker, after sending the events it has collected
+func (w *HoneycombWorker) Stop() {
+	w.QuitChannel <- true
+}
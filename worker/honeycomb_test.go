@@ -0,0 +1,83 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var honeycombEventTestCases = []struct {
+	event    map[string]interface{}
+	expected map[string]interface{}
+}{
+	{map[string]interface{}{"status": int64(200)}, map[string]interface{}{"data": map[string]interface{}{"status": int64(200)}}},
+	{map[string]interface{}{"status": int64(200), "sample_rate": int64(10)},
+		map[string]interface{}{"data": map[string]interface{}{"status": int64(200)}, "samplerate": int64(10)}},
+	{map[string]interface{}{"sample_rate": "often"}, map[string]interface{}{"data": map[string]interface{}{"sample_rate": "often"}}},
+	{map[string]interface{}{"created": "2024-03-01T12:00:00Z"},
+		map[string]interface{}{"data": map[string]interface{}{"created": "2024-03-01T12:00:00Z"}, "time": "2024-03-01T12:00:00Z"}},
+}
+
+func TestHoneycombEvent(t *testing.T) {
+	viper.Reset()
+	for i, tt := range honeycombEventTestCases {
+		actual := worker.HoneycombEvent(tt.event, "sample_rate")
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("In test %d, HoneycombEvent(%v): expected %v, actual %v", i, tt.event, tt.expected, actual)
+		}
+	}
+}
+
+func TestHoneycombWorker(t *testing.T) {
+	viper.Reset()
+	type request struct {
+		path   string
+		team   string
+		events []map[string]interface{}
+	}
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		bs, _ := io.ReadAll(req.Body)
+		r := request{path: req.URL.Path, team: req.Header.Get("X-Honeycomb-Team")}
+		json.Unmarshal(bs, &r.events)
+		requests <- r
+		results := make([]map[string]interface{}, len(r.events))
+		for i := range results {
+			results[i] = map[string]interface{}{"status": 202}
+		}
+		json.NewEncoder(rw).Encode(results)
+	}))
+	defer server.Close()
+	viper.Set("honeycomb.url", server.URL)
+	viper.Set("honeycomb.api_key", "secret")
+	viper.Set("honeycomb.dataset", "{service}")
+	viper.Set("honeycomb.flush_interval", "1h")
+
+	w := &worker.HoneycombWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"service": "api", "status": int64(200), "sample_rate": int64(4)}
+	w.WorkChannel <- map[string]interface{}{"service": "api", "status": int64(500)}
+	w.Flush()
+
+	select {
+	case r := <-requests:
+		if r.path != "/1/batch/api" || r.team != "secret" || len(r.events) != 2 {
+			t.Fatalf("HoneycombWorker: unexpected request %v", r)
+		}
+		if r.events[0]["samplerate"] != float64(4) || r.events[0]["data"].(map[string]interface{})["status"] != float64(200) {
+			t.Errorf("HoneycombWorker: unexpected event %v", r.events[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("HoneycombWorker: timed out waiting for the batch")
+	}
+}
@@ -0,0 +1,102 @@
+package worker
+
+/*
+	in_flight.go sends an output's requests concurrently
+
+	By default, an output sends one request at a time (an Elastic Search
+	bulk request, or a Kinesis put), and collects no more events while it
+	waits for the response. With
+
+		[output]
+		workers = 4         requests sent at the same time
+		max_in_flight = 8   requests being sent, or waiting for a worker
+
+	requests are sent by output.workers goroutines while the output keeps
+	collecting events, until output.max_in_flight requests are outstanding;
+	only then does it wait, which slows the pipeline down. max_in_flight is
+	workers by default, and never fewer. Requests sent concurrently may
+	complete out of order.
+*/
+import (
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const configOutputWorkers = "output.workers"
+const configOutputMaxInFlight = "output.max_in_flight"
+
+// ConfiguredOutputWorkers returns how many requests an output sends at the
+// same time
+func ConfiguredOutputWorkers() int {
+	if viper.IsSet(configOutputWorkers) {
+		if workers := viper.GetInt(configOutputWorkers); workers > 0 {
+			return workers
+		}
+		reportError(&ConfigError{Key: configOutputWorkers, Value: viper.Get(configOutputWorkers), Reason: "using 1"})
+	}
+	return 1
+}
+
+// ConfiguredOutputMaxInFlight returns how many requests may be outstanding
+// before the output waits
+func ConfiguredOutputMaxInFlight() int {
+	workers := ConfiguredOutputWorkers()
+	if viper.IsSet(configOutputMaxInFlight) {
+		if max := viper.GetInt(configOutputMaxInFlight); max >= workers {
+			return max
+		}
+		reportError(&ConfigError{Key: configOutputMaxInFlight, Value: viper.Get(configOutputMaxInFlight), Reason: "using output.workers"})
+	}
+	return workers
+}
+
+// requestPool sends requests on a fixed number of goroutines
+type requestPool struct {
+	name    string
+	queue   chan func()
+	slots   chan bool // one for each outstanding request
+	pending sync.WaitGroup
+}
+
+// ConfiguredRequestPool returns the pool an output's requests are sent on,
+// or nil if they are sent one at a time, by the output itself
+func ConfiguredRequestPool(name string) *requestPool {
+	workers, maxInFlight := ConfiguredOutputWorkers(), ConfiguredOutputMaxInFlight()
+	if maxInFlight <= 1 {
+		return nil
+	}
+	p := &requestPool{name: name, queue: make(chan func(), maxInFlight), slots: make(chan bool, maxInFlight)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// work sends queued requests
+func (p *requestPool) work() {
+	for send := range p.queue {
+		runRecovered(p.name+" request", send)
+		<-p.slots
+		p.pending.Done()
+	}
+}
+
+// Do sends a request, waiting while too many are outstanding; without a
+// pool, it is sent before Do returns
+func (p *requestPool) Do(send func()) {
+	if p == nil {
+		send()
+		return
+	}
+	p.slots <- true
+	p.pending.Add(1)
+	p.queue <- send
+}
+
+// Wait waits until the outstanding requests are sent
+func (p *requestPool) Wait() {
+	if p != nil {
+		p.pending.Wait()
+	}
+}
@@ -0,0 +1,53 @@
+package worker_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var outputWorkersTestCases = []struct {
+	workers     int
+	concurrency int32
+}{
+	{1, 1},
+	{2, 2},
+}
+
+func TestElasticSearchConcurrentRequests(t *testing.T) {
+	for i, tt := range outputWorkersTestCases {
+		var active, most, requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&active, 1)
+			for m := atomic.LoadInt32(&most); n > m && !atomic.CompareAndSwapInt32(&most, m, n); m = atomic.LoadInt32(&most) {
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.AddInt32(&active, -1)
+			atomic.AddInt32(&requests, 1)
+			fmt.Fprint(rw, `{"errors": false, "items": []}`)
+		}))
+		viper.Reset()
+		viper.Set("es.hosts", []string{server.URL})
+		viper.Set("es.max", 1)
+		viper.Set("output.workers", tt.workers)
+		w := &worker.ElasticSearchWorker{}
+		w.Init()
+		channel := make(chan map[string]interface{})
+		w.SetWorkChannel(channel)
+		w.Start()
+		for n := 1; n <= 4; n++ {
+			channel <- map[string]interface{}{"n": n}
+		}
+		w.Stop()
+		if actual, concurrency := atomic.LoadInt32(&requests), atomic.LoadInt32(&most); actual != 4 || concurrency != tt.concurrency {
+			t.Errorf("In test %d, with %d workers: expected 4 requests, at most %d at a time, actual %d, at most %d", i, tt.workers, tt.concurrency, actual, concurrency)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,145 @@
+package worker
+
+/*
+	input_exec.go runs a command and reads lines from its output
+
+	With input.type = "exec", translog runs input.command (a string, run
+	with /bin/sh -c, or a list of the program and its arguments) and parses
+	the lines it writes to stdout; lines written to stderr are logged. If
+	the command exits, it is started again after input.restart_delay (unless
+	input.restart is false); the delay doubles, up to a minute, while the
+	command keeps exiting within a few seconds of starting.
+*/
+import (
+	"bufio"
+	"os/exec"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configInputCommand = "input.command"
+const configInputRestart = "input.restart"
+const configInputRestartDelay = "input.restart_delay"
+
+// maxRestartDelay limits the delay between restarts of a failing command
+const maxRestartDelay = time.Minute
+
+// healthyRunTime is how long a command has to run for its restart delay to
+// be reset
+const healthyRunTime = 10 * time.Second
+
+// ConfiguredInputCommand returns the command to run, as the program and its
+// arguments
+func ConfiguredInputCommand() []string {
+	if command, ok := viper.Get(configInputCommand).(string); ok {
+		return []string{"/bin/sh", "-c", command}
+	}
+	return viper.GetStringSlice(configInputCommand)
+}
+
+// ConfiguredInputRestart returns true if the command is restarted when it
+// exits
+func ConfiguredInputRestart() bool {
+	if viper.IsSet(configInputRestart) {
+		return viper.GetBool(configInputRestart)
+	}
+	return true
+}
+
+// ConfiguredInputRestartDelay returns how long to wait before restarting the
+// command
+func ConfiguredInputRestartDelay() time.Duration {
+	if viper.IsSet(configInputRestartDelay) {
+		return viper.GetDuration(configInputRestartDelay)
+	}
+	return time.Second
+}
+
+// processCloser kills a command when the input is closed
+type processCloser struct {
+	cmd *exec.Cmd
+}
+
+func (p processCloser) Close() error {
+	return p.cmd.Process.Kill()
+}
+
+// runCommand runs the command once, reading lines from its output until it
+// exits
+func (w *LogParser) runCommand(command []string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	closer := processCloser{cmd}
+	if !w.addInput(closer) {
+		cmd.Wait()
+		return nil
+	}
+	defer w.removeInput(closer)
+	logged := make(chan bool)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logs.Warn("%s: %s", command[0], scanner.Text())
+		}
+		close(logged)
+	}()
+	w.readLines(stdout)
+	<-logged
+	return cmd.Wait()
+}
+
+// readCommand runs the configured command, restarting it when it exits
+func (w *LogParser) readCommand() {
+	command := ConfiguredInputCommand()
+	if len(command) == 0 {
+		logs.Warn("No %s configured", configInputCommand)
+		return
+	}
+	done := make(chan bool)
+	if !w.addPoller(done) {
+		return
+	}
+	defer w.polling.Done()
+	delay := ConfiguredInputRestartDelay()
+	for {
+		logs.Info("Running %v", command)
+		start := time.Now()
+		err := w.runCommand(command)
+		if w.isStopped() {
+			return
+		}
+		if err != nil {
+			logs.Warn("Command %v failed: %v", command, err)
+		} else {
+			logs.Info("Command %v exited", command)
+		}
+		Counters.Inc("command_exits")
+		if !ConfiguredInputRestart() {
+			return
+		}
+		if time.Since(start) > healthyRunTime {
+			delay = ConfiguredInputRestartDelay()
+		}
+		logs.Info("Restarting %v in %v", command, delay)
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
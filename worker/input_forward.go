@@ -0,0 +1,150 @@
+package worker
+
+/*
+	input_forward.go receives events forwarded by other translogs
+
+	With input.type = "forward", translog serves the forwarding protocol
+	(see forward.proto) on input.address (":9520" by default), for the
+	forward output of other translogs (see forward.go). Received events are
+	not parsed again: they go through the transforms, lookups, metrics and
+	alerts of this translog, and on to its output. Each batch is
+	acknowledged once its events are in the pipeline.
+
+	With input.forward.tls, the input serves TLS, configured in input.tls
+	(see tls.go), and with input.forward.token, it only accepts senders with
+	that token.
+*/
+import (
+	"crypto/subtle"
+	"net"
+	"sync/atomic"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // to accept compressed batches
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const configInputForwardTLS = "input.forward.tls"
+const configInputForwardToken = "input.forward.token"
+
+// ConfiguredInputForwardAddress returns the address the forward input
+// listens on
+func ConfiguredInputForwardAddress() string {
+	if viper.IsSet(configInputAddress) {
+		return viper.GetString(configInputAddress)
+	}
+	return ":9520"
+}
+
+// forwardServer receives the Send streams
+type forwardServer struct {
+	w     *LogParser
+	token string
+}
+
+// authorized checks the token of a stream
+func (s *forwardServer) authorized(stream grpc.ServerStream) bool {
+	if s.token == "" {
+		return true
+	}
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	for _, value := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+s.token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// send receives batches, and acknowledges them
+func (s *forwardServer) send(stream grpc.ServerStream) error {
+	if !s.authorized(stream) {
+		Counters.Inc("forward_unauthorized")
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	for {
+		batch := &structpb.Struct{}
+		if err := stream.RecvMsg(batch); err != nil {
+			return nil
+		}
+		events := batch.Fields["events"].GetListValue().GetValues()
+		for _, event := range events {
+			if fields := event.GetStructValue(); fields != nil {
+				s.w.readForwardedEvent(forwardedValue(fields.AsMap()).(map[string]interface{}))
+			}
+		}
+		Counters.Add("forward_received", int64(len(events)))
+		ack := &structpb.Struct{Fields: map[string]*structpb.Value{"seq": batch.Fields["seq"]}}
+		if err := stream.SendMsg(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardServiceDesc describes the Forward service, for the server
+var forwardServiceDesc = grpc.ServiceDesc{
+	ServiceName: "translog.Forward",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Send",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(*forwardServer).send(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "forward.proto",
+}
+
+// readForwardedEvent processes an event received from another translog
+func (w *LogParser) readForwardedEvent(v map[string]interface{}) {
+	w.throttle.Wait()
+	w.waitWhilePaused()
+	if w.maxMemory > 0 {
+		waitForMemory(w.maxMemory)
+	}
+	trace := w.tracer.start("")
+	trace.stage("received", v)
+	w.transformer.Transform(v)
+	trace.stage("transform", v)
+	w.processEvent(v, trace, w.emit)
+	w.touch()
+	atomic.AddInt64(&w.linesRead, 1)
+}
+
+// readForwarded serves the forwarding protocol until the parser is stopped
+func (w *LogParser) readForwarded() {
+	address := ConfiguredInputForwardAddress()
+	var options []grpc.ServerOption
+	if viper.GetBool(configInputForwardTLS) {
+		config, err := configuredServerTLS("input")
+		if err != nil {
+			logs.Warn("Invalid input TLS configuration: %v", err)
+			return
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(config)))
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logs.Warn("Unable to listen on %s: %v", address, err)
+		return
+	}
+	server := grpc.NewServer(options...)
+	server.RegisterService(&forwardServiceDesc, &forwardServer{w: w, token: viper.GetString(configInputForwardToken)})
+	if !w.addInput(closerFunc(func() error { server.Stop(); return nil })) {
+		listener.Close()
+		return
+	}
+	logs.Info("Receiving forwarded events on %s", listener.Addr())
+	if err := server.Serve(listener); err != nil && !w.isStopped() {
+		logs.Warn("Unable to receive forwarded events on %s: %v", address, err)
+	}
+}
@@ -0,0 +1,144 @@
+package worker
+
+/*
+	input_framing.go splits a byte stream into records
+
+	By default, the unix (stream), fifo, and exec inputs read one record per
+	line. input.framing reads other kinds of records:
+
+		lines       (the default) newline-delimited, with an optional \r
+		null        null-byte delimited, like GELF over TCP
+		delimiter   delimited by the string input.delimiter, e.g. "\n\n" for
+		            records that span lines, separated by blank lines
+		regexp      delimited by matches of the regular expression
+		            input.delimiter, e.g. "\r?\n\r?\n" or "\n-{3,}\n"
+		octet       prefixed by their length in ASCII digits and a space
+		            (octet counting, as in RFC 6587 syslog over TCP)
+		uint32      prefixed by their length as a 4-byte big-endian integer
+
+	Each record, which may contain newlines, is parsed as a line. A record
+	longer than 16 MiB, or a malformed length prefix, ends the input (or, for
+	a socket, the connection).
+*/
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+const configInputFraming = "input.framing"
+const configInputDelimiter = "input.delimiter"
+
+// maxRecordBytes is the largest record read from a stream
+const maxRecordBytes = 16 * 1024 * 1024
+
+// ConfiguredInputFraming returns how records are delimited in input streams
+func ConfiguredInputFraming() string {
+	if viper.IsSet(configInputFraming) {
+		switch framing := viper.GetString(configInputFraming); framing {
+		case "lines", "null", "delimiter", "regexp", "octet", "uint32":
+			return framing
+		}
+		reportError(&ConfigError{Key: configInputFraming, Value: viper.Get(configInputFraming), Reason: "using lines"})
+	}
+	return "lines"
+}
+
+// configuredSplit returns the function that splits input streams into
+// records
+func configuredSplit() (bufio.SplitFunc, error) {
+	switch framing := ConfiguredInputFraming(); framing {
+	case "null":
+		return scanNullTerminated, nil
+	case "delimiter", "regexp":
+		delimiter := viper.GetString(configInputDelimiter)
+		if delimiter == "" {
+			return nil, fmt.Errorf("No %s configured for %s framing", configInputDelimiter, framing)
+		}
+		if framing == "delimiter" {
+			delimiter = regexp.QuoteMeta(delimiter)
+		}
+		re, err := regexp.Compile(delimiter)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %v", configInputDelimiter, err)
+		}
+		if re.MatchString("") {
+			return nil, fmt.Errorf("Invalid %s: %q matches an empty string", configInputDelimiter, delimiter)
+		}
+		return scanDelimited(re), nil
+	case "octet":
+		return scanOctetCounted, nil
+	case "uint32":
+		return scanUint32Prefixed, nil
+	}
+	return bufio.ScanLines, nil
+}
+
+// scanDelimited splits records delimited by matches of re; a match that
+// reaches the end of the data read so far may go on, so it waits for more
+func scanDelimited(re *regexp.Regexp) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if loc := re.FindIndex(data); loc != nil && (loc[1] < len(data) || atEOF) {
+			return loc[1], data[:loc[0]], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// scanOctetCounted splits records prefixed by their length and a space
+func scanOctetCounted(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	space := bytes.IndexByte(data, ' ')
+	if space < 0 {
+		if len(data) > len(strconv.Itoa(maxRecordBytes)) || atEOF {
+			return 0, nil, fmt.Errorf("Invalid record length %q", data)
+		}
+		return 0, nil, nil
+	}
+	n, err := strconv.Atoi(string(data[:space]))
+	if err != nil || n < 0 || n > maxRecordBytes {
+		return 0, nil, fmt.Errorf("Invalid record length %q", data[:space])
+	}
+	return scanPrefixed(data, atEOF, space+1, n)
+}
+
+// scanUint32Prefixed splits records prefixed by their length, as a 4-byte
+// big-endian integer
+func scanUint32Prefixed(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if len(data) < 4 {
+		if atEOF {
+			return 0, nil, fmt.Errorf("Truncated record length")
+		}
+		return 0, nil, nil
+	}
+	n := binary.BigEndian.Uint32(data)
+	if n > maxRecordBytes {
+		return 0, nil, fmt.Errorf("Invalid record length %d", n)
+	}
+	return scanPrefixed(data, atEOF, 4, int(n))
+}
+
+// scanPrefixed returns the record of n bytes after a prefix, once it has
+// been read
+func scanPrefixed(data []byte, atEOF bool, prefix int, n int) (advance int, token []byte, err error) {
+	if len(data) < prefix+n {
+		if atEOF {
+			return 0, nil, fmt.Errorf("Truncated record of %d bytes", n)
+		}
+		return 0, nil, nil
+	}
+	return prefix + n, data[prefix : prefix+n], nil
+}
@@ -0,0 +1,34 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+var framingTestCases = []struct {
+	framing   string
+	delimiter string
+	output    string // printf format
+}{
+	{"lines", "", `1\r\n2\n3`},
+	{"null", "", `1\0002\0003\000`},
+	{"delimiter", ";;", `1;;2;;3`},
+	{"regexp", "\n-+\n", `1\n---\n2\n-\n3\n`},
+	{"octet", "", `1 11 21 3`},
+	{"uint32", "", `\000\000\000\0011\000\000\000\0012\000\000\000\0013`},
+}
+
+func TestInputFraming(t *testing.T) {
+	for _, tt := range framingTestCases {
+		viper.Reset()
+		viper.Set("input.type", "exec")
+		viper.Set("input.command", []string{"printf", tt.output})
+		viper.Set("input.restart", false)
+		viper.Set("input.framing", tt.framing)
+		viper.Set("input.delimiter", tt.delimiter)
+		w, channel := startParser(t)
+		expectEvents(t, channel, 3)
+		w.Stop()
+	}
+}
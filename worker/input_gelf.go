@@ -0,0 +1,193 @@
+package worker
+
+/*
+	input_gelf.go receives GELF messages, e.g. from Graylog senders or
+	Docker's gelf logging driver
+
+	With input.type = "gelf", translog listens on input.address for GELF
+	messages over input.protocol: "udp" (the default; messages may be
+	compressed and chunked), "tcp" (null-byte delimited messages) or "tls"
+	(the same over TLS, configured in input.tls; see tls.go). Each
+	message becomes an event, its additional fields losing their leading
+	underscore. If parse.pattern or input.codec is set, the short_message
+	is also decoded, and the fields it yields are added to the event.
+*/
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configInputAddress = "input.address"
+const configInputProtocol = "input.protocol"
+
+// ConfiguredInputAddress returns the address to listen on
+func ConfiguredInputAddress() string {
+	if viper.IsSet(configInputAddress) {
+		return viper.GetString(configInputAddress)
+	}
+	return ":12201"
+}
+
+// ConfiguredInputProtocol returns the protocol to listen with, udp, tcp or
+// tls
+func ConfiguredInputProtocol() string {
+	if viper.IsSet(configInputProtocol) {
+		return viper.GetString(configInputProtocol)
+	}
+	return "udp"
+}
+
+// ProcessGELF converts a GELF message into an event, putting it on the
+// shared channel
+func (w *LogParser) ProcessGELF(payload []byte) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		logs.Warn("Invalid GELF message %q: %v", payload, err)
+		Counters.Inc("lines_unmatched")
+		return
+	}
+	v := DecodeGELF(m)
+	message, _ := v["short_message"].(string)
+	message = strings.TrimSpace(message)
+	if (viper.GetString(configParsePattern) != "" || ConfiguredInputCodec() != "regex") && message != "" {
+		parsed, err := w.ParseEvents(message)
+		w.observeParse(message, err)
+		if err != nil {
+			Counters.Inc("lines_unmatched")
+			reportError(err)
+			return
+		}
+		for key, value := range parsed {
+			if _, found := v[key]; !found {
+				v[key] = value
+			}
+		}
+	} else {
+		w.transformer.Transform(v)
+	}
+	Counters.Inc("lines_parsed")
+	w.emit(v)
+}
+
+// readGELFMessage processes a GELF message read from the input
+func (w *LogParser) readGELFMessage(payload []byte) {
+	w.throttle.Wait()
+	w.waitWhilePaused()
+	if w.maxMemory > 0 {
+		waitForMemory(w.maxMemory)
+	}
+	w.ProcessGELF(payload)
+	w.touch()
+	atomic.AddInt64(&w.linesRead, 1)
+}
+
+// readGELF listens for GELF messages
+func (w *LogParser) readGELF() {
+	address := ConfiguredInputAddress()
+	switch protocol := ConfiguredInputProtocol(); protocol {
+	case "udp":
+		w.readGELFDatagrams(address)
+	case "tcp", "tls":
+		w.readGELFStreams(address, protocol == "tls")
+	default:
+		reportError(&ConfigError{Key: configInputProtocol, Value: protocol, Reason: "expected udp, tcp, or tls"})
+	}
+}
+
+// readGELFDatagrams reads (possibly chunked) GELF messages over UDP
+func (w *LogParser) readGELFDatagrams(address string) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		logs.Warn("Unable to listen on %s: %v", address, err)
+		return
+	}
+	if !w.addInput(conn) {
+		return
+	}
+	logs.Info("Reading GELF messages over UDP on %s", conn.LocalAddr())
+	var assembler GELFAssembler
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if !w.isStopped() {
+				logs.Warn("Unable to read from %s: %v", address, err)
+			}
+			return
+		}
+		payload, err := assembler.Add(buffer[:n])
+		if err != nil {
+			logs.Warn("Invalid GELF datagram: %v", err)
+			continue
+		}
+		if payload != nil {
+			w.readGELFMessage(payload)
+		}
+	}
+}
+
+// readGELFStreams reads null-byte delimited GELF messages over TCP, or TLS
+func (w *LogParser) readGELFStreams(address string, useTLS bool) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logs.Warn("Unable to listen on %s: %v", address, err)
+		return
+	}
+	if useTLS {
+		config, err := configuredServerTLS("input")
+		if err != nil {
+			logs.Warn("Invalid input TLS configuration: %v", err)
+			listener.Close()
+			return
+		}
+		listener = tls.NewListener(listener, config)
+	}
+	if !w.addInput(listener) {
+		return
+	}
+	logs.Info("Reading GELF messages over %s on %s", strings.ToUpper(ConfiguredInputProtocol()), listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !w.isStopped() {
+				logs.Warn("Unable to accept connection on %s: %v", address, err)
+			}
+			return
+		}
+		if !w.addInput(conn) {
+			return
+		}
+		go func() {
+			defer recoverPanic("GELF connection")
+			defer w.removeInput(conn)
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 64*1024), maxDatagramSize*16)
+			scanner.Split(scanNullTerminated)
+			for scanner.Scan() {
+				if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+					w.readGELFMessage(scanner.Bytes())
+				}
+			}
+		}()
+	}
+}
+
+// scanNullTerminated is a bufio.SplitFunc for null-byte delimited messages
+func scanNullTerminated(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
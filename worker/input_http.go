@@ -0,0 +1,232 @@
+package worker
+
+/*
+	input_http.go polls a URL for log lines
+
+	With input.type = "http", translog GETs input.url every input.poll_every
+	(with the headers in input.headers, e.g. an Authorization header), and
+	feeds what it finds into the pipeline:
+
+		input.format = "lines"   each line of the response body is a log line
+		input.format = "json"    the response is a JSON array (or contains one
+		                         at input.json_path, e.g. "data.logs"); each
+		                         element is a log line, as JSON, or the value of
+		                         its input.message_field
+
+	To avoid processing the same entries again, JSON elements whose
+	input.cursor_field is not beyond the highest cursor seen so far are
+	skipped (numbers are compared as numbers, anything else, such as ISO
+	timestamps, as strings); the cursor is also sent as the query parameter
+	input.cursor_param, if set. Without a cursor field, entries that were in
+	the previous response are skipped. Requests use the TLS configuration in
+	input.tls (see tls.go), e.g. for client certificates, and the proxy in
+	input.proxy (see proxy.go).
+*/
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configInputURL = "input.url"
+const configInputHeaders = "input.headers"
+const configInputPollEvery = "input.poll_every"
+const configInputFormat = "input.format"
+const configInputJSONPath = "input.json_path"
+const configInputMessageField = "input.message_field"
+const configInputCursorField = "input.cursor_field"
+const configInputCursorParam = "input.cursor_param"
+
+// pollTimeout limits each poll
+const pollTimeout = 30 * time.Second
+
+// ConfiguredInputPollEvery returns how often the URL is polled
+func ConfiguredInputPollEvery() time.Duration {
+	if viper.IsSet(configInputPollEvery) {
+		return viper.GetDuration(configInputPollEvery)
+	}
+	return 30 * time.Second
+}
+
+// httpPoller keeps the state of an HTTP polling input between polls
+type httpPoller struct {
+	client   *http.Client
+	cursor   interface{}
+	previous map[string]bool
+}
+
+// cursorAfter returns true if cursor a is beyond cursor b
+func cursorAfter(a interface{}, b interface{}) bool {
+	if b == nil {
+		return true
+	}
+	fa, aIsNumber := a.(float64)
+	fb, bIsNumber := b.(float64)
+	if aIsNumber && bIsNumber {
+		return fa > fb
+	}
+	return fmt.Sprint(a) > fmt.Sprint(b)
+}
+
+// request builds the poll request
+func (p *httpPoller) request() (*http.Request, error) {
+	u, err := url.Parse(viper.GetString(configInputURL))
+	if err != nil {
+		return nil, err
+	}
+	if param := viper.GetString(configInputCursorParam); param != "" && p.cursor != nil {
+		query := u.Query()
+		cursor := fmt.Sprint(p.cursor)
+		if f, ok := p.cursor.(float64); ok {
+			cursor = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		query.Set(param, cursor)
+		u.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range viper.GetStringMapString(configInputHeaders) {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// entries extracts the log lines from a response body
+func (p *httpPoller) entries(body []byte) ([]string, error) {
+	if viper.GetString(configInputFormat) != "json" {
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return p.unseen(lines), scanner.Err()
+	}
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, err
+	}
+	if path := viper.GetString(configInputJSONPath); path != "" {
+		m, ok := document.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Response is not a JSON object")
+		}
+		document, _ = lookupField(m, path)
+	}
+	elements, ok := document.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Response has no JSON array")
+	}
+	cursorField := viper.GetString(configInputCursorField)
+	messageField := viper.GetString(configInputMessageField)
+	lines := make([]string, 0, len(elements))
+	cursor := p.cursor
+	for _, element := range elements {
+		m, _ := element.(map[string]interface{})
+		if cursorField != "" {
+			value, found := lookupField(m, cursorField)
+			if !found || !cursorAfter(value, p.cursor) {
+				continue
+			}
+			if cursorAfter(value, cursor) {
+				cursor = value
+			}
+		}
+		if message, found := lookupField(m, messageField); found && messageField != "" {
+			lines = append(lines, fmt.Sprint(message))
+			continue
+		}
+		bs, _ := json.Marshal(element)
+		lines = append(lines, string(bs))
+	}
+	p.cursor = cursor
+	if cursorField == "" {
+		return p.unseen(lines), nil
+	}
+	return lines, nil
+}
+
+// unseen returns the lines that were not in the previous response
+func (p *httpPoller) unseen(lines []string) []string {
+	current := make(map[string]bool, len(lines))
+	fresh := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !p.previous[line] {
+			fresh = append(fresh, line)
+		}
+		current[line] = true
+	}
+	p.previous = current
+	return fresh
+}
+
+// poll fetches the URL once, and returns the new log lines
+func (p *httpPoller) poll() ([]string, error) {
+	req, err := p.request()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	return p.entries(body)
+}
+
+// pollURL polls the configured URL until the parser is stopped
+func (w *LogParser) pollURL() {
+	transport, err := configuredTransport("input")
+	if err != nil {
+		logs.Warn("Invalid input TLS or proxy configuration: %v", err)
+		return
+	}
+	poller := &httpPoller{client: &http.Client{Timeout: pollTimeout, Transport: transport}}
+	done := make(chan bool)
+	if !w.addPoller(done) {
+		return
+	}
+	defer w.polling.Done()
+	ticker := time.NewTicker(ConfiguredInputPollEvery())
+	defer ticker.Stop()
+	logs.Info("Polling %s every %v", viper.GetString(configInputURL), ConfiguredInputPollEvery())
+	for {
+		lines, err := poller.poll()
+		if err != nil {
+			logs.Warn("Unable to poll %s: %v", viper.GetString(configInputURL), err)
+			Counters.Inc("poll_errors")
+		}
+		for _, line := range lines {
+			w.readLine(line)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,336 @@
+package worker
+
+/*
+	input_redis.go reads entries from a Redis Stream
+
+	With input.type = "redis", translog reads the stream input.redis.stream
+	at input.redis.address as a member of the consumer group
+	input.redis.group (created if it doesn't exist, starting with the
+	entries added from then on, or with all of them if input.redis.start is
+	"0"). Each entry is a log line: the value of its input.message_field, or
+	else all of its fields, as a JSON object. Entries are acknowledged once
+	they are parsed, so an entry is read again, by another consumer of the
+	group if need be, if translog stops before parsing it:
+
+		- on start, the entries this consumer read but didn't acknowledge
+		  are read first
+		- every input.redis.claim_idle, the entries other consumers read
+		  but didn't acknowledge for that long are claimed (XAUTOCLAIM, from
+		  Redis 6.2) and read
+
+	If the connection fails, translog reconnects, waiting longer and longer
+	between attempts, up to a minute. With input.redis.tls, the connection
+	uses the TLS configuration in input.tls (see tls.go).
+*/
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configInputRedisAddress = "input.redis.address"
+const configInputRedisPassword = "input.redis.password"
+const configInputRedisDB = "input.redis.db"
+const configInputRedisTLS = "input.redis.tls"
+const configInputRedisStream = "input.redis.stream"
+const configInputRedisGroup = "input.redis.group"
+const configInputRedisConsumer = "input.redis.consumer"
+const configInputRedisStart = "input.redis.start"
+const configInputRedisCount = "input.redis.count"
+const configInputRedisBlock = "input.redis.block"
+const configInputRedisClaimIdle = "input.redis.claim_idle"
+
+// maxRedisRetryDelay limits the wait between connection attempts
+const maxRedisRetryDelay = time.Minute
+
+// ConfiguredInputRedisAddress returns the address of the Redis server
+func ConfiguredInputRedisAddress() string {
+	if viper.IsSet(configInputRedisAddress) {
+		return viper.GetString(configInputRedisAddress)
+	}
+	return "localhost:6379"
+}
+
+// ConfiguredInputRedisGroup returns the consumer group to read as
+func ConfiguredInputRedisGroup() string {
+	if viper.IsSet(configInputRedisGroup) {
+		return viper.GetString(configInputRedisGroup)
+	}
+	return "translog"
+}
+
+// ConfiguredInputRedisConsumer returns the name of the consumer in the group
+func ConfiguredInputRedisConsumer() string {
+	if viper.IsSet(configInputRedisConsumer) {
+		return viper.GetString(configInputRedisConsumer)
+	}
+	hostname, _ := os.Hostname()
+	return "translog-" + hostname
+}
+
+// ConfiguredInputRedisStart returns the id the consumer group starts from,
+// when it is created
+func ConfiguredInputRedisStart() string {
+	if viper.IsSet(configInputRedisStart) {
+		return viper.GetString(configInputRedisStart)
+	}
+	return "$"
+}
+
+// ConfiguredInputRedisCount returns how many entries are read at a time
+func ConfiguredInputRedisCount() int {
+	if viper.IsSet(configInputRedisCount) {
+		if count := viper.GetInt(configInputRedisCount); count > 0 {
+			return count
+		}
+		reportError(&ConfigError{Key: configInputRedisCount, Value: viper.Get(configInputRedisCount), Reason: "using 100"})
+	}
+	return 100
+}
+
+// ConfiguredInputRedisBlock returns how long a read waits for new entries
+func ConfiguredInputRedisBlock() time.Duration {
+	if viper.IsSet(configInputRedisBlock) {
+		if block := viper.GetDuration(configInputRedisBlock); block >= time.Millisecond {
+			return block
+		}
+		reportError(&ConfigError{Key: configInputRedisBlock, Value: viper.Get(configInputRedisBlock), Reason: "using 5s"})
+	}
+	return 5 * time.Second
+}
+
+// ConfiguredInputRedisClaimIdle returns how long entries other consumers
+// read stay unacknowledged before they are claimed; 0 disables claims
+func ConfiguredInputRedisClaimIdle() time.Duration {
+	if viper.IsSet(configInputRedisClaimIdle) {
+		return viper.GetDuration(configInputRedisClaimIdle)
+	}
+	return time.Minute
+}
+
+// redisEntry is an entry of a stream
+type redisEntry struct {
+	id     string
+	fields []interface{} // names and values, alternately
+}
+
+// redisEntries converts a list of entries from a reply
+func redisEntries(reply interface{}) []redisEntry {
+	items, _ := reply.([]interface{})
+	entries := make([]redisEntry, 0, len(items))
+	for _, item := range items {
+		pair, _ := item.([]interface{})
+		if len(pair) != 2 {
+			continue
+		}
+		id, _ := pair[0].(string)
+		fields, _ := pair[1].([]interface{}) // nil for deleted entries
+		entries = append(entries, redisEntry{id: id, fields: fields})
+	}
+	return entries
+}
+
+// line returns the log line of an entry
+func (e redisEntry) line() string {
+	messageField := viper.GetString(configInputMessageField)
+	m := make(map[string]interface{}, len(e.fields)/2)
+	for i := 0; i+1 < len(e.fields); i += 2 {
+		name := fmt.Sprint(e.fields[i])
+		if name == messageField {
+			return fmt.Sprint(e.fields[i+1])
+		}
+		m[name] = e.fields[i+1]
+	}
+	bs, _ := json.Marshal(m)
+	return string(bs)
+}
+
+// redisReader reads a stream as a member of a consumer group
+type redisReader struct {
+	conn     *redisConn
+	stream   string
+	group    string
+	consumer string
+	claimed  time.Time
+}
+
+// connect connects to the server, and creates the consumer group
+func (r *redisReader) connect() error {
+	tlsConfig, err := configuredTLS("input")
+	if err != nil {
+		return err
+	}
+	if !viper.GetBool(configInputRedisTLS) {
+		tlsConfig = nil
+	} else if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if r.conn, err = dialRedis(ConfiguredInputRedisAddress(), tlsConfig); err != nil {
+		return err
+	}
+	if password := refreshedString(configInputRedisPassword); password != "" {
+		if _, err = r.conn.do(0, "AUTH", password); err != nil {
+			return err
+		}
+	}
+	if db := viper.GetInt(configInputRedisDB); db != 0 {
+		if _, err = r.conn.do(0, "SELECT", strconv.Itoa(db)); err != nil {
+			return err
+		}
+	}
+	_, err = r.conn.do(0, "XGROUP", "CREATE", r.stream, r.group, ConfiguredInputRedisStart(), "MKSTREAM")
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		// the group exists already
+		err = nil
+	}
+	return err
+}
+
+// read reads entries: from id, or new ones if id is ">"
+func (r *redisReader) read(id string, block time.Duration) ([]redisEntry, error) {
+	args := []string{"XREADGROUP", "GROUP", r.group, r.consumer, "COUNT", strconv.Itoa(ConfiguredInputRedisCount())}
+	if block > 0 {
+		args = append(args, "BLOCK", strconv.FormatInt(int64(block/time.Millisecond), 10))
+	}
+	reply, err := r.conn.do(block, append(args, "STREAMS", r.stream, id)...)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	streams, _ := reply.([]interface{})
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	stream, _ := streams[0].([]interface{})
+	if len(stream) != 2 {
+		return nil, fmt.Errorf("Invalid XREADGROUP reply %v", reply)
+	}
+	return redisEntries(stream[1]), nil
+}
+
+// claim claims entries other consumers left unacknowledged for idle
+func (r *redisReader) claim(idle time.Duration, cursor string) ([]redisEntry, string, error) {
+	reply, err := r.conn.do(0, "XAUTOCLAIM", r.stream, r.group, r.consumer, strconv.FormatInt(int64(idle/time.Millisecond), 10),
+		cursor, "COUNT", strconv.Itoa(ConfiguredInputRedisCount()))
+	if err != nil {
+		return nil, "", err
+	}
+	parts, _ := reply.([]interface{})
+	if len(parts) < 2 {
+		return nil, "", fmt.Errorf("Invalid XAUTOCLAIM reply %v", reply)
+	}
+	next, _ := parts[0].(string)
+	return redisEntries(parts[1]), next, nil
+}
+
+// ack acknowledges entries
+func (r *redisReader) ack(entries []redisEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	args := []string{"XACK", r.stream, r.group}
+	for _, entry := range entries {
+		args = append(args, entry.id)
+	}
+	_, err := r.conn.do(0, args...)
+	return err
+}
+
+// readRedisEntries parses entries, and acknowledges them
+func (w *LogParser) readRedisEntries(r *redisReader, entries []redisEntry) error {
+	for _, entry := range entries {
+		if entry.fields != nil {
+			w.readLine(entry.line())
+		}
+	}
+	Counters.Add("redis_entries", int64(len(entries)))
+	return r.ack(entries)
+}
+
+// consumeRedisStream reads the stream until the connection fails, or the
+// parser is stopped
+func (w *LogParser) consumeRedisStream(r *redisReader) error {
+	// first, the entries read before a restart, and not acknowledged
+	for id := "0"; ; {
+		entries, err := r.read(id, 0)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if err := w.readRedisEntries(r, entries); err != nil {
+			return err
+		}
+		id = entries[len(entries)-1].id
+	}
+	for !w.isStopped() {
+		if idle := ConfiguredInputRedisClaimIdle(); idle > 0 && time.Since(r.claimed) >= idle {
+			for cursor := "0-0"; ; {
+				entries, next, err := r.claim(idle, cursor)
+				if err != nil {
+					return err
+				}
+				Counters.Add("redis_claimed", int64(len(entries)))
+				if err := w.readRedisEntries(r, entries); err != nil {
+					return err
+				}
+				if next == "0-0" || next == "" || next == cursor {
+					break
+				}
+				cursor = next
+			}
+			r.claimed = time.Now()
+		}
+		entries, err := r.read(">", ConfiguredInputRedisBlock())
+		if err != nil {
+			return err
+		}
+		if err := w.readRedisEntries(r, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRedisStream reads the configured stream, reconnecting when the
+// connection fails
+func (w *LogParser) readRedisStream() {
+	stream := viper.GetString(configInputRedisStream)
+	if stream == "" {
+		logs.Warn("No %s configured", configInputRedisStream)
+		return
+	}
+	delay := time.Second
+	for !w.isStopped() {
+		r := &redisReader{stream: stream, group: ConfiguredInputRedisGroup(), consumer: ConfiguredInputRedisConsumer()}
+		err := r.connect()
+		if err == nil {
+			if !w.addInput(r.conn) {
+				return
+			}
+			logs.Info("Reading Redis stream %s at %s as %s of group %s", stream, ConfiguredInputRedisAddress(), r.consumer, r.group)
+			delay = time.Second
+			err = w.consumeRedisStream(r)
+			w.removeInput(r.conn)
+		}
+		if r.conn != nil {
+			r.conn.Close()
+		}
+		if w.isStopped() {
+			return
+		}
+		logs.Warn("Unable to read Redis stream %s: %v; retrying in %v", stream, err, delay)
+		Counters.Inc("redis_errors")
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRedisRetryDelay {
+			delay = maxRedisRetryDelay
+		}
+	}
+}
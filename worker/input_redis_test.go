@@ -0,0 +1,124 @@
+package worker_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// readRedisCommand reads a command sent to the fake Redis server
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+// redisEntryReply encodes a stream entry with a message field
+func redisEntryReply(id string, message string) string {
+	return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*2\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n", len(id), id, len(message), message)
+}
+
+func TestRedisInput(t *testing.T) {
+	viper.Reset()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var lock sync.Mutex
+	var acked []string
+	delivered := false
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			args, err := readRedisCommand(reader)
+			if err != nil {
+				return
+			}
+			switch {
+			case args[0] == "AUTH" && args[1] == "rotated":
+				fmt.Fprint(conn, "+OK\r\n")
+			case args[0] == "AUTH":
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			case args[0] == "XGROUP":
+				fmt.Fprint(conn, "-BUSYGROUP Consumer Group name already exists\r\n")
+			case args[0] == "XREADGROUP" && args[len(args)-1] == "0":
+				// an entry read before a restart
+				fmt.Fprint(conn, "*1\r\n*2\r\n$6\r\nevents\r\n*1\r\n"+redisEntryReply("1-0", "1"))
+			case args[0] == "XREADGROUP" && args[len(args)-1] == ">" && !delivered:
+				delivered = true
+				fmt.Fprint(conn, "*1\r\n*2\r\n$6\r\nevents\r\n*1\r\n"+redisEntryReply("3-0", "3"))
+			case args[0] == "XREADGROUP":
+				time.Sleep(10 * time.Millisecond)
+				if args[len(args)-1] == ">" {
+					fmt.Fprint(conn, "*-1\r\n")
+				} else {
+					fmt.Fprint(conn, "*1\r\n*2\r\n$6\r\nevents\r\n*0\r\n")
+				}
+			case args[0] == "XAUTOCLAIM":
+				// an entry another consumer didn't acknowledge
+				fmt.Fprint(conn, "*3\r\n$3\r\n0-0\r\n*1\r\n"+redisEntryReply("2-0", "2")+"*0\r\n")
+			case args[0] == "XACK":
+				lock.Lock()
+				acked = append(acked, args[3:]...)
+				lock.Unlock()
+				fmt.Fprintf(conn, ":%d\r\n", len(args)-3)
+			default:
+				fmt.Fprintf(conn, "-ERR unknown command %s\r\n", args[0])
+			}
+		}
+	}()
+	viper.Set("input.type", "redis")
+	viper.Set("input.redis.address", listener.Addr().String())
+	viper.Set("input.redis.stream", "events")
+	viper.Set("input.redis.block", "10ms")
+	viper.Set("input.message_field", "message")
+	// a secret read again since the configuration was
+	viper.Set("input.redis.password", "expired")
+	worker.PublishRefreshed("input.redis.password", "rotated")
+	defer worker.ResetRefreshed()
+	w, channel := startParser(t)
+	defer w.Stop()
+	expectEvents(t, channel, 3)
+	for i := 0; i < 100; i++ {
+		lock.Lock()
+		n := len(acked)
+		lock.Unlock()
+		if n >= 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if strings.Join(acked, ",") != "1-0,2-0,3-0" {
+		t.Errorf("expected the entries to be acknowledged, actual %v", acked)
+	}
+}
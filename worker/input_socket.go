@@ -0,0 +1,222 @@
+package worker
+
+/*
+	input_socket.go reads lines from Unix domain sockets and named pipes
+
+	With input.type = "unix", translog listens on the socket named by
+	parse.input_file, and reads newline-delimited lines from every client
+	that connects (input.socket_type = "stream"), or treats each datagram
+	as a line, like syslog(3)'s /dev/log (input.socket_type = "datagram").
+
+	With input.type = "fifo", translog reads lines from the named pipe
+	parse.input_file (creating it if necessary), and opens it again
+	whenever the last writer closes it, so that writers can come and go.
+
+	Both read other kinds of records with input.framing (see
+	input_framing.go).
+*/
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configInputType = "input.type"
+const configInputSocketType = "input.socket_type"
+const configInputSocketMode = "input.socket_mode"
+
+// maxDatagramSize is the largest datagram read from a datagram socket
+const maxDatagramSize = 64 * 1024
+
+// ConfiguredInputType returns the kind of input: file (the default), unix,
+// fifo, exec, http, or gelf
+func ConfiguredInputType() string {
+	if viper.IsSet(configInputType) {
+		return viper.GetString(configInputType)
+	}
+	return "file"
+}
+
+// ConfiguredInputSocketMode returns the permissions of the input socket
+func ConfiguredInputSocketMode() os.FileMode {
+	if viper.IsSet(configInputSocketMode) {
+		return os.FileMode(viper.GetInt(configInputSocketMode))
+	}
+	return 0666
+}
+
+// addInput records an open input, so that Stop can close it; it returns
+// false (and closes the input) if the parser has already been stopped
+func (w *LogParser) addInput(c io.Closer) bool {
+	w.inputLock.Lock()
+	defer w.inputLock.Unlock()
+	if w.stopped {
+		c.Close()
+		return false
+	}
+	w.inputs = append(w.inputs, c)
+	return true
+}
+
+// addPoller records an input polled by the calling goroutine, so that Stop
+// can stop it, by closing done, and wait for it to return, which it signals
+// with w.polling.Done(); it returns false if the parser has already been
+// stopped
+func (w *LogParser) addPoller(done chan bool) bool {
+	w.inputLock.Lock()
+	defer w.inputLock.Unlock()
+	if w.stopped {
+		return false
+	}
+	w.inputs = append(w.inputs, closerFunc(func() error { close(done); return nil }))
+	w.polling.Add(1)
+	return true
+}
+
+// removeInput forgets an input that has been closed
+func (w *LogParser) removeInput(c io.Closer) {
+	w.inputLock.Lock()
+	defer w.inputLock.Unlock()
+	for i, input := range w.inputs {
+		if input == c {
+			w.inputs = append(w.inputs[:i], w.inputs[i+1:]...)
+			return
+		}
+	}
+}
+
+// isStopped returns true once Stop has been called
+func (w *LogParser) isStopped() bool {
+	w.inputLock.Lock()
+	defer w.inputLock.Unlock()
+	return w.stopped
+}
+
+// closeInput closes the open sockets and pipes
+func (w *LogParser) closeInput() {
+	w.inputLock.Lock()
+	defer w.inputLock.Unlock()
+	w.stopped = true
+	for _, input := range w.inputs {
+		input.Close()
+	}
+	w.inputs = nil
+}
+
+// readLines reads lines (or records, see input_framing.go) until the reader
+// is exhausted
+func (w *LogParser) readLines(r io.Reader) {
+	split, err := configuredSplit()
+	if err != nil {
+		logs.Warn("Unable to read input: %v", err)
+		return
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordBytes+16)
+	scanner.Split(split)
+	for scanner.Scan() {
+		w.readLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil && !w.isStopped() {
+		logs.Warn("Error reading input: %v", err)
+	}
+}
+
+// readUnixSocket listens on a Unix domain socket
+func (w *LogParser) readUnixSocket(path string) {
+	os.Remove(path) // remove a stale socket from a previous run
+	if viper.GetString(configInputSocketType) == "datagram" {
+		w.readUnixDatagrams(path)
+		return
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		logs.Warn("Unable to listen on socket %s: %v", path, err)
+		return
+	}
+	os.Chmod(path, ConfiguredInputSocketMode())
+	if !w.addInput(listener) {
+		return
+	}
+	logs.Info("Reading lines from socket %s", path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !w.isStopped() {
+				logs.Warn("Unable to accept connection on socket %s: %v", path, err)
+			}
+			return
+		}
+		if !w.addInput(conn) {
+			return
+		}
+		go func() {
+			defer recoverPanic("socket connection")
+			defer w.removeInput(conn)
+			defer conn.Close()
+			w.readLines(conn)
+		}()
+	}
+}
+
+// readUnixDatagrams reads datagrams from a Unix domain socket; each is a line
+// (or several, if it contains newlines)
+func (w *LogParser) readUnixDatagrams(path string) {
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		logs.Warn("Unable to listen on socket %s: %v", path, err)
+		return
+	}
+	os.Chmod(path, ConfiguredInputSocketMode())
+	if !w.addInput(conn) {
+		return
+	}
+	logs.Info("Reading datagrams from socket %s", path)
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if !w.isStopped() {
+				logs.Warn("Unable to read from socket %s: %v", path, err)
+			}
+			return
+		}
+		for _, line := range strings.Split(strings.TrimRight(string(buffer[:n]), "\n"), "\n") {
+			w.readLine(line)
+		}
+	}
+}
+
+// readFIFO reads lines from a named pipe, reopening it whenever the last
+// writer closes it
+func (w *LogParser) readFIFO(path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := syscall.Mkfifo(path, 0660); err != nil {
+			logs.Warn("Unable to create named pipe %s: %v", path, err)
+			return
+		}
+	}
+	logs.Info("Reading lines from named pipe %s", path)
+	for !w.isStopped() {
+		// opening blocks until there is a writer
+		fifo, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			logs.Warn("Unable to open named pipe %s: %v", path, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if !w.addInput(fifo) {
+			return
+		}
+		w.readLines(fifo)
+		w.removeInput(fifo)
+		fifo.Close()
+	}
+}
@@ -0,0 +1,135 @@
+package worker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// fakePsql prints two rows after cursor 0, and none after that, and records
+// its arguments and environment
+const fakePsql = `#!/bin/sh
+echo "$* $PGPASSWORD" > "$(dirname "$0")/args.tmp" && mv "$(dirname "$0")/args.tmp" "$(dirname "$0")/args"
+cat > "$(dirname "$0")/stdin.tmp" && mv "$(dirname "$0")/stdin.tmp" "$(dirname "$0")/stdin"
+case "$*" in
+*--set=cursor=0*) printf 'id,message,note\n1,1,\n2,2,"a, b"\n' ;;
+*) printf 'id,message,note\n' ;;
+esac
+`
+
+// fakeMysql prints a row, and records its arguments and environment
+const fakeMysql = `#!/bin/sh
+cat > "$(dirname "$0")/stdin.tmp" && mv "$(dirname "$0")/stdin.tmp" "$(dirname "$0")/stdin"
+echo "$* $MYSQL_PWD" > "$(dirname "$0")/args"
+printf 'id\tmessage\n7\t1\n'
+`
+
+// writeClient writes a fake database client, in a directory tests keep the
+// cursor file in too
+func writeClient(t *testing.T, script string) string {
+	client := filepath.Join(t.TempDir(), "client")
+	if err := ioutil.WriteFile(client, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// waitForCursor waits until the cursor file holds cursor
+func waitForCursor(t *testing.T, path string, cursor string) {
+	for i := 0; i < 500; i++ {
+		if c, err := worker.ReadCheckpoint(path); err == nil && c.Cursor == cursor {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected cursor %s to be saved in %s", cursor, path)
+}
+
+func TestSQLInput(t *testing.T) {
+	viper.Reset()
+	client := writeClient(t, fakePsql)
+	cursorFile := filepath.Join(filepath.Dir(client), "cursor")
+	viper.Set("input.type", "sql")
+	viper.Set("input.poll_every", "10ms")
+	viper.Set("input.message_field", "message")
+	viper.Set("input.sql.dsn", "postgres://audit:secret@db/app")
+	viper.Set("input.sql.client", client)
+	viper.Set("input.sql.query", "SELECT * FROM audit WHERE id > {cursor} ORDER BY id")
+	viper.Set("input.sql.cursor_column", "id")
+	viper.Set("input.sql.cursor_file", cursorFile)
+	w, channel := startParser(t)
+	defer w.Stop()
+	expectEvents(t, channel, 2)
+	waitForCursor(t, cursorFile, "2")
+	stdin, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(client), "stdin"))
+	if expected := "SELECT * FROM audit WHERE id > :'cursor' ORDER BY id;\n"; string(stdin) != expected {
+		t.Errorf("expected query %q, actual %q", expected, stdin)
+	}
+	args, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(client), "args"))
+	if !strings.HasSuffix(strings.TrimSpace(string(args)), "--dbname=postgres://audit@db/app secret") {
+		t.Errorf("expected the password in PGPASSWORD, not the arguments, actual %q", args)
+	}
+	select {
+	case v := <-channel:
+		t.Errorf("expected no more events, actual %v", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSQLInputMySQL(t *testing.T) {
+	viper.Reset()
+	client := writeClient(t, fakeMysql)
+	cursorFile := filepath.Join(filepath.Dir(client), "cursor")
+	if err := worker.WriteCheckpoint(cursorFile, worker.Checkpoint{Cursor: "it's"}); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("input.type", "sql")
+	viper.Set("input.poll_every", "1h")
+	viper.Set("input.message_field", "message")
+	viper.Set("input.sql.driver", "mysql")
+	viper.Set("input.sql.dsn", "mysql://audit:secret@db:3307/app")
+	viper.Set("input.sql.client", client)
+	viper.Set("input.sql.query", "SELECT id, line AS message FROM audit WHERE id > {cursor}")
+	viper.Set("input.sql.cursor_column", "id")
+	viper.Set("input.sql.cursor_file", cursorFile)
+	w, channel := startParser(t)
+	defer w.Stop()
+	expectEvents(t, channel, 1)
+	waitForCursor(t, cursorFile, "7")
+	dir := filepath.Dir(client)
+	stdin, _ := ioutil.ReadFile(filepath.Join(dir, "stdin"))
+	if expected := "SET @cursor = 'it\\'s';\nSELECT id, line AS message FROM audit WHERE id > @cursor;\n"; string(stdin) != expected {
+		t.Errorf("expected query %q, actual %q", expected, stdin)
+	}
+	args, _ := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if expected := "--batch --host=db --port=3307 --user=audit app secret"; strings.TrimSpace(string(args)) != expected {
+		t.Errorf("expected arguments %q, actual %q", expected, args)
+	}
+}
+
+var postgresConnectionTestCases = []struct {
+	dsn      string
+	expected string
+	env      []string
+}{
+	{"postgres://audit@db/app", "postgres://audit@db/app", nil},
+	{"postgres://audit:s%40cret@db:5433/app?sslmode=require", "postgres://audit@db:5433/app?sslmode=require", []string{"PGPASSWORD=s@cret"}},
+	{"postgresql://db/app?password=secret&user=audit", "postgresql://db/app?user=audit", []string{"PGPASSWORD=secret"}},
+	{"host=db dbname=app", "host=db dbname=app", nil},
+	{"host=db password=secret dbname=app", "host=db dbname=app", []string{"PGPASSWORD=secret"}},
+	{`host=db password='it\'s secret'`, "host=db", []string{"PGPASSWORD=it's secret"}},
+}
+
+func TestPostgresConnection(t *testing.T) {
+	for i, tt := range postgresConnectionTestCases {
+		actual, env := worker.PostgresConnection(tt.dsn)
+		if actual != tt.expected || strings.Join(env, " ") != strings.Join(tt.env, " ") {
+			t.Errorf("In test %d, PostgresConnection(%q): expected %q %v, actual %q %v", i, tt.dsn, tt.expected, tt.env, actual, env)
+		}
+	}
+}
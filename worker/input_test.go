@@ -0,0 +1,182 @@
+package worker_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// startParser starts a parser reading the configured input, and returns the
+// channel its events are sent on
+func startParser(t *testing.T) (*worker.LogParser, chan map[string]interface{}) {
+	viper.Set("parse.pattern", `^(?P<n>\d+)$`)
+	channel := make(chan map[string]interface{}, 10)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	go w.Start()
+	return w, channel
+}
+
+// waitForFile waits until the input socket or pipe exists
+func waitForFile(t *testing.T, path string) {
+	for i := 0; i < 500; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %s to be created", path)
+}
+
+// expectEvents checks that the events numbered 1 to n arrive (in any
+// order, as events are sent concurrently)
+func expectEvents(t *testing.T, channel chan map[string]interface{}, n int) {
+	seen := make(map[interface{}]bool)
+	for i := 1; i <= n; i++ {
+		select {
+		case v := <-channel:
+			seen[v["n"]] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d events, got %v", n, seen)
+		}
+	}
+	for i := 1; i <= n; i++ {
+		if !seen[int64(i)] {
+			t.Errorf("expected event %d, got %v", i, seen)
+		}
+	}
+}
+
+var socketInputTestCases = []struct {
+	inputType  string
+	socketType string
+	network    string
+}{
+	{"unix", "stream", "unix"},
+	{"unix", "datagram", "unixgram"},
+	{"fifo", "", ""},
+}
+
+func TestSocketInputs(t *testing.T) {
+	for i, tt := range socketInputTestCases {
+		viper.Reset()
+		path := filepath.Join(t.TempDir(), "input")
+		viper.Set("input.type", tt.inputType)
+		viper.Set("input.socket_type", tt.socketType)
+		viper.Set("parse.input_file", path)
+		w, channel := startParser(t)
+		waitForFile(t, path)
+		if tt.network == "" {
+			fifo, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatalf("In test %d, expected to open the pipe, got %v", i+1, err)
+			}
+			fmt.Fprint(fifo, "1\n2\n")
+			fifo.Close()
+			// a new writer, after the first one has closed the pipe
+			fifo, _ = os.OpenFile(path, os.O_WRONLY, 0)
+			fmt.Fprint(fifo, "3\n")
+			fifo.Close()
+		} else {
+			conn, err := net.Dial(tt.network, path)
+			if err != nil {
+				t.Fatalf("In test %d, expected to connect, got %v", i+1, err)
+			}
+			fmt.Fprint(conn, "1\n")
+			fmt.Fprint(conn, "2\n3\n")
+			conn.Close()
+		}
+		expectEvents(t, channel, 3)
+		w.Stop()
+	}
+}
+
+func TestExecInput(t *testing.T) {
+	viper.Reset()
+	viper.Set("input.type", "exec")
+	viper.Set("input.command", "printf '1\\n2\\n'; echo 3; echo oops >&2; exec sleep 10")
+	w, channel := startParser(t)
+	expectEvents(t, channel, 3)
+	// Stop kills the command, and returns once the input has
+	start := time.Now()
+	w.Stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Stop to kill the command, took %v", elapsed)
+	}
+}
+
+func TestHTTPInput(t *testing.T) {
+	viper.Reset()
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("expected the Authorization header, got %v", r.Header)
+		}
+		n := atomic.AddInt32(&polls, 1)
+		switch n {
+		case 1:
+			fmt.Fprint(rw, `{"data": {"logs": [{"id": 1, "msg": "1"}, {"id": 2, "msg": "2"}]}}`)
+		default:
+			if n == 2 && r.URL.Query().Get("since") != "2" {
+				t.Errorf("expected the cursor to be sent, got %v", r.URL)
+			}
+			fmt.Fprint(rw, `{"data": {"logs": [{"id": 2, "msg": "2"}, {"id": 3, "msg": "3"}]}}`)
+		}
+	}))
+	defer server.Close()
+	viper.Set("input.type", "http")
+	viper.Set("input.url", server.URL)
+	viper.Set("input.headers", map[string]string{"Authorization": "Bearer secret"})
+	viper.Set("input.poll_every", "10ms")
+	viper.Set("input.format", "json")
+	viper.Set("input.json_path", "data.logs")
+	viper.Set("input.message_field", "msg")
+	viper.Set("input.cursor_field", "id")
+	viper.Set("input.cursor_param", "since")
+	w, channel := startParser(t)
+	expectEvents(t, channel, 3)
+	select {
+	case v := <-channel:
+		t.Errorf("expected entries to be deduplicated, got %v", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Stop returns once the poller has
+	w.Stop()
+	n := atomic.LoadInt32(&polls)
+	time.Sleep(50 * time.Millisecond)
+	if actual := atomic.LoadInt32(&polls); actual != n {
+		t.Errorf("expected no polls after Stop, actual %d", actual-n)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	viper.Reset()
+	path := filepath.Join(t.TempDir(), "input")
+	viper.Set("input.type", "unix")
+	viper.Set("parse.input_file", path)
+	viper.Set("heartbeat.interval", "100ms")
+	w, channel := startParser(t)
+	defer w.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-channel:
+			if v["heartbeat"] != true || v["source"] != path || v["lines_read"] != int64(0) {
+				t.Errorf("unexpected heartbeat %v", v)
+			}
+			if _, found := v["offset"]; found {
+				t.Errorf("expected no offset for a socket, got %v", v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected heartbeat %d", i+1)
+		}
+	}
+}
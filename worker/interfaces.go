@@ -8,3 +8,29 @@ type Worker interface {
 	Stop()
 	SetWorkChannel(chan map[string]interface{})
 }
+
+// An EventWorker can also consume typed events (see event.go)
+type EventWorker interface {
+	SetEventChannel(chan *Event)
+}
+
+// A BatchWorker can also consume batches of events (see batch.go)
+type BatchWorker interface {
+	SetBatchChannel(chan []map[string]interface{})
+}
+
+// A Reopener can close and reopen its output files, e.g. after they have been
+// rotated by logrotate
+type Reopener interface {
+	Reopen()
+}
+
+// A Flusher can flush any buffered output on demand
+type Flusher interface {
+	Flush()
+}
+
+// A HealthReporter reports on the health of its output
+type HealthReporter interface {
+	Health() Health
+}
@@ -0,0 +1,384 @@
+package worker
+
+/*
+	kinesis.go puts events to an AWS Kinesis stream or Firehose delivery stream
+
+	Events are sent in batches of up to kinesis.max records, at least every
+	kinesis.flush_interval. For Kinesis streams, the partition key of each
+	record is the value of the kinesis.partition_key field of the event (or
+	a random key, if the event has no such field). With kinesis.aggregate,
+	events with the same partition key are combined, newline-delimited, into
+	records of up to kinesis.max_record_bytes, which cuts the number of
+	records (and the cost) for small events.
+
+	Records rejected because the stream is throttled are retried, up to
+	kinesis.max_retries times with exponential backoff; other rejected
+	records are dead-lettered.
+
+	Credentials are taken from the usual AWS environment variables, shared
+	credentials file, or instance role. Requests go through kinesis.proxy, if
+	set (see proxy.go).
+*/
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configKinesisStream = "kinesis.stream"
+const configKinesisDeliveryStream = "kinesis.delivery_stream"
+const configKinesisRegion = "kinesis.region"
+const configKinesisEndpoint = "kinesis.endpoint"
+const configKinesisPartitionKey = "kinesis.partition_key"
+const configKinesisAggregate = "kinesis.aggregate"
+const configKinesisMaxRecordBytes = "kinesis.max_record_bytes"
+const configKinesisMax = "kinesis.max"
+const configKinesisFlushInterval = "kinesis.flush_interval"
+const configKinesisMaxRetries = "kinesis.max_retries"
+const configKinesisRetryBackoff = "kinesis.retry_backoff"
+
+// kinesisMaxRecords is the most records PutRecords and PutRecordBatch accept
+const kinesisMaxRecords = 500
+
+// kinesisRecord is a record waiting to be put
+type kinesisRecord struct {
+	partitionKey string
+	data         []byte
+	events       int
+}
+
+// recordPutter puts a batch of records, and returns, for each record, its
+// error code ("" if it was accepted)
+type recordPutter interface {
+	put(records []kinesisRecord) (errorCodes []string, err error)
+}
+
+type kinesisPutter struct {
+	client *kinesis.Kinesis
+	stream string
+}
+
+func (p kinesisPutter) put(records []kinesisRecord) ([]string, error) {
+	entries := make([]*kinesis.PutRecordsRequestEntry, len(records))
+	for i, record := range records {
+		entries[i] = &kinesis.PutRecordsRequestEntry{Data: record.data, PartitionKey: aws.String(record.partitionKey)}
+	}
+	out, err := p.client.PutRecords(&kinesis.PutRecordsInput{Records: entries, StreamName: aws.String(p.stream)})
+	if err != nil {
+		return nil, err
+	}
+	errorCodes := make([]string, len(out.Records))
+	for i, result := range out.Records {
+		errorCodes[i] = aws.StringValue(result.ErrorCode)
+	}
+	return errorCodes, nil
+}
+
+type firehosePutter struct {
+	client         *firehose.Firehose
+	deliveryStream string
+}
+
+func (p firehosePutter) put(records []kinesisRecord) ([]string, error) {
+	entries := make([]*firehose.Record, len(records))
+	for i, record := range records {
+		entries[i] = &firehose.Record{Data: record.data}
+	}
+	out, err := p.client.PutRecordBatch(&firehose.PutRecordBatchInput{Records: entries, DeliveryStreamName: aws.String(p.deliveryStream)})
+	if err != nil {
+		return nil, err
+	}
+	errorCodes := make([]string, len(out.RequestResponses))
+	for i, result := range out.RequestResponses {
+		errorCodes[i] = aws.StringValue(result.ErrorCode)
+	}
+	return errorCodes, nil
+}
+
+// KinesisWorker puts events to Kinesis or Firehose
+type KinesisWorker struct {
+	WorkChannel  chan map[string]interface{}
+	BatchChannel chan []map[string]interface{}
+	QuitChannel  chan bool
+	FlushChannel chan bool
+	putter       recordPutter
+	records      []kinesisRecord
+	startTime    time.Time
+	requests     *requestPool
+	healthTracker
+}
+
+// ConfiguredKinesisMax returns how many records are put at a time
+func ConfiguredKinesisMax() int {
+	if viper.IsSet(configKinesisMax) {
+		if max := viper.GetInt(configKinesisMax); max > 0 && max < kinesisMaxRecords {
+			return max
+		}
+	}
+	return kinesisMaxRecords
+}
+
+// ConfiguredKinesisMaxRecordBytes returns the largest aggregated record
+func ConfiguredKinesisMaxRecordBytes() int {
+	if viper.IsSet(configKinesisMaxRecordBytes) {
+		return viper.GetInt(configKinesisMaxRecordBytes)
+	}
+	return 1000 * 1024 // Firehose's limit; Kinesis allows 1 MiB
+}
+
+// ConfiguredKinesisFlushInterval returns the longest time records are held
+func ConfiguredKinesisFlushInterval() time.Duration {
+	if viper.IsSet(configKinesisFlushInterval) {
+		return viper.GetDuration(configKinesisFlushInterval)
+	}
+	return time.Second
+}
+
+// ConfiguredKinesisMaxRetries returns how often throttled records are retried
+func ConfiguredKinesisMaxRetries() int {
+	if viper.IsSet(configKinesisMaxRetries) {
+		return viper.GetInt(configKinesisMaxRetries)
+	}
+	return 5
+}
+
+// ConfiguredKinesisRetryBackoff returns the wait before the first retry; it
+// doubles with each retry
+func ConfiguredKinesisRetryBackoff() time.Duration {
+	if viper.IsSet(configKinesisRetryBackoff) {
+		return viper.GetDuration(configKinesisRetryBackoff)
+	}
+	return 100 * time.Millisecond
+}
+
+// newRecordPutter creates the client for the configured stream
+func newRecordPutter() (recordPutter, error) {
+	config := aws.NewConfig()
+	if region := viper.GetString(configKinesisRegion); region != "" {
+		config = config.WithRegion(region)
+	}
+	if endpoint := viper.GetString(configKinesisEndpoint); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	transport, err := configuredProxyTransport("kinesis")
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		config = config.WithHTTPClient(&http.Client{Transport: transport})
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Config: *config, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	if stream := viper.GetString(configKinesisStream); stream != "" {
+		return kinesisPutter{client: kinesis.New(sess), stream: stream}, nil
+	}
+	if deliveryStream := viper.GetString(configKinesisDeliveryStream); deliveryStream != "" {
+		return firehosePutter{client: firehose.New(sess), deliveryStream: deliveryStream}, nil
+	}
+	return nil, fmt.Errorf("Either %s or %s must be set", configKinesisStream, configKinesisDeliveryStream)
+}
+
+// kinesisDestination returns the name of the configured stream
+func kinesisDestination() string {
+	if stream := viper.GetString(configKinesisStream); stream != "" {
+		return stream
+	}
+	return viper.GetString(configKinesisDeliveryStream)
+}
+
+// randomPartitionKey returns a random partition key, for events without one
+func randomPartitionKey() string {
+	bs := make([]byte, 16)
+	rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+// retryableKinesisError returns true for errors caused by throttling
+func retryableKinesisError(code string) bool {
+	switch code {
+	case "ProvisionedThroughputExceededException", "ThrottlingException",
+		"ServiceUnavailableException", "InternalFailure", "ServiceUnavailable":
+		return true
+	}
+	return false
+}
+
+func (w *KinesisWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+// SetBatchChannel sets the channel batches of events are received on
+func (w *KinesisWorker) SetBatchChannel(channel chan []map[string]interface{}) {
+	w.BatchChannel = channel
+}
+
+func (w *KinesisWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	if w.putter == nil {
+		w.putter, err = newRecordPutter()
+		if err != nil {
+			logs.Warn("Unable to create Kinesis client: %v", err)
+		}
+	}
+	return
+}
+
+// Start the work
+func (w *KinesisWorker) Start() {
+	w.requests = ConfiguredRequestPool("KinesisWorker")
+	go Supervise("KinesisWorker", w.Work)
+}
+
+// add adds an event to the pending records, aggregating it if configured
+func (w *KinesisWorker) add(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	data, err := json.Marshal(obj)
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	partitionKey := randomPartitionKey()
+	if value, found := lookupField(obj, viper.GetString(configKinesisPartitionKey)); found && value != nil {
+		partitionKey = fmt.Sprint(value)
+	}
+	if viper.GetBool(configKinesisAggregate) {
+		for i := range w.records {
+			record := &w.records[i]
+			if record.partitionKey == partitionKey && len(record.data)+len(data)+1 <= ConfiguredKinesisMaxRecordBytes() {
+				record.data = append(append(record.data, '\n'), data...)
+				record.events++
+				return
+			}
+		}
+	}
+	w.records = append(w.records, kinesisRecord{partitionKey: partitionKey, data: data, events: 1})
+}
+
+// Work the queue
+func (w *KinesisWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("KinesisWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredKinesisFlushInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.add(obj)
+			if len(w.records) >= ConfiguredKinesisMax() {
+				w.flush()
+			}
+
+		case batch := <-w.BatchChannel:
+			for _, obj := range batch {
+				w.add(obj)
+				if len(w.records) >= ConfiguredKinesisMax() {
+					w.flush()
+				}
+			}
+
+		case <-ticker.C:
+			w.flush()
+
+		case <-w.FlushChannel:
+			w.flush()
+			w.requests.Wait()
+
+		case <-w.QuitChannel:
+			logs.Info("KinesisWorker received quit")
+			w.flush()
+			w.requests.Wait()
+			return
+		}
+	}
+}
+
+// Flush asks the worker to put the records it has collected
+func (w *KinesisWorker) Flush() {
+	w.FlushChannel <- true
+}
+
+// Stop stops the worker, after putting the records it has collected
+func (w *KinesisWorker) Stop() {
+	w.QuitChannel <- true
+}
+
+// flush puts the pending records
+func (w *KinesisWorker) flush() {
+	records := w.records
+	w.records = nil
+	if len(records) > 0 {
+		w.requests.Do(func() { w.putRecords(records) })
+	}
+}
+
+// putRecords puts records, retrying throttled records
+func (w *KinesisWorker) putRecords(records []kinesisRecord) {
+	backoff := ConfiguredKinesisRetryBackoff()
+	for attempt := 0; len(records) > 0; attempt++ {
+		if attempt > ConfiguredKinesisMaxRetries() {
+			logs.Warn("Giving up on %d Kinesis records after %d retries", len(records), attempt-1)
+			w.deadLetter(records, "retries_exhausted", fmt.Sprintf("still throttled after %d retries", attempt-1))
+			return
+		}
+		if attempt > 0 {
+			Counters.Add("kinesis_retries", int64(len(records)))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		records = w.put(records)
+	}
+}
+
+// put puts a batch of records, and returns the records to retry
+func (w *KinesisWorker) put(records []kinesisRecord) (retry []kinesisRecord) {
+	if w.putter == nil {
+		w.deadLetter(records, "rejected", "no Kinesis client")
+		return nil
+	}
+	start := time.Now()
+	errorCodes, err := w.putter.put(records)
+	if err != nil {
+		w.failed(&OutputError{Destination: kinesisDestination(), Err: err})
+		if aerr, ok := err.(awserr.Error); ok && !retryableKinesisError(aerr.Code()) {
+			w.deadLetter(records, "rejected", err.Error())
+			return nil
+		}
+		return records
+	}
+	w.succeeded(time.Since(start))
+	for i, code := range errorCodes {
+		switch {
+		case code == "":
+		case retryableKinesisError(code):
+			retry = append(retry, records[i])
+		default:
+			w.deadLetter(records[i:i+1], "rejected", code)
+		}
+	}
+	return retry
+}
+
+// deadLetter sends the events of rejected records to the dead letter sink
+func (w *KinesisWorker) deadLetter(records []kinesisRecord, reason string, errorText string) {
+	for _, record := range records {
+		Counters.Add("kinesis_rejected", int64(record.events))
+		for _, line := range bytes.Split(record.data, []byte("\n")) {
+			deadLetterDocument(string(line), reason, errorText)
+		}
+	}
+}
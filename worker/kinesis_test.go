@@ -0,0 +1,87 @@
+package worker_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+type putRecordsRequest struct {
+	StreamName string
+	Records    []struct {
+		Data         string
+		PartitionKey string
+	}
+}
+
+func TestKinesisWorkerRetriesThrottledRecords(t *testing.T) {
+	viper.Reset()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	requests := make(chan putRecordsRequest, 2)
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "Kinesis_20131202.PutRecords" {
+			t.Errorf("expected PutRecords, got %v", r.Header.Get("X-Amz-Target"))
+		}
+		var request putRecordsRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		rw.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if atomic.AddInt32(&count, 1) == 1 {
+			// the first record is throttled
+			fmt.Fprint(rw, `{"FailedRecordCount": 1, "Records": [
+				{"ErrorCode": "ProvisionedThroughputExceededException", "ErrorMessage": "slow down"},
+				{"SequenceNumber": "1", "ShardId": "shardId-000000000000"}]}`)
+		} else {
+			fmt.Fprint(rw, `{"FailedRecordCount": 0, "Records": [{"SequenceNumber": "2", "ShardId": "shardId-000000000000"}]}`)
+		}
+		requests <- request
+	}))
+	defer server.Close()
+	viper.Set("kinesis.stream", "events")
+	viper.Set("kinesis.region", "us-east-1")
+	viper.Set("kinesis.endpoint", server.URL)
+	viper.Set("kinesis.partition_key", "user")
+	viper.Set("kinesis.aggregate", true)
+	viper.Set("kinesis.retry_backoff", "1ms")
+
+	w := &worker.KinesisWorker{}
+	if err := w.Init(); err != nil {
+		t.Fatalf("expected Init to succeed, got %v", err)
+	}
+	channel := make(chan map[string]interface{})
+	w.SetWorkChannel(channel)
+	w.Start()
+	defer w.Stop()
+	channel <- map[string]interface{}{"user": "alice", "n": 1}
+	channel <- map[string]interface{}{"user": "bob", "n": 2}
+	channel <- map[string]interface{}{"user": "alice", "n": 3}
+	w.Flush()
+	for i, expectedKeys := range []string{"alice,bob", "alice"} {
+		select {
+		case request := <-requests:
+			keys := make([]string, len(request.Records))
+			for j, record := range request.Records {
+				keys[j] = record.PartitionKey
+			}
+			if request.StreamName != "events" || strings.Join(keys, ",") != expectedKeys {
+				t.Errorf("In request %d, expected partition keys %v, actual %v", i+1, expectedKeys, keys)
+			}
+			data, _ := base64.StdEncoding.DecodeString(request.Records[0].Data)
+			if string(data) != `{"n":1,"user":"alice"}`+"\n"+`{"n":3,"user":"alice"}` {
+				t.Errorf("In request %d, expected alice's events to be aggregated, actual %s", i+1, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected request %d", i+1)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package worker
+
+/*
+	log_charset.go transcodes input lines to UTF-8
+
+	parse.charset names the character set of the input file, using any of the
+	WHATWG encoding labels (e.g. "latin1", "windows-1252", "shift_jis"). The
+	special value "auto" leaves valid UTF-8 lines alone, and decodes any other
+	line as Windows-1252 (a superset of the printable Latin-1 characters). If
+	parse.charset is not set, or is "utf-8", lines are not transcoded.
+*/
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const configParseCharset = "parse.charset"
+
+// charsetEncoding looks up the encoding for the charset name
+func charsetEncoding(charset string) (encoding.Encoding, error) {
+	if strings.EqualFold(charset, "auto") {
+		return charmap.Windows1252, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown charset: %s", charset)
+	}
+	return enc, nil
+}
+
+// TranscodeLine converts a line in the given charset into UTF-8
+func TranscodeLine(line string, charset string) (string, error) {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "utf8") {
+		return line, nil
+	}
+	if strings.EqualFold(charset, "auto") && utf8.ValidString(line) {
+		return line, nil
+	}
+	enc, err := charsetEncoding(charset)
+	if err != nil {
+		return line, err
+	}
+	return enc.NewDecoder().String(line)
+}
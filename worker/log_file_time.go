@@ -0,0 +1,88 @@
+package worker
+
+/*
+	log_file_time.go takes the date of events from the input file name
+
+	Some logs only have the time of day in their lines, the date being in
+	the file name (app-2024-06-01.log). parse.file_time_pattern is a regular
+	expression matched against the base name of the input file, with named
+	groups year, month and day (and optionally hour). An event timestamp
+	that has no date (such as one parsed with the time pattern "15:04:05")
+	gets the date from the file name; an event that has no timestamp at all
+	gets one ("created") from the file name, at the hour in the file name,
+	if any.
+*/
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configParseFileTimePattern = "parse.file_time_pattern"
+
+// fileTime is the date taken from the input file name
+type fileTime struct {
+	found bool
+	date  time.Time // in UTC
+}
+
+// ConfiguredFileTime returns the date in the name of the input file, if
+// parse.file_time_pattern is set and matches it
+func ConfiguredFileTime() fileTime {
+	pattern := viper.GetString(configParseFileTimePattern)
+	if pattern == "" {
+		return fileTime{}
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		reportError(&ConfigError{Key: configParseFileTimePattern, Value: pattern, Reason: err.Error()})
+		return fileTime{}
+	}
+	return FileTime(regex, filepath.Base(viper.GetString(configParseInputFile)))
+}
+
+// FileTime returns the date in a file name, as matched by the named groups
+// year, month, day and hour of regex
+func FileTime(regex *regexp.Regexp, name string) fileTime {
+	match := regex.FindStringSubmatch(name)
+	if match == nil {
+		return fileTime{}
+	}
+	parts := map[string]int{"year": 0, "month": 1, "day": 1, "hour": 0}
+	for i, group := range regex.SubexpNames() {
+		if _, ok := parts[group]; !ok || match[i] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i])
+		if err != nil {
+			continue
+		}
+		parts[group] = n
+	}
+	if parts["year"] == 0 {
+		return fileTime{}
+	}
+	return fileTime{
+		found: true,
+		date:  time.Date(parts["year"], time.Month(parts["month"]), parts["day"], parts["hour"], 0, 0, 0, time.UTC),
+	}
+}
+
+// apply gives the date of the file to the timestamp of an event without a
+// date, or gives a timestamp to an event without one
+func (f fileTime) apply(v map[string]interface{}) {
+	for _, key := range timestampFields {
+		t, ok := v[key].(time.Time)
+		if !ok {
+			continue
+		}
+		if t.Year() == 0 {
+			v[key] = time.Date(f.date.Year(), f.date.Month(), f.date.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		}
+		return
+	}
+	v["created"] = f.date
+}
@@ -0,0 +1,76 @@
+package worker
+
+/*
+	log_headers.go parses captured cookie and request header strings
+
+	If the log pattern captures a `cookie` or `request_headers` field, the
+	whitelisted cookies and headers in it are added to the event as their own
+	fields (cookie_<name> and header_<name>), so that, e.g., session IDs and
+	trace IDs become searchable.
+*/
+import (
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const configParseCookies = "parse.cookies"
+const configParseHeaders = "parse.headers"
+
+// headerFieldName converts a header name into a field name, e.g.
+// X-Request-Id becomes header_x_request_id
+func headerFieldName(name string) string {
+	return "header_" + strings.Replace(strings.ToLower(name), "-", "_", -1)
+}
+
+// ParseCookies parses a Cookie header value (name=value; name2=value2) and
+// adds the whitelisted cookies (parse.cookies) into the main map.
+func (w *LogParser) ParseCookies(cookies string, v map[string]interface{}) {
+	whitelist := viper.GetStringSlice(configParseCookies)
+	if cookies == "" || len(whitelist) == 0 {
+		return
+	}
+	for _, pair := range strings.Split(cookies, ";") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || !sliceContains(whitelist, parts[0]) {
+			continue
+		}
+		newKey := newKeyName("cookie_"+parts[0], v)
+		if !w.shouldIgnore(newKey) {
+			v[newKey] = strings.Trim(parts[1], `"`)
+		}
+	}
+}
+
+// ParseHeaders parses a captured request header string, made of
+// `Name: value` pairs separated by newlines or `|`, and adds the
+// whitelisted headers (parse.headers) into the main map. Header names are
+// matched case-insensitively.
+func (w *LogParser) ParseHeaders(headers string, v map[string]interface{}) {
+	whitelist := viper.GetStringSlice(configParseHeaders)
+	if headers == "" || len(whitelist) == 0 {
+		return
+	}
+	canonical := make([]string, len(whitelist))
+	for i, name := range whitelist {
+		canonical[i] = http.CanonicalHeaderKey(name)
+	}
+	lines := strings.FieldsFunc(headers, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == '|'
+	})
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))
+		if !sliceContains(canonical, name) {
+			continue
+		}
+		newKey := newKeyName(headerFieldName(name), v)
+		if !w.shouldIgnore(newKey) {
+			v[newKey] = strings.TrimSpace(parts[1])
+		}
+	}
+}
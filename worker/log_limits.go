@@ -0,0 +1,143 @@
+package worker
+
+/*
+	log_limits.go guards against oversized input lines
+
+	Lines longer than parse.max_line_bytes are handled according to
+	parse.oversized_policy:
+
+		truncate     (the default) truncate the line, and add truncated: true
+		             to the event
+		drop         drop the line
+		dead_letter  send the line to the dead letter file
+
+	Lines in which more than parse.binary_threshold (by default, 0.3) of the
+	characters are non-printable, such as those read from an accidentally
+	tailed binary file, are counted and handled according to
+	parse.binary_policy (drop, the default, or dead_letter). A threshold of 0
+	disables the check.
+
+	Lines the codec can't decode, or the pattern doesn't match, are counted
+	and handled according to parse.unmatched_policy (drop, the default, or
+	dead_letter, so that they can be requeued once the pattern is fixed; see
+	dead_letter_sqlite.go).
+*/
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configParseMaxLineBytes = "parse.max_line_bytes"
+const configParseOversizedPolicy = "parse.oversized_policy"
+const configParseBinaryThreshold = "parse.binary_threshold"
+const configParseBinaryPolicy = "parse.binary_policy"
+const configParseUnmatchedPolicy = "parse.unmatched_policy"
+
+// DefaultBinaryThreshold is the default ratio of non-printable characters
+// above which a line is considered binary
+const DefaultBinaryThreshold = 0.3
+
+// TruncateLine truncates s to at most n bytes, without splitting a UTF-8
+// encoded character
+func TruncateLine(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// limitLine applies the oversized line policy to the line. It returns the
+// (possibly truncated) line, whether it was truncated, and whether it should
+// be processed at all.
+func limitLine(line string) (limited string, truncated bool, ok bool) {
+	max := viper.GetInt(configParseMaxLineBytes)
+	if max <= 0 || len(line) <= max {
+		return line, false, true
+	}
+	switch policy := strings.ToLower(viper.GetString(configParseOversizedPolicy)); policy {
+	case "drop":
+		logs.Debug("Dropping line of %d bytes", len(line))
+		Counters.Inc("lines_oversized_dropped")
+		return "", false, false
+	case "dead_letter":
+		DeadLetter(DeadLetterRecord{
+			Reason: "oversized",
+			Line:   line,
+			Error:  fmt.Sprintf("line of %d bytes exceeds %d", len(line), max),
+		})
+		return "", false, false
+	case "truncate", "":
+	default:
+		logs.Warn("Invalid oversized line policy %s; truncating", policy)
+	}
+	Counters.Inc("lines_truncated")
+	return TruncateLine(line, max), true, true
+}
+
+// NonPrintableRatio returns the ratio of non-printable characters (control
+// characters other than tab, and invalid UTF-8 bytes) in the line
+func NonPrintableRatio(line string) float64 {
+	if len(line) == 0 {
+		return 0
+	}
+	total, bad := 0, 0
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		total++
+		if r == utf8.RuneError && size == 1 {
+			bad++
+		} else if r < 0x20 && r != '\t' && r != '\r' && r != '\n' || r == 0x7f {
+			bad++
+		}
+		i += size
+	}
+	return float64(bad) / float64(total)
+}
+
+// ConfiguredBinaryThreshold returns the ratio of non-printable characters
+// above which a line is considered binary
+func ConfiguredBinaryThreshold() float64 {
+	if viper.IsSet(configParseBinaryThreshold) {
+		return viper.GetFloat64(configParseBinaryThreshold)
+	}
+	return DefaultBinaryThreshold
+}
+
+// isBinaryLine checks whether the line looks binary, and if so, applies
+// the binary line policy to it
+func isBinaryLine(line string) bool {
+	threshold := ConfiguredBinaryThreshold()
+	if threshold <= 0 {
+		return false
+	}
+	ratio := NonPrintableRatio(line)
+	if ratio <= threshold {
+		return false
+	}
+	Counters.Inc("lines_binary")
+	if strings.ToLower(viper.GetString(configParseBinaryPolicy)) == "dead_letter" {
+		DeadLetter(DeadLetterRecord{
+			Reason: "binary",
+			Line:   line,
+			Error:  fmt.Sprintf("%.0f%% of characters are non-printable", ratio*100),
+		})
+	} else {
+		logs.Debug("Dropping binary line of %d bytes", len(line))
+	}
+	return true
+}
+
+// unmatchedLine applies the unmatched line policy to a line that could not
+// be parsed
+func unmatchedLine(line string, err error) {
+	if strings.ToLower(viper.GetString(configParseUnmatchedPolicy)) == "dead_letter" {
+		DeadLetter(DeadLetterRecord{Reason: "unmatched", Line: line, Error: err.Error()})
+	}
+}
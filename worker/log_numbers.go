@@ -0,0 +1,63 @@
+package worker
+
+/*
+	log_numbers.go keeps numbers parsed from strings exact
+
+	ParseStringForValue parses integers as int64, and other numbers as
+	float64. A float64 only holds integers up to 2^53 exactly, though, and
+	so do JavaScript and many JSON parsers, so long digit strings, such as
+	19-digit ids, may be silently corrupted on the way: integers too large
+	for an int64 become approximate float64s, and those that fit may still
+	be rounded by whoever reads the JSON output.
+
+	With parse.numbers.big_as_string set, digit strings beyond 2^53 (in
+	magnitude) are kept as strings. With parse.numbers.prefer_int set,
+	numbers that parse as floats but are whole, such as 2.0 or 1e3, are
+	parsed as int64 (up to 2^53, beyond which floats aren't exact anyway).
+*/
+import (
+	"math"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+const configParseNumbersBigAsString = "parse.numbers.big_as_string"
+const configParseNumbersPreferInt = "parse.numbers.prefer_int"
+
+// maxSafeInteger is the largest integer below which a float64 holds every
+// integer exactly
+const maxSafeInteger = 1 << 53
+
+// isUnsafeInteger returns true if s is a digit string, optionally signed,
+// beyond 2^53 in magnitude
+func isUnsafeInteger(s string) bool {
+	digits := s
+	if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') {
+		digits = digits[1:]
+	}
+	if digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	return err != nil || i > maxSafeInteger || i < -maxSafeInteger
+}
+
+// bigAsString returns true if s should be kept as a string, rather than
+// parsed as a number that can't be represented exactly
+func bigAsString(s string) bool {
+	return viper.GetBool(configParseNumbersBigAsString) && isUnsafeInteger(s)
+}
+
+// preferInt returns f as an int64, if it is whole and ints are preferred
+func preferInt(f float64) (int64, bool) {
+	if !viper.GetBool(configParseNumbersPreferInt) || f != math.Trunc(f) || math.Abs(f) > maxSafeInteger {
+		return 0, false
+	}
+	return int64(f), true
+}
@@ -0,0 +1,113 @@
+package worker
+
+/*
+	log_params.go controls which query parameters become fields
+
+	Every query parameter of a captured uri becomes a field of the event
+	(see ParseURI), so clients sending arbitrary parameters can create any
+	number of distinct fields, and as many mappings in Elasticsearch, and
+	fields named like those parsed from the line.
+
+	With parse.uri.param_allowlist set, only the parameters it lists become
+	fields; the others are only kept in uri_query_raw. With
+	parse.uri.param_prefix set (e.g. "query_"), the fields are named with
+	that prefix, e.g. query_q for ?q=, so that they can't be mistaken for
+	fields parsed from the line. Without it, a parameter named like a field
+	the event already has is added with underscores prepended instead (e.g.
+	_status for ?status=).
+
+	Up to parse.uri.max_params (1000 by default; 0 for no limit) distinct
+	parameter names are promoted to fields. Once that many have been seen,
+	parameters with other names are folded into a params_other object
+	instead, e.g. {"params_other": {"utm_x1": "a"}}, counted as
+	params_folded, and a warning is logged.
+*/
+import (
+	"sync"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configParseURIMaxParams = "parse.uri.max_params"
+const configParseURIParamAllowlist = "parse.uri.param_allowlist"
+const configParseURIParamPrefix = "parse.uri.param_prefix"
+
+// paramsOtherField holds the query parameters that aren't promoted
+const paramsOtherField = "params_other"
+
+// ConfiguredParseURIMaxParams returns how many distinct query parameter
+// names are promoted to fields; 0 for no limit
+func ConfiguredParseURIMaxParams() int {
+	if viper.IsSet(configParseURIMaxParams) {
+		if max := viper.GetInt(configParseURIMaxParams); max >= 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configParseURIMaxParams, Value: viper.Get(configParseURIMaxParams), Reason: "using 1000"})
+	}
+	return 1000
+}
+
+// paramGuard keeps the query parameter names promoted to fields
+type paramGuard struct {
+	allowed map[string]bool // all of them, if nil
+	prefix  string
+	max     int
+	lock    sync.Mutex
+	names   map[string]bool
+	warned  bool
+}
+
+// allow returns true if the query parameter name is allowed to become a
+// field
+func (g *paramGuard) allow(name string) bool {
+	return g.allowed == nil || g.allowed[name]
+}
+
+// promote returns true if the query parameter name is, or may become, a
+// field of its own
+func (g *paramGuard) promote(name string) bool {
+	if g.max == 0 {
+		return true
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.names[name] {
+		return true
+	}
+	if len(g.names) < g.max {
+		g.names[name] = true
+		return true
+	}
+	if !g.warned {
+		g.warned = true
+		logs.Warn("Seen %d distinct query parameters; folding new ones, starting with %q, into %s", g.max, name, paramsOtherField)
+	}
+	return false
+}
+
+// params returns the guard of the query parameter names
+func (w *LogParser) params() *paramGuard {
+	w.paramsOnce.Do(func() {
+		w.paramGuard = &paramGuard{max: ConfiguredParseURIMaxParams(), prefix: viper.GetString(configParseURIParamPrefix), names: make(map[string]bool)}
+		if allowlist := viper.GetStringSlice(configParseURIParamAllowlist); len(allowlist) > 0 {
+			w.paramGuard.allowed = make(map[string]bool, len(allowlist))
+			for _, name := range allowlist {
+				w.paramGuard.allowed[name] = true
+			}
+		}
+	})
+	return w.paramGuard
+}
+
+// foldParam adds a query parameter that isn't promoted to the params_other
+// object of the event
+func foldParam(name string, value interface{}, v map[string]interface{}) {
+	other, ok := v[paramsOtherField].(map[string]interface{})
+	if !ok {
+		other = make(map[string]interface{})
+		v[paramsOtherField] = other
+	}
+	other[name] = value
+	Counters.Inc("params_folded")
+}
@@ -12,17 +12,20 @@ package worker
 	The worker configuation information is found in config.go.
 */
 import (
-	"fmt"
+	"io"
 	"math"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ActiveState/tail"
+	"github.com/ActiveState/tail/watch"
 	"github.com/fizx/logs"
 	"github.com/spf13/viper"
 )
@@ -33,17 +36,64 @@ const configParsePattern = "parse.pattern"
 const configParseTimePatterns = "parse.time_patterns"
 const configTailFromBeginning = "tail.from_beginning"
 const configTailReopen = "tail.reopen"
+const configTailPoll = "tail.poll"
+const configTailPollInterval = "tail.poll_interval"
+const configTailCheckpointFile = "tail.checkpoint_file"
+const configTailCheckpointEvery = "tail.checkpoint_every"
 
 // DefaultParseLogPattern is the default pattern for understanding log patterns
 const DefaultParseLogPattern = `(?P<line>.*)` // `(?P<host>\S+) (?P<client>\S+) (?P<user>\S+) \[(?P<created>[^\]]+)\] "((?P<method>[A-Z]+) )?(?P<uri>\S+).*"`
 
 // LogParser parses the imput and puts events on a channel
 type LogParser struct {
-	Channel chan map[string]interface{}
-	tailer  *tail.Tail
-	Regex   *regexp.Regexp
-	pattern string
-	lock    sync.Mutex
+	Channel       chan map[string]interface{}
+	EventChannel  chan *Event
+	BatchChannel  chan []map[string]interface{}
+	batcher       batcher
+	shards        *shardPool
+	shardOnce     sync.Once
+	sequence      bool
+	seq           int64
+	eventIDs      string
+	tenants       *tenancy
+	orderedQueue  chan interface{}
+	orderedOnce   sync.Once
+	clock         clockGuard
+	ttl           eventTTL
+	fileTime      fileTime
+	lookups       []*lookupTable
+	dns           *DNSCache
+	dnsField      string
+	alerts        []*alertRule
+	reporter      *reporter
+	reportOnce    sync.Once
+	tracer        *eventTracer
+	failures      *failureMonitor
+	paramGuard    *paramGuard
+	paramsOnce    sync.Once
+	drift         *driftMonitor
+	schema        *Schema
+	tailer        *tail.Tail
+	Regex         *regexp.Regexp
+	pattern       string
+	codec         Codec
+	codecOnce     sync.Once
+	lock          sync.Mutex
+	transformer   Transformer
+	linesRead     int64
+	lastRead      int64
+	heartbeatOnce sync.Once
+	pending       int64
+	Tap           EventTap
+	pauseLock     sync.Mutex
+	pauseCond     *sync.Cond
+	paused        bool
+	throttle      *Throttle
+	maxMemory     int64
+	inputLock     sync.Mutex
+	inputs        []io.Closer
+	stopped       bool
+	polling       sync.WaitGroup
 }
 
 func newKeyName(k string, m map[string]interface{}) string {
@@ -122,6 +172,9 @@ func ParseStringForValue(ts string) interface{} {
 	if e == nil {
 		return t
 	}
+	if bigAsString(ts) {
+		return ts
+	}
 	pi, err := strconv.ParseInt(ts, 10, 64)
 	if err == nil {
 		return pi
@@ -133,63 +186,237 @@ func ParseStringForValue(ts string) interface{} {
 	pf, err := strconv.ParseFloat(ts, 64)
 	if err == nil {
 		// it might be the string "NaN"
-		if !math.IsNaN(pf) {
+		if math.IsNaN(pf) {
+			return 0.0 // return 0.0 for NaN
+		}
+		// or "Inf", which JSON can't represent either
+		if !math.IsInf(pf, 0) {
+			if pi, ok := preferInt(pf); ok {
+				return pi
+			}
 			return pf
 		}
-		return 0.0 // return 0.0 for NaN
 	}
 	return ts
 }
 
+// addURIField adds a decomposed part of the URI to the map, unless it is
+// empty or should be ignored.
+func (w *LogParser) addURIField(key string, value interface{}, v map[string]interface{}) {
+	if value == "" {
+		return
+	}
+	newKey := newKeyName(key, v)
+	if !w.shouldIgnore(newKey) {
+		v[newKey] = value
+	}
+}
+
+// uriSegments splits a (decoded) URI path into its non-empty segments
+func uriSegments(p string) []interface{} {
+	segments := make([]interface{}, 0)
+	for _, segment := range strings.Split(p, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
 // ParseURI parses the URI string and adds the relevant query parameters
 // into the main map.
 // it also attempts to determine the data type of the items by
 // parsing as date, int, bool, float, and if all of these fail, then keeping
-// as string
+// as string. Query parameters with more than one value are added as arrays.
+// Only those in parse.uri.param_allowlist, if set, are added, named with
+// parse.uri.param_prefix, and beyond parse.uri.max_params distinct names,
+// they are folded into params_other (see log_params.go).
+//
+// The URI itself is decomposed into uri_path (percent-decoded),
+// uri_query_raw, uri_segments (the path segments) and uri_extension.
 func (w *LogParser) ParseURI(uri string, v map[string]interface{}) {
 	if uri != "" {
 		url, err := url.Parse(uri)
 		if err == nil {
 			q := url.Query()
+			params := w.params()
 			for k, kvs := range q {
-				newKey := newKeyName(k, v)
+				if !params.allow(k) {
+					continue
+				}
+				newKey := newKeyName(params.prefix+k, v)
 				if !w.shouldIgnore(newKey) && len(kvs) > 0 {
-					v[newKey] = ParseStringForValue(kvs[0])
+					var value interface{}
+					if len(kvs) == 1 {
+						value = parseFieldValue(k, kvs[0])
+					} else {
+						values := make([]interface{}, len(kvs))
+						for i, kv := range kvs {
+							values[i] = parseFieldValue(k, kv)
+						}
+						value = values
+					}
+					if params.promote(k) {
+						v[newKey] = value
+					} else {
+						foldParam(k, value, v)
+					}
 				}
 			}
+			w.addURIField("uri_path", url.Path, v)
+			w.addURIField("uri_query_raw", url.RawQuery, v)
+			if segments := uriSegments(url.Path); len(segments) > 0 {
+				w.addURIField("uri_segments", segments, v)
+			}
+			w.addURIField("uri_extension", strings.TrimPrefix(path.Ext(url.Path), "."), v)
 		}
 	}
 }
 
-// ParseEvents parses the line (including a call to ParseURI) to
-// add events to the map of strings -> anything. It returns that map
+// utf8BOM is the UTF-8 encoded byte order mark
+const utf8BOM = "\ufeff"
+
+// ParseEvents decodes the line with the input codec (including a call to
+// ParseURI) to add events to the map of strings -> anything. It returns that
+// map. A leading byte order mark and a trailing carriage return (from
+// Windows produced files) are ignored.
 func (w *LogParser) ParseEvents(line string) (map[string]interface{}, error) {
-	v := make(map[string]interface{})
-	regex := w.CachedRegex()
-	match := regex.FindStringSubmatch(line)
+	v, err := w.Codec().Decode(line)
+	if err != nil {
+		return nil, err
+	}
+	w.finishEvent(v, nil)
+	return v, nil
+}
+
+// eventFromMatch converts the submatches of the pattern into an event
+func (w *LogParser) eventFromMatch(regex *regexp.Regexp, match []string) map[string]interface{} {
+	v := w.fieldsFromMatch(regex, match)
+	w.finishEvent(v, nil)
+	return v
+}
+
+// fieldsFromMatch converts the submatches of the pattern into fields
+func (w *LogParser) fieldsFromMatch(regex *regexp.Regexp, match []string) map[string]interface{} {
 	names := regex.SubexpNames()
-	if match != nil {
-		for i, submatch := range match {
-			name := names[i]
-			if !w.shouldIgnore(name) {
-				v[names[i]] = ParseStringForValue(submatch)
-			}
-			if name == "uri" {
-				w.ParseURI(submatch, v)
-			}
+	v := newEvent(len(names))
+	for i, submatch := range match {
+		w.addField(names[i], submatch, v)
+	}
+	return v
+}
+
+// addField adds a decoded field to the event, and the fields it is
+// decomposed into, if it is a uri, cookie, request_headers or referer
+func (w *LogParser) addField(name string, value string, v map[string]interface{}) {
+	if !w.shouldIgnore(name) {
+		v[name] = parseFieldValue(name, value)
+	}
+	switch name {
+	case "uri":
+		w.ParseURI(value, v)
+	case "cookie":
+		w.ParseCookies(value, v)
+	case "request_headers":
+		w.ParseHeaders(value, v)
+	case "referer", "referrer":
+		w.ParseReferer(name, value, v)
+	}
+}
+
+// finishEvent applies the file name time, the clock guard, and the
+// transforms to decoded fields
+func (w *LogParser) finishEvent(v map[string]interface{}, trace *eventTrace) {
+	if w.fileTime.found {
+		w.fileTime.apply(v)
+		trace.stage("file_time", v)
+	}
+	if w.clock.enabled() {
+		w.clock.apply(v, time.Now())
+		trace.stage("clock", v)
+	}
+	w.transformer.Transform(v)
+	trace.stage("transform", v)
+}
+
+// ConfiguredTailPollInterval returns how often the input file is polled for
+// changes, when polling is used instead of inotify
+func ConfiguredTailPollInterval() time.Duration {
+	key := configTailPollInterval
+	if viper.IsSet(key) {
+		if interval := viper.GetDuration(key); interval > 0 {
+			return interval
+		}
+		logs.Warn("Invalid poll interval %s; using 250ms", viper.GetString(key))
+	}
+	return 250 * time.Millisecond
+}
+
+// ConfiguredCheckpointEvery returns how many lines are read between
+// checkpoints
+func ConfiguredCheckpointEvery() int64 {
+	key := configTailCheckpointEvery
+	if viper.IsSet(key) {
+		return int64(viper.GetInt(key))
+	}
+	return int64(1000)
+}
+
+// checkpointLocation returns the location saved in the checkpoint file, if
+// there is a valid one for the input file
+func checkpointLocation(inputFile string) *tail.SeekInfo {
+	checkpointFile := viper.GetString(configTailCheckpointFile)
+	if checkpointFile == "" {
+		return nil
+	}
+	c, err := ReadCheckpoint(checkpointFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logs.Warn("Ignoring checkpoint: %v", err)
 		}
-		return v, nil
+		return nil
+	}
+	info, err := os.Stat(inputFile)
+	if c.File != inputFile || err != nil || info.Size() < c.Offset {
+		logs.Info("Ignoring checkpoint for %s at offset %d", c.File, c.Offset)
+		return nil
+	}
+	logs.Info("Resuming %s from checkpoint at offset %d", inputFile, c.Offset)
+	return &tail.SeekInfo{Offset: c.Offset, Whence: os.SEEK_SET}
+}
+
+// saveCheckpoint saves the current read position, if checkpointing is
+// configured. That is the position of the lines read, which may not have
+// been sent yet (see checkpoint.go).
+func (w *LogParser) saveCheckpoint() {
+	checkpointFile := viper.GetString(configTailCheckpointFile)
+	if checkpointFile == "" || w.tailer == nil {
+		return
+	}
+	offset, err := w.tailer.Tell()
+	if err != nil {
+		logs.Warn("Unable to determine offset for checkpoint: %v", err)
+		return
+	}
+	c := Checkpoint{File: w.tailer.Filename, Offset: offset, Time: time.Now()}
+	if err = WriteCheckpoint(checkpointFile, c); err != nil {
+		logs.Warn("Unable to write checkpoint file %s: %v", checkpointFile, err)
 	}
-	logs.Debug("Line %s did not match pattern.", line)
-	return nil, fmt.Errorf("Line %s did not match pattern.", line)
 }
 
 // converts w config into tail Config
 func (w *LogParser) convertConfig() (config tail.Config) {
 	if !viper.GetBool(configTailFromBeginning) {
-		config.Location = &tail.SeekInfo{0, os.SEEK_END}
+		config.Location = &tail.SeekInfo{Offset: 0, Whence: os.SEEK_END}
+	}
+	if location := checkpointLocation(viper.GetString(configParseInputFile)); location != nil {
+		config.Location = location
 	}
 	config.ReOpen = viper.GetBool(configTailReopen)
+	config.Poll = viper.GetBool(configTailPoll)
+	if config.Poll {
+		watch.POLL_DURATION = ConfiguredTailPollInterval()
+	}
 	config.Follow = true
 	config.Logger = tail.DiscardingLogger
 	logs.Info("tail config: %v", config)
@@ -200,31 +427,334 @@ func (w *LogParser) SetWorkChannel(channel chan map[string]interface{}) {
 	w.Channel = channel
 }
 
+// SetEventChannel sets the channel typed events are sent on, for sinks that
+// implement EventWorker
+func (w *LogParser) SetEventChannel(channel chan *Event) {
+	w.EventChannel = channel
+}
+
 // recompile regex if necessaary ...
 
 func (w *LogParser) CachedRegex() *regexp.Regexp {
+	regex, _ := w.CompiledRegex()
+	return regex
+}
+
+// CompiledRegex returns the compiled pattern, and the error compiling it,
+// if it doesn't compile; the regex is then the last pattern that did, if
+// any
+func (w *LogParser) CompiledRegex() (*regexp.Regexp, error) {
 	w.lock.Lock()
+	defer w.lock.Unlock()
 	pattern := viper.GetString(configParsePattern)
-	if pattern != w.pattern {
-		if pattern == "" {
-			pattern = DefaultParseLogPattern
+	if pattern == "" {
+		pattern = DefaultParseLogPattern
+	}
+	if pattern != w.pattern || w.Regex == nil {
+		expanded := pattern
+		var err error
+		if viper.GetString(configInputCodec) == "grok" {
+			expanded, err = ExpandGrok(pattern, viper.GetStringMapString(configCodecGrokPatterns))
+		}
+		var regex *regexp.Regexp
+		if err == nil {
+			regex, err = regexp.Compile(expanded)
 		}
-		regex, err := regexp.Compile(pattern)
 		if err != nil {
 			logs.Warn("Could not compile Regex. Error: %v", err)
-		} else {
-			logs.Debug("Resetting regex: %v", pattern)
-			w.pattern = pattern
-			w.Regex = regex
+			return w.Regex, err
 		}
+		logs.Debug("Resetting regex: %v", pattern)
+		w.pattern = pattern
+		w.Regex = regex
 	}
-	w.lock.Unlock()
-	return w.Regex
+	return w.Regex, nil
 }
 
-// Init initializes worker's Regex
+// Init initializes worker's Regex, and the schema of typed events, if one is
+// configured
 func (w *LogParser) Init() {
-	w.CachedRegex()
+	schema, err := ConfiguredSchema(w.CachedRegex())
+	if err != nil {
+		reportError(err)
+	}
+	if _, regex := w.Codec().(regexCodec); schema != nil && !regex {
+		reportError(&ConfigError{Key: configSchemaFields, Value: ConfiguredInputCodec(), Reason: "typed events need the regex or grok codec; ignoring the schema"})
+		schema = nil
+	}
+	w.sequence = ConfiguredParseSequence()
+	w.eventIDs = ConfiguredParseEventID()
+	w.tenants = ConfiguredTenancy()
+	if schema != nil && (w.sequence || w.eventIDs != "" || w.tenants != nil) {
+		reportError(&ConfigError{Key: configSchemaFields, Value: viper.Get(configSchemaFields), Reason: "typed events can't be stamped with parse.sequence, parse.event_id or the tenant; ignoring the schema"})
+		schema = nil
+	}
+	w.clock = ConfiguredClockGuard()
+	w.ttl = ConfiguredEventTTL()
+	w.fileTime = ConfiguredFileTime()
+	w.lookups = ConfiguredLookups()
+	w.dns = ConfiguredDNSCache()
+	w.dnsField = ConfiguredReverseDNSField()
+	w.alerts = ConfiguredAlertRules()
+	EventMetrics.Configure()
+	w.reporter = ConfiguredReporter()
+	w.tracer = ConfiguredEventTracer()
+	w.failures = ConfiguredFailureMonitor()
+	w.drift = ConfiguredDriftMonitor()
+	if keys := w.mapOnlySettings(); schema != nil && len(keys) > 0 {
+		reportError(&ConfigError{Key: configSchemaFields, Value: viper.Get(configSchemaFields), Reason: "typed events skip " + strings.Join(keys, ", ") + "; ignoring the schema"})
+		schema = nil
+	}
+	w.schema = schema
+	w.startOrdered()
+}
+
+// mapOnlySettings returns the configured settings that only apply to events
+// as maps, which typed events skip: they are neither finished (see
+// finishEvent) nor processed (see processEvent)
+func (w *LogParser) mapOnlySettings() []string {
+	var keys []string
+	if viper.GetString(configParseFileTimePattern) != "" {
+		keys = append(keys, configParseFileTimePattern)
+	}
+	if w.clock.skew != 0 {
+		keys = append(keys, configTimeSkew)
+	}
+	if w.clock.maxFuture > 0 {
+		keys = append(keys, configTimeMaxFuture)
+	}
+	if w.clock.maxPast > 0 {
+		keys = append(keys, configTimeMaxPast)
+	}
+	if w.ttl.ttl > 0 {
+		keys = append(keys, configTimeTTL)
+	}
+	if len(w.lookups) > 0 {
+		keys = append(keys, configTransformLookups)
+	}
+	if w.dns != nil {
+		keys = append(keys, configReverseDNSField)
+	}
+	if len(viper.GetStringMap(configMetrics)) > 0 {
+		keys = append(keys, configMetrics)
+	}
+	if w.reporter != nil {
+		keys = append(keys, configReportFields)
+	}
+	if w.drift != nil {
+		keys = append(keys, configDriftWarmup)
+	}
+	if len(w.alerts) > 0 {
+		keys = append(keys, configAlertsRules)
+	}
+	return keys
+}
+
+// LinesRead returns the number of lines read from the input file so far
+func (w *LogParser) LinesRead() int64 {
+	return atomic.LoadInt64(&w.linesRead)
+}
+
+// Pending returns the number of parsed events waiting to be taken by the
+// sink
+func (w *LogParser) Pending() int64 {
+	return atomic.LoadInt64(&w.pending)
+}
+
+func (w *LogParser) cond() *sync.Cond {
+	if w.pauseCond == nil {
+		w.pauseCond = sync.NewCond(&w.pauseLock)
+	}
+	return w.pauseCond
+}
+
+// Pause stops reading the input file until Resume is called
+func (w *LogParser) Pause() {
+	w.pauseLock.Lock()
+	w.paused = true
+	w.pauseLock.Unlock()
+	logs.Info("Pausing input")
+}
+
+// Resume resumes reading the input file after a Pause
+func (w *LogParser) Resume() {
+	w.pauseLock.Lock()
+	w.paused = false
+	w.cond().Broadcast()
+	w.pauseLock.Unlock()
+	logs.Info("Resuming input")
+}
+
+// Paused returns true if reading the input file is paused
+func (w *LogParser) Paused() bool {
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+	return w.paused
+}
+
+func (w *LogParser) waitWhilePaused() {
+	w.pauseLock.Lock()
+	for w.paused {
+		w.cond().Wait()
+	}
+	w.pauseLock.Unlock()
+}
+
+// ProcessLine checks, transcodes, limits and parses a raw input line,
+// putting the parsed event on the shared channel.
+func (w *LogParser) ProcessLine(text string) {
+	w.processLine(text, w.emit)
+}
+
+// processLine checks, transcodes, limits and parses a raw input line,
+// passing the parsed event to emit
+func (w *LogParser) processLine(text string, emit func(map[string]interface{})) {
+	binary := w.binaryCodec()
+	if !binary {
+		var err error
+		if text, err = TranscodeLine(text, viper.GetString(configParseCharset)); err != nil {
+			logs.Warn("Could not transcode line %q: %v", text, err)
+			return
+		}
+		// after transcoding, so that the bytes of other charsets don't count
+		// as invalid UTF-8
+		if isBinaryLine(text) {
+			return
+		}
+	}
+	text, truncated, ok := limitLine(text)
+	if !ok {
+		return
+	}
+	s := text
+	if !binary {
+		s = strings.TrimSpace(text)
+	}
+	logs.Debug("Processing line %v", s)
+	if w.schema != nil {
+		w.processTyped(s, emit)
+		return
+	}
+	trace := w.tracer.start(s)
+	v, err := w.Codec().Decode(s)
+	w.observeParse(s, err)
+	if err != nil {
+		trace.fail("decode", err)
+		w.tracer.finish(trace)
+		Counters.Inc("lines_unmatched")
+		reportError(err)
+		unmatchedLine(s, err)
+		return
+	}
+	trace.stage("decode", v)
+	w.finishEvent(v, trace)
+	Counters.Inc("lines_parsed")
+	if truncated {
+		v["truncated"] = true
+		trace.stage("limit", v)
+	}
+	w.processEvent(v, trace, emit)
+}
+
+// processEvent enriches, observes and checks a parsed event, and passes it
+// to emit
+func (w *LogParser) processEvent(v map[string]interface{}, trace *eventTrace, emit func(map[string]interface{})) {
+	if !w.ttl.apply(v, time.Now()) {
+		trace.dropped()
+		w.tracer.finish(trace)
+		ReleaseEvent(v)
+		return
+	}
+	if w.ttl.ttl > 0 {
+		trace.stage("ttl", v)
+	}
+	if !w.enrich(v) {
+		trace.stage("enrich", v)
+		trace.dropped()
+		w.tracer.finish(trace)
+		Counters.Inc("lookup_dropped")
+		ReleaseEvent(v)
+		return
+	}
+	trace.stage("enrich", v)
+	w.resolve(v)
+	trace.stage("resolve", v)
+	w.tracer.finish(trace)
+	EventMetrics.Observe(v)
+	w.reporter.observe(v)
+	w.checkDrift(v, emit)
+	w.checkAlerts(v, emit)
+	emit(v)
+}
+
+// emit publishes a parsed event, and puts it on the shared channel
+func (w *LogParser) emit(v map[string]interface{}) {
+	w.publish(applySchema(v))
+}
+
+// publish publishes an event that has been mapped to the output schema, and
+// puts it on the shared channel
+func (w *LogParser) publish(v map[string]interface{}) {
+	w.stamp(v)
+	if w.Tap.Subscribers() > 0 {
+		// the output may release the event to the pool while observers
+		// still look at it
+		w.Tap.Publish(copyEvent(v))
+	}
+	if w.shards != nil && w.shards.lanes != nil {
+		w.shards.route(v)
+		return
+	}
+	if w.BatchChannel != nil {
+		w.addToBatch(v)
+		return
+	}
+	if w.orderedQueue != nil {
+		w.enqueue(v)
+		return
+	}
+	atomic.AddInt64(&w.pending, 1)
+	go func() {
+		w.Channel <- v
+		atomic.AddInt64(&w.pending, -1)
+	}()
+}
+
+// processTyped parses a line into a typed event, putting it on the event
+// channel, or, if the sink does not take typed events, passes it to emit as a
+// map
+func (w *LogParser) processTyped(line string, emit func(map[string]interface{})) {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, utf8BOM), "\r")
+	match := w.Regex.FindStringSubmatch(line)
+	if match == nil {
+		err := &ParseError{Line: line, Pattern: w.pattern}
+		w.observeParse(line, err)
+		Counters.Inc("lines_unmatched")
+		reportError(err)
+		unmatchedLine(line, err)
+		return
+	}
+	w.observeParse(line, nil)
+	Counters.Inc("lines_parsed")
+	e := w.schema.Parse(match)
+	if w.EventChannel == nil {
+		v := e.Map()
+		e.Release()
+		emit(v)
+		return
+	}
+	if w.Tap.Subscribers() > 0 {
+		w.Tap.Publish(e.Map())
+	}
+	if w.orderedQueue != nil {
+		w.enqueue(e)
+		return
+	}
+	atomic.AddInt64(&w.pending, 1)
+	go func() {
+		w.EventChannel <- e
+		atomic.AddInt64(&w.pending, -1)
+	}()
 }
 
 // Start starts the LogWorker.
@@ -233,36 +763,111 @@ func (w *LogParser) Init() {
 func (w *LogParser) Start() {
 	logs.Info("Starting LOG PARSING process")
 	w.Init()
-
+	w.throttle = ConfiguredThrottle()
+	w.maxMemory = ConfiguredPipelineMaxMemory()
+	w.touch()
+	w.shardOnce.Do(func() {
+		if n := ConfiguredPipelineShards(); n > 1 {
+			key := ConfiguredPipelineShardKey()
+			if w.orderedQueue != nil {
+				// all events are in order already
+				key = ""
+			}
+			w.shards = w.startShards(n, key)
+		}
+	})
+	if interval := ConfiguredHeartbeatInterval(); interval > 0 {
+		// Start is run again if the parser is restarted after a panic
+		w.heartbeatOnce.Do(func() {
+			go Supervise("heartbeat", func() { w.heartbeat(interval) })
+		})
+	}
+	if interval := ConfiguredReportInterval(); interval > 0 && w.reporter != nil {
+		w.reportOnce.Do(func() {
+			go Supervise("report", func() { w.reportPeriodically(interval) })
+		})
+	}
 	inputFile := viper.GetString(configParseInputFile)
-	t, err := tail.TailFile(inputFile,
-		w.convertConfig())
-	if err != nil {
-		logs.Warn("Input file could not be opened: %s; error: %s", inputFile, err)
+	switch inputType := ConfiguredInputType(); inputType {
+	case "unix":
+		w.readUnixSocket(inputFile)
+	case "fifo":
+		w.readFIFO(inputFile)
+	case "exec":
+		w.readCommand()
+	case "http":
+		w.pollURL()
+	case "gelf":
+		w.readGELF()
+	case "redis":
+		w.readRedisStream()
+	case "sql":
+		w.pollSQL()
+	case "forward":
+		w.readForwarded()
+	default:
+		w.tailFile(inputFile)
+	}
+	logs.Info("Stopping worker process")
+}
 
+// readLine processes a line read from the input
+func (w *LogParser) readLine(text string) int64 {
+	w.throttle.Wait()
+	w.waitWhilePaused()
+	if w.maxMemory > 0 {
+		waitForMemory(w.maxMemory)
+	}
+	waitForDisk()
+	if w.shards != nil {
+		w.shards.dispatch(text)
 	} else {
-		w.tailer = t
-		for line := range t.Lines {
-			s := strings.TrimSpace(line.Text)
-			logs.Debug("Processing line %v", s)
-			v, err := w.ParseEvents(s)
-			if err == nil {
-				go func() {
-					w.Channel <- v
-				}()
-			}
+		w.ProcessLine(text)
+	}
+	w.touch()
+	return atomic.AddInt64(&w.linesRead, 1)
+}
+
+// tailFile reads lines from inputFile, following it as it grows
+func (w *LogParser) tailFile(inputFile string) {
+	config := w.convertConfig()
+	if w.tailer != nil {
+		// restarted after a panic: carry on where the previous tailer was
+		if offset, err := w.tailer.Tell(); err == nil {
+			config.Location = &tail.SeekInfo{Offset: offset, Whence: os.SEEK_SET}
+		}
+		w.tailer.Stop()
+	}
+	t, err := tail.TailFile(inputFile, config)
+	if err != nil {
+		logs.Warn("Input file could not be opened: %s; error: %s", inputFile, err)
+		return
+	}
+	w.tailer = t
+	checkpointEvery := ConfiguredCheckpointEvery()
+	for line := range t.Lines {
+		linesRead := w.readLine(line.Text)
+		if checkpointEvery > 0 && linesRead%checkpointEvery == 0 {
+			w.saveCheckpoint()
 		}
 	}
-	logs.Info("Stopping worker process")
 }
 
-// Stop stops the worker and cleans up. Does *not* stop ElasticSearchWorker
+// Stop stops the worker and cleans up, waiting for the polling inputs to
+// return. Does *not* stop ElasticSearchWorker
 func (w *LogParser) Stop() {
 	if w.tailer != nil {
+		w.saveCheckpoint()
 		//logs.Debug("Stopping tailer")
 		//err := w.tailer.Stop()
 		logs.Debug("Cleaning up tailer")
 		w.tailer.Cleanup()
 		logs.Debug("Done stopping tailer")
 	}
+	w.closeInput()
+	w.polling.Wait()
+	if w.BatchChannel != nil {
+		w.flushBatch(0)
+	}
+	w.tracer.close()
 }
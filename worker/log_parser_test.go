@@ -172,6 +172,39 @@ func TestParseDoubleStrings(t *testing.T) {
 	}
 }
 
+var numberPrecisionTestCases = []struct {
+	input       string
+	bigAsString bool
+	preferInt   bool
+	expected    interface{}
+}{
+	{"1234567890123456789", false, false, int64(1234567890123456789)},
+	{"1234567890123456789", true, false, "1234567890123456789"},
+	{"-1234567890123456789", true, false, "-1234567890123456789"},
+	{"12345678901234567890", false, false, 12345678901234567890.0},
+	{"12345678901234567890", true, false, "12345678901234567890"},
+	{"9007199254740992", true, false, int64(9007199254740992)},
+	{"9007199254740993", true, false, "9007199254740993"},
+	{"2.0", false, false, 2.0},
+	{"2.0", false, true, int64(2)},
+	{"1e3", false, true, int64(1000)},
+	{"2.5", false, true, 2.5},
+	{"1e20", true, true, 1e20},
+}
+
+func TestParseNumberPrecision(t *testing.T) {
+	defer viper.Reset()
+	for i, testCase := range numberPrecisionTestCases {
+		viper.Reset()
+		viper.Set("parse.numbers.big_as_string", testCase.bigAsString)
+		viper.Set("parse.numbers.prefer_int", testCase.preferInt)
+		actual := worker.ParseStringForValue(testCase.input)
+		if actual != testCase.expected {
+			t.Errorf("In test %d, ParseStringForValue(%v): expected %v (%T), actual %v (%T)", i+1, testCase.input, testCase.expected, testCase.expected, actual, actual)
+		}
+	}
+}
+
 type BoolFormatTest struct {
 	input    string
 	expected bool
@@ -193,3 +226,279 @@ func TestParseBool(t *testing.T) {
 		}
 	}
 }
+
+func TestParseURIDecomposition(t *testing.T) {
+	viper.Reset()
+	uri := "/a%20b/c/report.pdf?tag=x&tag=7&single=1"
+	m := make(map[string]interface{})
+	w := &worker.LogParser{}
+	w.Init()
+	w.ParseURI(uri, m)
+	if m["uri_path"] != "/a b/c/report.pdf" {
+		t.Errorf("expected uri_path to be %v, but was %v", "/a b/c/report.pdf", m["uri_path"])
+	}
+	if m["uri_query_raw"] != "tag=x&tag=7&single=1" {
+		t.Errorf("expected uri_query_raw to be %v, but was %v", "tag=x&tag=7&single=1", m["uri_query_raw"])
+	}
+	if m["uri_extension"] != "pdf" {
+		t.Errorf("expected uri_extension to be pdf, but was %v", m["uri_extension"])
+	}
+	segments := []interface{}{"a b", "c", "report.pdf"}
+	if !reflect.DeepEqual(m["uri_segments"], segments) {
+		t.Errorf("expected uri_segments to be %v, but was %v", segments, m["uri_segments"])
+	}
+	tags := []interface{}{"x", int64(7)}
+	if !reflect.DeepEqual(m["tag"], tags) {
+		t.Errorf("expected tag to be %v, but was %v", tags, m["tag"])
+	}
+	if m["single"] != int64(1) {
+		t.Errorf("expected single to be 1, but was %v", m["single"])
+	}
+}
+
+func TestParseURIMaxParams(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.uri.max_params", 2)
+	w := &worker.LogParser{}
+	w.Init()
+	first := make(map[string]interface{})
+	w.ParseURI("/?a=1&b=2", first)
+	if first["a"] != int64(1) || first["b"] != int64(2) || first["params_other"] != nil {
+		t.Errorf("expected a and b to be fields, but the event was %v", first)
+	}
+	second := make(map[string]interface{})
+	w.ParseURI("/?b=3&c=x&d=y", second)
+	other := map[string]interface{}{"c": "x", "d": "y"}
+	if second["b"] != int64(3) || second["c"] != nil || !reflect.DeepEqual(second["params_other"], other) {
+		t.Errorf("expected b to be a field, and c and d to be folded into %v, but the event was %v", other, second)
+	}
+}
+
+func TestParseURIParamAllowlistAndPrefix(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.uri.param_allowlist", []string{"q", "status"})
+	viper.Set("parse.uri.param_prefix", "query_")
+	w := &worker.LogParser{}
+	w.Init()
+	m := map[string]interface{}{"status": int64(200)}
+	w.ParseURI("/search?q=bob&status=500&evil=1", m)
+	expected := map[string]interface{}{"q": nil, "evil": nil, "query_q": "bob", "query_status": int64(500), "query_evil": nil, "status": int64(200)}
+	for key, value := range expected {
+		if m[key] != value {
+			t.Errorf("expected %s to be %v, but was %v", key, value, m[key])
+		}
+	}
+}
+
+func TestParseCookiesAndHeaders(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `(?P<cookie>[^#]*)#(?P<request_headers>.*)`)
+	viper.Set("parse.cookies", []string{"session_id"})
+	viper.Set("parse.headers", []string{"x-trace-id"})
+	w := &worker.LogParser{}
+	w.Init()
+	m, err := w.ParseEvents(`theme=dark; session_id="abc123"#Host: example.com|X-Trace-Id: 42-17`)
+	if err != nil {
+		t.Fatalf("Couldn't parse example line: %v", err)
+	}
+	if m["cookie_session_id"] != "abc123" {
+		t.Errorf("expected cookie_session_id to be abc123, but was %v", m["cookie_session_id"])
+	}
+	if _, found := m["cookie_theme"]; found {
+		t.Errorf("expected cookie_theme not to be found, since it is not whitelisted")
+	}
+	if m["header_x_trace_id"] != "42-17" {
+		t.Errorf("expected header_x_trace_id to be 42-17, but was %v", m["header_x_trace_id"])
+	}
+	if _, found := m["header_host"]; found {
+		t.Errorf("expected header_host not to be found, since it is not whitelisted")
+	}
+}
+
+var refererTestCases = []struct {
+	referer  string
+	host     interface{}
+	internal interface{}
+}{
+	{"http://www.archive.org/details/x?q=1", "www.archive.org", true},
+	{"https://ARCHIVE.org/", "ARCHIVE.org", true},
+	{"http://notarchive.org/", "notarchive.org", false},
+	{"-", nil, nil},
+}
+
+func TestParseReferer(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.referer.decompose", true)
+	viper.Set("parse.referer.internal_domains", []string{"archive.org"})
+	w := &worker.LogParser{}
+	w.Init()
+	for i, tt := range refererTestCases {
+		m := make(map[string]interface{})
+		w.ParseReferer("referer", tt.referer, m)
+		if m["referer_host"] != tt.host || m["referer_internal"] != tt.internal {
+			t.Errorf("In test %d, TestParseReferer(%v): expected host %v and internal %v, actual %v and %v",
+				i+1, tt.referer, tt.host, tt.internal, m["referer_host"], m["referer_internal"])
+		}
+	}
+}
+
+var transcodeTestCases = []struct {
+	input    string
+	charset  string
+	expected string
+}{
+	{"caf\xe9", "latin1", "café"},
+	{"\x93quoted\x94", "windows-1252", "“quoted”"},
+	{"caf\xe9", "auto", "café"},
+	{"café", "auto", "café"},
+	{"café", "", "café"},
+}
+
+func TestTranscodeLine(t *testing.T) {
+	for i, tt := range transcodeTestCases {
+		actual, err := worker.TranscodeLine(tt.input, tt.charset)
+		if err != nil || actual != tt.expected {
+			t.Errorf("In test %d, TranscodeLine(%q, %v): expected %q, actual %q (error: %v)",
+				i+1, tt.input, tt.charset, tt.expected, actual, err)
+		}
+	}
+	if _, err := worker.TranscodeLine("x", "klingon"); err == nil {
+		t.Errorf("expected an error for an unknown charset")
+	}
+}
+
+func TestProcessLineLatin1IsNotBinary(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.charset", "latin1")
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	before := worker.Counters.Get("lines_binary")
+	w.ProcessLine("\xe9t\xe9")
+	select {
+	case m := <-channel:
+		if m["line"] != "été" {
+			t.Errorf("expected the line été, got %v", m)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the latin1 line not to be dropped as binary")
+	}
+	if worker.Counters.Get("lines_binary") != before {
+		t.Errorf("expected the latin1 line not to be counted as binary")
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	if worker.TruncateLine("short", 10) != "short" {
+		t.Errorf("expected short line not to be truncated")
+	}
+	if worker.TruncateLine("abcdef", 3) != "abc" {
+		t.Errorf("expected abc, got %v", worker.TruncateLine("abcdef", 3))
+	}
+	// é is two bytes; it must not be split
+	if worker.TruncateLine("caféine", 4) != "caf" {
+		t.Errorf("expected caf, got %q", worker.TruncateLine("caféine", 4))
+	}
+}
+
+func TestProcessLineOversized(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.max_line_bytes", 4)
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.ProcessLine("abcdefgh")
+	m := <-channel
+	if m["line"] != "abcd" || m["truncated"] != true {
+		t.Errorf("expected truncated line abcd, got %v", m)
+	}
+	viper.Set("parse.oversized_policy", "drop")
+	before := worker.Counters.Get("lines_oversized_dropped")
+	w.ProcessLine("abcdefgh")
+	if worker.Counters.Get("lines_oversized_dropped") != before+1 {
+		t.Errorf("expected oversized line to be counted as dropped")
+	}
+}
+
+func TestProcessLineReportsParseError(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<n>\d+)$`)
+	w := &worker.LogParser{}
+	w.Init()
+	w.ProcessLine("abc")
+	// other tests may have reported errors too
+	for {
+		select {
+		case err := <-worker.Errors():
+			if e, ok := err.(*worker.ParseError); ok && e.Line == "abc" {
+				if e.Pattern != `^(?P<n>\d+)$` {
+					t.Errorf("expected the pattern in the error, got %v", e)
+				}
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected a ParseError to be reported")
+		}
+	}
+}
+
+func TestNonPrintableRatio(t *testing.T) {
+	if worker.NonPrintableRatio("GET /index.html\t200") != 0 {
+		t.Errorf("expected a text line to have no non-printable characters")
+	}
+	if worker.NonPrintableRatio("caf\xe9") != 0.25 {
+		t.Errorf("expected 0.25, got %v", worker.NonPrintableRatio("caf\xe9"))
+	}
+	if worker.NonPrintableRatio("\x00\x01\x02\xff") != 1 {
+		t.Errorf("expected 1, got %v", worker.NonPrintableRatio("\x00\x01\x02\xff"))
+	}
+}
+
+func TestProcessLineBinary(t *testing.T) {
+	viper.Reset()
+	w := &worker.LogParser{}
+	w.Init()
+	before := worker.Counters.Get("lines_binary")
+	w.ProcessLine("\x00\x00\x1f\x8b\x08\x00ab")
+	if worker.Counters.Get("lines_binary") != before+1 {
+		t.Errorf("expected binary line to be counted")
+	}
+}
+
+func TestParseEventsBOMAndCRLF(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<created>\S+) (?P<status>\d+)$`)
+	w := &worker.LogParser{}
+	w.Init()
+	for i, line := range []string{
+		"2011-07-26T00:00:00Z 200",
+		"\ufeff2011-07-26T00:00:00Z 200",
+		"2011-07-26T00:00:00Z 200\r",
+		"\ufeff2011-07-26T00:00:00Z 200\r",
+	} {
+		m, err := w.ParseEvents(line)
+		if err != nil {
+			t.Errorf("In test %d, couldn't parse %q: %v", i+1, line, err)
+			continue
+		}
+		if m["status"] != int64(200) {
+			t.Errorf("In test %d, expected status 200, got %v", i+1, m["status"])
+		}
+		if _, ok := m["created"].(time.Time); !ok {
+			t.Errorf("In test %d, expected created to be a time, got %v", i+1, m["created"])
+		}
+	}
+}
+
+func TestConfiguredTailPollInterval(t *testing.T) {
+	viper.Reset()
+	if worker.ConfiguredTailPollInterval() != 250*time.Millisecond {
+		t.Errorf("expected default poll interval to be 250ms, but was %v", worker.ConfiguredTailPollInterval())
+	}
+	viper.Set("tail.poll_interval", "2s")
+	if worker.ConfiguredTailPollInterval() != 2*time.Second {
+		t.Errorf("expected poll interval to be 2s, but was %v", worker.ConfiguredTailPollInterval())
+	}
+}
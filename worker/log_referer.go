@@ -0,0 +1,62 @@
+package worker
+
+/*
+	log_referer.go decomposes captured referer URLs
+
+	If parse.referer.decompose is set, and the log pattern captures a
+	`referer` (or `referrer`) field, the URL is split into <name>_host,
+	<name>_path and <name>_query, and classified as internal or external
+	(<name>_internal) using the configured internal domains.
+*/
+import (
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const configParseRefererDecompose = "parse.referer.decompose"
+const configParseRefererInternalDomains = "parse.referer.internal_domains"
+
+// isInternalHost returns true if host is one of the domains, or a
+// subdomain of one of them
+func isInternalHost(host string, domains []string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseReferer decomposes the referer URL found in field name and adds
+// its parts into the main map.
+func (w *LogParser) ParseReferer(name string, referer string, v map[string]interface{}) {
+	if !viper.GetBool(configParseRefererDecompose) || referer == "" || referer == "-" {
+		return
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return
+	}
+	fields := []struct {
+		key   string
+		value interface{}
+	}{
+		{name + "_host", u.Hostname()},
+		{name + "_path", u.Path},
+		{name + "_query", u.RawQuery},
+		{name + "_internal", isInternalHost(u.Hostname(), viper.GetStringSlice(configParseRefererInternalDomains))},
+	}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		newKey := newKeyName(field.key, v)
+		if !w.shouldIgnore(newKey) {
+			v[newKey] = field.value
+		}
+	}
+}
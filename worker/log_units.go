@@ -0,0 +1,98 @@
+package worker
+
+/*
+	log_units.go parses durations and byte sizes
+
+	Fields listed in parse.durations are parsed as Go-style durations (12ms,
+	1.5s, 3m20s) and converted to parse.duration_unit ("s", the default, for
+	float seconds; "ms" for float milliseconds; or "ns" for integer
+	nanoseconds).
+
+	Fields listed in parse.byte_sizes are parsed as byte sizes (300, 1.5MB,
+	300KiB) and converted to integer bytes. KB, MB, ... are powers of 1000;
+	KiB, MiB, ... are powers of 1024.
+
+	Values that cannot be parsed this way fall back to ParseStringForValue.
+*/
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const configParseDurations = "parse.durations"
+const configParseDurationUnit = "parse.duration_unit"
+const configParseByteSizes = "parse.byte_sizes"
+
+var byteSizeRegex = regexp.MustCompile(`^\s*([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)\s*$`)
+
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// ParseByteSize parses a byte size like 1.5MB or 300KiB into a number of
+// bytes
+func ParseByteSize(s string) (int64, error) {
+	match := byteSizeRegex.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("Invalid byte size: %s", s)
+	}
+	multiplier, found := byteSizeUnits[strings.ToLower(match[2])]
+	if !found {
+		return 0, fmt.Errorf("Invalid byte size unit: %s", match[2])
+	}
+	f, _ := strconv.ParseFloat(match[1], 64) // regex assures a number
+	return int64(math.Round(f * multiplier)), nil
+}
+
+// ParseDuration parses a duration like 12ms or 3m20s, and converts it to
+// the unit given ("s" or "ms" return a float64; "ns" returns an int64)
+func ParseDuration(s string, unit string) (interface{}, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(unit) {
+	case "ns":
+		return int64(d), nil
+	case "ms":
+		return float64(d) / float64(time.Millisecond), nil
+	case "s", "":
+		return d.Seconds(), nil
+	}
+	return nil, fmt.Errorf("Invalid duration unit: %s", unit)
+}
+
+// parseFieldValue parses the value of the named field, taking into account
+// the per-field duration and byte size configuration
+func parseFieldValue(name string, s string) interface{} {
+	if name != "" {
+		if sliceContains(viper.GetStringSlice(configParseDurations), name) {
+			if value, err := ParseDuration(s, viper.GetString(configParseDurationUnit)); err == nil {
+				return value
+			}
+		}
+		if sliceContains(viper.GetStringSlice(configParseByteSizes), name) {
+			if value, err := ParseByteSize(s); err == nil {
+				return value
+			}
+		}
+	}
+	return ParseStringForValue(s)
+}
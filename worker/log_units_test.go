@@ -0,0 +1,78 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var byteSizeTestCases = []struct {
+	input      string
+	expected   int64
+	should_err bool
+}{
+	{"300", 300, false},
+	{"12B", 12, false},
+	{"1.5MB", 1500000, false},
+	{"300KiB", 307200, false},
+	{"2 gib", 2147483648, false},
+	{"1.5XB", 0, true},
+	{"fast", 0, true},
+}
+
+func TestParseByteSize(t *testing.T) {
+	for i, tt := range byteSizeTestCases {
+		actual, err := worker.ParseByteSize(tt.input)
+		if tt.should_err && err == nil {
+			t.Errorf("In test %d, ParseByteSize(%v): expected error, actual %v", i+1, tt.input, actual)
+		}
+		if err == nil && actual != tt.expected {
+			t.Errorf("In test %d, ParseByteSize(%v): expected %v, actual %v", i+1, tt.input, tt.expected, actual)
+		}
+	}
+}
+
+var durationTestCases = []struct {
+	input    string
+	unit     string
+	expected interface{}
+}{
+	{"12ms", "s", 0.012},
+	{"1.5s", "", 1.5},
+	{"3m20s", "s", float64(200)},
+	{"1.5s", "ms", float64(1500)},
+	{"12ms", "ns", int64(12000000)},
+}
+
+func TestParseDuration(t *testing.T) {
+	for i, tt := range durationTestCases {
+		actual, err := worker.ParseDuration(tt.input, tt.unit)
+		if err != nil || actual != tt.expected {
+			t.Errorf("In test %d, ParseDuration(%v, %v): expected %v, actual %v (error: %v)",
+				i+1, tt.input, tt.unit, tt.expected, actual, err)
+		}
+	}
+}
+
+func TestParseEventsUnits(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `(?P<latency>\S+) (?P<size>\S+) (?P<other>\S+)`)
+	viper.Set("parse.durations", []string{"latency"})
+	viper.Set("parse.byte_sizes", []string{"size"})
+	w := &worker.LogParser{}
+	w.Init()
+	m, err := w.ParseEvents("250ms 2KiB 10ms")
+	if err != nil {
+		t.Fatalf("Couldn't parse example line: %v", err)
+	}
+	if m["latency"] != 0.25 {
+		t.Errorf("expected latency to be 0.25, but was %v", m["latency"])
+	}
+	if m["size"] != int64(2048) {
+		t.Errorf("expected size to be 2048, but was %v", m["size"])
+	}
+	if m["other"] != "10ms" {
+		t.Errorf("expected other to be left as 10ms, but was %v", m["other"])
+	}
+}
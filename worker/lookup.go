@@ -0,0 +1,243 @@
+package worker
+
+/*
+	lookup.go enriches events from local lookup tables
+
+	Lookup tables are declared in transform.lookups, e.g.
+
+		[transform.lookups.plans]
+		file = "/etc/translog/plans.csv"
+		field = "user_id"
+
+		[transform.lookups.status_text]
+		file = "/etc/translog/status.json"
+		field = "status"
+		on_miss = "default"
+		default = { status_text = "Unknown" }
+
+	A CSV file has a header row; the key is in the column named by key (the
+	first column by default), and the other columns of the matching row are
+	added to the event. A JSON file is an object from keys to values; a
+	value that is an object has its fields added to the event, and any other
+	value is added as a field named after the lookup table.
+
+	The value of the event field is looked up as a string (so a status of
+	404 matches the key "404"). When it isn't found, on_miss says what to
+	do: "skip" (the default) adds nothing, "default" adds the fields of
+	default, and "drop" drops the event.
+
+	Lookup files are reloaded when they change, which is checked at most
+	every few seconds; if a reload fails, the previous table is kept.
+	Lookups are applied after derived fields, in the alphabetical order of
+	their names.
+*/
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configTransformLookups = "transform.lookups"
+
+// lookupReloadInterval is how often lookup files are checked for changes
+const lookupReloadInterval = 5 * time.Second
+
+// lookupTable enriches events with the rows of a lookup file
+type lookupTable struct {
+	name     string
+	file     string
+	field    string
+	key      string
+	onMiss   string
+	defaults map[string]interface{}
+
+	rows      atomic.Value // map[string]map[string]interface{}
+	checkLock sync.Mutex
+	checked   int64 // when the file was last checked for changes, in Unix nanoseconds
+	modTime   time.Time
+}
+
+// ConfiguredLookups returns the lookup tables, loading their files
+func ConfiguredLookups() []*lookupTable {
+	config := viper.GetStringMap(configTransformLookups)
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tables := make([]*lookupTable, 0, len(names))
+	for _, name := range names {
+		key := configTransformLookups + "." + name
+		t := &lookupTable{
+			name:     name,
+			file:     viper.GetString(key + ".file"),
+			field:    viper.GetString(key + ".field"),
+			key:      viper.GetString(key + ".key"),
+			onMiss:   viper.GetString(key + ".on_miss"),
+			defaults: viper.GetStringMap(key + ".default"),
+		}
+		switch t.onMiss {
+		case "":
+			t.onMiss = "skip"
+		case "skip", "default", "drop":
+		default:
+			reportError(&ConfigError{Key: key + ".on_miss", Value: t.onMiss, Reason: "expected skip, default, or drop; using skip"})
+			t.onMiss = "skip"
+		}
+		if t.file == "" || t.field == "" {
+			reportError(&ConfigError{Key: key, Value: config[name], Reason: "a lookup needs a file and a field; ignoring it"})
+			continue
+		}
+		t.rows.Store(map[string]map[string]interface{}{})
+		if err := t.load(); err != nil {
+			reportError(&ConfigError{Key: key + ".file", Value: t.file, Reason: err.Error()})
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+// load reads the lookup file
+func (t *lookupTable) load() error {
+	info, err := os.Stat(t.file)
+	if err != nil {
+		return err
+	}
+	bs, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return err
+	}
+	var rows map[string]map[string]interface{}
+	if strings.EqualFold(filepath.Ext(t.file), ".json") {
+		rows, err = t.parseJSON(bs)
+	} else {
+		rows, err = t.parseCSV(bs)
+	}
+	if err != nil {
+		return err
+	}
+	t.rows.Store(rows)
+	t.modTime = info.ModTime()
+	atomic.StoreInt64(&t.checked, time.Now().UnixNano())
+	logs.Info("Loaded %d rows for lookup %s from %s", len(rows), t.name, t.file)
+	return nil
+}
+
+// parseCSV reads rows from a CSV file with a header row
+func (t *lookupTable) parseCSV(bs []byte) (map[string]map[string]interface{}, error) {
+	records, err := csv.NewReader(strings.NewReader(string(bs))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header row")
+	}
+	header := records[0]
+	keyColumn := 0
+	if t.key != "" {
+		keyColumn = -1
+		for i, name := range header {
+			if name == t.key {
+				keyColumn = i
+			}
+		}
+		if keyColumn < 0 {
+			return nil, fmt.Errorf("no column named %s", t.key)
+		}
+	}
+	rows := make(map[string]map[string]interface{}, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(header)-1)
+		for i, value := range record {
+			if i != keyColumn && i < len(header) {
+				row[header[i]] = ParseStringForValue(value)
+			}
+		}
+		rows[record[keyColumn]] = row
+	}
+	return rows, nil
+}
+
+// parseJSON reads rows from a JSON object
+func (t *lookupTable) parseJSON(bs []byte) (map[string]map[string]interface{}, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(bs, &object); err != nil {
+		return nil, err
+	}
+	rows := make(map[string]map[string]interface{}, len(object))
+	for key, value := range object {
+		if row, ok := value.(map[string]interface{}); ok {
+			rows[key] = row
+		} else {
+			rows[key] = map[string]interface{}{t.name: value}
+		}
+	}
+	return rows, nil
+}
+
+// reloadIfChanged reloads the lookup file if it has changed since it was
+// loaded, checking at most every lookupReloadInterval
+func (t *lookupTable) reloadIfChanged() {
+	if time.Since(time.Unix(0, atomic.LoadInt64(&t.checked))) < lookupReloadInterval {
+		return
+	}
+	t.checkLock.Lock()
+	defer t.checkLock.Unlock()
+	if time.Since(time.Unix(0, atomic.LoadInt64(&t.checked))) < lookupReloadInterval {
+		// another goroutine has just checked
+		return
+	}
+	atomic.StoreInt64(&t.checked, time.Now().UnixNano())
+	if info, err := os.Stat(t.file); err != nil || info.ModTime().Equal(t.modTime) {
+		return
+	}
+	if err := t.load(); err != nil {
+		logs.Warn("Could not reload lookup %s from %s; keeping the previous table: %v", t.name, t.file, err)
+	}
+}
+
+// apply adds the fields of the row matching the event; it returns false if
+// the event should be dropped
+func (t *lookupTable) apply(v map[string]interface{}) bool {
+	t.reloadIfChanged()
+	value, found := lookupField(v, t.field)
+	var row map[string]interface{}
+	if found && value != nil {
+		row = t.rows.Load().(map[string]map[string]interface{})[fmt.Sprint(value)]
+	}
+	if row == nil {
+		Counters.Inc("lookup_misses")
+		switch t.onMiss {
+		case "drop":
+			return false
+		case "default":
+			row = t.defaults
+		}
+	}
+	for key, value := range row {
+		v[key] = value
+	}
+	return true
+}
+
+// enrich applies the lookup tables to an event; it returns false if the
+// event should be dropped
+func (w *LogParser) enrich(v map[string]interface{}) bool {
+	for _, t := range w.lookups {
+		if !t.apply(v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package worker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var lookupTestCases = []struct {
+	line     string
+	expected map[string]interface{}
+}{
+	{"u1 404", map[string]interface{}{"user_id": "u1", "status": int64(404), "plan": "pro", "seats": int64(5), "status_text": "Not Found"}},
+	{"u2 500", map[string]interface{}{"user_id": "u2", "status": int64(500), "plan": "free", "seats": int64(1), "status_text": "Unknown"}},
+	{"u3 404", nil},
+}
+
+func TestLookups(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	plans := filepath.Join(dir, "plans.csv")
+	ioutil.WriteFile(plans, []byte("id,plan,seats\nu1,pro,5\nu2,free,1\n"), 0666)
+	statuses := filepath.Join(dir, "status.json")
+	ioutil.WriteFile(statuses, []byte(`{"404": "Not Found"}`), 0666)
+	viper.Set("parse.pattern", `^(?P<user_id>\w+) (?P<status>\d+)$`)
+	viper.Set("transform.lookups", map[string]interface{}{
+		"plans":       map[string]interface{}{"file": plans, "field": "user_id", "on_miss": "drop"},
+		"status_text": map[string]interface{}{"file": statuses, "field": "status", "on_miss": "default", "default": map[string]interface{}{"status_text": "Unknown"}},
+	})
+	channel := make(chan map[string]interface{}, 10)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	for i, tt := range lookupTestCases {
+		w.ProcessLine(tt.line)
+		var actual map[string]interface{}
+		select {
+		case actual = <-channel:
+		case <-time.After(100 * time.Millisecond):
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("In test %d, ProcessLine(%v): expected %v, actual %v", i, tt.line, tt.expected, actual)
+		}
+	}
+}
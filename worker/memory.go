@@ -0,0 +1,103 @@
+package worker
+
+/*
+	memory.go reduces and bounds the memory used by events
+
+	Event maps are pooled: the parser takes maps from the pool (pre-sized
+	from the number of capture groups), and outputs return them with
+	ReleaseEvent once they have serialized them. Outputs that keep events
+	after sending them (such as the live stream) must not release them.
+
+	pipeline.max_memory (e.g. "512MB") is a soft cap on the heap: while it
+	is exceeded, typically because the output can't keep up, the input
+	stops reading, so that the events in flight can drain.
+*/
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configPipelineMaxMemory = "pipeline.max_memory"
+
+// memoryCheckInterval is how often the heap size is checked
+const memoryCheckInterval = 100 * time.Millisecond
+
+var eventPool sync.Pool
+
+// heapInUse is the heap size, as of the last check
+var heapInUse int64
+
+var memoryMonitorOnce sync.Once
+
+// ConfiguredPipelineMaxMemory returns the soft cap on the heap, in bytes; 0
+// (the default) for none
+func ConfiguredPipelineMaxMemory() int64 {
+	if !viper.IsSet(configPipelineMaxMemory) {
+		return 0
+	}
+	size, err := ParseByteSize(viper.GetString(configPipelineMaxMemory))
+	if err != nil {
+		reportError(&ConfigError{Key: configPipelineMaxMemory, Value: viper.Get(configPipelineMaxMemory), Reason: err.Error()})
+		return 0
+	}
+	return size
+}
+
+// newEvent returns an empty event map, from the pool if possible
+func newEvent(size int) map[string]interface{} {
+	if v, ok := eventPool.Get().(map[string]interface{}); ok {
+		return v
+	}
+	return make(map[string]interface{}, size)
+}
+
+// ReleaseEvent returns an event map to the pool; the event must not be used
+// afterwards
+func ReleaseEvent(v map[string]interface{}) {
+	if v == nil {
+		return
+	}
+	for key := range v {
+		delete(v, key)
+	}
+	eventPool.Put(v)
+}
+
+// copyEvent returns a shallow copy of an event
+func copyEvent(v map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(v))
+	for key, value := range v {
+		c[key] = value
+	}
+	return c
+}
+
+// monitorMemory keeps heapInUse up to date
+func monitorMemory() {
+	var stats runtime.MemStats
+	for {
+		runtime.ReadMemStats(&stats)
+		atomic.StoreInt64(&heapInUse, int64(stats.HeapInuse))
+		time.Sleep(memoryCheckInterval)
+	}
+}
+
+// waitForMemory blocks while the heap is larger than maxMemory
+func waitForMemory(maxMemory int64) {
+	memoryMonitorOnce.Do(func() {
+		go monitorMemory()
+	})
+	if atomic.LoadInt64(&heapInUse) <= maxMemory {
+		return
+	}
+	logs.Info("Heap of %d bytes exceeds %s; pausing input", atomic.LoadInt64(&heapInUse), configPipelineMaxMemory)
+	Counters.Inc("memory_throttled")
+	for atomic.LoadInt64(&heapInUse) > maxMemory {
+		time.Sleep(memoryCheckInterval)
+	}
+}
@@ -0,0 +1,55 @@
+package worker_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestReleasedEventsAreReused(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<a>\w+)( (?P<b>\w+))?$`)
+	w := &worker.LogParser{}
+	w.Init()
+	v, err := w.ParseEvents("x y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker.ReleaseEvent(v)
+	if len(v) != 0 {
+		t.Errorf("expected a released event to be emptied, got %v", v)
+	}
+	v, err = w.ParseEvents("z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"a": "z", "b": ""}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected %v, actual %v", expected, v)
+	}
+}
+
+var maxMemoryTestCases = []struct {
+	setting  interface{}
+	expected int64
+}{
+	{nil, 0},
+	{"512MB", 512 * 1000 * 1000},
+	{"1GiB", 1024 * 1024 * 1024},
+	{"lots", 0},
+}
+
+func TestConfiguredPipelineMaxMemory(t *testing.T) {
+	for i, tt := range maxMemoryTestCases {
+		viper.Reset()
+		if tt.setting != nil {
+			viper.Set("pipeline.max_memory", tt.setting)
+		}
+		actual := worker.ConfiguredPipelineMaxMemory()
+		if actual != tt.expected {
+			t.Errorf("In test %d, ConfiguredPipelineMaxMemory(%v): expected %v, actual %v", i, tt.setting, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package worker
+
+/*
+	mqtt.go publishes events to an MQTT broker
+
+	Each event is published as JSON to the topic mqtt.topic, which may use
+	the same placeholders as es.index (e.g. "sites/{site}/events"). Field
+	values are stripped of the MQTT wildcard and level characters (+, #
+	and /), so that an event can't publish outside its topic; missing
+	fields become "unknown".
+
+	For TLS, use an ssl:// broker URL; mqtt.tls (or the shared tls section,
+	see tls.go) configures the certificate authority and client certificate.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configMQTTBroker = "mqtt.broker"
+const configMQTTClientID = "mqtt.client_id"
+const configMQTTUsername = "mqtt.username"
+const configMQTTPassword = "mqtt.password"
+const configMQTTTopic = "mqtt.topic"
+const configMQTTQoS = "mqtt.qos"
+const configMQTTRetain = "mqtt.retain"
+
+// mqttPublishTimeout limits how long to wait for a publication to be
+// acknowledged
+const mqttPublishTimeout = 10 * time.Second
+
+var mqttTopicReplacer = strings.NewReplacer("+", "_", "#", "_", "/", "_")
+
+// MQTTWorker publishes events to an MQTT broker
+type MQTTWorker struct {
+	WorkChannel chan map[string]interface{}
+	QuitChannel chan bool
+	client      mqtt.Client
+	startTime   time.Time
+	healthTracker
+}
+
+// ConfiguredMQTTBroker returns the URL of the broker
+func ConfiguredMQTTBroker() string {
+	if viper.IsSet(configMQTTBroker) {
+		return viper.GetString(configMQTTBroker)
+	}
+	return "tcp://localhost:1883"
+}
+
+// ConfiguredMQTTClientID returns the client ID to connect with
+func ConfiguredMQTTClientID() string {
+	if viper.IsSet(configMQTTClientID) {
+		return viper.GetString(configMQTTClientID)
+	}
+	hostname, _ := os.Hostname()
+	return "translog-" + hostname
+}
+
+// ConfiguredMQTTTopic returns the topic template
+func ConfiguredMQTTTopic() string {
+	if viper.IsSet(configMQTTTopic) {
+		return viper.GetString(configMQTTTopic)
+	}
+	return "translog/events"
+}
+
+// ConfiguredMQTTQoS returns the quality of service: 0 (at most once), 1 (at
+// least once), or 2 (exactly once)
+func ConfiguredMQTTQoS() byte {
+	if viper.IsSet(configMQTTQoS) {
+		if qos := viper.GetInt(configMQTTQoS); qos >= 0 && qos <= 2 {
+			return byte(qos)
+		}
+		reportError(&ConfigError{Key: configMQTTQoS, Value: viper.Get(configMQTTQoS), Reason: "using 0"})
+	}
+	return 0
+}
+
+// MQTTTopic fills in the placeholders of a topic template from an event
+func MQTTTopic(template string, v map[string]interface{}) string {
+	return fillTemplate(template, v, "unknown", mqttTopicReplacer.Replace)
+}
+
+func (w *MQTTWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *MQTTWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	options := mqtt.NewClientOptions().
+		AddBroker(ConfiguredMQTTBroker()).
+		SetClientID(ConfiguredMQTTClientID()).
+		SetCredentialsProvider(func() (string, string) {
+			// read on every connection, so that rotated credentials are used
+			return refreshedString(configMQTTUsername), refreshedString(configMQTTPassword)
+		}).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			w.failed(&OutputError{Destination: ConfiguredMQTTBroker(), Err: err})
+		})
+	tlsConfig, err := configuredTLS("mqtt")
+	if err != nil {
+		logs.Warn("Invalid MQTT TLS configuration: %v", err)
+		return
+	}
+	if tlsConfig != nil {
+		options.SetTLSConfig(tlsConfig)
+	}
+	w.client = mqtt.NewClient(options)
+	// with SetConnectRetry, Connect keeps trying in the background
+	w.client.Connect()
+	return
+}
+
+// Start the work
+func (w *MQTTWorker) Start() {
+	go Supervise("MQTTWorker", w.Work)
+}
+
+// publish publishes an event, waiting for it to be acknowledged
+func (w *MQTTWorker) publish(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	payload, err := json.Marshal(obj)
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	topic := MQTTTopic(ConfiguredMQTTTopic(), obj)
+	start := time.Now()
+	token := w.client.Publish(topic, ConfiguredMQTTQoS(), viper.GetBool(configMQTTRetain), payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		err = fmt.Errorf("Timed out publishing to %s", topic)
+	} else {
+		err = token.Error()
+	}
+	if err != nil {
+		w.failed(&OutputError{Destination: topic, Err: err})
+		deadLetterDocument(string(payload), "rejected", err.Error())
+		return
+	}
+	w.succeeded(time.Since(start))
+}
+
+// Work the queue
+func (w *MQTTWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("MQTTWorker starting work at %v", w.startTime)
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			logs.Debug("Worker received: %v", obj)
+			w.publish(obj)
+
+		case <-w.QuitChannel:
+			logs.Info("MQTTWorker received quit")
+			return
+		}
+	}
+}
+
+// Stop stops the worker, and disconnects from the broker
+func (w *MQTTWorker) Stop() {
+	w.QuitChannel <- true
+	if w.client != nil {
+		w.client.Disconnect(250)
+	}
+}
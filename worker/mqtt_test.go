@@ -0,0 +1,27 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/willf/translog/worker"
+)
+
+var mqttTopicTestCases = []struct {
+	template string
+	event    map[string]interface{}
+	expected string
+}{
+	{"translog/events", map[string]interface{}{}, "translog/events"},
+	{"sites/{site}/events", map[string]interface{}{"site": "Boston"}, "sites/Boston/events"},
+	{"sites/{site}/events", map[string]interface{}{"site": "a/#"}, "sites/a__/events"},
+	{"sites/{site}/events", map[string]interface{}{}, "sites/unknown/events"},
+}
+
+func TestMQTTTopic(t *testing.T) {
+	for i, tt := range mqttTopicTestCases {
+		actual := worker.MQTTTopic(tt.template, tt.event)
+		if actual != tt.expected {
+			t.Errorf("In test %d, MQTTTopic(%v, %v): expected %v, actual %v", i+1, tt.template, tt.event, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package worker
+
+/*
+	null.go counts, and optionally validates, events without sending them
+	anywhere
+
+	translog null is for benchmarking ingestion, and for trying a
+	configuration on production traffic. Every null.report_every (10s by
+	default), it logs how many events it received, and how fast.
+
+	Events can also be validated: each of null.required_fields (which may
+	be dotted, e.g. "http.status") must be present, and, with
+	null.require_timestamp, each event must have a timestamp (see
+	timestampFields). A timestamp more than null.max_past behind or
+	null.max_future ahead of the wall clock is invalid too (neither is
+	checked by default). Invalid events are counted as null_invalid, and
+	dead-lettered with the reason "invalid", listing what is wrong with them.
+*/
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configNullRequiredFields = "null.required_fields"
+const configNullRequireTimestamp = "null.require_timestamp"
+const configNullMaxPast = "null.max_past"
+const configNullMaxFuture = "null.max_future"
+const configNullReportEvery = "null.report_every"
+
+// ConfiguredNullReportEvery returns how often the null output logs its
+// counts
+func ConfiguredNullReportEvery() time.Duration {
+	if viper.IsSet(configNullReportEvery) {
+		if every := viper.GetDuration(configNullReportEvery); every > 0 {
+			return every
+		}
+		reportError(&ConfigError{Key: configNullReportEvery, Value: viper.Get(configNullReportEvery), Reason: "using 10s"})
+	}
+	return 10 * time.Second
+}
+
+// EventValidator checks events for the null output
+type EventValidator struct {
+	RequiredFields   []string
+	RequireTimestamp bool
+	MaxPast          time.Duration // 0 for no limit
+	MaxFuture        time.Duration // 0 for no limit
+}
+
+// ConfiguredEventValidator returns the checks of the null output
+func ConfiguredEventValidator() EventValidator {
+	return EventValidator{
+		RequiredFields:   viper.GetStringSlice(configNullRequiredFields),
+		RequireTimestamp: viper.GetBool(configNullRequireTimestamp),
+		MaxPast:          viper.GetDuration(configNullMaxPast),
+		MaxFuture:        viper.GetDuration(configNullMaxFuture),
+	}
+}
+
+// Validate returns what is wrong with an event, if anything
+func (c EventValidator) Validate(v map[string]interface{}, now time.Time) (problems []string) {
+	for _, field := range c.RequiredFields {
+		if value, found := lookupField(v, field); !found || value == nil {
+			problems = append(problems, "missing "+field)
+		}
+	}
+	t, found := eventTimestamp(v)
+	switch {
+	case !found:
+		if c.RequireTimestamp {
+			problems = append(problems, "no timestamp")
+		}
+	case c.MaxPast > 0 && now.Sub(t) > c.MaxPast:
+		problems = append(problems, fmt.Sprintf("timestamp %v is more than %v old", t.Format(time.RFC3339), c.MaxPast))
+	case c.MaxFuture > 0 && t.Sub(now) > c.MaxFuture:
+		problems = append(problems, fmt.Sprintf("timestamp %v is more than %v ahead", t.Format(time.RFC3339), c.MaxFuture))
+	}
+	return problems
+}
+
+// NullWorker counts and validates events, and discards them
+type NullWorker struct {
+	WorkChannel  chan map[string]interface{}
+	EventChannel chan *Event
+	QuitChannel  chan bool
+	validator    EventValidator
+	events       int64
+	invalid      int64
+	startTime    time.Time
+}
+
+func (w *NullWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+// SetEventChannel sets the channel typed events are received on
+func (w *NullWorker) SetEventChannel(channel chan *Event) {
+	w.EventChannel = channel
+}
+
+func (w *NullWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.validator = ConfiguredEventValidator()
+	return
+}
+
+// Start the work
+func (w *NullWorker) Start() {
+	go Supervise("NullWorker", w.Work)
+}
+
+// check counts and validates an event
+func (w *NullWorker) check(v map[string]interface{}) {
+	w.events++
+	Counters.Inc("null_events")
+	problems := w.validator.Validate(v, time.Now())
+	if len(problems) == 0 {
+		return
+	}
+	w.invalid++
+	Counters.Inc("null_invalid")
+	DeadLetter(DeadLetterRecord{Reason: "invalid", Event: copyEvent(v), Error: strings.Join(problems, "; ")})
+}
+
+// report logs the counts since the worker started
+func (w *NullWorker) report() {
+	elapsed := time.Since(w.startTime)
+	logs.Info("NullWorker received %d events in %v (%.0f/s), %d invalid", w.events, elapsed.Round(time.Second), float64(w.events)/elapsed.Seconds(), w.invalid)
+}
+
+// Work the queue
+func (w *NullWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("NullWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredNullReportEvery())
+	defer ticker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.check(obj)
+			ReleaseEvent(obj)
+
+		case e := <-w.EventChannel:
+			v := e.Map()
+			e.Release()
+			w.check(v)
+
+		case <-ticker.C:
+			w.report()
+
+		case <-w.QuitChannel:
+			w.report()
+			return
+		}
+	}
+}
+
+// Stop stops the worker
+func (w *NullWorker) Stop() {
+	w.QuitChannel <- true
+}
@@ -0,0 +1,54 @@
+package worker_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var validateTestCases = []struct {
+	event    map[string]interface{}
+	expected []string
+}{
+	{map[string]interface{}{"host": "web1", "http": map[string]interface{}{"status": 200}, "created": time.Now()}, nil},
+	{map[string]interface{}{"host": nil, "created": time.Now()}, []string{"missing host", "missing http.status"}},
+	{map[string]interface{}{"host": "web1", "http": map[string]interface{}{"status": 200}}, []string{"no timestamp"}},
+	{map[string]interface{}{"host": "web1", "http": map[string]interface{}{"status": 200}, "created": time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+		[]string{"timestamp 2001-01-01T00:00:00Z is more than 24h0m0s old"}},
+}
+
+func TestValidate(t *testing.T) {
+	validator := worker.EventValidator{RequiredFields: []string{"host", "http.status"}, RequireTimestamp: true, MaxPast: 24 * time.Hour, MaxFuture: time.Minute}
+	for i, tt := range validateTestCases {
+		actual := validator.Validate(tt.event, time.Now())
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("In test %d, Validate(%v): expected %v, actual %v", i, tt.event, tt.expected, actual)
+		}
+	}
+}
+
+func TestNullWorker(t *testing.T) {
+	viper.Reset()
+	viper.Set("null.required_fields", []string{"n"})
+	w := &worker.NullWorker{}
+	channel := make(chan map[string]interface{})
+	w.SetWorkChannel(channel)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	w.Start()
+	events, invalid := worker.Counters.Get("null_events"), worker.Counters.Get("null_invalid")
+	channel <- map[string]interface{}{"n": 1}
+	channel <- map[string]interface{}{"m": 2}
+	w.Stop()
+	if worker.Counters.Get("null_events") != events+2 || worker.Counters.Get("null_invalid") != invalid+1 {
+		t.Errorf("expected 2 events, 1 of them invalid, actual %d and %d", worker.Counters.Get("null_events")-events, worker.Counters.Get("null_invalid")-invalid)
+	}
+	recent := worker.RecentDeadLetters()
+	if last := recent[len(recent)-1]; last.Reason != "invalid" || last.Error != "missing n" || last.Event["m"] != 2 {
+		t.Errorf("expected the invalid event to be dead-lettered, actual %v", last)
+	}
+}
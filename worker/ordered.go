@@ -0,0 +1,72 @@
+package worker
+
+/*
+	ordered.go delivers events to the output in the order they were read
+
+	By default, each event is handed to the output by a goroutine of its
+	own, so that a slow output doesn't hold up parsing, but events may
+	reach the output out of order. With pipeline.ordered set, events are
+	queued instead (up to pipeline.ordered_buffer of them, after which the
+	input waits), and a single goroutine hands them to the output in the
+	order they were read. Batches (see batch.go) are always delivered in
+	order.
+*/
+import (
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+)
+
+const configPipelineOrdered = "pipeline.ordered"
+const configPipelineOrderedBuffer = "pipeline.ordered_buffer"
+
+// ConfiguredPipelineOrdered returns true if events are delivered in order
+func ConfiguredPipelineOrdered() bool {
+	if viper.IsSet(configPipelineOrdered) {
+		return viper.GetBool(configPipelineOrdered)
+	}
+	return false
+}
+
+// ConfiguredPipelineOrderedBuffer returns how many events may wait to be
+// delivered in order
+func ConfiguredPipelineOrderedBuffer() int {
+	if viper.IsSet(configPipelineOrderedBuffer) {
+		if size := viper.GetInt(configPipelineOrderedBuffer); size > 0 {
+			return size
+		}
+		reportError(&ConfigError{Key: configPipelineOrderedBuffer, Value: viper.Get(configPipelineOrderedBuffer), Reason: "using 1000"})
+	}
+	return 1000
+}
+
+// startOrdered starts delivering events in order, if configured
+func (w *LogParser) startOrdered() {
+	if !ConfiguredPipelineOrdered() {
+		return
+	}
+	w.orderedOnce.Do(func() {
+		w.orderedQueue = make(chan interface{}, ConfiguredPipelineOrderedBuffer())
+		go Supervise("ordered delivery", w.deliverOrdered)
+	})
+}
+
+// enqueue queues an event (a map, or a typed *Event) for delivery in order
+func (w *LogParser) enqueue(v interface{}) {
+	atomic.AddInt64(&w.pending, 1)
+	w.orderedQueue <- v
+}
+
+// deliverOrdered puts the queued events on the shared channel (or the typed
+// event channel), one at a time
+func (w *LogParser) deliverOrdered() {
+	for v := range w.orderedQueue {
+		switch v := v.(type) {
+		case *Event:
+			w.EventChannel <- v
+		case map[string]interface{}:
+			w.Channel <- v
+		}
+		atomic.AddInt64(&w.pending, -1)
+	}
+}
@@ -0,0 +1,34 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestOrderedDelivery(t *testing.T) {
+	viper.Reset()
+	viper.Set("input.type", "exec")
+	viper.Set("input.command", "seq 1 500")
+	viper.Set("input.restart", false)
+	viper.Set("pipeline.ordered", true)
+	viper.Set("pipeline.ordered_buffer", 10)
+	viper.Set("parse.pattern", `^(?P<n>\d+)$`)
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	go w.Start()
+	defer w.Stop()
+	for i := int64(1); i <= 500; i++ {
+		select {
+		case v := <-channel:
+			if v["n"] != i {
+				t.Fatalf("expected event %d, actual %v", i, v["n"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected event %d", i)
+		}
+	}
+}
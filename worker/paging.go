@@ -0,0 +1,301 @@
+package worker
+
+/*
+	paging.go creates alerts in PagerDuty or Opsgenie
+
+	translog pagerduty triggers PagerDuty incidents through the Events API
+	v2, with the integration key pagerduty.routing_key; translog opsgenie
+	creates Opsgenie alerts, with the API key opsgenie.api_key (set
+	opsgenie.url to https://api.eu.opsgenie.com/v2/alerts for the EU
+	instance). Each takes the events matching its where condition, as in
+	alert rules (see alert.go; every event, if it is empty), and, with
+	alerts_only, only the events of alert rules that fired.
+
+	Repeated events are folded into one incident (or alert) by their dedup
+	key (the alias, for Opsgenie): dedup_key (alias) is a template over the
+	event's fields, e.g. "{service}-{error.fingerprint}"; by default, it is
+	the name of the alert rule, for alert events, and error.fingerprint
+	(see transform_fingerprint.go), for the others, or left to the service
+	if the event has neither.
+
+	The summary (message) is the summary (message) template, or the
+	message field of the event, and the whole event is attached as custom
+	details. The severity (priority) is the fixed severity (priority), if
+	set, or follows the level of the event (see severity.go): error (P3)
+	if the event has none. Requests go through pagerduty.proxy or
+	opsgenie.proxy, if set (see proxy.go); events the service rejects are
+	dead-lettered.
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configPagerDutyRoutingKey = "pagerduty.routing_key"
+const configPagerDutyURL = "pagerduty.url"
+const configPagerDutyDedupKey = "pagerduty.dedup_key"
+const configPagerDutySummary = "pagerduty.summary"
+const configPagerDutySeverity = "pagerduty.severity"
+const configOpsgenieAPIKey = "opsgenie.api_key"
+const configOpsgenieURL = "opsgenie.url"
+const configOpsgenieAlias = "opsgenie.alias"
+const configOpsgenieMessage = "opsgenie.message"
+const configOpsgeniePriority = "opsgenie.priority"
+
+// pagerDutySeverities maps syslog severities to PagerDuty severities
+var pagerDutySeverities = []string{"critical", "critical", "critical", "error", "warning", "info", "info", "info"}
+
+// opsgeniePriorities maps syslog severities to Opsgenie priorities
+var opsgeniePriorities = []string{"P1", "P1", "P2", "P3", "P4", "P5", "P5", "P5"}
+
+// opsgenieMaxMessage is the longest message Opsgenie accepts
+const opsgenieMaxMessage = 130
+
+// pagerDutyMaxSummary is the longest summary PagerDuty accepts
+const pagerDutyMaxSummary = 1024
+
+// ConfiguredPagerDutyURL returns the URL of the Events API
+func ConfiguredPagerDutyURL() string {
+	if viper.IsSet(configPagerDutyURL) {
+		return viper.GetString(configPagerDutyURL)
+	}
+	return "https://events.pagerduty.com/v2/enqueue"
+}
+
+// ConfiguredOpsgenieURL returns the URL alerts are created with
+func ConfiguredOpsgenieURL() string {
+	if viper.IsSet(configOpsgenieURL) {
+		return viper.GetString(configOpsgenieURL)
+	}
+	return "https://api.opsgenie.com/v2/alerts"
+}
+
+// DedupKey returns the key repeated events are folded by: the template
+// filled in from the event, if there is one, or else the name of the alert
+// rule, or error.fingerprint; it is empty if the event has neither
+func DedupKey(template string, v map[string]interface{}) string {
+	if template != "" {
+		return FillTemplate(template, v)
+	}
+	if alert, found := v["alert"]; found {
+		return "translog-alert-" + fmt.Sprint(alert)
+	}
+	if fingerprint, found := lookupField(v, "error.fingerprint"); found {
+		return fmt.Sprint(fingerprint)
+	}
+	return ""
+}
+
+// pagingSummary returns the template filled in from the event, if there is
+// one, or the first line of its message, or else the event as JSON,
+// shortened to max bytes
+func pagingSummary(template string, v map[string]interface{}, max int) string {
+	var summary string
+	if template != "" {
+		summary = FillTemplate(template, v)
+	} else if message, found := v["message"]; found && message != nil {
+		summary = strings.SplitN(fmt.Sprint(message), "\n", 2)[0]
+	} else {
+		bs, _ := json.Marshal(v)
+		summary = string(bs)
+	}
+	if len(summary) > max {
+		summary = summary[:max]
+	}
+	return summary
+}
+
+// pagingSeverity returns the syslog severity of the event, error if it has
+// none
+func pagingSeverity(v map[string]interface{}) int {
+	if level, found := lookupField(v, "level"); found {
+		if severity, ok := SyslogSeverity(level); ok {
+			return severity
+		}
+	}
+	return 3
+}
+
+// pagingSource returns the host of the event, or this host
+func pagingSource(v map[string]interface{}) string {
+	if host, found := lookupField(v, "host"); found && host != nil {
+		return fmt.Sprint(host)
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// PagerDutyEvent converts an event into a PagerDuty Events API v2 trigger
+func PagerDutyEvent(v map[string]interface{}) map[string]interface{} {
+	severity := viper.GetString(configPagerDutySeverity)
+	if severity == "" {
+		severity = pagerDutySeverities[pagingSeverity(v)]
+	}
+	timestamp, found := eventTimestamp(v)
+	if !found {
+		timestamp = time.Now()
+	}
+	e := map[string]interface{}{
+		"routing_key":  viper.GetString(configPagerDutyRoutingKey),
+		"event_action": "trigger",
+		"payload": map[string]interface{}{
+			"summary":        pagingSummary(viper.GetString(configPagerDutySummary), v, pagerDutyMaxSummary),
+			"source":         pagingSource(v),
+			"severity":       severity,
+			"timestamp":      timestamp.UTC().Format(time.RFC3339Nano),
+			"custom_details": v,
+		},
+	}
+	if key := DedupKey(viper.GetString(configPagerDutyDedupKey), v); key != "" {
+		e["dedup_key"] = key
+	}
+	return e
+}
+
+// OpsgenieAlert converts an event into an Opsgenie alert
+func OpsgenieAlert(v map[string]interface{}) map[string]interface{} {
+	priority := viper.GetString(configOpsgeniePriority)
+	if priority == "" {
+		priority = opsgeniePriorities[pagingSeverity(v)]
+	}
+	details := make(map[string]string, len(v))
+	for key, value := range v {
+		details[key] = fmt.Sprint(value)
+	}
+	a := map[string]interface{}{
+		"message":  pagingSummary(viper.GetString(configOpsgenieMessage), v, opsgenieMaxMessage),
+		"source":   pagingSource(v),
+		"priority": priority,
+		"details":  details,
+	}
+	if trace, found := findStackTrace(v); found {
+		a["description"] = trace
+	}
+	if alias := DedupKey(viper.GetString(configOpsgenieAlias), v); alias != "" {
+		a["alias"] = alias
+	}
+	return a
+}
+
+// PagingWorker creates alerts in PagerDuty or Opsgenie
+type PagingWorker struct {
+	Service     string // pagerduty or opsgenie
+	WorkChannel chan map[string]interface{}
+	QuitChannel chan bool
+	client      *http.Client
+	where       func(v map[string]interface{}) bool
+	alertsOnly  bool
+	startTime   time.Time
+	healthTracker
+}
+
+func (w *PagingWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *PagingWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	key := w.Service + ".where"
+	w.where, err = CompileCondition(viper.GetString(key))
+	if err != nil {
+		reportError(&ConfigError{Key: key, Value: viper.Get(key), Reason: err.Error() + "; sending every event"})
+		w.where, _ = CompileCondition("")
+	}
+	w.alertsOnly = viper.GetBool(w.Service + ".alerts_only")
+	transport, err := configuredProxyTransport(w.Service)
+	if err != nil {
+		logs.Warn("Invalid %s proxy: %v", w.Service, err)
+	}
+	w.client = &http.Client{Timeout: notifyTimeout, Transport: transport}
+	return nil
+}
+
+// Start the work
+func (w *PagingWorker) Start() {
+	go Supervise("PagingWorker", w.Work)
+}
+
+// request returns the request creating an alert for the event
+func (w *PagingWorker) request(v map[string]interface{}) (*http.Request, error) {
+	var url string
+	var body map[string]interface{}
+	switch w.Service {
+	case "opsgenie":
+		url, body = ConfiguredOpsgenieURL(), OpsgenieAlert(v)
+	default:
+		url, body = ConfiguredPagerDutyURL(), PagerDutyEvent(v)
+	}
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Service == "opsgenie" {
+		req.Header.Set("Authorization", "GenieKey "+viper.GetString(configOpsgenieAPIKey))
+	}
+	return req, nil
+}
+
+// send creates an alert for an event, if it is to be sent
+func (w *PagingWorker) send(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	if _, alert := obj["alert"]; (w.alertsOnly && !alert) || !w.where(obj) {
+		Counters.Inc("paging_skipped")
+		return
+	}
+	req, err := w.request(obj)
+	if err != nil {
+		logs.Info("Unable to create an alert for %v: %v", obj, err)
+		return
+	}
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		w.failed(&OutputError{Destination: w.Service, Err: err})
+		// the event, rather than the request, which holds the routing key
+		DeadLetter(DeadLetterRecord{Reason: "rejected", Event: copyEvent(obj), Error: err.Error()})
+		return
+	}
+	Counters.Inc("paging_alerts")
+	w.succeeded(time.Since(start))
+}
+
+// Work the queue
+func (w *PagingWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("PagingWorker (%s) starting work at %v", w.Service, w.startTime)
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			logs.Debug("Worker received: %v", obj)
+			w.send(obj)
+
+		case <-w.QuitChannel:
+			logs.Info("PagingWorker received quit")
+			return
+		}
+	}
+}
+
+// Stop stops the worker
+func (w *PagingWorker) Stop() {
+	w.QuitChannel <- true
+}
@@ -0,0 +1,95 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var dedupKeyTestCases = []struct {
+	template string
+	event    map[string]interface{}
+	expected string
+}{
+	{"", map[string]interface{}{"alert": "server_errors", "count": 51}, "translog-alert-server_errors"},
+	{"", map[string]interface{}{"error.fingerprint": "f00"}, "f00"},
+	{"", map[string]interface{}{"error": map[string]interface{}{"fingerprint": "f00"}}, "f00"},
+	{"", map[string]interface{}{"message": "no key"}, ""},
+	{"{service}-{error.fingerprint}", map[string]interface{}{"service": "api", "error.fingerprint": "f00"}, "api-f00"},
+}
+
+func TestDedupKey(t *testing.T) {
+	for i, tt := range dedupKeyTestCases {
+		actual := worker.DedupKey(tt.template, tt.event)
+		if actual != tt.expected {
+			t.Errorf("In test %d, DedupKey(%v, %v): expected %v, actual %v", i, tt.template, tt.event, tt.expected, actual)
+		}
+	}
+}
+
+func TestPagerDutyEvent(t *testing.T) {
+	viper.Reset()
+	viper.Set("pagerduty.routing_key", "key")
+	v := map[string]interface{}{"message": "disk full\ndetails", "level": "crit", "host": "db1", "error.fingerprint": "f00"}
+	e := worker.PagerDutyEvent(v)
+	payload := e["payload"].(map[string]interface{})
+	if e["routing_key"] != "key" || e["event_action"] != "trigger" || e["dedup_key"] != "f00" {
+		t.Errorf("PagerDutyEvent(%v): unexpected event %v", v, e)
+	}
+	if payload["summary"] != "disk full" || payload["severity"] != "critical" || payload["source"] != "db1" {
+		t.Errorf("PagerDutyEvent(%v): unexpected payload %v", v, payload)
+	}
+	viper.Set("pagerduty.severity", "warning")
+	if severity := worker.PagerDutyEvent(v)["payload"].(map[string]interface{})["severity"]; severity != "warning" {
+		t.Errorf("PagerDutyEvent(%v) with pagerduty.severity: expected warning, actual %v", v, severity)
+	}
+}
+
+func TestOpsgenieWorker(t *testing.T) {
+	viper.Reset()
+	alerts := make(chan map[string]interface{}, 10)
+	auth := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		bs, _ := io.ReadAll(req.Body)
+		var a map[string]interface{}
+		json.Unmarshal(bs, &a)
+		auth <- req.Header.Get("Authorization")
+		alerts <- a
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	viper.Set("opsgenie.url", server.URL)
+	viper.Set("opsgenie.api_key", "secret")
+	viper.Set("opsgenie.alerts_only", true)
+
+	w := &worker.PagingWorker{Service: "opsgenie"}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"level": "error", "message": "not an alert"}
+	w.WorkChannel <- map[string]interface{}{"alert": "server_errors", "count": int64(51), "message": "51 server errors in the last minute"}
+
+	select {
+	case a := <-alerts:
+		if a["alias"] != "translog-alert-server_errors" || a["message"] != "51 server errors in the last minute" || a["priority"] != "P3" {
+			t.Errorf("PagingWorker: unexpected alert %v", a)
+		}
+		if header := <-auth; header != "GenieKey secret" {
+			t.Errorf("PagingWorker: expected Authorization GenieKey secret, actual %q", header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PagingWorker: timed out waiting for the alert")
+	}
+	select {
+	case a := <-alerts:
+		t.Errorf("PagingWorker: expected one alert, actual another: %v", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
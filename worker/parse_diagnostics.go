@@ -0,0 +1,239 @@
+package worker
+
+/*
+	parse_diagnostics.go raises an alarm when too many lines fail to parse
+
+	The lines that fail to parse are counted over windows of
+	parse.failure_window (1m by default). When, in a window, the share of
+	lines that failed exceeds parse.failure_threshold (0.1 by default; 0
+	disables the alarm), a diagnostic is logged, counted as
+	parse_failure_alarms, and kept for the admin API (/diagnostics). It
+	shows, for the first parse.failure_samples lines that failed in the
+	window, where the pattern diverges from the line: the longest prefix of
+	the pattern that matches the line, the part of the line it matched, and
+	the rest of the pattern and of the line, e.g., for the pattern
+	^(?P<ip>\S+) (?P<status>\d+)$,
+
+		line "10.0.0.1 - 200": matched "10.0.0.1 " with `^(?P<ip>\S+) `,
+		then `(?P<status>\d+)$` failed at "- 200"
+
+	With codecs other than regex and grok, the samples show the decoding
+	errors instead.
+*/
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configParseFailureThreshold = "parse.failure_threshold"
+const configParseFailureWindow = "parse.failure_window"
+const configParseFailureSamples = "parse.failure_samples"
+
+// ConfiguredParseFailureThreshold returns the share of lines failing to
+// parse above which an alarm is raised; 0 disables the alarm
+func ConfiguredParseFailureThreshold() float64 {
+	if viper.IsSet(configParseFailureThreshold) {
+		threshold := viper.GetFloat64(configParseFailureThreshold)
+		if threshold >= 0 && threshold <= 1 {
+			return threshold
+		}
+		reportError(&ConfigError{Key: configParseFailureThreshold, Value: viper.Get(configParseFailureThreshold), Reason: "using 0.1"})
+	}
+	return 0.1
+}
+
+// ConfiguredParseFailureWindow returns the window the failure rate is
+// computed over
+func ConfiguredParseFailureWindow() time.Duration {
+	if viper.IsSet(configParseFailureWindow) {
+		if window := viper.GetDuration(configParseFailureWindow); window > 0 {
+			return window
+		}
+		reportError(&ConfigError{Key: configParseFailureWindow, Value: viper.Get(configParseFailureWindow), Reason: "using 1m"})
+	}
+	return time.Minute
+}
+
+// ConfiguredParseFailureSamples returns how many failing lines are
+// diagnosed
+func ConfiguredParseFailureSamples() int {
+	if viper.IsSet(configParseFailureSamples) {
+		if samples := viper.GetInt(configParseFailureSamples); samples > 0 {
+			return samples
+		}
+		reportError(&ConfigError{Key: configParseFailureSamples, Value: viper.Get(configParseFailureSamples), Reason: "using 5"})
+	}
+	return 5
+}
+
+// PatternDivergence shows where a pattern stops matching a line
+type PatternDivergence struct {
+	Line           string `json:"line"`
+	MatchedPattern string `json:"matched_pattern"`
+	Matched        string `json:"matched"`
+	NextPattern    string `json:"next_pattern,omitempty"`
+	Rest           string `json:"rest"`
+	Error          string `json:"error,omitempty"`
+}
+
+func (d PatternDivergence) String() string {
+	if d.Error != "" {
+		return fmt.Sprintf("line %q: %s", d.Line, d.Error)
+	}
+	if d.NextPattern == "" {
+		return fmt.Sprintf("line %q matches the whole pattern", d.Line)
+	}
+	return fmt.Sprintf("line %q: matched %q with `%s`, then `%s` failed at %q", d.Line, d.Matched, d.MatchedPattern, d.NextPattern, d.Rest)
+}
+
+// LongestMatchingPrefix finds the longest prefix of the pattern that
+// matches the line, to show where the pattern diverges from it
+func LongestMatchingPrefix(pattern string, line string) (PatternDivergence, error) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return PatternDivergence{}, err
+	}
+	// a prefix that isn't a valid expression, such as one with an unclosed
+	// group, is skipped; the empty prefix always matches
+	for i := len(pattern); i >= 0; i-- {
+		prefix, err := regexp.Compile(pattern[:i])
+		if err != nil {
+			continue
+		}
+		if loc := prefix.FindStringIndex(line); loc != nil {
+			return PatternDivergence{
+				Line:           line,
+				MatchedPattern: pattern[:i],
+				Matched:        line[loc[0]:loc[1]],
+				NextPattern:    pattern[i:],
+				Rest:           line[loc[1]:],
+			}, nil
+		}
+	}
+	return PatternDivergence{Line: line, Rest: line, NextPattern: pattern}, nil
+}
+
+// ParseDiagnostic describes a window in which too many lines failed to parse
+type ParseDiagnostic struct {
+	Created       time.Time           `json:"created"`
+	WindowSeconds float64             `json:"window_seconds"`
+	Lines         int64               `json:"lines"`
+	Failed        int64               `json:"failed"`
+	Rate          float64             `json:"rate"`
+	Threshold     float64             `json:"threshold"`
+	Codec         string              `json:"codec"`
+	Pattern       string              `json:"pattern,omitempty"`
+	Samples       []PatternDivergence `json:"samples"`
+}
+
+// parseFailure is a line that failed to parse
+type parseFailure struct {
+	line string
+	err  error
+}
+
+// failureMonitor counts the lines that fail to parse over a window
+type failureMonitor struct {
+	threshold  float64
+	window     time.Duration
+	maxSamples int
+	lock       sync.Mutex
+	started    time.Time
+	lines      int64
+	failed     int64
+	samples    []parseFailure
+	diagnostic *ParseDiagnostic
+}
+
+// ConfiguredFailureMonitor returns the monitor of parse failures, or nil if
+// the alarm is disabled
+func ConfiguredFailureMonitor() *failureMonitor {
+	threshold := ConfiguredParseFailureThreshold()
+	if threshold == 0 {
+		return nil
+	}
+	return &failureMonitor{threshold: threshold, window: ConfiguredParseFailureWindow(), maxSamples: ConfiguredParseFailureSamples(), started: time.Now()}
+}
+
+// observe counts a line, and returns the failures of the previous window
+// if it has ended with too many of them
+func (m *failureMonitor) observe(line string, err error, now time.Time) (failures []parseFailure, lines int64, failed int64, window time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if elapsed := now.Sub(m.started); elapsed >= m.window {
+		if m.failed > 0 && float64(m.failed) > m.threshold*float64(m.lines) {
+			failures, lines, failed, window = m.samples, m.lines, m.failed, elapsed
+		}
+		m.started, m.lines, m.failed, m.samples = now, 0, 0, nil
+	}
+	m.lines++
+	if err != nil {
+		m.failed++
+		if len(m.samples) < m.maxSamples {
+			m.samples = append(m.samples, parseFailure{line, err})
+		}
+	}
+	return
+}
+
+// observeParse counts a line that was parsed, or that failed to parse, and
+// raises an alarm when a window ends with too many failures
+func (w *LogParser) observeParse(line string, err error) {
+	m := w.failures
+	if m == nil {
+		return
+	}
+	failures, lines, failed, window := m.observe(line, err, time.Now())
+	if failures == nil {
+		return
+	}
+	d := &ParseDiagnostic{
+		Created:       time.Now().UTC(),
+		WindowSeconds: window.Seconds(),
+		Lines:         lines,
+		Failed:        failed,
+		Rate:          float64(failed) / float64(lines),
+		Threshold:     m.threshold,
+		Codec:         ConfiguredInputCodec(),
+	}
+	_, regex := w.Codec().(regexCodec)
+	if regex {
+		d.Pattern = w.CachedRegex().String()
+	}
+	for _, f := range failures {
+		divergence := PatternDivergence{Line: f.line, Error: f.err.Error()}
+		if regex {
+			if diverged, err := LongestMatchingPrefix(d.Pattern, f.line); err == nil {
+				divergence = diverged
+			}
+		}
+		d.Samples = append(d.Samples, divergence)
+	}
+	m.lock.Lock()
+	m.diagnostic = d
+	m.lock.Unlock()
+	Counters.Inc("parse_failure_alarms")
+	samples := make([]string, len(d.Samples))
+	for i, sample := range d.Samples {
+		samples[i] = "  " + sample.String()
+	}
+	logs.Warn("%d of %d lines (%.1f%%) failed to parse in the last %v, above the %.1f%% threshold; pattern %s\n%s",
+		failed, lines, 100*d.Rate, window.Round(time.Second), 100*m.threshold, d.Pattern, strings.Join(samples, "\n"))
+}
+
+// ParseDiagnostic returns the diagnostic of the last window in which too
+// many lines failed to parse, or nil if there was none
+func (w *LogParser) ParseDiagnostic() *ParseDiagnostic {
+	m := w.failures
+	if m == nil {
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.diagnostic
+}
@@ -0,0 +1,66 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var longestMatchingPrefixTestCases = []struct {
+	pattern        string
+	line           string
+	matchedPattern string
+	matched        string
+	rest           string
+}{
+	{`^(?P<ip>\S+) (?P<status>\d+)$`, "10.0.0.1 - 200", `^(?P<ip>\S+) `, "10.0.0.1 ", "- 200"},
+	{`^(?P<n>\d+)$`, "x 2", `^`, "", "x 2"},
+	{`^(?P<method>GET|POST) (?P<uri>\S+) HTTP/1\.1$`, "GET / HTTP/2", `^(?P<method>GET|POST) (?P<uri>\S+) HTTP/`, "GET / HTTP/", "2"},
+	{`^(?P<n>\d+)$`, "12", `^(?P<n>\d+)$`, "12", ""},
+}
+
+func TestLongestMatchingPrefix(t *testing.T) {
+	for i, tt := range longestMatchingPrefixTestCases {
+		d, err := worker.LongestMatchingPrefix(tt.pattern, tt.line)
+		if err != nil || d.MatchedPattern != tt.matchedPattern || d.Matched != tt.matched || d.Rest != tt.rest || d.MatchedPattern+d.NextPattern != tt.pattern {
+			t.Errorf("In test %d, LongestMatchingPrefix(%v, %v): expected %v, %q, %q, actual %+v (%v)", i, tt.pattern, tt.line, tt.matchedPattern, tt.matched, tt.rest, d, err)
+		}
+	}
+	if _, err := worker.LongestMatchingPrefix(`(`, "x"); err == nil {
+		t.Errorf("expected an invalid pattern to be an error")
+	}
+}
+
+func TestParseFailureAlarm(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<n>\d+)$`)
+	viper.Set("parse.failure_threshold", 0.5)
+	viper.Set("parse.failure_window", "50ms")
+	viper.Set("parse.failure_samples", 1)
+	channel := make(chan map[string]interface{}, 10)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	for _, line := range []string{"1", "x 2", "3"} {
+		w.ProcessLine(line)
+	}
+	time.Sleep(60 * time.Millisecond)
+	w.ProcessLine("4")
+	if d := w.ParseDiagnostic(); d != nil {
+		t.Errorf("expected no diagnostic below the threshold, actual %v", d)
+	}
+	for _, line := range []string{"x 5", "y 6"} {
+		w.ProcessLine(line)
+	}
+	time.Sleep(60 * time.Millisecond)
+	w.ProcessLine("7")
+	d := w.ParseDiagnostic()
+	if d == nil || d.Lines != 3 || d.Failed != 2 || len(d.Samples) != 1 {
+		t.Fatalf("expected a diagnostic of 2 failures in 3 lines, actual %+v", d)
+	}
+	if sample := d.Samples[0]; sample.Line != "x 5" || sample.MatchedPattern != "^" || sample.NextPattern != `(?P<n>\d+)$` {
+		t.Errorf("expected where x 5 diverges from the pattern, actual %+v", sample)
+	}
+}
@@ -0,0 +1,79 @@
+package worker
+
+/*
+	proxy.go configures the HTTP transport of inputs and outputs
+
+	HTTP requests go through the proxies in HTTP_PROXY and HTTPS_PROXY
+	(except for the hosts in NO_PROXY), unless the input or output has its
+	own proxy setting, such as es.proxy:
+
+		proxy = "http://proxy.example.com:3128"   an HTTP proxy
+		proxy = "socks5://proxy.example.com:1080" a SOCKS5 proxy
+		proxy = "direct"                          no proxy at all
+
+	The Elastic Search output and the http input also use their TLS
+	configuration (see tls.go).
+*/
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/viper"
+)
+
+// proxySchemes are the schemes of the proxies supported
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// proxyFunc returns the proxy function of a proxy setting
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	if proxy == "direct" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if !proxySchemes[u.Scheme] || u.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy %q; expected http://, https://, or socks5:// and a host, or direct", proxy)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// newTransport returns an HTTP transport with the proxy of section, and a
+// TLS configuration, or nil (the default transport, which uses the
+// environment's proxies) if neither is configured
+func newTransport(section string, config *tls.Config) (http.RoundTripper, error) {
+	proxy := viper.GetString(section + ".proxy")
+	if config == nil && proxy == "" {
+		return nil, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	if proxy != "" {
+		f, err := proxyFunc(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = f
+	}
+	return transport, nil
+}
+
+// configuredTransport returns an HTTP transport with the TLS configuration
+// and proxy of section, or nil if neither is configured
+func configuredTransport(section string) (http.RoundTripper, error) {
+	config, err := configuredTLS(section)
+	if err != nil {
+		return nil, err
+	}
+	return newTransport(section, config)
+}
+
+// configuredProxyTransport returns an HTTP transport with the proxy of
+// section, or nil if it isn't configured, for outputs whose servers don't
+// share the TLS configuration, such as AWS or Slack
+func configuredProxyTransport(section string) (http.RoundTripper, error) {
+	return newTransport(section, nil)
+}
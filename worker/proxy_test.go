@@ -0,0 +1,28 @@
+package worker_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestHTTPInputProxy(t *testing.T) {
+	viper.Reset()
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "logs.example.invalid" {
+			t.Errorf("expected a request for logs.example.invalid through the proxy, got %v", r.URL)
+		}
+		fmt.Fprint(rw, "1\n2\n")
+	}))
+	defer proxy.Close()
+	viper.Set("input.type", "http")
+	viper.Set("input.url", "http://logs.example.invalid/logs")
+	viper.Set("input.proxy", proxy.URL)
+	viper.Set("input.poll_every", "1h")
+	w, channel := startParser(t)
+	defer w.Stop()
+	expectEvents(t, channel, 2)
+}
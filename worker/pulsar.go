@@ -0,0 +1,354 @@
+package worker
+
+/*
+	pulsar.go produces events to Apache Pulsar
+
+	Events are produced as JSON messages, through the REST producer API of
+	the Pulsar broker (or proxy) at pulsar.url, to the topic pulsar.topic,
+	which may use the same placeholders as es.index (e.g.
+	"persistent://logs/prod/{service}"). Field values are stripped of "/",
+	so that an event can't produce outside its namespace; missing fields
+	become "unknown". Topics without a tenant and namespace are in
+	public/default, as with Pulsar clients.
+
+	The value of the event field pulsar.key, if set, is the message key, so
+	that Key_Shared subscriptions and compaction see an event's entity, and
+	the event's timestamp (see timestampFields), if it has one, its event
+	time. Messages have the STRING schema, unless pulsar.schema names a file
+	holding a JSON schema definition (Avro-style, as Pulsar clients
+	generate), in which case they have the JSON schema, and Pulsar checks
+	its compatibility with the topic's.
+
+	Messages are sent in batches of up to pulsar.max messages per topic, at
+	least every pulsar.flush_interval. A batch that fails is sent again, up
+	to pulsar.max_retries times with exponential backoff, and then
+	dead-lettered; messages Pulsar rejects one by one are dead-lettered at
+	once. Requests are authenticated with pulsar.token, if set, go through
+	pulsar.proxy, if set (see proxy.go), and pulsar.tls configures TLS (see
+	tls.go).
+*/
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configPulsarURL = "pulsar.url"
+const configPulsarTopic = "pulsar.topic"
+const configPulsarKey = "pulsar.key"
+const configPulsarToken = "pulsar.token"
+const configPulsarSchema = "pulsar.schema"
+const configPulsarProducerName = "pulsar.producer_name"
+const configPulsarMax = "pulsar.max"
+const configPulsarFlushInterval = "pulsar.flush_interval"
+const configPulsarMaxRetries = "pulsar.max_retries"
+const configPulsarRetryBackoff = "pulsar.retry_backoff"
+
+// pulsarTimeout is how long producing a batch may take
+const pulsarTimeout = 30 * time.Second
+
+var pulsarTopicReplacer = strings.NewReplacer("/", "_")
+
+// ConfiguredPulsarURL returns the URL of the Pulsar broker or proxy
+func ConfiguredPulsarURL() string {
+	if viper.IsSet(configPulsarURL) {
+		return strings.TrimRight(viper.GetString(configPulsarURL), "/")
+	}
+	return "http://localhost:8080"
+}
+
+// ConfiguredPulsarTopic returns the topic template
+func ConfiguredPulsarTopic() string {
+	if viper.IsSet(configPulsarTopic) {
+		return viper.GetString(configPulsarTopic)
+	}
+	return "persistent://public/default/translog"
+}
+
+// ConfiguredPulsarMax returns how many messages are sent to a topic at a
+// time
+func ConfiguredPulsarMax() int {
+	if viper.IsSet(configPulsarMax) {
+		if max := viper.GetInt(configPulsarMax); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configPulsarMax, Value: viper.Get(configPulsarMax), Reason: "using 100"})
+	}
+	return 100
+}
+
+// ConfiguredPulsarFlushInterval returns the longest messages wait for
+// their batch to be sent
+func ConfiguredPulsarFlushInterval() time.Duration {
+	if viper.IsSet(configPulsarFlushInterval) {
+		if interval := viper.GetDuration(configPulsarFlushInterval); interval > 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configPulsarFlushInterval, Value: viper.Get(configPulsarFlushInterval), Reason: "using 1s"})
+	}
+	return time.Second
+}
+
+// ConfiguredPulsarMaxRetries returns how often a failed batch is sent
+// again
+func ConfiguredPulsarMaxRetries() int {
+	if viper.IsSet(configPulsarMaxRetries) {
+		return viper.GetInt(configPulsarMaxRetries)
+	}
+	return 3
+}
+
+// ConfiguredPulsarRetryBackoff returns the wait before the first retry
+func ConfiguredPulsarRetryBackoff() time.Duration {
+	if viper.IsSet(configPulsarRetryBackoff) {
+		return viper.GetDuration(configPulsarRetryBackoff)
+	}
+	return time.Second
+}
+
+// PulsarTopicPath returns the path of a topic in the REST API, e.g.
+// persistent/public/default/translog
+func PulsarTopicPath(topic string) (string, error) {
+	domain := "persistent"
+	if i := strings.Index(topic, "://"); i >= 0 {
+		domain, topic = topic[:i], topic[i+3:]
+	}
+	if domain != "persistent" && domain != "non-persistent" {
+		return "", fmt.Errorf("invalid topic domain %q", domain)
+	}
+	switch parts := strings.Split(topic, "/"); {
+	case len(parts) == 1 && parts[0] != "":
+		return domain + "/public/default/" + topic, nil
+	case len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] != "":
+		return domain + "/" + topic, nil
+	}
+	return "", fmt.Errorf("invalid topic %q", topic)
+}
+
+// PulsarValueSchema returns the schema of messages: the JSON schema with a
+// definition, or else the STRING schema
+func PulsarValueSchema(definition string) string {
+	schema := map[string]interface{}{"name": "", "type": "STRING", "schema": "", "properties": map[string]string{}}
+	if definition != "" {
+		schema["type"], schema["schema"] = "JSON", definition
+	}
+	bs, _ := json.Marshal(schema)
+	return string(bs)
+}
+
+// PulsarMessage converts an event into a message of the REST producer API,
+// keyed by the value of keyField
+func PulsarMessage(v map[string]interface{}, keyField string) (map[string]interface{}, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{"payload": string(payload)}
+	if keyField != "" {
+		if key, found := lookupField(v, keyField); found && key != nil {
+			m["key"] = fmt.Sprint(key)
+		}
+	}
+	if timestamp, found := eventTimestamp(v); found {
+		m["eventTime"] = strconv.FormatInt(timestamp.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return m, nil
+}
+
+// pulsarResult is Pulsar's response for a message of a batch
+type pulsarResult struct {
+	MessageID string `json:"messageId"`
+	ErrorCode int    `json:"errorCode"`
+	ErrorMsg  string `json:"errorMsg"`
+}
+
+// PulsarWorker produces events to Pulsar
+type PulsarWorker struct {
+	WorkChannel  chan map[string]interface{}
+	QuitChannel  chan bool
+	FlushChannel chan bool
+	client       *http.Client
+	valueSchema  string
+	batches      map[string][]map[string]interface{}
+	startTime    time.Time
+	healthTracker
+}
+
+func (w *PulsarWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *PulsarWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	w.batches = make(map[string][]map[string]interface{})
+	transport, err := configuredTransport("pulsar")
+	if err != nil {
+		logs.Warn("Invalid Pulsar TLS or proxy configuration: %v", err)
+	}
+	w.client = &http.Client{Timeout: pulsarTimeout, Transport: transport}
+	var definition []byte
+	if path := viper.GetString(configPulsarSchema); path != "" {
+		if definition, err = ioutil.ReadFile(path); err != nil {
+			reportError(&ConfigError{Key: configPulsarSchema, Value: path, Reason: err.Error() + "; using the STRING schema"})
+		}
+	}
+	w.valueSchema = PulsarValueSchema(strings.TrimSpace(string(definition)))
+	return nil
+}
+
+// Start the work
+func (w *PulsarWorker) Start() {
+	go Supervise("PulsarWorker", w.Work)
+}
+
+// add adds an event to the pending batch of its topic
+func (w *PulsarWorker) add(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	topic := fillTemplate(ConfiguredPulsarTopic(), obj, "unknown", pulsarTopicReplacer.Replace)
+	message, err := PulsarMessage(obj, viper.GetString(configPulsarKey))
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	w.batches[topic] = append(w.batches[topic], message)
+	if messages := w.batches[topic]; len(messages) >= ConfiguredPulsarMax() {
+		delete(w.batches, topic)
+		w.flushTopic(topic, messages)
+	}
+}
+
+// send sends a batch of messages to a topic, and returns the result for
+// each message
+func (w *PulsarWorker) send(path string, bs []byte) ([]pulsarResult, error) {
+	req, err := http.NewRequest("POST", ConfiguredPulsarURL()+"/topics/"+path, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := viper.GetString(configPulsarToken); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var response struct {
+		MessagePublishResults []pulsarResult `json:"messagePublishResults"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response.MessagePublishResults, nil
+}
+
+// flushTopic sends the batch of a topic, retrying until it is accepted, or
+// dead-lettering it
+func (w *PulsarWorker) flushTopic(topic string, messages []map[string]interface{}) {
+	deadLetterAll := func(reason string, err error) {
+		Counters.Add("pulsar_rejected", int64(len(messages)))
+		for _, m := range messages {
+			DeadLetter(DeadLetterRecord{Reason: reason, Line: m["payload"].(string), Error: err.Error()})
+		}
+	}
+	path, err := PulsarTopicPath(topic)
+	if err != nil {
+		w.failed(&OutputError{Destination: "Pulsar topic " + topic, Err: err})
+		deadLetterAll("rejected", err)
+		return
+	}
+	request := map[string]interface{}{"valueSchema": w.valueSchema, "messages": messages}
+	if producerName := viper.GetString(configPulsarProducerName); producerName != "" {
+		request["producerName"] = producerName
+	}
+	bs, err := json.Marshal(request)
+	if err != nil {
+		logs.Info("Unable to marshal a batch of %d messages: %v", len(messages), err)
+		return
+	}
+	backoff := ConfiguredPulsarRetryBackoff()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			Counters.Add("pulsar_retries", int64(len(messages)))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		start := time.Now()
+		results, err := w.send(path, bs)
+		if err == nil {
+			w.succeeded(time.Since(start))
+			for i, result := range results {
+				if i < len(messages) && result.ErrorCode != 0 {
+					Counters.Inc("pulsar_rejected")
+					DeadLetter(DeadLetterRecord{Reason: "rejected", Line: messages[i]["payload"].(string), Error: fmt.Sprintf("error %d: %s", result.ErrorCode, result.ErrorMsg)})
+				}
+			}
+			Counters.Inc("pulsar_batches")
+			Counters.Add("pulsar_messages", int64(len(messages)))
+			return
+		}
+		w.failed(&OutputError{Destination: "Pulsar topic " + topic, Err: err})
+		if attempt >= ConfiguredPulsarMaxRetries() {
+			logs.Warn("Giving up on a batch of %d messages after %d retries", len(messages), attempt)
+			deadLetterAll("retries_exhausted", err)
+			return
+		}
+	}
+}
+
+// flush sends the pending batches
+func (w *PulsarWorker) flush() {
+	batches := w.batches
+	w.batches = make(map[string][]map[string]interface{})
+	for topic, messages := range batches {
+		w.flushTopic(topic, messages)
+	}
+}
+
+// Work the queue
+func (w *PulsarWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("PulsarWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredPulsarFlushInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.add(obj)
+
+		case <-ticker.C:
+			w.flush()
+
+		case <-w.FlushChannel:
+			w.flush()
+
+		case <-w.QuitChannel:
+			logs.Info("PulsarWorker received quit")
+			w.flush()
+			return
+		}
+	}
+}
+
+// Flush asks the worker to send the messages it has collected
+func (w *PulsarWorker) Flush() {
+	w.FlushChannel <- true
+}
+
+// Stop stops the worker, after sending the messages it has collected
+func (w *PulsarWorker) Stop() {
+	w.QuitChannel <- true
+}
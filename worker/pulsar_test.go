@@ -0,0 +1,100 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var pulsarTopicPathTestCases = []struct {
+	topic    string
+	expected string
+	valid    bool
+}{
+	{"persistent://logs/prod/api", "persistent/logs/prod/api", true},
+	{"non-persistent://logs/prod/api", "non-persistent/logs/prod/api", true},
+	{"events", "persistent/public/default/events", true},
+	{"logs/prod/api", "persistent/logs/prod/api", true},
+	{"kafka://logs/prod/api", "", false},
+	{"persistent://logs/api", "", false},
+	{"persistent://logs//api", "", false},
+}
+
+func TestPulsarTopicPath(t *testing.T) {
+	for i, tt := range pulsarTopicPathTestCases {
+		actual, err := worker.PulsarTopicPath(tt.topic)
+		if actual != tt.expected || (err == nil) != tt.valid {
+			t.Errorf("In test %d, PulsarTopicPath(%v): expected %v (valid %v), actual %v (%v)", i, tt.topic, tt.expected, tt.valid, actual, err)
+		}
+	}
+}
+
+func TestPulsarMessage(t *testing.T) {
+	viper.Reset()
+	m, err := worker.PulsarMessage(map[string]interface{}{"host": "a", "created": "2024-03-01T12:00:00Z"}, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["key"] != "a" || m["eventTime"] != "1709294400000" || m["payload"] != `{"created":"2024-03-01T12:00:00Z","host":"a"}` {
+		t.Errorf("PulsarMessage: unexpected message %v", m)
+	}
+}
+
+func TestPulsarWorker(t *testing.T) {
+	viper.Reset()
+	type request struct {
+		path          string
+		authorization string
+		body          struct {
+			ValueSchema string
+			Messages    []map[string]interface{}
+		}
+	}
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r := request{path: req.URL.Path, authorization: req.Header.Get("Authorization")}
+		json.NewDecoder(req.Body).Decode(&r.body)
+		requests <- r
+		results := make([]map[string]interface{}, len(r.body.Messages))
+		for i := range results {
+			results[i] = map[string]interface{}{"messageId": "1:0:0", "errorCode": 0}
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{"messagePublishResults": results})
+	}))
+	defer server.Close()
+	viper.Set("pulsar.url", server.URL)
+	viper.Set("pulsar.topic", "persistent://logs/prod/{service}")
+	viper.Set("pulsar.key", "host")
+	viper.Set("pulsar.token", "secret")
+	viper.Set("pulsar.flush_interval", "1h")
+
+	w := &worker.PulsarWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"service": "a/b", "host": "h1"}
+	w.WorkChannel <- map[string]interface{}{"service": "a/b", "host": "h2"}
+	w.Flush()
+
+	select {
+	case r := <-requests:
+		if r.path != "/topics/persistent/logs/prod/a_b" || r.authorization != "Bearer secret" || len(r.body.Messages) != 2 {
+			t.Fatalf("PulsarWorker: unexpected request %v", r)
+		}
+		if r.body.Messages[1]["key"] != "h2" {
+			t.Errorf("PulsarWorker: unexpected message %v", r.body.Messages[1])
+		}
+		var schema map[string]interface{}
+		if json.Unmarshal([]byte(r.body.ValueSchema), &schema); schema["type"] != "STRING" {
+			t.Errorf("PulsarWorker: expected the STRING schema, actual %v", r.body.ValueSchema)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PulsarWorker: timed out waiting for the batch")
+	}
+}
@@ -0,0 +1,54 @@
+package worker
+
+/*
+	refreshed.go publishes configuration values that change while translog
+	runs
+
+	Secrets the configuration refers to (see run/secrets.go) are read again
+	while translog runs, so that rotated credentials are picked up. Their
+	new values aren't written to the configuration, which workers read
+	without locking, but published here with PublishRefreshed; outputs and
+	inputs read credentials with refreshedString whenever they connect.
+*/
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// refreshed are the values published since the configuration was read
+var refreshed = struct {
+	sync.RWMutex
+	values map[string]interface{}
+}{values: make(map[string]interface{})}
+
+// PublishRefreshed publishes the new value of a configuration key
+func PublishRefreshed(key string, value interface{}) {
+	refreshed.Lock()
+	defer refreshed.Unlock()
+	refreshed.values[key] = value
+}
+
+// ResetRefreshed forgets the published values, when the configuration is
+// read again
+func ResetRefreshed() {
+	refreshed.Lock()
+	defer refreshed.Unlock()
+	refreshed.values = make(map[string]interface{})
+}
+
+// refreshedString returns the value of a configuration key: the one last
+// published, if any, or else the configured one
+func refreshedString(key string) string {
+	refreshed.RLock()
+	value, found := refreshed.values[key]
+	refreshed.RUnlock()
+	if !found {
+		return viper.GetString(key)
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,112 @@
+package worker
+
+/*
+	relp.go is a minimal client of RELP, the Reliable Event Logging
+	Protocol (as spoken by rsyslog's imrelp)
+
+	Every frame is "TXNR COMMAND DATALEN[ DATA]\n"; the receiver answers
+	each frame with an "rsp" frame of the same transaction number, whose
+	data starts with a status code (200 for success). The client sends one
+	message at a time, and waits for its response, so that a message is
+	only considered delivered once the receiver has acknowledged it.
+*/
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relpTimeout limits how long to wait for a response
+const relpTimeout = 30 * time.Second
+
+// relpOffers are sent when opening a session
+const relpOffers = "relp_version=0\nrelp_software=translog\ncommands=syslog"
+
+type relpClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	txnr   int
+}
+
+// readField reads up to (and not including) the next space
+func (c *relpClient) readField() (string, error) {
+	field, err := c.reader.ReadString(' ')
+	return strings.TrimSuffix(field, " "), err
+}
+
+// command sends a command, and returns the data of its response
+func (c *relpClient) command(command string, data string) (string, error) {
+	c.txnr++ // transaction numbers start at 1
+	c.conn.SetDeadline(time.Now().Add(relpTimeout))
+	frame := fmt.Sprintf("%d %s %d", c.txnr, command, len(data))
+	if data != "" {
+		frame += " " + data
+	}
+	if _, err := io.WriteString(c.conn, frame+"\n"); err != nil {
+		return "", err
+	}
+	for {
+		txnr, err := c.readField()
+		if err != nil {
+			return "", err
+		}
+		responseCommand, err := c.readField()
+		if err != nil {
+			return "", err
+		}
+		// DATALEN is followed by a space and the data, or by the trailer
+		header, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		fields := strings.SplitN(strings.TrimSuffix(header, "\n"), " ", 2)
+		length, err := strconv.Atoi(fields[0])
+		if err != nil || (length > 0) != (len(fields) == 2) {
+			return "", fmt.Errorf("Invalid RELP frame %s %s %q", txnr, responseCommand, header)
+		}
+		response := ""
+		if length > 0 {
+			// the data may contain newlines, so the header may have ended early
+			response = fields[1] + "\n"
+			if len(response) < length+1 {
+				rest := make([]byte, length+1-len(response))
+				if _, err := io.ReadFull(c.reader, rest); err != nil {
+					return "", err
+				}
+				response += string(rest)
+			}
+			response = response[:length]
+		}
+		if responseCommand == "serverclose" {
+			return "", fmt.Errorf("RELP server closed the session")
+		}
+		if responseCommand != "rsp" || txnr != strconv.Itoa(c.txnr) {
+			continue
+		}
+		if !strings.HasPrefix(response, "200") {
+			return "", fmt.Errorf("RELP %s failed: %s", command, response)
+		}
+		return response, nil
+	}
+}
+
+// open opens a session
+func (c *relpClient) open() error {
+	_, err := c.command("open", relpOffers)
+	return err
+}
+
+// send sends a syslog message, returning once it has been acknowledged
+func (c *relpClient) send(message string) error {
+	_, err := c.command("syslog", message)
+	return err
+}
+
+// close closes the session
+func (c *relpClient) close() {
+	c.command("close", "")
+}
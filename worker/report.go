@@ -0,0 +1,173 @@
+package worker
+
+/*
+	report.go periodically reports the top values of fields
+
+	With report.interval and report.fields set, e.g.
+
+		[report]
+		interval = "5m"
+		fields = ["uri", "client"]
+
+	the most frequent values of each field (report.top of them) and an
+	estimate of its number of distinct values are logged every interval,
+	which helps spotting abuse, such as a client hammering one URL, without
+	a full analytics stack. With report.emit set, they are also emitted as
+	events, one per field, like
+
+		{"report": "uri", "top": [{"value": "/login", "count": 5123}, ...],
+		 "distinct": 812, "events": 20480, "window_seconds": 300,
+		 "created": "2024-03-01T12:00:00Z"}
+
+	Each report covers the events parsed since the last one.
+*/
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configReportInterval = "report.interval"
+const configReportFields = "report.fields"
+const configReportTop = "report.top"
+const configReportEmit = "report.emit"
+
+// ConfiguredReportInterval returns how often reports are made; 0 (the
+// default) disables them
+func ConfiguredReportInterval() time.Duration {
+	if viper.IsSet(configReportInterval) {
+		interval := viper.GetDuration(configReportInterval)
+		if interval >= 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configReportInterval, Value: viper.Get(configReportInterval), Reason: "disabling reports"})
+	}
+	return 0
+}
+
+// ConfiguredReportTop returns how many values are reported for each field
+func ConfiguredReportTop() int {
+	if viper.IsSet(configReportTop) {
+		top := viper.GetInt(configReportTop)
+		if top > 0 {
+			return top
+		}
+		reportError(&ConfigError{Key: configReportTop, Value: viper.Get(configReportTop), Reason: "using 10"})
+	}
+	return 10
+}
+
+// ConfiguredReporter returns the reporter of the configured fields, or nil
+// if reports are disabled
+func ConfiguredReporter() *reporter {
+	fields := viper.GetStringSlice(configReportFields)
+	if ConfiguredReportInterval() == 0 || len(fields) == 0 {
+		return nil
+	}
+	return newReporter(fields, ConfiguredReportTop())
+}
+
+// fieldSummary summarizes the values of a field
+type fieldSummary struct {
+	top      *TopK
+	distinct *HyperLogLog
+	events   int64
+}
+
+// reporter summarizes the values of fields for periodic reports
+type reporter struct {
+	fields    []string
+	k         int
+	lock      sync.Mutex
+	summaries map[string]*fieldSummary
+	started   time.Time
+}
+
+// newReporter returns a reporter of the top k values of fields
+func newReporter(fields []string, k int) *reporter {
+	r := &reporter{fields: fields, k: k}
+	r.reset(time.Now())
+	return r
+}
+
+// reset starts new summaries
+func (r *reporter) reset(now time.Time) {
+	r.summaries = make(map[string]*fieldSummary, len(r.fields))
+	for _, field := range r.fields {
+		r.summaries[field] = &fieldSummary{top: NewTopK(r.k), distinct: NewHyperLogLog()}
+	}
+	r.started = now
+}
+
+// observe adds the event's values to the summaries
+func (r *reporter) observe(v map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, field := range r.fields {
+		value, found := lookupField(v, field)
+		if !found || value == nil {
+			continue
+		}
+		s := fmt.Sprint(value)
+		summary := r.summaries[field]
+		summary.top.Add(s)
+		summary.distinct.Add(s)
+		summary.events++
+	}
+}
+
+// report returns a report event for each field, and starts new summaries
+func (r *reporter) report(now time.Time) []map[string]interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	reports := make([]map[string]interface{}, 0, len(r.fields))
+	for _, field := range r.fields {
+		summary := r.summaries[field]
+		reports = append(reports, map[string]interface{}{
+			"report":         field,
+			"top":            summary.top.Top(),
+			"distinct":       summary.distinct.Count(),
+			"events":         summary.events,
+			"window_seconds": int64(now.Sub(r.started) / time.Second),
+			"created":        now.UTC().Format(time.RFC3339),
+		})
+	}
+	r.reset(now)
+	return reports
+}
+
+// formatReport formats a report event for the log
+func formatReport(report map[string]interface{}) string {
+	values := make([]string, 0)
+	for _, top := range report["top"].([]TopValue) {
+		values = append(values, fmt.Sprintf("%s (%d)", top.Value, top.Count))
+	}
+	return fmt.Sprintf("Top %s of %d events (about %d distinct): %s", report["report"], report["events"], report["distinct"], strings.Join(values, ", "))
+}
+
+// reportPeriodically makes reports every interval, until the parser is
+// stopped
+func (w *LogParser) reportPeriodically(interval time.Duration) {
+	logs.Info("Reporting the top values of %s every %v", strings.Join(w.reporter.fields, ", "), interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if w.isStopped() {
+			return
+		}
+		for _, report := range w.reporter.report(now) {
+			logs.Info("%s", formatReport(report))
+			if viper.GetBool(configReportEmit) {
+				Counters.Inc("reports")
+				w.emit(report)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package worker
+
+/*
+	resp.go speaks RESP, the Redis serialization protocol
+
+	Commands are sent as arrays of bulk strings, and replies are read as
+	strings (simple and bulk), int64s, errors (redisError), nil, or
+	[]interface{} of replies. That is all the Redis Streams input needs.
+*/
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisTimeout limits a command, besides the time it blocks for
+const redisTimeout = 10 * time.Second
+
+// redisError is an error reply
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisConn is a connection to a Redis server
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialRedis connects to a Redis server, with TLS if config isn't nil
+func dialRedis(address string, config *tls.Config) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if config != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// do sends a command, and reads its reply, waiting up to block longer than
+// usual for it
+func (c *redisConn) do(block time.Duration, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout + block))
+	buffer := make([]byte, 0, 64)
+	buffer = append(buffer, '*')
+	buffer = strconv.AppendInt(buffer, int64(len(args)), 10)
+	buffer = append(buffer, '\r', '\n')
+	for _, arg := range args {
+		buffer = append(buffer, '$')
+		buffer = strconv.AppendInt(buffer, int64(len(arg)), 10)
+		buffer = append(buffer, '\r', '\n')
+		buffer = append(buffer, arg...)
+		buffer = append(buffer, '\r', '\n')
+	}
+	if _, err := c.conn.Write(buffer); err != nil {
+		return nil, err
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// readLine reads a line of the protocol, without its CRLF
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("Invalid RESP line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply reads a reply
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		bs := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, bs); err != nil {
+			return nil, err
+		}
+		return string(bs[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("Invalid RESP reply %q", line)
+}
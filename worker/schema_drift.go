@@ -0,0 +1,189 @@
+package worker
+
+/*
+	schema_drift.go reports changes in the fields of events
+
+	With drift.warmup set (e.g. 1000), the fields of events, and the types
+	their values were parsed as (see typeName; nested fields are named with
+	dots, e.g. geo.city), are tracked. Once that many events have been seen,
+	the fields are known, and a field appearing for the first time, or the
+	dominant type of a field changing (e.g. status suddenly parsing as a
+	string), is a drift: it is logged, counted (as schema_drift_new_fields
+	or schema_drift_type_changes), and a synthetic event is emitted, like
+
+		{"schema_drift": "type_changed", "field": "status",
+		 "type": "string", "previous_type": "int",
+		 "created": "2024-03-01T12:00:00Z"}
+
+	or, for a new field, {"schema_drift": "new_field", "field": "referer",
+	"type": "string", ...}, so that upstream format changes are caught
+	quickly. The dominant type of a field is its most frequent type over the
+	last drift.window (100) events that have it; null values don't count.
+	At most drift.max_fields (1000) fields are tracked; any more are only
+	counted, as schema_drift_untracked.
+*/
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configDriftWarmup = "drift.warmup"
+const configDriftWindow = "drift.window"
+const configDriftMaxFields = "drift.max_fields"
+
+// ConfiguredDriftWindow returns how many values of a field its dominant type
+// is found over
+func ConfiguredDriftWindow() int64 {
+	if viper.IsSet(configDriftWindow) {
+		if window := viper.GetInt64(configDriftWindow); window > 0 {
+			return window
+		}
+		reportError(&ConfigError{Key: configDriftWindow, Value: viper.Get(configDriftWindow), Reason: "using 100"})
+	}
+	return 100
+}
+
+// ConfiguredDriftMaxFields returns how many fields are tracked, at most
+func ConfiguredDriftMaxFields() int {
+	if viper.IsSet(configDriftMaxFields) {
+		if max := viper.GetInt(configDriftMaxFields); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configDriftMaxFields, Value: viper.Get(configDriftMaxFields), Reason: "using 1000"})
+	}
+	return 1000
+}
+
+// driftField is the types a field was seen with
+type driftField struct {
+	dominant string
+	counts   map[string]int64 // in the current window
+	seen     int64            // in the current window
+}
+
+// driftMonitor tracks the fields of events, and their types
+type driftMonitor struct {
+	warmup    int64
+	window    int64
+	maxFields int
+	lock      sync.Mutex
+	events    int64
+	fields    map[string]*driftField
+}
+
+// ConfiguredDriftMonitor returns the monitor of schema drift, or nil if it
+// is disabled
+func ConfiguredDriftMonitor() *driftMonitor {
+	warmup := viper.GetInt64(configDriftWarmup)
+	if warmup <= 0 {
+		return nil
+	}
+	return &driftMonitor{warmup: warmup, window: ConfiguredDriftWindow(), maxFields: ConfiguredDriftMaxFields(), fields: make(map[string]*driftField)}
+}
+
+// eventFieldTypes adds the types of the fields of v, with nested fields
+// named with dots, to types
+func eventFieldTypes(prefix string, v map[string]interface{}, types map[string]string) {
+	for key, value := range v {
+		name := prefix + key
+		if nested, ok := value.(map[string]interface{}); ok {
+			eventFieldTypes(name+".", nested, types)
+			continue
+		}
+		types[name] = typeName(value)
+	}
+}
+
+// observe tracks the fields of an event, and returns the drift events of
+// the changes it finds
+func (m *driftMonitor) observe(v map[string]interface{}, now time.Time) []map[string]interface{} {
+	types := make(map[string]string, len(v))
+	eventFieldTypes("", v, types)
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.events++
+	warm := m.events > m.warmup
+	var drifts []map[string]interface{}
+	for _, name := range names {
+		t := types[name]
+		f, found := m.fields[name]
+		if !found {
+			if len(m.fields) >= m.maxFields {
+				Counters.Inc("schema_drift_untracked")
+				continue
+			}
+			f = &driftField{counts: make(map[string]int64)}
+			m.fields[name] = f
+			if warm {
+				drifts = append(drifts, driftEvent("new_field", name, t, "", now))
+			}
+		}
+		if t == "null" {
+			continue
+		}
+		f.counts[t]++
+		f.seen++
+		if f.dominant == "" {
+			// the type a field is first seen with is its dominant type
+			// until the first window has passed
+			f.dominant = t
+		}
+		if f.seen < m.window {
+			continue
+		}
+		dominant := f.dominant
+		for t, count := range f.counts {
+			if count > f.counts[dominant] {
+				dominant = t
+			}
+		}
+		if dominant != f.dominant && warm {
+			drifts = append(drifts, driftEvent("type_changed", name, dominant, f.dominant, now))
+		}
+		f.dominant = dominant
+		f.counts = make(map[string]int64)
+		f.seen = 0
+	}
+	return drifts
+}
+
+// driftEvent returns the synthetic event of a drift
+func driftEvent(drift string, field string, t string, previous string, now time.Time) map[string]interface{} {
+	v := map[string]interface{}{
+		"schema_drift": drift,
+		"field":        field,
+		"type":         t,
+		"created":      now.UTC().Format(time.RFC3339),
+	}
+	if previous != "" {
+		v["previous_type"] = previous
+	}
+	return v
+}
+
+// checkDrift tracks the fields of an event, passing the drift events of the
+// changes it finds to emit
+func (w *LogParser) checkDrift(v map[string]interface{}, emit func(map[string]interface{})) {
+	if w.drift == nil {
+		return
+	}
+	for _, drift := range w.drift.observe(v, time.Now()) {
+		if drift["schema_drift"] == "new_field" {
+			logs.Warn("Schema drift: new field %s (%s)", drift["field"], drift["type"])
+			Counters.Inc("schema_drift_new_fields")
+		} else {
+			logs.Warn("Schema drift: field %s changed from %s to %s", drift["field"], drift["previous_type"], drift["type"])
+			Counters.Inc("schema_drift_type_changes")
+		}
+		emit(drift)
+	}
+}
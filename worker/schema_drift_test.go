@@ -0,0 +1,51 @@
+package worker_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	viper.Reset()
+	viper.Set("input.codec", "json")
+	viper.Set("drift.warmup", 2)
+	viper.Set("drift.window", 2)
+	channel := make(chan map[string]interface{}, 20)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	for _, line := range []string{
+		`{"status": 200, "geo": {"city": "Paris"}}`,
+		`{"status": 404, "geo": {"city": null}}`,
+		`{"status": 500, "geo": {"city": "Rome"}, "referer": "x"}`,
+		`{"status": "error"}`,
+		`{"status": "error"}`,
+		`{"status": "unknown"}`,
+	} {
+		w.ProcessLine(line)
+	}
+	// events are sent concurrently, so drift events arrive in any order
+	drifts := make(map[string]map[string]interface{})
+	for i := 0; i < 8; i++ {
+		select {
+		case v := <-channel:
+			if drift, ok := v["schema_drift"].(string); ok {
+				drifts[drift+" "+fmt.Sprint(v["field"])] = v
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if len(drifts) != 2 {
+		t.Fatalf("expected 2 drift events, actual %v", drifts)
+	}
+	if v := drifts["new_field referer"]; v == nil || v["type"] != "string" {
+		t.Errorf("expected the new field referer, actual %v", drifts)
+	}
+	if v := drifts["type_changed status"]; v == nil || v["type"] != "string" || v["previous_type"] != "int" {
+		t.Errorf("expected status to change from int to string, actual %v", drifts)
+	}
+}
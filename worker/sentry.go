@@ -0,0 +1,282 @@
+package worker
+
+/*
+	sentry.go sends error events to Sentry
+
+	Events matching sentry.where, a condition as in alert rules (see
+	alert.go), e.g. "level = error" (every event, if it is empty), are
+	sent to the project of sentry.dsn, as Sentry events with:
+
+		- the value of sentry.message_field (message by default) as the
+		  message; its first line, if it holds the stack trace
+		- the first stack trace found in the event (see
+		  transform_fingerprint.go) as the exception, with one frame per line
+		- the values of the sentry.tags fields as tags
+		- the level (see severity.go), error if the event has none
+		- error.fingerprint, if set, as the fingerprint, so that Sentry
+		  groups crashes the way translog does
+		- the whole event as extra data
+
+	Events not matching sentry.where are counted as sentry_skipped. When
+	Sentry rate limits translog, events are dead-lettered until its
+	Retry-After has passed; events Sentry rejects are dead-lettered too.
+	Requests go through sentry.proxy, if set (see proxy.go).
+*/
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configSentryDSN = "sentry.dsn"
+const configSentryWhere = "sentry.where"
+const configSentryMessageField = "sentry.message_field"
+const configSentryTags = "sentry.tags"
+const configSentryEnvironment = "sentry.environment"
+
+// sentryMaxTagLength is the longest tag value Sentry accepts
+const sentryMaxTagLength = 200
+
+// sentryLevels maps syslog severities to Sentry levels
+var sentryLevels = []string{"fatal", "fatal", "fatal", "error", "warning", "info", "info", "debug"}
+
+// ConfiguredSentryMessageField returns the field messages are read from
+func ConfiguredSentryMessageField() string {
+	if viper.IsSet(configSentryMessageField) {
+		return viper.GetString(configSentryMessageField)
+	}
+	return "message"
+}
+
+// ParseSentryDSN returns the URL events are posted to, and the public key,
+// of a DSN such as https://key@o1.ingest.sentry.io/42
+func ParseSentryDSN(dsn string) (storeURL string, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("no public key in %q", dsn)
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || u.Path[i+1:] == "" {
+		return "", "", fmt.Errorf("no project id in %q", dsn)
+	}
+	return fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], u.Path[i+1:]), u.User.Username(), nil
+}
+
+// SentryEvent converts an event into a Sentry event, with the message read
+// from messageField and the values of the tags fields as tags
+func SentryEvent(v map[string]interface{}, messageField string, tags []string) map[string]interface{} {
+	id := make([]byte, 16)
+	rand.Read(id)
+	timestamp, found := eventTimestamp(v)
+	if !found {
+		timestamp = time.Now()
+	}
+	level := "error"
+	if l, found := lookupField(v, "level"); found {
+		if severity, ok := SyslogSeverity(l); ok {
+			level = sentryLevels[severity]
+		}
+	}
+	e := map[string]interface{}{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": timestamp.UTC().Format(time.RFC3339Nano),
+		"level":     level,
+		"logger":    "translog",
+		"platform":  "other",
+		"extra":     v,
+	}
+	if message, found := lookupField(v, messageField); found && message != nil {
+		e["message"] = map[string]string{"formatted": strings.SplitN(fmt.Sprint(message), "\n", 2)[0]}
+	}
+	if trace, found := findStackTrace(v); found {
+		e["exception"] = map[string]interface{}{"values": []interface{}{sentryException(trace)}}
+	}
+	if fingerprint, found := lookupField(v, "error.fingerprint"); found {
+		e["fingerprint"] = []string{fmt.Sprint(fingerprint)}
+	}
+	t := make(map[string]string)
+	for _, field := range tags {
+		if value, found := lookupField(v, field); found && value != nil {
+			s := fmt.Sprint(value)
+			if len(s) > sentryMaxTagLength {
+				s = s[:sentryMaxTagLength]
+			}
+			t[field] = s
+		}
+	}
+	if len(t) > 0 {
+		e["tags"] = t
+	}
+	if environment := viper.GetString(configSentryEnvironment); environment != "" {
+		e["environment"] = environment
+	}
+	return e
+}
+
+// sentryException converts a stack trace into a Sentry exception: its type
+// and value are read from the first line, e.g.
+// "java.lang.IllegalStateException: order not found", and each frame line
+// is a frame, listed from the outermost, as Sentry expects
+func sentryException(trace string) map[string]interface{} {
+	lines := strings.Split(strings.TrimSpace(trace), "\n")
+	exception := map[string]interface{}{"type": strings.TrimSpace(lines[0])}
+	if i := strings.Index(lines[0], ": "); i >= 0 {
+		exception["type"] = strings.TrimSpace(lines[0][:i])
+		exception["value"] = strings.TrimSpace(lines[0][i+2:])
+	}
+	var frames []interface{}
+	for i := len(lines) - 1; i > 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if stackFrameRegex.MatchString(line) {
+			frames = append(frames, map[string]string{"function": line})
+		}
+	}
+	if len(frames) > 0 {
+		exception["stacktrace"] = map[string]interface{}{"frames": frames}
+	}
+	return exception
+}
+
+// retryAfter returns how long a rate limited response asks to wait before
+// the next request: its Retry-After, in seconds, or a minute
+func retryAfter(resp *http.Response) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Minute
+}
+
+// SentryWorker sends error events to Sentry
+type SentryWorker struct {
+	WorkChannel chan map[string]interface{}
+	QuitChannel chan bool
+	client      *http.Client
+	storeURL    string
+	key         string
+	where       func(v map[string]interface{}) bool
+	retryAfter  time.Time
+	startTime   time.Time
+	healthTracker
+}
+
+func (w *SentryWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *SentryWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.where, err = CompileCondition(viper.GetString(configSentryWhere))
+	if err != nil {
+		reportError(&ConfigError{Key: configSentryWhere, Value: viper.Get(configSentryWhere), Reason: err.Error() + "; sending every event"})
+		w.where, _ = CompileCondition("")
+	}
+	w.storeURL, w.key, err = ParseSentryDSN(viper.GetString(configSentryDSN))
+	if err != nil {
+		reportError(&ConfigError{Key: configSentryDSN, Value: viper.Get(configSentryDSN), Reason: err.Error() + "; dead-lettering events"})
+	}
+	transport, err := configuredProxyTransport("sentry")
+	if err != nil {
+		logs.Warn("Invalid Sentry proxy: %v", err)
+	}
+	w.client = &http.Client{Timeout: notifyTimeout, Transport: transport}
+	return nil
+}
+
+// Start the work
+func (w *SentryWorker) Start() {
+	go Supervise("SentryWorker", w.Work)
+}
+
+// post posts a Sentry event; if Sentry rate limits translog, it returns
+// how long to wait before posting again
+func (w *SentryWorker) post(bs []byte) (time.Duration, error) {
+	if w.storeURL == "" {
+		return 0, fmt.Errorf("no valid %s", configSentryDSN)
+	}
+	req, err := http.NewRequest("POST", w.storeURL, bytes.NewReader(bs))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=translog/1.0, sentry_key=%s", w.key))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait := retryAfter(resp)
+		return wait, fmt.Errorf("rate limited for %v", wait)
+	}
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return 0, nil
+}
+
+// send sends an event, if it matches sentry.where
+func (w *SentryWorker) send(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	if !w.where(obj) {
+		Counters.Inc("sentry_skipped")
+		return
+	}
+	bs, err := json.Marshal(SentryEvent(obj, ConfiguredSentryMessageField(), viper.GetStringSlice(configSentryTags)))
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	if time.Now().Before(w.retryAfter) {
+		Counters.Inc("sentry_rate_limited")
+		deadLetterDocument(string(bs), "rate_limited", "Sentry rate limits translog until "+w.retryAfter.Format(time.RFC3339))
+		return
+	}
+	start := time.Now()
+	wait, err := w.post(bs)
+	if err != nil {
+		if wait > 0 {
+			w.retryAfter = time.Now().Add(wait)
+			Counters.Inc("sentry_rate_limited")
+		}
+		w.failed(&OutputError{Destination: "Sentry", Err: err})
+		deadLetterDocument(string(bs), "rejected", err.Error())
+		return
+	}
+	Counters.Inc("sentry_events")
+	w.succeeded(time.Since(start))
+}
+
+// Work the queue
+func (w *SentryWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("SentryWorker starting work at %v", w.startTime)
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			logs.Debug("Worker received: %v", obj)
+			w.send(obj)
+
+		case <-w.QuitChannel:
+			logs.Info("SentryWorker received quit")
+			return
+		}
+	}
+}
+
+// Stop stops the worker
+func (w *SentryWorker) Stop() {
+	w.QuitChannel <- true
+}
@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"strconv"
+	"strings"
+)
+
+// syslogSeverities maps level names, as commonly logged, to syslog
+// severities (0 is emergency, 7 is debug)
+var syslogSeverities = map[string]int{
+	"emerg": 0, "emergency": 0, "panic": 0,
+	"alert": 1,
+	"crit":  2, "critical": 2, "fatal": 2,
+	"err": 3, "error": 3,
+	"warn": 4, "warning": 4,
+	"notice": 5,
+	"info":   6, "informational": 6,
+	"debug": 7, "trace": 7,
+}
+
+// SyslogSeverity converts a level (a syslog severity number, or a name such
+// as "warn" or "ERROR") into a syslog severity
+func SyslogSeverity(level interface{}) (int, bool) {
+	switch l := level.(type) {
+	case int64:
+		return int(l), l >= 0 && l <= 7
+	case int:
+		return l, l >= 0 && l <= 7
+	case float64:
+		return int(l), l >= 0 && l <= 7
+	case string:
+		if n, err := strconv.Atoi(l); err == nil {
+			return n, n >= 0 && n <= 7
+		}
+		severity, found := syslogSeverities[strings.ToLower(l)]
+		return severity, found
+	}
+	return 0, false
+}
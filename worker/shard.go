@@ -0,0 +1,144 @@
+package worker
+
+/*
+	shard.go parses lines on several CPUs at once
+
+	With pipeline.shards greater than one (or 0, for one shard per CPU),
+	lines read from the input are dealt out in turn to that many parsing
+	goroutines, and their events are collected in the same turn, so they
+	leave the shards in the order the lines were read. With
+	pipeline.shard_key set, each event is then handed to the output by the
+	goroutine of the shard its key hashes to, so that events with the same
+	key reach the output in order while events with different keys don't
+	wait on each other.
+*/
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+)
+
+const configPipelineShards = "pipeline.shards"
+const configPipelineShardKey = "pipeline.shard_key"
+
+// shardQueueSize is how many lines, or events, may wait for each shard
+const shardQueueSize = 256
+
+// ConfiguredPipelineShards returns how many goroutines parse lines
+func ConfiguredPipelineShards() int {
+	if viper.IsSet(configPipelineShards) {
+		shards := viper.GetInt(configPipelineShards)
+		if shards == 0 {
+			return runtime.NumCPU()
+		}
+		if shards > 0 {
+			return shards
+		}
+		reportError(&ConfigError{Key: configPipelineShards, Value: viper.Get(configPipelineShards), Reason: "using 1"})
+	}
+	return 1
+}
+
+// ConfiguredPipelineShardKey returns the field whose events are kept in
+// order, if any
+func ConfiguredPipelineShardKey() string {
+	if viper.IsSet(configPipelineShardKey) {
+		return viper.GetString(configPipelineShardKey)
+	}
+	return ""
+}
+
+// shardPool deals lines out to the parsing shards, and events to the output
+type shardPool struct {
+	lines   []chan string
+	results []chan []map[string]interface{}
+	lanes   []chan map[string]interface{} // one per shard, with a shard key
+	key     string
+	lock    sync.Mutex
+	next    int // the shard the next line goes to; guarded by lock
+	current int // the shard the next events come from; only used by the collector
+	parser  *LogParser
+}
+
+// startShards starts n parsing shards, and, with a key, their output lanes
+func (w *LogParser) startShards(n int, key string) *shardPool {
+	s := &shardPool{key: key, parser: w}
+	for i := 0; i < n; i++ {
+		lines := make(chan string, shardQueueSize)
+		results := make(chan []map[string]interface{}, shardQueueSize)
+		s.lines = append(s.lines, lines)
+		s.results = append(s.results, results)
+		go Supervise(fmt.Sprintf("shard %d", i), func() { w.parseShard(lines, results) })
+		if key != "" {
+			lane := make(chan map[string]interface{}, shardQueueSize)
+			s.lanes = append(s.lanes, lane)
+			go Supervise(fmt.Sprintf("shard %d output", i), func() { w.deliverShard(lane) })
+		}
+	}
+	go Supervise("shard collector", func() { w.collectShards(s) })
+	return s
+}
+
+// dispatch hands a line to the next shard; lines read by several readers
+// (e.g. each connection to a socket) are dispatched one at a time, so that
+// the collector takes their events in the order of dispatch
+func (s *shardPool) dispatch(text string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	atomic.AddInt64(&s.parser.pending, 1)
+	s.lines[s.next] <- text
+	s.next = (s.next + 1) % len(s.lines)
+}
+
+// parseShard parses lines, sending exactly one (possibly empty) list of
+// events per line, so that the collector never waits for a line forever
+func (w *LogParser) parseShard(lines chan string, results chan []map[string]interface{}) {
+	for text := range lines {
+		var events []map[string]interface{}
+		runRecovered("shard", func() {
+			w.processLine(text, func(v map[string]interface{}) {
+				events = append(events, applySchema(v))
+			})
+		})
+		results <- events
+	}
+}
+
+// collectShards publishes the events of each line, in the order the lines
+// were dispatched
+func (w *LogParser) collectShards(s *shardPool) {
+	for {
+		for _, v := range <-s.results[s.current] {
+			w.publish(v)
+		}
+		atomic.AddInt64(&w.pending, -1)
+		s.current = (s.current + 1) % len(s.results)
+	}
+}
+
+// route hands an event to the output lane of its key
+func (s *shardPool) route(v map[string]interface{}) {
+	h := fnv.New32a()
+	if key, ok := v[s.key]; ok {
+		fmt.Fprint(h, key)
+	}
+	atomic.AddInt64(&s.parser.pending, 1)
+	s.lanes[h.Sum32()%uint32(len(s.lanes))] <- v
+}
+
+// deliverShard puts the events of an output lane on the shared channel, in
+// order
+func (w *LogParser) deliverShard(lane chan map[string]interface{}) {
+	for v := range lane {
+		if w.BatchChannel != nil {
+			w.addToBatch(v)
+		} else {
+			w.Channel <- v
+		}
+		atomic.AddInt64(&w.pending, -1)
+	}
+}
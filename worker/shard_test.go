@@ -0,0 +1,59 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var shardsTestCases = []struct {
+	setting  interface{}
+	expected int
+}{
+	{nil, 1},
+	{4, 4},
+	{-1, 1},
+}
+
+func TestConfiguredPipelineShards(t *testing.T) {
+	for i, tt := range shardsTestCases {
+		viper.Reset()
+		if tt.setting != nil {
+			viper.Set("pipeline.shards", tt.setting)
+		}
+		actual := worker.ConfiguredPipelineShards()
+		if actual != tt.expected {
+			t.Errorf("In test %d, ConfiguredPipelineShards(%v): expected %v, actual %v", i, tt.setting, tt.expected, actual)
+		}
+	}
+}
+
+func TestShardsKeepKeysInOrder(t *testing.T) {
+	viper.Reset()
+	viper.Set("input.type", "exec")
+	viper.Set("input.command", "for i in $(seq 1 200); do echo a $i; echo b $i; echo c $i; done")
+	viper.Set("input.restart", false)
+	viper.Set("pipeline.shards", 4)
+	viper.Set("pipeline.shard_key", "k")
+	viper.Set("parse.pattern", `^(?P<k>\w) (?P<n>\d+)$`)
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	go w.Start()
+	defer w.Stop()
+	last := make(map[interface{}]int64)
+	for i := 0; i < 600; i++ {
+		select {
+		case v := <-channel:
+			n := v["n"].(int64)
+			if n != last[v["k"]]+1 {
+				t.Fatalf("expected %v %d after %d, actual %d", v["k"], last[v["k"]]+1, last[v["k"]], n)
+			}
+			last[v["k"]] = n
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 600 events, got %v", last)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package worker
+
+/*
+	sketch.go summarizes streams of values in bounded memory
+
+	TopK finds the most frequent values with the Space-Saving algorithm:
+	it counts at most a fixed number of values, and when a new value comes
+	along while they're all taken, it replaces the least frequent, inheriting
+	its count. Values that are really frequent are never replaced, so the
+	top of the list is right, though counts may be overestimated by up to
+	the count they inherited.
+
+	HyperLogLog estimates the number of distinct values to within about 1%,
+	in 16KB, however many values there are.
+*/
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// TopValue is a value and how often it was seen
+type TopValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// TopK finds the most frequent values of a stream
+type TopK struct {
+	k        int
+	capacity int
+	counts   map[string]*TopValue
+}
+
+// NewTopK returns a TopK finding the k most frequent values
+func NewTopK(k int) *TopK {
+	return &TopK{k: k, capacity: 10 * k, counts: make(map[string]*TopValue)}
+}
+
+// Add counts a value
+func (t *TopK) Add(value string) {
+	if c, ok := t.counts[value]; ok {
+		c.Count++
+		return
+	}
+	if len(t.counts) < t.capacity {
+		t.counts[value] = &TopValue{Value: value, Count: 1}
+		return
+	}
+	var min *TopValue
+	for _, c := range t.counts {
+		if min == nil || c.Count < min.Count {
+			min = c
+		}
+	}
+	delete(t.counts, min.Value)
+	t.counts[value] = &TopValue{Value: value, Count: min.Count + 1}
+}
+
+// Top returns the most frequent values, most frequent first
+func (t *TopK) Top() []TopValue {
+	top := make([]TopValue, 0, len(t.counts))
+	for _, c := range t.counts {
+		top = append(top, *c)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > t.k {
+		top = top[:t.k]
+	}
+	return top
+}
+
+// hllPrecision is the number of hash bits choosing a register
+const hllPrecision = 14
+
+// HyperLogLog estimates the number of distinct values of a stream
+type HyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// NewHyperLogLog returns an empty HyperLogLog
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// hash64 hashes a value, mixing the bits of its FNV hash so that all of
+// them are well distributed
+func hash64(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Add adds a value
+func (h *HyperLogLog) Add(value string) {
+	x := hash64(value)
+	register := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[register] {
+		h.registers[register] = rank
+	}
+}
+
+// Count returns the estimated number of distinct values
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small counts
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
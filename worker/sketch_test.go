@@ -0,0 +1,50 @@
+package worker_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/willf/translog/worker"
+)
+
+func TestTopK(t *testing.T) {
+	top := worker.NewTopK(3)
+	for i := 0; i < 1000; i++ {
+		top.Add(fmt.Sprintf("rare-%d", i))
+		if i%2 == 0 {
+			top.Add("/login")
+		}
+		if i%4 == 0 {
+			top.Add("/")
+		}
+		if i%10 == 0 {
+			top.Add("/admin")
+		}
+	}
+	actual := top.Top()
+	values := []string{}
+	for _, v := range actual {
+		values = append(values, v.Value)
+	}
+	expected := []string{"/login", "/", "/admin"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Top(): expected %v, actual %v", expected, actual)
+	}
+}
+
+var hyperLogLogTestCases = []int{0, 1, 100, 10000, 200000}
+
+func TestHyperLogLog(t *testing.T) {
+	for i, n := range hyperLogLogTestCases {
+		h := worker.NewHyperLogLog()
+		for j := 0; j < n; j++ {
+			h.Add(fmt.Sprintf("10.0.%d.%d", j/256, j%256))
+			h.Add(fmt.Sprintf("10.0.%d.%d", j/256, j%256))
+		}
+		actual := h.Count()
+		if float64(actual) < 0.97*float64(n) || float64(actual) > 1.03*float64(n) {
+			t.Errorf("In test %d, Count() of %d distinct values: expected within 3%%, actual %d", i, n, actual)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package worker
+
+/*
+	snowflake.go loads events into Snowflake through a stage
+
+	Events are written, as gzipped JSON lines, to files of up to
+	snowflake.max events, at least every snowflake.flush_interval, which
+	are uploaded to the S3 bucket snowflake.bucket, under snowflake.prefix
+	("translog/" by default), as objects named by the time they are
+	uploaded (e.g. translog/2024/03/01/12/20240301T120000Z-1a2b3c4d.json.gz).
+	For Google Cloud Storage, set snowflake.endpoint to
+	https://storage.googleapis.com, and use HMAC keys as the AWS
+	credentials, which are taken from the usual AWS environment variables,
+	shared credentials file, or instance role, as in kinesis.go.
+
+	Every snowflake.copy_interval (5m by default), if files were uploaded
+	since, translog runs snowflake.copy, by default
+
+		COPY INTO {table} FROM {stage} FILE_FORMAT = (TYPE = JSON COMPRESSION = GZIP)
+
+	with {table} and {stage} replaced by snowflake.table and snowflake.stage
+	(an external stage on the bucket and prefix, such as @translog_stage),
+	with the Snowflake client snowflake.client (snowsql by default), using
+	its connection snowflake.connection. Snowflake remembers which files it
+	has loaded, so a COPY that fails is simply run again the next time, and
+	loads everything uploaded since the last one that succeeded.
+
+	Files that can't be uploaded are dead-lettered, one line per event.
+	Requests go through snowflake.proxy, if set (see proxy.go).
+*/
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configSnowflakeBucket = "snowflake.bucket"
+const configSnowflakePrefix = "snowflake.prefix"
+const configSnowflakeRegion = "snowflake.region"
+const configSnowflakeEndpoint = "snowflake.endpoint"
+const configSnowflakeMax = "snowflake.max"
+const configSnowflakeFlushInterval = "snowflake.flush_interval"
+const configSnowflakeCopyInterval = "snowflake.copy_interval"
+const configSnowflakeStage = "snowflake.stage"
+const configSnowflakeTable = "snowflake.table"
+const configSnowflakeCopy = "snowflake.copy"
+const configSnowflakeClient = "snowflake.client"
+const configSnowflakeConnection = "snowflake.connection"
+
+// snowflakeCopyTimeout limits each COPY INTO
+const snowflakeCopyTimeout = 30 * time.Minute
+
+// ConfiguredSnowflakePrefix returns the prefix of uploaded objects
+func ConfiguredSnowflakePrefix() string {
+	if viper.IsSet(configSnowflakePrefix) {
+		return viper.GetString(configSnowflakePrefix)
+	}
+	return "translog/"
+}
+
+// ConfiguredSnowflakeMax returns the most events in a file
+func ConfiguredSnowflakeMax() int {
+	if viper.IsSet(configSnowflakeMax) {
+		if max := viper.GetInt(configSnowflakeMax); max > 0 {
+			return max
+		}
+		reportError(&ConfigError{Key: configSnowflakeMax, Value: viper.Get(configSnowflakeMax), Reason: "using 10000"})
+	}
+	return 10000
+}
+
+// ConfiguredSnowflakeFlushInterval returns the longest events wait for
+// their file to be uploaded
+func ConfiguredSnowflakeFlushInterval() time.Duration {
+	if viper.IsSet(configSnowflakeFlushInterval) {
+		if interval := viper.GetDuration(configSnowflakeFlushInterval); interval > 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configSnowflakeFlushInterval, Value: viper.Get(configSnowflakeFlushInterval), Reason: "using 1m"})
+	}
+	return time.Minute
+}
+
+// ConfiguredSnowflakeCopyInterval returns how often COPY INTO is run
+func ConfiguredSnowflakeCopyInterval() time.Duration {
+	if viper.IsSet(configSnowflakeCopyInterval) {
+		if interval := viper.GetDuration(configSnowflakeCopyInterval); interval > 0 {
+			return interval
+		}
+		reportError(&ConfigError{Key: configSnowflakeCopyInterval, Value: viper.Get(configSnowflakeCopyInterval), Reason: "using 5m"})
+	}
+	return 5 * time.Minute
+}
+
+// ConfiguredSnowflakeCopy returns the statement loading the staged files
+func ConfiguredSnowflakeCopy() string {
+	statement := "COPY INTO {table} FROM {stage} FILE_FORMAT = (TYPE = JSON COMPRESSION = GZIP)"
+	if viper.IsSet(configSnowflakeCopy) {
+		statement = viper.GetString(configSnowflakeCopy)
+	}
+	return strings.NewReplacer("{table}", viper.GetString(configSnowflakeTable), "{stage}", viper.GetString(configSnowflakeStage)).Replace(statement)
+}
+
+// ConfiguredSnowflakeClient returns the Snowflake client program
+func ConfiguredSnowflakeClient() string {
+	if viper.IsSet(configSnowflakeClient) {
+		return viper.GetString(configSnowflakeClient)
+	}
+	return "snowsql"
+}
+
+// SnowflakeObjectKey returns the key of a file uploaded at a time
+func SnowflakeObjectKey(prefix string, now time.Time) string {
+	now = now.UTC()
+	return prefix + now.Format("2006/01/02/15/20060102T150405Z") + "-" + randomPartitionKey()[:8] + ".json.gz"
+}
+
+// newSnowflakeClient creates the client for the configured bucket
+func newSnowflakeClient() (*s3.S3, error) {
+	if viper.GetString(configSnowflakeBucket) == "" {
+		return nil, fmt.Errorf("%s must be set", configSnowflakeBucket)
+	}
+	config := aws.NewConfig()
+	if region := viper.GetString(configSnowflakeRegion); region != "" {
+		config = config.WithRegion(region)
+	}
+	if endpoint := viper.GetString(configSnowflakeEndpoint); endpoint != "" {
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+	transport, err := configuredProxyTransport("snowflake")
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		config = config.WithHTTPClient(&http.Client{Transport: transport})
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Config: *config, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// SnowflakeWorker stages events in S3 and loads them into Snowflake
+type SnowflakeWorker struct {
+	WorkChannel  chan map[string]interface{}
+	QuitChannel  chan bool
+	FlushChannel chan bool
+	client       *s3.S3
+	lines        [][]byte
+	copying      sync.Mutex // held while COPY INTO runs
+	lock         sync.Mutex // guards uploaded
+	uploaded     bool       // files were uploaded since the last COPY INTO that succeeded
+	startTime    time.Time
+	healthTracker
+}
+
+func (w *SnowflakeWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *SnowflakeWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.FlushChannel = make(chan bool)
+	w.client, err = newSnowflakeClient()
+	if err != nil {
+		logs.Warn("Unable to create the Snowflake stage client: %v", err)
+	}
+	return nil
+}
+
+// Start the work
+func (w *SnowflakeWorker) Start() {
+	go Supervise("SnowflakeWorker", w.Work)
+}
+
+// add adds an event to the pending file
+func (w *SnowflakeWorker) add(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	line, err := json.Marshal(obj)
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	w.lines = append(w.lines, line)
+	if len(w.lines) >= ConfiguredSnowflakeMax() {
+		w.flush()
+	}
+}
+
+// upload writes lines to a gzipped file in the bucket
+func (w *SnowflakeWorker) upload(key string, lines [][]byte) error {
+	if w.client == nil {
+		return fmt.Errorf("no Snowflake stage client")
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	for _, line := range lines {
+		gz.Write(line)
+		gz.Write([]byte{'\n'})
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	_, err := w.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(viper.GetString(configSnowflakeBucket)),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body.Bytes()),
+		ContentType: aws.String("application/gzip"),
+	})
+	return err
+}
+
+// flush uploads the pending events as one file, or dead-letters them
+func (w *SnowflakeWorker) flush() {
+	lines := w.lines
+	w.lines = nil
+	if len(lines) == 0 {
+		return
+	}
+	key := SnowflakeObjectKey(ConfiguredSnowflakePrefix(), time.Now())
+	start := time.Now()
+	if err := w.upload(key, lines); err != nil {
+		w.failed(&OutputError{Destination: "s3://" + viper.GetString(configSnowflakeBucket) + "/" + key, Err: err})
+		Counters.Add("snowflake_rejected", int64(len(lines)))
+		for _, line := range lines {
+			DeadLetter(DeadLetterRecord{Reason: "rejected", Line: string(line), Error: err.Error()})
+		}
+		return
+	}
+	w.succeeded(time.Since(start))
+	Counters.Inc("snowflake_files")
+	Counters.Add("snowflake_events", int64(len(lines)))
+	w.setUploaded(true)
+}
+
+// setUploaded sets whether there are files for COPY INTO to load, and
+// returns whether there were
+func (w *SnowflakeWorker) setUploaded(uploaded bool) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	was := w.uploaded
+	w.uploaded = uploaded
+	return was
+}
+
+// runCopy runs COPY INTO with the Snowflake client
+func runCopy(statement string) error {
+	var args []string
+	if connection := viper.GetString(configSnowflakeConnection); connection != "" {
+		args = append(args, "-c", connection)
+	}
+	args = append(args, "-o", "exit_on_error=true", "-o", "friendly=false", "-o", "quiet=true")
+	cmd := exec.Command(ConfiguredSnowflakeClient(), args...)
+	var stderr bytes.Buffer
+	cmd.Stdin, cmd.Stderr = strings.NewReader(statement+";\n"), &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(snowflakeCopyTimeout, func() { cmd.Process.Kill() })
+	err := cmd.Wait()
+	timer.Stop()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// copy loads the files uploaded since the last COPY INTO that succeeded;
+// the caller holds w.copying
+func (w *SnowflakeWorker) copy() {
+	if !w.setUploaded(false) {
+		return
+	}
+	start := time.Now()
+	if err := runCopy(ConfiguredSnowflakeCopy()); err != nil {
+		logs.Warn("Unable to load the staged files into Snowflake: %v", err)
+		Counters.Inc("snowflake_copy_errors")
+		w.failed(&OutputError{Destination: "Snowflake table " + viper.GetString(configSnowflakeTable), Err: err})
+		w.setUploaded(true)
+		return
+	}
+	Counters.Inc("snowflake_copies")
+	w.succeeded(time.Since(start))
+}
+
+// copyNow runs COPY INTO, after waiting for one running already
+func (w *SnowflakeWorker) copyNow() {
+	w.copying.Lock()
+	defer w.copying.Unlock()
+	w.copy()
+}
+
+// Work the queue
+func (w *SnowflakeWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("SnowflakeWorker starting work at %v", w.startTime)
+	ticker := time.NewTicker(ConfiguredSnowflakeFlushInterval())
+	defer ticker.Stop()
+	copyTicker := time.NewTicker(ConfiguredSnowflakeCopyInterval())
+	defer copyTicker.Stop()
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.add(obj)
+
+		case <-ticker.C:
+			w.flush()
+
+		case <-copyTicker.C:
+			// a slow COPY INTO mustn't hold up uploads, nor pile up
+			if w.copying.TryLock() {
+				go func() {
+					defer w.copying.Unlock()
+					w.copy()
+				}()
+			}
+
+		case <-w.FlushChannel:
+			w.flush()
+			w.copyNow()
+
+		case <-w.QuitChannel:
+			logs.Info("SnowflakeWorker received quit")
+			w.flush()
+			w.copyNow()
+			return
+		}
+	}
+}
+
+// Flush asks the worker to upload the events it has collected, and load
+// them into Snowflake
+func (w *SnowflakeWorker) Flush() {
+	w.FlushChannel <- true
+}
+
+// Stop stops the worker, after uploading and loading the events it has
+// collected
+func (w *SnowflakeWorker) Stop() {
+	w.QuitChannel <- true
+}
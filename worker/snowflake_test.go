@@ -0,0 +1,93 @@
+package worker_test
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// fakeSnowsql records its arguments and the statement it is given
+const fakeSnowsql = `#!/bin/sh
+echo "$*" > "$(dirname "$0")/args"
+cat > "$(dirname "$0")/stdin"
+`
+
+func TestSnowflakeObjectKey(t *testing.T) {
+	key := worker.SnowflakeObjectKey("translog/", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC))
+	if !regexp.MustCompile(`^translog/2024/03/01/12/20240301T123000Z-[0-9a-f]{8}\.json\.gz$`).MatchString(key) {
+		t.Errorf("SnowflakeObjectKey: unexpected key %q", key)
+	}
+}
+
+func TestSnowflakeWorker(t *testing.T) {
+	viper.Reset()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	type upload struct {
+		path string
+		body string
+	}
+	uploads := make(chan upload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" {
+			t.Errorf("expected PUT, got %v", req.Method)
+		}
+		u := upload{path: req.URL.Path}
+		if gz, err := gzip.NewReader(req.Body); err == nil {
+			bs, _ := io.ReadAll(gz)
+			u.body = string(bs)
+		}
+		uploads <- u
+	}))
+	defer server.Close()
+	client := writeClient(t, fakeSnowsql)
+	viper.Set("snowflake.bucket", "logs")
+	viper.Set("snowflake.region", "us-east-1")
+	viper.Set("snowflake.endpoint", server.URL)
+	viper.Set("snowflake.flush_interval", "1h")
+	viper.Set("snowflake.copy_interval", "1h")
+	viper.Set("snowflake.stage", "@translog_stage")
+	viper.Set("snowflake.table", "events")
+	viper.Set("snowflake.client", client)
+	viper.Set("snowflake.connection", "loader")
+
+	w := &worker.SnowflakeWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"status": int64(200)}
+	w.WorkChannel <- map[string]interface{}{"status": int64(500)}
+	w.Flush()
+
+	select {
+	case u := <-uploads:
+		if !regexp.MustCompile(`^/logs/translog/.*\.json\.gz$`).MatchString(u.path) {
+			t.Errorf("SnowflakeWorker: unexpected object %q", u.path)
+		}
+		if expected := "{\"status\":200}\n{\"status\":500}\n"; u.body != expected {
+			t.Errorf("SnowflakeWorker: expected %q, actual %q", expected, u.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SnowflakeWorker: timed out waiting for the upload")
+	}
+	// the copy runs before the next flush is received
+	w.Flush()
+	stdin, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(client), "stdin"))
+	if expected := "COPY INTO events FROM @translog_stage FILE_FORMAT = (TYPE = JSON COMPRESSION = GZIP);\n"; string(stdin) != expected {
+		t.Errorf("SnowflakeWorker: expected statement %q, actual %q", expected, stdin)
+	}
+	args, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(client), "args"))
+	if !regexp.MustCompile(`^-c loader `).Match(args) {
+		t.Errorf("SnowflakeWorker: expected the loader connection, actual %q", args)
+	}
+}
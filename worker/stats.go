@@ -0,0 +1,151 @@
+package worker
+
+/*
+	stats.go summarizes a whole log file
+
+	Summarize parses every line of a log file with the configured pattern,
+	without sending anything anywhere, and describes what it found: how many
+	lines matched, which types each field was parsed as, the range of the
+	events' timestamps, and the top values of chosen fields. It is a quick
+	sanity check of a pattern before shipping logs continuously.
+*/
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary describes the events parsed from a log file
+type Summary struct {
+	Lines    int64
+	Matched  int64
+	Types    map[string]map[string]int64 // the number of events with each type, by field
+	First    time.Time
+	Last     time.Time
+	Top      map[string][]TopValue
+	Distinct map[string]uint64
+	fields   []string
+}
+
+// typeName returns the name of the type a value was parsed as
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64:
+		return "int"
+	case float32, float64:
+		return "float"
+	case time.Time:
+		return "time"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}, []string:
+		return "list"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// Summarize parses every line read from in, and summarizes the events,
+// with the k most frequent values of fields
+func (w *LogParser) Summarize(in io.Reader, fields []string, k int) (Summary, error) {
+	s := Summary{
+		Types:    make(map[string]map[string]int64),
+		Top:      make(map[string][]TopValue),
+		Distinct: make(map[string]uint64),
+		fields:   fields,
+	}
+	tops := make(map[string]*TopK, len(fields))
+	distinct := make(map[string]*HyperLogLog, len(fields))
+	for _, field := range fields {
+		tops[field] = NewTopK(k)
+		distinct[field] = NewHyperLogLog()
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		s.Lines++
+		v, err := w.ParseEvents(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			continue
+		}
+		s.Matched++
+		for key, value := range v {
+			if s.Types[key] == nil {
+				s.Types[key] = make(map[string]int64)
+			}
+			s.Types[key][typeName(value)]++
+		}
+		if t, found := eventTimestamp(v); found {
+			if s.First.IsZero() || t.Before(s.First) {
+				s.First = t
+			}
+			if t.After(s.Last) {
+				s.Last = t
+			}
+		}
+		for _, field := range fields {
+			if value, found := lookupField(v, field); found && value != nil {
+				tops[field].Add(fmt.Sprint(value))
+				distinct[field].Add(fmt.Sprint(value))
+			}
+		}
+		ReleaseEvent(v)
+	}
+	for _, field := range fields {
+		s.Top[field] = tops[field].Top()
+		s.Distinct[field] = distinct[field].Count()
+	}
+	return s, scanner.Err()
+}
+
+// MatchRate returns the percentage of lines that matched
+func (s Summary) MatchRate() float64 {
+	if s.Lines == 0 {
+		return 0
+	}
+	return 100 * float64(s.Matched) / float64(s.Lines)
+}
+
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Lines:          %d\n", s.Lines)
+	fmt.Fprintf(&b, "Matched:        %d (%.1f%%)\n", s.Matched, s.MatchRate())
+	if !s.First.IsZero() {
+		fmt.Fprintf(&b, "Time range:     %s - %s (%v)\n", s.First.Format(time.RFC3339), s.Last.Format(time.RFC3339), s.Last.Sub(s.First))
+	} else {
+		fmt.Fprintf(&b, "Time range:     - (no timestamps)\n")
+	}
+	keys := make([]string, 0, len(s.Types))
+	for key := range s.Types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(&b, "\nFields:\n")
+	for _, key := range keys {
+		types := make([]string, 0, len(s.Types[key]))
+		for name := range s.Types[key] {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		described := make([]string, 0, len(types))
+		for _, name := range types {
+			described = append(described, fmt.Sprintf("%s %.1f%%", name, 100*float64(s.Types[key][name])/float64(s.Matched)))
+		}
+		fmt.Fprintf(&b, "  %-20s %s\n", key, strings.Join(described, ", "))
+	}
+	for _, field := range s.fields {
+		fmt.Fprintf(&b, "\nTop %s (about %d distinct):\n", field, s.Distinct[field])
+		for _, top := range s.Top[field] {
+			fmt.Fprintf(&b, "  %8d  %s\n", top.Count, top.Value)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package worker_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestSummarize(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?P<created>\S+) (?P<uri>\S+) (?P<status>\S+)$`)
+	w := &worker.LogParser{}
+	w.Init()
+	in := strings.NewReader(`2024-03-01T12:00:00Z /login 200
+2024-03-01T12:05:00Z /login 500
+2024-03-01T11:55:00Z / -
+not a log line
+`)
+	s, err := w.Summarize(in, []string{"uri"}, 2)
+	if err != nil {
+		t.Fatalf("Summarize(): unexpected error %v", err)
+	}
+	if s.Lines != 4 || s.Matched != 3 {
+		t.Errorf("Summarize(): expected 3 of 4 lines to match, actual %d of %d", s.Matched, s.Lines)
+	}
+	expectedTypes := map[string]int64{"int": 2, "string": 1}
+	if !reflect.DeepEqual(s.Types["status"], expectedTypes) {
+		t.Errorf("Summarize(): expected status types %v, actual %v", expectedTypes, s.Types["status"])
+	}
+	first, last := time.Date(2024, 3, 1, 11, 55, 0, 0, time.UTC), time.Date(2024, 3, 1, 12, 5, 0, 0, time.UTC)
+	if !s.First.Equal(first) || !s.Last.Equal(last) {
+		t.Errorf("Summarize(): expected the time range %v - %v, actual %v - %v", first, last, s.First, s.Last)
+	}
+	expectedTop := []worker.TopValue{{Value: "/login", Count: 2}, {Value: "/", Count: 1}}
+	if !reflect.DeepEqual(s.Top["uri"], expectedTop) || s.Distinct["uri"] != 2 {
+		t.Errorf("Summarize(): expected top uris %v (2 distinct), actual %v (%d distinct)", expectedTop, s.Top["uri"], s.Distinct["uri"])
+	}
+}
@@ -3,22 +3,28 @@ package worker
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/fizx/logs"
 )
 
 type StdOutWorker struct {
-	WorkChannel chan map[string]interface{}
-	QuitChannel chan bool
-	startTime   time.Time
+	WorkChannel  chan map[string]interface{}
+	EventChannel chan *Event
+	QuitChannel  chan bool
+	startTime    time.Time
 }
 
 func (w *StdOutWorker) SetWorkChannel(channel chan map[string]interface{}) {
 	w.WorkChannel = channel
 }
 
-//
+// SetEventChannel sets the channel typed events are received on
+func (w *StdOutWorker) SetEventChannel(channel chan *Event) {
+	w.EventChannel = channel
+}
+
 func (w *StdOutWorker) Init() (err error) {
 	w.QuitChannel = make(chan bool)
 	return
@@ -27,11 +33,12 @@ func (w *StdOutWorker) Init() (err error) {
 // Start the work
 func (w *StdOutWorker) Start() {
 	logs.Debug("Worker is %v", w)
-	go w.Work()
+	go Supervise("StdOutWorker", w.Work)
 }
 
 // Work the queue
 func (w *StdOutWorker) Work() {
+	var buffer []byte
 	w.startTime = time.Now()
 	logs.Info("StdOutWorker starting work at %v", w.startTime)
 	for {
@@ -43,8 +50,14 @@ func (w *StdOutWorker) Work() {
 				logs.Info("Unable to marshal object %v", obj)
 				break
 			}
+			ReleaseEvent(obj)
 			fmt.Println(string(line))
 
+		case e := <-w.EventChannel:
+			buffer = append(e.AppendJSON(buffer[:0]), '\n')
+			e.Release()
+			os.Stdout.Write(buffer)
+
 		case <-w.QuitChannel:
 			logs.Info("Worker received quit")
 			return
@@ -0,0 +1,276 @@
+package worker
+
+/*
+	stream.go serves the parsed events as a live stream
+
+	The StreamWorker listens on stream.address and broadcasts each event to
+	every connected client, as Server-Sent Events on /events, or as
+	WebSocket text messages on /ws. Both take optional query parameters:
+
+		fields=ip,status    only send these fields of each event
+		filter=status:404   only send events whose field has this value
+		                    (may be repeated; all must match)
+
+	Clients that can't keep up miss events, rather than slowing down the
+	pipeline. With stream.tls.cert_file set, the stream is served over TLS
+	(https and wss), and with stream.tls.client_ca_file, only to clients
+	with a certificate it signed (see tls.go).
+*/
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+const configStreamAddress = "stream.address"
+const configStreamBuffer = "stream.buffer"
+const configStreamAllowedOrigins = "stream.allowed_origins"
+const configStreamTLSCertFile = "stream.tls.cert_file"
+
+// streamKeepAlive is how often an idle SSE stream sends a comment, so that
+// proxies don't close it
+const streamKeepAlive = 15 * time.Second
+
+// StreamWorker broadcasts events to Server-Sent Events and WebSocket clients
+type StreamWorker struct {
+	WorkChannel chan map[string]interface{}
+	QuitChannel chan bool
+	Tap         EventTap
+	Mux         *http.ServeMux
+	listener    net.Listener
+	upgrader    websocket.Upgrader
+	startTime   time.Time
+}
+
+// ConfiguredStreamAddress returns the address the stream is served on
+func ConfiguredStreamAddress() string {
+	if viper.IsSet(configStreamAddress) {
+		return viper.GetString(configStreamAddress)
+	}
+	return "127.0.0.1:6070"
+}
+
+// ConfiguredStreamBuffer returns how many events are buffered for each
+// client
+func ConfiguredStreamBuffer() int {
+	if viper.IsSet(configStreamBuffer) {
+		return viper.GetInt(configStreamBuffer)
+	}
+	return 100
+}
+
+// streamQuery is a client's selection of events and fields
+type streamQuery struct {
+	fields  []string
+	filters map[string]string
+}
+
+func parseStreamQuery(req *http.Request) streamQuery {
+	q := streamQuery{filters: make(map[string]string)}
+	if fields := req.URL.Query().Get("fields"); fields != "" {
+		q.fields = strings.Split(fields, ",")
+	}
+	for _, filter := range req.URL.Query()["filter"] {
+		parts := strings.SplitN(filter, ":", 2)
+		if len(parts) == 2 {
+			q.filters[parts[0]] = parts[1]
+		}
+	}
+	return q
+}
+
+// apply returns the event as it is sent to the client, or nil if it should
+// not be sent
+func (q streamQuery) apply(v map[string]interface{}) map[string]interface{} {
+	for key, expected := range q.filters {
+		value, found := lookupField(v, key)
+		if !found || fmt.Sprint(value) != expected {
+			return nil
+		}
+	}
+	if q.fields == nil {
+		return v
+	}
+	selected := make(map[string]interface{}, len(q.fields))
+	for _, key := range q.fields {
+		if value, found := lookupField(v, key); found {
+			selected[key] = value
+		}
+	}
+	return selected
+}
+
+// allowedOrigin checks the Origin of WebSocket connections against
+// stream.allowed_origins (by default, only same-origin connections)
+func allowedOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range viper.GetStringSlice(configStreamAllowedOrigins) {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(origin, "http://"), "https://") == req.Host
+}
+
+func (w *StreamWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *StreamWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.upgrader = websocket.Upgrader{CheckOrigin: allowedOrigin}
+	w.Mux = http.NewServeMux()
+	w.Mux.HandleFunc("/events", w.handleEvents)
+	w.Mux.HandleFunc("/ws", w.handleWebSocket)
+	return
+}
+
+// Start the work
+func (w *StreamWorker) Start() {
+	address := ConfiguredStreamAddress()
+	listener, err := w.listen(address)
+	if err != nil {
+		logs.Warn("Unable to serve the event stream on %s: %v", address, err)
+	} else {
+		w.listener = listener
+		sse, ws := "http", "ws"
+		if viper.GetString(configStreamTLSCertFile) != "" {
+			sse, ws = "https", "wss"
+		}
+		logs.Info("Serving the event stream on %s://%s/events and %s://%s/ws", sse, address, ws, address)
+		go http.Serve(listener, w.Mux)
+	}
+	go Supervise("StreamWorker", w.Work)
+}
+
+// listen listens on address, with TLS if a certificate is configured
+func (w *StreamWorker) listen(address string) (net.Listener, error) {
+	var config *tls.Config
+	if viper.GetString(configStreamTLSCertFile) != "" {
+		var err error
+		if config, err = configuredServerTLS("stream"); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil || config == nil {
+		return listener, err
+	}
+	return tls.NewListener(listener, config), nil
+}
+
+// Work the queue
+func (w *StreamWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("StreamWorker starting work at %v", w.startTime)
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.Tap.Publish(obj)
+
+		case <-w.QuitChannel:
+			logs.Info("StreamWorker received quit")
+			return
+		}
+	}
+}
+
+// Stop stops the worker, and the server
+func (w *StreamWorker) Stop() {
+	w.QuitChannel <- true
+	if w.listener != nil {
+		w.listener.Close()
+	}
+}
+
+// Subscribers returns the number of connected clients
+func (w *StreamWorker) Subscribers() int {
+	return w.Tap.Subscribers()
+}
+
+func (w *StreamWorker) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	query := parseStreamQuery(req)
+	events := w.Tap.Subscribe(ConfiguredStreamBuffer())
+	defer w.Tap.Unsubscribe(events)
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case v := <-events:
+			if v = query.apply(v); v == nil {
+				continue
+			}
+			bs, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(rw, "data: %s\n\n", bs); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(rw, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (w *StreamWorker) handleWebSocket(rw http.ResponseWriter, req *http.Request) {
+	conn, err := w.upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		logs.Debug("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	query := parseStreamQuery(req)
+	events := w.Tap.Subscribe(ConfiguredStreamBuffer())
+	defer w.Tap.Unsubscribe(events)
+	// the client only sends control messages; reading them notices when it
+	// goes away
+	closed := make(chan bool)
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case v := <-events:
+			if v = query.apply(v); v == nil {
+				continue
+			}
+			if err := conn.WriteJSON(v); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package worker_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// waitForSubscribers waits until n clients are connected to the stream
+func waitForSubscribers(t *testing.T, w *worker.StreamWorker, n int) {
+	for i := 0; w.Subscribers() < n; i++ {
+		if i > 500 {
+			t.Fatalf("expected %d subscribers, got %d", n, w.Subscribers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamWorker(t *testing.T) {
+	viper.Reset()
+	viper.Set("stream.address", "127.0.0.1:0")
+	w := &worker.StreamWorker{}
+	w.Init()
+	channel := make(chan map[string]interface{})
+	w.SetWorkChannel(channel)
+	w.Start()
+	defer w.Stop()
+	server := httptest.NewServer(w.Mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?fields=ip&filter=status:404")
+	if err != nil {
+		t.Fatalf("expected the event stream, got %v", err)
+	}
+	defer resp.Body.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("expected a WebSocket connection, got %v", err)
+	}
+	defer conn.Close()
+	waitForSubscribers(t, w, 2)
+
+	channel <- map[string]interface{}{"ip": "8.8.8.8", "status": 200}
+	channel <- map[string]interface{}{"ip": "8.8.4.4", "status": 404}
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != `data: {"ip":"8.8.4.4"}`+"\n" {
+		t.Errorf("expected only the filtered event's ip, got %q (%v)", line, err)
+	}
+	for _, expected := range []string{"8.8.8.8", "8.8.4.4"} {
+		var v map[string]interface{}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&v); err != nil || v["ip"] != expected {
+			t.Errorf("expected the WebSocket to receive %v, got %v (%v)", expected, v, err)
+		}
+	}
+}
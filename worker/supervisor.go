@@ -0,0 +1,89 @@
+package worker
+
+/*
+	supervisor.go keeps the pipeline running when a goroutine panics
+
+	Long-running goroutines (the input, and each output's work loop) run
+	under Supervise, which recovers a panic, logs it with its stack trace,
+	and restarts the goroutine after a backoff (supervisor.initial_backoff,
+	doubling up to supervisor.max_backoff while the goroutine keeps
+	panicking). Restarts are counted per goroutine in Restarts. Short-lived
+	goroutines, such as those serving a single connection, use
+	recoverPanic, which logs the panic but does not restart anything.
+*/
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configSupervisorInitialBackoff = "supervisor.initial_backoff"
+const configSupervisorMaxBackoff = "supervisor.max_backoff"
+
+// Restarts counts how often each supervised goroutine has been restarted
+var Restarts = &CounterSet{}
+
+// ConfiguredSupervisorInitialBackoff returns how long to wait before
+// restarting a goroutine that panicked
+func ConfiguredSupervisorInitialBackoff() time.Duration {
+	if viper.IsSet(configSupervisorInitialBackoff) {
+		return viper.GetDuration(configSupervisorInitialBackoff)
+	}
+	return time.Second
+}
+
+// ConfiguredSupervisorMaxBackoff returns the longest wait before a restart
+func ConfiguredSupervisorMaxBackoff() time.Duration {
+	if viper.IsSet(configSupervisorMaxBackoff) {
+		return viper.GetDuration(configSupervisorMaxBackoff)
+	}
+	return time.Minute
+}
+
+// logPanic logs a recovered panic with its stack trace, and counts it
+func logPanic(name string, r interface{}) {
+	logs.Error("Panic in %s: %v\n%s", name, r, debug.Stack())
+	Counters.Inc("panics")
+}
+
+// recoverPanic logs and counts a panic; it must be deferred
+func recoverPanic(name string) {
+	if r := recover(); r != nil {
+		logPanic(name, r)
+	}
+}
+
+// runRecovered runs f, returning true if it panicked
+func runRecovered(name string, f func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(name, r)
+			panicked = true
+		}
+	}()
+	f()
+	return false
+}
+
+// Supervise runs f, restarting it with exponential backoff whenever it
+// panics; it returns when f returns normally
+func Supervise(name string, f func()) {
+	backoff := ConfiguredSupervisorInitialBackoff()
+	for {
+		start := time.Now()
+		if !runRecovered(name, f) {
+			return
+		}
+		if time.Since(start) > healthyRunTime {
+			backoff = ConfiguredSupervisorInitialBackoff()
+		}
+		logs.Warn("Restarting %s in %v", name, backoff)
+		time.Sleep(backoff)
+		Restarts.Inc(name)
+		if backoff *= 2; backoff > ConfiguredSupervisorMaxBackoff() {
+			backoff = ConfiguredSupervisorMaxBackoff()
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestSupervise(t *testing.T) {
+	viper.Reset()
+	viper.Set("supervisor.initial_backoff", "1ms")
+	runs := 0
+	before := worker.Restarts.Get("flaky")
+	worker.Supervise("flaky", func() {
+		runs++
+		if runs < 3 {
+			panic("boom")
+		}
+	})
+	if runs != 3 {
+		t.Errorf("expected 3 runs, actual %d", runs)
+	}
+	if restarts := worker.Restarts.Get("flaky") - before; restarts != 2 {
+		t.Errorf("expected 2 restarts, actual %d", restarts)
+	}
+}
@@ -0,0 +1,359 @@
+package worker
+
+/*
+	syslog.go forwards events as RFC 5424 syslog messages
+
+	Messages are sent to syslog.address over syslog.protocol: "tcp", "tls",
+	"relp", or "relp+tls". Over TCP (and TLS), messages are framed by octet
+	counting (RFC 6587); RELP (the Reliable Event Logging Protocol, see
+	relp.go) waits for the receiver to acknowledge each message, and
+	resends it over a new connection if it is not acknowledged.
+
+	The severity comes from the event's syslog.severity_field (a severity
+	number, or a name such as "warn"), and the facility from its
+	syslog.facility_field (a facility number, or a name such as "local0");
+	syslog.severity and syslog.facility are used for events without them.
+	The message is the event's syslog.message_field, or the whole event as
+	JSON. With syslog.structured_data, the other fields are also sent as
+	structured data.
+*/
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configSyslogAddress = "syslog.address"
+const configSyslogProtocol = "syslog.protocol"
+const configSyslogAppName = "syslog.app_name"
+const configSyslogSeverity = "syslog.severity"
+const configSyslogSeverityField = "syslog.severity_field"
+const configSyslogFacility = "syslog.facility"
+const configSyslogFacilityField = "syslog.facility_field"
+const configSyslogMessageField = "syslog.message_field"
+const configSyslogStructuredData = "syslog.structured_data"
+const configSyslogMaxRetries = "syslog.max_retries"
+
+// syslogDialTimeout limits how long to wait for a connection
+const syslogDialTimeout = 10 * time.Second
+
+// syslogSDID is the ID of the structured data element holding event fields
+// (32473 is the private enterprise number reserved for examples)
+const syslogSDID = "translog@32473"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSDReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// SyslogWorker forwards events to a syslog receiver
+type SyslogWorker struct {
+	WorkChannel chan map[string]interface{}
+	QuitChannel chan bool
+	conn        net.Conn
+	relp        *relpClient
+	startTime   time.Time
+	healthTracker
+}
+
+// ConfiguredSyslogAddress returns the address of the syslog receiver
+func ConfiguredSyslogAddress() string {
+	if viper.IsSet(configSyslogAddress) {
+		return viper.GetString(configSyslogAddress)
+	}
+	return "localhost:514"
+}
+
+// ConfiguredSyslogProtocol returns the protocol: tcp, tls, relp, or relp+tls
+func ConfiguredSyslogProtocol() string {
+	if viper.IsSet(configSyslogProtocol) {
+		return viper.GetString(configSyslogProtocol)
+	}
+	return "tcp"
+}
+
+// ConfiguredSyslogMaxRetries returns how often a message is resent before
+// it is given up on
+func ConfiguredSyslogMaxRetries() int {
+	if viper.IsSet(configSyslogMaxRetries) {
+		return viper.GetInt(configSyslogMaxRetries)
+	}
+	return 3
+}
+
+// SyslogFacility converts a facility (a number, or a name such as "local0")
+// into a facility number
+func SyslogFacility(facility interface{}) (int, bool) {
+	switch f := facility.(type) {
+	case int64:
+		return int(f), f >= 0 && f <= 23
+	case int:
+		return f, f >= 0 && f <= 23
+	case float64:
+		return int(f), f >= 0 && f <= 23
+	case string:
+		if n, err := strconv.Atoi(f); err == nil {
+			return n, n >= 0 && n <= 23
+		}
+		n, found := syslogFacilities[strings.ToLower(f)]
+		return n, found
+	}
+	return 0, false
+}
+
+// syslogField returns the event field configured by key, or defaultField
+func syslogField(key string, defaultField string) string {
+	if viper.IsSet(key) {
+		return viper.GetString(key)
+	}
+	return defaultField
+}
+
+// syslogPriority returns the PRI of an event: facility * 8 + severity
+func syslogPriority(v map[string]interface{}) int {
+	severity, ok := SyslogSeverity(v[syslogField(configSyslogSeverityField, "level")])
+	if !ok {
+		severity, ok = SyslogSeverity(viper.Get(configSyslogSeverity))
+		if !ok {
+			severity = 6 // info
+		}
+	}
+	facility, ok := SyslogFacility(v[syslogField(configSyslogFacilityField, "facility")])
+	if !ok {
+		facility, ok = SyslogFacility(viper.Get(configSyslogFacility))
+		if !ok {
+			facility = 1 // user
+		}
+	}
+	return facility*8 + severity
+}
+
+// syslogHeaderValue returns a header field, which must be printable ASCII
+// without spaces, of at most max characters; or "-" if it is empty
+func syslogHeaderValue(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	if len(value) > max {
+		value = value[:max]
+	}
+	return value
+}
+
+// FormatSyslog formats an event as an RFC 5424 syslog message
+func FormatSyslog(v map[string]interface{}) string {
+	messageField := syslogField(configSyslogMessageField, "message")
+	var message string
+	if m, found := v[messageField]; found {
+		message = fmt.Sprint(m)
+	} else {
+		bs, _ := json.Marshal(v)
+		message = string(bs)
+	}
+	hostname, found := v["host"]
+	if !found {
+		hostname, _ = os.Hostname()
+	}
+	appName := "translog"
+	if viper.IsSet(configSyslogAppName) {
+		appName = FillTemplate(viper.GetString(configSyslogAppName), v)
+	}
+	structuredData := "-"
+	if viper.GetBool(configSyslogStructuredData) {
+		structuredData = formatSyslogSD(v, messageField)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - %s %s",
+		syslogPriority(v),
+		EventTime(v).UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderValue(fmt.Sprint(hostname), 255),
+		syslogHeaderValue(appName, 48),
+		structuredData,
+		message)
+}
+
+// formatSyslogSD formats the fields of an event, other than the message, as
+// a structured data element
+func formatSyslogSD(v map[string]interface{}, messageField string) string {
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		if key != messageField {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "-"
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("[" + syslogSDID)
+	for _, key := range keys {
+		// parameter names are at most 32 printable characters, without = ] "
+		name := syslogHeaderValue(strings.NewReplacer("=", "_", "]", "_", `"`, "_").Replace(key), 32)
+		value := v[key]
+		switch value.(type) {
+		case string, int64, int, float64, bool:
+		case time.Time:
+			value = value.(time.Time).Format(time.RFC3339Nano)
+		default:
+			bs, _ := json.Marshal(value)
+			value = string(bs)
+		}
+		fmt.Fprintf(&b, ` %s="%s"`, name, syslogSDReplacer.Replace(fmt.Sprint(value)))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+func (w *SyslogWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *SyslogWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	switch protocol := ConfiguredSyslogProtocol(); protocol {
+	case "tcp", "tls", "relp", "relp+tls":
+	default:
+		err = &ConfigError{Key: configSyslogProtocol, Value: protocol, Reason: "expected tcp, tls, relp, or relp+tls"}
+		reportError(err)
+	}
+	return
+}
+
+// Start the work
+func (w *SyslogWorker) Start() {
+	go Supervise("SyslogWorker", w.Work)
+}
+
+// connect opens the connection, if it is not already open
+func (w *SyslogWorker) connect() error {
+	if w.conn != nil {
+		return nil
+	}
+	protocol := ConfiguredSyslogProtocol()
+	address := ConfiguredSyslogAddress()
+	var conn net.Conn
+	var err error
+	if protocol == "tls" || protocol == "relp+tls" {
+		var config *tls.Config
+		config, err = configuredTLS("syslog")
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			config = &tls.Config{}
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: syslogDialTimeout}, "tcp", address, config)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, syslogDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(protocol, "relp") {
+		relp := &relpClient{conn: conn, reader: bufio.NewReader(conn)}
+		if err = relp.open(); err != nil {
+			conn.Close()
+			return err
+		}
+		w.relp = relp
+	}
+	w.conn = conn
+	return nil
+}
+
+// disconnect closes the connection
+func (w *SyslogWorker) disconnect() {
+	if w.conn == nil {
+		return
+	}
+	if w.relp != nil {
+		w.relp.close()
+		w.relp = nil
+	}
+	w.conn.Close()
+	w.conn = nil
+}
+
+// write sends a message, returning once it has been acknowledged (with
+// RELP) or written (otherwise)
+func (w *SyslogWorker) write(message string) error {
+	if err := w.connect(); err != nil {
+		return err
+	}
+	var err error
+	if w.relp != nil {
+		err = w.relp.send(message)
+	} else {
+		_, err = fmt.Fprintf(w.conn, "%d %s", len(message), message)
+	}
+	if err != nil {
+		w.disconnect()
+	}
+	return err
+}
+
+// send forwards an event, retrying over a new connection if necessary
+func (w *SyslogWorker) send(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	message := FormatSyslog(obj)
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt <= ConfiguredSyslogMaxRetries(); attempt++ {
+		if attempt > 0 {
+			Counters.Inc("syslog_retries")
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		if err = w.write(message); err == nil {
+			w.succeeded(time.Since(start))
+			return
+		}
+		logs.Warn("Unable to send syslog message to %s: %v", ConfiguredSyslogAddress(), err)
+	}
+	w.failed(&OutputError{Destination: ConfiguredSyslogAddress(), Err: err})
+	document, _ := json.Marshal(obj)
+	deadLetterDocument(string(document), "rejected", err.Error())
+}
+
+// Work the queue
+func (w *SyslogWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("SyslogWorker starting work at %v", w.startTime)
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			logs.Debug("Worker received: %v", obj)
+			w.send(obj)
+
+		case <-w.QuitChannel:
+			logs.Info("SyslogWorker received quit")
+			w.disconnect()
+			return
+		}
+	}
+}
+
+// Stop stops the worker, and closes its connection
+func (w *SyslogWorker) Stop() {
+	w.QuitChannel <- true
+}
@@ -0,0 +1,163 @@
+package worker_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var formatSyslogTestCases = []struct {
+	event    map[string]interface{}
+	expected string
+}{
+	{
+		map[string]interface{}{"message": "hello", "host": "web1", "created": "2024-03-01T12:00:00Z"},
+		"<14>1 2024-03-01T12:00:00.000000Z web1 translog - - - hello",
+	},
+	{
+		map[string]interface{}{"message": "oops", "host": "web1", "created": "2024-03-01T12:00:00Z", "level": "ERROR", "facility": "local0"},
+		"<131>1 2024-03-01T12:00:00.000000Z web1 translog - - - oops",
+	},
+	{
+		map[string]interface{}{"host": "web 1", "created": "2024-03-01T12:00:00Z", "level": int64(4)},
+		`<12>1 2024-03-01T12:00:00.000000Z web_1 translog - - - {"created":"2024-03-01T12:00:00Z","host":"web 1","level":4}`,
+	},
+}
+
+func TestFormatSyslog(t *testing.T) {
+	viper.Reset()
+	for i, tt := range formatSyslogTestCases {
+		actual := worker.FormatSyslog(tt.event)
+		if actual != tt.expected {
+			t.Errorf("In test %d, FormatSyslog(%v): expected %q, actual %q", i, tt.event, tt.expected, actual)
+		}
+	}
+	viper.Set("syslog.structured_data", true)
+	viper.Set("syslog.app_name", "{app}")
+	event := map[string]interface{}{"message": "hi", "host": "web1", "created": "2024-03-01T12:00:00Z", "app": "shop", "path": `/a"]`}
+	expected := `<14>1 2024-03-01T12:00:00.000000Z web1 shop - - [translog@32473 app="shop" created="2024-03-01T12:00:00Z" host="web1" path="/a\"\]"] hi`
+	if actual := worker.FormatSyslog(event); actual != expected {
+		t.Errorf("FormatSyslog(%v): expected %q, actual %q", event, expected, actual)
+	}
+}
+
+// startSyslogWorker starts a worker sending to listener with protocol
+func startSyslogWorker(t *testing.T, listener net.Listener, protocol string) chan map[string]interface{} {
+	viper.Reset()
+	viper.Set("syslog.address", listener.Addr().String())
+	viper.Set("syslog.protocol", protocol)
+	channel := make(chan map[string]interface{})
+	w := &worker.SyslogWorker{}
+	w.SetWorkChannel(channel)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	w.Start()
+	t.Cleanup(w.Stop)
+	return channel
+}
+
+func TestSyslogTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	channel := startSyslogWorker(t, listener, "tcp")
+	channel <- map[string]interface{}{"message": "one"}
+	channel <- map[string]interface{}{"message": "two"}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for _, expected := range []string{"one", "two"} {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		message := make([]byte, n)
+		if _, err := io.ReadFull(reader, message); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(message), "<14>1 ") || !strings.HasSuffix(string(message), " "+expected) {
+			t.Errorf("expected a message ending with %q, got %q", expected, message)
+		}
+	}
+}
+
+// readRELPFrame reads a frame of a RELP session
+func readRELPFrame(reader *bufio.Reader) (txnr string, command string, data string, err error) {
+	var length int
+	if _, err = fmt.Fscanf(reader, "%s %s %d", &txnr, &command, &length); err != nil {
+		return
+	}
+	if length > 0 {
+		reader.ReadByte() // the space before the data
+		bs := make([]byte, length)
+		if _, err = io.ReadFull(reader, bs); err != nil {
+			return
+		}
+		data = string(bs)
+	}
+	_, err = reader.ReadByte() // the trailer
+	return
+}
+
+func TestSyslogRELP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 10)
+	go func() {
+		for connection := 0; ; connection++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			for {
+				txnr, command, data, err := readRELPFrame(reader)
+				if err != nil {
+					break
+				}
+				if command == "syslog" {
+					if connection == 0 {
+						// drop the first connection without acknowledging
+						break
+					}
+					received <- data
+				}
+				response := "200 OK"
+				if command == "open" {
+					response += "\n" + data
+				}
+				fmt.Fprintf(conn, "%s rsp %d %s\n", txnr, len(response), response)
+			}
+			conn.Close()
+		}
+	}()
+	channel := startSyslogWorker(t, listener, "relp")
+	channel <- map[string]interface{}{"message": "hello"}
+	select {
+	case message := <-received:
+		if !strings.HasSuffix(message, " hello") {
+			t.Errorf("expected a message ending with hello, got %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the message to be resent over a new connection")
+	}
+}
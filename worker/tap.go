@@ -0,0 +1,54 @@
+package worker
+
+import "sync"
+
+// An EventTap lets observers (e.g. the admin API's live tail) see the
+// events flowing through the pipeline. Publishing never blocks: events are
+// dropped for subscribers that do not keep up.
+type EventTap struct {
+	lock        sync.Mutex
+	subscribers map[chan map[string]interface{}]bool
+}
+
+// Subscribe returns a channel receiving published events; buffer is the
+// number of events to buffer before dropping
+func (t *EventTap) Subscribe(buffer int) chan map[string]interface{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.subscribers == nil {
+		t.subscribers = make(map[chan map[string]interface{}]bool)
+	}
+	channel := make(chan map[string]interface{}, buffer)
+	t.subscribers[channel] = true
+	return channel
+}
+
+// Unsubscribe stops sending events to the channel, and closes it
+func (t *EventTap) Unsubscribe(channel chan map[string]interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.subscribers[channel] {
+		delete(t.subscribers, channel)
+		close(channel)
+	}
+}
+
+// Subscribers returns the number of subscribers
+func (t *EventTap) Subscribers() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.subscribers)
+}
+
+// Publish sends the event to all subscribers that have room for it
+func (t *EventTap) Publish(v map[string]interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for channel := range t.subscribers {
+		select {
+		case channel <- v:
+		default:
+			Counters.Inc("tap_events_dropped")
+		}
+	}
+}
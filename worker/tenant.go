@@ -0,0 +1,96 @@
+package worker
+
+/*
+	tenant.go stamps events with the tenant they belong to
+
+	So that one translog can ship the logs of several teams, each event may
+	belong to a tenant: the value of its tenant.field, or tenant.id for
+	events without one (or if no field is configured). The tenant is added
+	to the event as tenant.label ("tenant" by default), e.g.
+
+		[tenant]
+		field = "kubernetes.namespace"
+		id = "platform"
+
+	and picks where the event goes: the Elastic Search index is prefixed
+	with the tenant (unless es.index already has a {tenant} placeholder),
+	and other templated destinations, such as mqtt.topic, can use the
+	{tenant} placeholder.
+*/
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const configTenantID = "tenant.id"
+const configTenantField = "tenant.field"
+const configTenantLabel = "tenant.label"
+
+// ConfiguredTenantLabel returns the field events are stamped with their
+// tenant in
+func ConfiguredTenantLabel() string {
+	if viper.IsSet(configTenantLabel) {
+		if label := viper.GetString(configTenantLabel); label != "" {
+			return label
+		}
+		reportError(&ConfigError{Key: configTenantLabel, Value: viper.Get(configTenantLabel), Reason: "using tenant"})
+	}
+	return "tenant"
+}
+
+// tenancy finds the tenants of events
+type tenancy struct {
+	id    string
+	field string
+	label string
+}
+
+// ConfiguredTenancy returns how the tenants of events are found, or nil if
+// there are no tenants
+func ConfiguredTenancy() *tenancy {
+	id, field := viper.GetString(configTenantID), viper.GetString(configTenantField)
+	if id == "" && field == "" {
+		return nil
+	}
+	return &tenancy{id: id, field: field, label: ConfiguredTenantLabel()}
+}
+
+// tenant returns the tenant of an event, or "" if it has none
+func (t *tenancy) tenant(v map[string]interface{}) string {
+	if t.field != "" {
+		if value, found := lookupField(v, t.field); found && value != nil {
+			if s := fmt.Sprint(value); s != "" {
+				return s
+			}
+		}
+	}
+	return t.id
+}
+
+// stamp adds the tenant to an event
+func (t *tenancy) stamp(v map[string]interface{}) {
+	if t == nil {
+		return
+	}
+	if _, found := v[t.label]; found {
+		return
+	}
+	if tenant := t.tenant(v); tenant != "" {
+		v[t.label] = tenant
+	}
+}
+
+// TenantIndex returns an index name template with the tenant as a prefix,
+// if there are tenants and the template doesn't place the tenant already
+func TenantIndex(template string) string {
+	if ConfiguredTenancy() == nil {
+		return template
+	}
+	placeholder := "{" + ConfiguredTenantLabel() + "}"
+	if strings.Contains(template, placeholder) {
+		return template
+	}
+	return placeholder + "-" + template
+}
@@ -0,0 +1,57 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+func TestProcessLineTenants(t *testing.T) {
+	viper.Reset()
+	viper.Set("parse.pattern", `^(?:(?P<team>\w+): )?(?P<message>.*)$`)
+	viper.Set("tenant.field", "team")
+	viper.Set("tenant.id", "platform")
+	channel := make(chan map[string]interface{})
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	var tenantTestCases = []struct {
+		line     string
+		expected string
+	}{
+		{"payments: charge failed", "payments"},
+		{"no team", "platform"},
+	}
+	for i, tt := range tenantTestCases {
+		w.ProcessLine(tt.line)
+		v := <-channel
+		if v["tenant"] != tt.expected {
+			t.Errorf("In test %d, ProcessLine(%v): expected tenant %v, actual %v", i, tt.line, tt.expected, v["tenant"])
+		}
+	}
+}
+
+func TestTenantIndex(t *testing.T) {
+	viper.Reset()
+	if index := worker.TenantIndex("logs-{2006.01.02}"); index != "logs-{2006.01.02}" {
+		t.Errorf("expected the index to be unchanged without tenants, actual %v", index)
+	}
+	viper.Set("tenant.id", "platform")
+	var tenantIndexTestCases = []struct {
+		template string
+		expected string
+	}{
+		{"logs-{2006.01.02}", "{tenant}-logs-{2006.01.02}"},
+		{"logs-{tenant}-{2006.01.02}", "logs-{tenant}-{2006.01.02}"},
+	}
+	for i, tt := range tenantIndexTestCases {
+		if actual := worker.TenantIndex(tt.template); actual != tt.expected {
+			t.Errorf("In test %d, TenantIndex(%v): expected %v, actual %v", i, tt.template, tt.expected, actual)
+		}
+	}
+	v := map[string]interface{}{"tenant": "Payments"}
+	if name := worker.IndexName(worker.TenantIndex("logs"), v); name != "payments-logs" {
+		t.Errorf("expected the tenant's index, actual %v", name)
+	}
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configInputMaxLinesPerSec = "input.max_lines_per_sec"
+
+// throttleReportEvery is how often progress is reported while throttling
+const throttleReportEvery = 10 * time.Second
+
+// A Throttle limits the rate at which lines are read, by all the readers
+// of the input together (e.g. each connection to a socket)
+type Throttle struct {
+	rate       float64
+	lock       sync.Mutex
+	start      time.Time
+	next       time.Time // when the next line may be read
+	count      int64
+	throttled  int64
+	lastReport time.Time
+}
+
+// NewThrottle creates a throttle allowing rate lines per second; a rate of 0
+// (or less) means no throttling
+func NewThrottle(rate float64) *Throttle {
+	now := time.Now()
+	return &Throttle{rate: rate, start: now, next: now, lastReport: now}
+}
+
+// ConfiguredThrottle creates a throttle from input.max_lines_per_sec
+func ConfiguredThrottle() *Throttle {
+	return NewThrottle(viper.GetFloat64(configInputMaxLinesPerSec))
+}
+
+// Wait blocks until the next line may be read, and reports progress
+// while throttling
+func (t *Throttle) Wait() {
+	if t.rate <= 0 {
+		return
+	}
+	// held while sleeping, so that the other readers wait their turn
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.count++
+	now := time.Now()
+	if t.next.After(now) {
+		t.throttled++
+		time.Sleep(t.next.Sub(now))
+		now = t.next
+	}
+	// lines not read while the input was idle aren't made up for in a burst
+	t.next = now.Add(time.Duration(float64(time.Second) / t.rate))
+	if now.Sub(t.lastReport) >= throttleReportEvery {
+		t.report(now)
+	}
+}
+
+func (t *Throttle) report(now time.Time) {
+	var report struct {
+		Throttled        bool    `json:"throttled"`
+		LinesRead        int64   `json:"lines_read"`
+		LinesThrottled   int64   `json:"lines_throttled"`
+		MaxLinesPerSec   float64 `json:"max_lines_per_sec"`
+		LinesPerSecond   float64 `json:"lines_per_second"`
+		TotalElapsedTime float64 `json:"total_elapsed_time"`
+	}
+	elapsed := now.Sub(t.start).Seconds()
+	report.Throttled = t.throttled > 0
+	report.LinesRead = t.count
+	report.LinesThrottled = t.throttled
+	report.MaxLinesPerSec = t.rate
+	report.LinesPerSecond = float64(t.count) / elapsed
+	report.TotalElapsedTime = elapsed
+	strReport, _ := json.Marshal(report)
+	logs.Info("%v", string(strReport))
+	t.lastReport = now
+	t.throttled = 0
+}
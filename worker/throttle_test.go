@@ -0,0 +1,66 @@
+package worker_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/willf/translog/worker"
+)
+
+func TestThrottle(t *testing.T) {
+	throttle := worker.NewThrottle(100)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		throttle.Wait()
+	}
+	elapsed := time.Since(start)
+	if elapsed < 90*time.Millisecond {
+		t.Errorf("expected 11 lines at 100 lines/sec to take about 100ms, but took %v", elapsed)
+	}
+}
+
+func TestThrottleAfterIdle(t *testing.T) {
+	throttle := worker.NewThrottle(100)
+	throttle.Wait()
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		throttle.Wait()
+	}
+	elapsed := time.Since(start)
+	if elapsed < 90*time.Millisecond {
+		t.Errorf("expected 11 lines after being idle to still take about 100ms, but took %v", elapsed)
+	}
+}
+
+func TestThrottleConcurrentReaders(t *testing.T) {
+	throttle := worker.NewThrottle(100)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for reader := 0; reader < 2; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 6; i++ {
+				throttle.Wait()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("expected 12 lines read by 2 readers at 100 lines/sec to take about 110ms, but took %v", elapsed)
+	}
+}
+
+func TestThrottleUnlimited(t *testing.T) {
+	throttle := worker.NewThrottle(0)
+	start := time.Now()
+	for i := 0; i < 10000; i++ {
+		throttle.Wait()
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("expected no throttling, but took %v", time.Since(start))
+	}
+}
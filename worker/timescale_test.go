@@ -0,0 +1,87 @@
+package worker_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// fakeTimescalePsql records its arguments, its password and the statements
+// it is given
+const fakeTimescalePsql = `#!/bin/sh
+echo "$* $PGPASSWORD" > "$(dirname "$0")/args"
+cat >> "$(dirname "$0")/stdin"
+`
+
+var timescaleChunkIntervalTestCases = []struct {
+	rate     float64
+	rows     int
+	expected time.Duration
+}{
+	{1, 10000000, 30 * 24 * time.Hour},
+	{10, 10000000, 7 * 24 * time.Hour},
+	{100, 10000000, 24 * time.Hour},
+	{1000, 10000000, time.Hour},
+	{100000, 10000000, time.Hour},
+}
+
+func TestTimescaleChunkInterval(t *testing.T) {
+	for i, tt := range timescaleChunkIntervalTestCases {
+		if actual := worker.TimescaleChunkInterval(tt.rate, tt.rows); actual != tt.expected {
+			t.Errorf("In test %d, TimescaleChunkInterval(%v, %v): expected %v, actual %v", i, tt.rate, tt.rows, tt.expected, actual)
+		}
+	}
+}
+
+func TestTimescaleRow(t *testing.T) {
+	timestamp := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	actual := worker.TimescaleRow(timestamp, []byte(`{"path":"C:\\tmp"}`))
+	if expected := "2024-03-01T12:00:00Z\t{\"path\":\"C:\\\\\\\\tmp\"}\n"; actual != expected {
+		t.Errorf("TimescaleRow: expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestTimescaleWorker(t *testing.T) {
+	viper.Reset()
+	client := writeClient(t, fakeTimescalePsql)
+	viper.Set("timescale.client", client)
+	viper.Set("timescale.dsn", "postgres://translog:secret@db/logs")
+	viper.Set("timescale.table", "logging.events")
+	viper.Set("timescale.chunk_interval", "24h")
+	viper.Set("timescale.flush_interval", "1h")
+
+	w := &worker.TimescaleWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"created": "2024-03-01T12:00:00Z", "status": int64(200)}
+	w.Flush()
+	w.WorkChannel <- map[string]interface{}{"created": "2024-03-01T12:00:01Z", "status": int64(500)}
+	w.Flush()
+	// the second batch is inserted before the next flush is received
+	w.Flush()
+
+	stdin, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(client), "stdin"))
+	expected := `CREATE TABLE IF NOT EXISTS "logging"."events" (time timestamptz NOT NULL, event jsonb NOT NULL);
+SELECT create_hypertable('"logging"."events"', 'time', chunk_time_interval => INTERVAL '86400 seconds', if_not_exists => TRUE);
+COPY "logging"."events" (time, event) FROM STDIN;
+2024-03-01T12:00:00Z	{"created":"2024-03-01T12:00:00Z","status":200}
+\.
+COPY "logging"."events" (time, event) FROM STDIN;
+2024-03-01T12:00:01Z	{"created":"2024-03-01T12:00:01Z","status":500}
+\.
+`
+	if string(stdin) != expected {
+		t.Errorf("TimescaleWorker: expected %q, actual %q", expected, stdin)
+	}
+	args, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(client), "args"))
+	if !strings.Contains(string(args), "--single-transaction") || !strings.HasSuffix(strings.TrimSpace(string(args)), "--dbname=postgres://translog@db/logs secret") {
+		t.Errorf("TimescaleWorker: unexpected arguments %q", args)
+	}
+}
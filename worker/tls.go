@@ -0,0 +1,129 @@
+package worker
+
+/*
+	tls.go configures TLS for network inputs and outputs
+
+	The [tls] section holds the settings shared by every input and output:
+
+		ca_file                 certificate authority for the peer's certificate
+		cert_file, key_file     our certificate, sent to servers that ask for
+		                        one (client-certificate authentication), and
+		                        presented by the inputs that listen with TLS
+		client_ca_file          for inputs, the certificate authority client
+		                        certificates must be signed by; clients
+		                        without one are refused (mutual TLS)
+		insecure_skip_verify    don't verify the server's certificate
+		min_version             oldest TLS version accepted: 1.0, 1.1, 1.2
+		                        (the default) or 1.3
+
+	Each input or output may override any of them in its own tls section,
+	e.g. es.tls.ca_file or input.tls.client_ca_file.
+*/
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+)
+
+const configTLS = "tls"
+
+// tlsVersions are the values of min_version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsSetting returns a TLS setting of section, or else the shared one
+func tlsSetting(section string, key string) string {
+	if k := section + ".tls." + key; viper.IsSet(k) {
+		return viper.GetString(k)
+	}
+	return viper.GetString(configTLS + "." + key)
+}
+
+// tlsFlag returns a boolean TLS setting of section, or else the shared one
+func tlsFlag(section string, key string) bool {
+	if k := section + ".tls." + key; viper.IsSet(k) {
+		return viper.GetBool(k)
+	}
+	return viper.GetBool(configTLS + "." + key)
+}
+
+// readCertPool reads the certificates of a certificate authority
+func readCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// baseTLS returns the settings common to clients and servers
+func baseTLS(section string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if version := tlsSetting(section, "min_version"); version != "" {
+		v, ok := tlsVersions[version]
+		if !ok {
+			return nil, fmt.Errorf("Invalid TLS min_version %q; expected 1.0, 1.1, 1.2, or 1.3", version)
+		}
+		config.MinVersion = v
+	}
+	if certFile := tlsSetting(section, "cert_file"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, tlsSetting(section, "key_file"))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// configuredTLS returns the client TLS configuration of section, or nil if
+// none is configured
+func configuredTLS(section string) (*tls.Config, error) {
+	caFile := tlsSetting(section, "ca_file")
+	certFile := tlsSetting(section, "cert_file")
+	skipVerify := tlsFlag(section, "insecure_skip_verify")
+	if caFile == "" && certFile == "" && !skipVerify && tlsSetting(section, "min_version") == "" {
+		return nil, nil
+	}
+	config, err := baseTLS(section)
+	if err != nil {
+		return nil, err
+	}
+	config.InsecureSkipVerify = skipVerify
+	if caFile != "" {
+		if config.RootCAs, err = readCertPool(caFile); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// configuredServerTLS returns the TLS configuration of an input listening
+// with TLS, which needs a certificate
+func configuredServerTLS(section string) (*tls.Config, error) {
+	config, err := baseTLS(section)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Certificates) == 0 {
+		return nil, fmt.Errorf("No TLS certificate; set %s.tls.cert_file and key_file", section)
+	}
+	if clientCAFile := tlsSetting(section, "client_ca_file"); clientCAFile != "" {
+		if config.ClientCAs, err = readCertPool(clientCAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
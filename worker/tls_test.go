@@ -0,0 +1,138 @@
+package worker_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// writeCertificate writes a certificate for 127.0.0.1 and its key to
+// dir/name.pem and dir/name-key.pem, signed by ca (or self-signed, as a
+// certificate authority, if ca is nil)
+func writeCertificate(t *testing.T, dir string, name string, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	parent, signer := template, interface{}(key)
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600)
+	cert, _ := tls.X509KeyPair(certPEM, keyPEM)
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return cert
+}
+
+// writeCertificates writes a certificate authority, and a server and a
+// client certificate it signed, to dir
+func writeCertificates(t *testing.T, dir string) (ca tls.Certificate, server tls.Certificate, client tls.Certificate) {
+	ca = writeCertificate(t, dir, "ca", nil)
+	server = writeCertificate(t, dir, "server", &ca)
+	client = writeCertificate(t, dir, "client", &ca)
+	return
+}
+
+func TestHTTPInputMutualTLS(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	ca, serverCert, _ := writeCertificates(t, dir)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "1\n2\n")
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	viper.Set("tls.ca_file", filepath.Join(dir, "ca.pem"))
+	viper.Set("tls.cert_file", filepath.Join(dir, "client.pem"))
+	viper.Set("tls.key_file", filepath.Join(dir, "client-key.pem"))
+	viper.Set("input.type", "http")
+	viper.Set("input.url", server.URL)
+	viper.Set("input.poll_every", "1h")
+	w, channel := startParser(t)
+	defer w.Stop()
+	expectEvents(t, channel, 2)
+}
+
+func TestGELFInputTLS(t *testing.T) {
+	viper.Reset()
+	dir := t.TempDir()
+	ca, _, clientCert := writeCertificates(t, dir)
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+	viper.Set("input.type", "gelf")
+	viper.Set("input.protocol", "tls")
+	viper.Set("input.address", address)
+	viper.Set("input.tls.cert_file", filepath.Join(dir, "server.pem"))
+	viper.Set("input.tls.key_file", filepath.Join(dir, "server-key.pem"))
+	viper.Set("input.tls.client_ca_file", filepath.Join(dir, "ca.pem"))
+	w, channel := startParser(t)
+	defer w.Stop()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	send := func(n int, certificates []tls.Certificate) {
+		var conn *tls.Conn
+		var err error
+		for i := 0; i < 500; i++ {
+			if conn, err = tls.Dial("tcp", address, &tls.Config{RootCAs: pool, Certificates: certificates}); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			return // refused during the handshake
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, `{"version": "1.1", "host": "h", "short_message": "%d"}`+"\x00", n)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		conn.Read(make([]byte, 1))
+	}
+	send(2, nil) // without a client certificate
+	send(1, []tls.Certificate{clientCert})
+	expectEvents(t, channel, 1)
+	select {
+	case v := <-channel:
+		t.Errorf("expected a client without a certificate to be refused, got %v", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
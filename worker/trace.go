@@ -0,0 +1,221 @@
+package worker
+
+/*
+	trace.go records how sampled events went through the pipeline
+
+	With trace.events set to a sample rate, e.g. 0.01, that share of the
+	lines read is traced; set to a condition, as in alert rules (see
+	alert.go), e.g. "status >= 500 and uri = /login", the events that match
+	it once processed are. For each traced event, a line of JSON is appended
+	to trace.file (translog-trace.jsonl by default), like
+
+		{"line": "10.0.0.1 - - [...] \"GET /login ...\" 500 12",
+		 "started": "2024-03-01T12:00:00Z", "micros": 41.2,
+		 "stages": [
+		   {"stage": "decode", "micros": 18.5, "set": {"status": 500, ...}},
+		   {"stage": "transform", "micros": 9.1, "set": {"status_class": "5xx"}},
+		   {"stage": "enrich", "micros": 2.3, "set": {"customer": "acme"}},
+		   {"stage": "resolve", "micros": 0.4}],
+		 "event": {...}}
+
+	listing, for each stage, the time spent in it, the fields it set or
+	changed, and the ones it removed, and the event as it was passed to the
+	output (before the output schema is applied). A line that could not be
+	decoded has the error in its decode stage, and an event that was dropped,
+	by a lookup or for being late (see event_ttl.go), ends with a dropped
+	stage. Typed events (see event.go) are not traced.
+
+	Tracing copies each event at every stage, so the condition form, which
+	has to trace every event to find the ones that match, is slow; it is
+	meant for debugging, not for production.
+*/
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configTraceEvents = "trace.events"
+const configTraceFile = "trace.file"
+
+// ConfiguredTraceFile returns the file traces are appended to
+func ConfiguredTraceFile() string {
+	if viper.IsSet(configTraceFile) {
+		return viper.GetString(configTraceFile)
+	}
+	return "translog-trace.jsonl"
+}
+
+// eventTracer writes traces of sampled events
+type eventTracer struct {
+	rate    float64
+	match   func(v map[string]interface{}) bool
+	lock    sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// ConfiguredEventTracer returns the tracer of the events selected by
+// trace.events, or nil if no events are traced
+func ConfiguredEventTracer() *eventTracer {
+	expr := viper.GetString(configTraceEvents)
+	if expr == "" {
+		return nil
+	}
+	t := &eventTracer{}
+	if rate, err := strconv.ParseFloat(expr, 64); err == nil {
+		if rate <= 0 || rate > 1 {
+			reportError(&ConfigError{Key: configTraceEvents, Value: expr, Reason: "the sample rate must be above 0 and at most 1; not tracing"})
+			return nil
+		}
+		t.rate = rate
+	} else {
+		match, err := CompileCondition(expr)
+		if err != nil {
+			reportError(&ConfigError{Key: configTraceEvents, Value: expr, Reason: err.Error() + "; not tracing"})
+			return nil
+		}
+		t.match = match
+	}
+	path := ConfiguredTraceFile()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logs.Warn("Unable to open trace file %s: %v", path, err)
+		return nil
+	}
+	t.file = file
+	t.encoder = json.NewEncoder(file)
+	logs.Info("Tracing events (%s) to %s", expr, path)
+	return t
+}
+
+// start starts tracing a line, if it is sampled; it returns nil otherwise
+func (t *eventTracer) start(line string) *eventTrace {
+	if t == nil || (t.match == nil && rand.Float64() >= t.rate) {
+		return nil
+	}
+	now := time.Now()
+	return &eventTrace{Line: line, Started: now.UTC(), stageStarted: now}
+}
+
+// finish writes a trace, unless its event doesn't match the condition
+func (t *eventTracer) finish(e *eventTrace) {
+	if e == nil {
+		return
+	}
+	if t.match != nil && (e.Event == nil || !t.match(e.Event)) {
+		return
+	}
+	e.Micros = micros(time.Since(e.Started))
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err := t.encoder.Encode(e); err != nil {
+		logs.Warn("Unable to write trace: %v", err)
+		return
+	}
+	Counters.Inc("events_traced")
+}
+
+// close closes the trace file
+func (t *eventTracer) close() {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.file.Close()
+}
+
+// traceStage is what a stage did to a traced event
+type traceStage struct {
+	Stage   string                 `json:"stage"`
+	Micros  float64                `json:"micros"`
+	Set     map[string]interface{} `json:"set,omitempty"`
+	Removed []string               `json:"removed,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// eventTrace is the trace of an event
+type eventTrace struct {
+	Line         string                 `json:"line,omitempty"`
+	Started      time.Time              `json:"started"`
+	Micros       float64                `json:"micros"`
+	Stages       []traceStage           `json:"stages"`
+	Event        map[string]interface{} `json:"event,omitempty"`
+	stageStarted time.Time
+}
+
+// micros returns a duration in microseconds
+func micros(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1000
+}
+
+// snapshotEvent copies an event, with its nested maps and lists, so that
+// later changes can be told apart
+func snapshotEvent(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			c[k] = snapshotEvent(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(value))
+		for i, e := range value {
+			c[i] = snapshotEvent(e)
+		}
+		return c
+	}
+	return value
+}
+
+// stage records the changes a stage made to the event
+func (e *eventTrace) stage(name string, v map[string]interface{}) {
+	if e == nil {
+		return
+	}
+	s := traceStage{Stage: name, Micros: micros(time.Since(e.stageStarted))}
+	for key, value := range v {
+		if previous, found := e.Event[key]; !found || !reflect.DeepEqual(previous, value) {
+			if s.Set == nil {
+				s.Set = make(map[string]interface{})
+			}
+			s.Set[key] = snapshotEvent(value)
+		}
+	}
+	for key := range e.Event {
+		if _, found := v[key]; !found {
+			s.Removed = append(s.Removed, key)
+		}
+	}
+	sort.Strings(s.Removed)
+	e.Stages = append(e.Stages, s)
+	e.Event = snapshotEvent(v).(map[string]interface{})
+	// the time spent copying the event isn't the next stage's
+	e.stageStarted = time.Now()
+}
+
+// dropped records that the event was dropped
+func (e *eventTrace) dropped() {
+	if e != nil {
+		e.Stages = append(e.Stages, traceStage{Stage: "dropped"})
+	}
+}
+
+// fail records the error a stage failed with
+func (e *eventTrace) fail(name string, err error) {
+	if e == nil {
+		return
+	}
+	e.Stages = append(e.Stages, traceStage{Stage: name, Micros: micros(time.Since(e.stageStarted)), Error: err.Error()})
+	e.stageStarted = time.Now()
+}
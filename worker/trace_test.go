@@ -0,0 +1,99 @@
+package worker_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// readTraces reads the traces written to a trace file
+func readTraces(t *testing.T, path string) []map[string]interface{} {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var traces []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var trace map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &trace); err != nil {
+			t.Fatal(err)
+		}
+		traces = append(traces, trace)
+	}
+	return traces
+}
+
+// traceStages returns the names of the stages of a trace, and what they set
+func traceStages(trace map[string]interface{}) ([]string, map[string]interface{}) {
+	var names []string
+	set := make(map[string]interface{})
+	for _, stage := range trace["stages"].([]interface{}) {
+		stage := stage.(map[string]interface{})
+		name := stage["stage"].(string)
+		names = append(names, name)
+		set[name] = stage["set"]
+	}
+	return names, set
+}
+
+func TestTraceEvents(t *testing.T) {
+	viper.Reset()
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	viper.Set("parse.pattern", `^(?P<n>\d+)$`)
+	viper.Set("transform.derive", map[string]interface{}{"double": "n * 2"})
+	viper.Set("trace.events", "1")
+	viper.Set("trace.file", path)
+	channel := make(chan map[string]interface{}, 10)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	w.ProcessLine("21")
+	w.ProcessLine("abc")
+	<-channel
+	w.Stop()
+	traces := readTraces(t, path)
+	if len(traces) != 2 {
+		t.Fatalf("expected 2 traces, actual %v", traces)
+	}
+	names, set := traceStages(traces[0])
+	if traces[0]["line"] != "21" || len(names) != 4 || names[0] != "decode" || names[1] != "transform" {
+		t.Errorf("expected the decode, transform, enrich and resolve stages, actual %v", traces[0])
+	}
+	if decoded, _ := set["decode"].(map[string]interface{}); decoded["n"] != float64(21) || decoded["double"] != nil {
+		t.Errorf("expected the decode stage to set n, actual %v", set["decode"])
+	}
+	if derived, _ := set["transform"].(map[string]interface{}); derived["double"] != float64(42) {
+		t.Errorf("expected the transform stage to set double, actual %v", set["transform"])
+	}
+	stage := traces[1]["stages"].([]interface{})[0].(map[string]interface{})
+	if stage["stage"] != "decode" || stage["error"] == nil {
+		t.Errorf("expected the decode error, actual %v", traces[1])
+	}
+}
+
+func TestTraceEventsMatching(t *testing.T) {
+	viper.Reset()
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	viper.Set("parse.pattern", `^(?P<n>\d+)$`)
+	viper.Set("trace.events", "n > 2")
+	viper.Set("trace.file", path)
+	channel := make(chan map[string]interface{}, 10)
+	w := &worker.LogParser{}
+	w.SetWorkChannel(channel)
+	w.Init()
+	for _, line := range []string{"1", "2", "3", "abc"} {
+		w.ProcessLine(line)
+	}
+	w.Stop()
+	traces := readTraces(t, path)
+	if len(traces) != 1 || traces[0]["line"] != "3" {
+		t.Errorf("expected the trace of 3, actual %v", traces)
+	}
+}
@@ -0,0 +1,286 @@
+package worker
+
+/*
+	transform.go applies configured transformations to parsed events
+
+	Derived fields are declared in the transform.derive table, as a map
+	from the new field name to a small expression, e.g.
+
+		[transform.derive]
+		status_class = "classify(status)"
+		is_bot = "matches(user_agent, bots)"
+		response_kb = "bytes / 1024"
+
+		[transform.lists]
+		bots = ["bot", "spider", "crawler"]
+
+	The supported expressions are:
+
+		classify(field)     the HTTP status class of field (e.g. "2xx")
+		matches(field, l)   true if field contains any of the strings in list l
+		lower(field)        field in lower case
+		upper(field)        field in upper case
+		field op number     arithmetic, where op is one of + - * /
+
+	Derived fields are computed in the alphabetical order of their names.
+
+	If transform.normalize_keys is set to snake_case, camelCase or lower, all
+	event keys (including query parameters from ParseURI) are normalized before
+	derived fields are computed, so UserID, user-id and userId all become
+	user_id (for snake_case). Derived field names are normalized too.
+
+	Log levels can be normalized too (see transform_level.go), and stack traces
+	fingerprinted (see transform_fingerprint.go).
+*/
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configTransformDerive = "transform.derive"
+const configTransformLists = "transform.lists"
+const configTransformNormalizeKeys = "transform.normalize_keys"
+
+var derivationCallRegex = regexp.MustCompile(`^(\w+)\(\s*([\w.]+)\s*(?:,\s*([\w.]+)\s*)?\)$`)
+var derivationArithmeticRegex = regexp.MustCompile(`^([\w.]+)\s*([-+*/])\s*([0-9]+(?:\.[0-9]+)?)$`)
+
+// A derivation computes a new value from an event; ok is false if the value
+// could not be computed
+type derivation struct {
+	key    string
+	derive func(v map[string]interface{}) (value interface{}, ok bool)
+}
+
+// Transformer applies the configured transformations to events
+type Transformer struct {
+	lock        sync.Mutex
+	config      map[string]string
+	derivations []derivation
+	levelConfig levelConfig
+	levels      *levelNormalizer
+}
+
+// toFloat converts a parsed value to a float64, if possible
+func toFloat(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// ClassifyStatus returns the class of an HTTP status (e.g. "4xx" for 404)
+func ClassifyStatus(value interface{}) (string, bool) {
+	f, ok := toFloat(value)
+	if !ok || f < 100 || f >= 600 {
+		return "", false
+	}
+	return fmt.Sprintf("%dxx", int(f)/100), true
+}
+
+func compileCall(function string, field string, arg string) (func(v map[string]interface{}) (interface{}, bool), error) {
+	switch function {
+	case "classify":
+		return func(v map[string]interface{}) (interface{}, bool) {
+			return ClassifyStatus(v[field])
+		}, nil
+	case "matches":
+		if arg == "" {
+			return nil, fmt.Errorf("matches needs a list name")
+		}
+		return func(v map[string]interface{}) (interface{}, bool) {
+			s, ok := v[field].(string)
+			if !ok {
+				return false, true
+			}
+			s = strings.ToLower(s)
+			for _, needle := range viper.GetStringSlice(configTransformLists + "." + arg) {
+				if strings.Contains(s, strings.ToLower(needle)) {
+					return true, true
+				}
+			}
+			return false, true
+		}, nil
+	case "lower", "upper":
+		return func(v map[string]interface{}) (interface{}, bool) {
+			s, ok := v[field].(string)
+			if !ok {
+				return nil, false
+			}
+			if function == "lower" {
+				return strings.ToLower(s), true
+			}
+			return strings.ToUpper(s), true
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown function %s", function)
+}
+
+func compileArithmetic(field string, op string, operand float64) (func(v map[string]interface{}) (interface{}, bool), error) {
+	if op == "/" && operand == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return func(v map[string]interface{}) (interface{}, bool) {
+		f, ok := toFloat(v[field])
+		if !ok {
+			return nil, false
+		}
+		switch op {
+		case "+":
+			return f + operand, true
+		case "-":
+			return f - operand, true
+		case "*":
+			return f * operand, true
+		}
+		return f / operand, true
+	}, nil
+}
+
+// compileDerivation compiles a derived field expression
+func compileDerivation(key string, expr string) (d derivation, err error) {
+	d.key = key
+	expr = strings.TrimSpace(expr)
+	if match := derivationCallRegex.FindStringSubmatch(expr); match != nil {
+		d.derive, err = compileCall(match[1], match[2], match[3])
+		return
+	}
+	if match := derivationArithmeticRegex.FindStringSubmatch(expr); match != nil {
+		operand, _ := strconv.ParseFloat(match[3], 64) // regex assures a number
+		d.derive, err = compileArithmetic(match[1], match[2], operand)
+		return
+	}
+	err = fmt.Errorf("unrecognized expression")
+	return
+}
+
+// cachedDerivations recompiles the derivations if the configuration changed
+func (t *Transformer) cachedDerivations() []derivation {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	config := viper.GetStringMapString(configTransformDerive)
+	if !reflect.DeepEqual(config, t.config) {
+		keys := make([]string, 0, len(config))
+		for key := range config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		derivations := make([]derivation, 0, len(keys))
+		for _, key := range keys {
+			d, err := compileDerivation(key, config[key])
+			if err != nil {
+				logs.Warn("Could not compile derived field %s = %s. Error: %v", key, config[key], err)
+				continue
+			}
+			derivations = append(derivations, d)
+		}
+		t.config = config
+		t.derivations = derivations
+	}
+	return t.derivations
+}
+
+// keyWords splits a key into its words, breaking on separators and on
+// case changes (UserID is User, ID; HTTPServer is HTTP, Server)
+func keyWords(key string) []string {
+	words := make([]string, 0)
+	runes := []rune(key)
+	start := -1
+	for i, r := range runes {
+		if r == '_' || r == '-' || r == '.' || unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, string(runes[start:i]))
+				start = -1
+			}
+			continue
+		}
+		if start >= 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// NormalizeKey normalizes a key to the given style (snake_case, camelCase,
+// or lower). Leading underscores are kept. Unknown styles leave the key
+// unchanged.
+func NormalizeKey(key string, style string) string {
+	trimmed := strings.TrimLeft(key, "_")
+	prefix := key[:len(key)-len(trimmed)]
+	switch strings.ToLower(style) {
+	case "snake_case":
+		return prefix + strings.ToLower(strings.Join(keyWords(trimmed), "_"))
+	case "camelcase":
+		words := keyWords(trimmed)
+		for i, word := range words {
+			word = strings.ToLower(word)
+			if i > 0 {
+				runes := []rune(word)
+				runes[0] = unicode.ToUpper(runes[0])
+				word = string(runes)
+			}
+			words[i] = word
+		}
+		return prefix + strings.Join(words, "")
+	case "lower":
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+// normalizeKeys normalizes all the keys of the event, in place
+func normalizeKeys(v map[string]interface{}, style string) {
+	for key, value := range v {
+		newKey := NormalizeKey(key, style)
+		if newKey != key {
+			delete(v, key)
+			v[newKeyName(newKey, v)] = value
+		}
+	}
+}
+
+// Transform applies the transformations to the event, in place
+func (t *Transformer) Transform(v map[string]interface{}) {
+	style := viper.GetString(configTransformNormalizeKeys)
+	if style != "" {
+		normalizeKeys(v, style)
+	}
+	if levels := t.cachedLevels(); levels != nil {
+		levels.normalize(v)
+	}
+	if viper.GetBool(configTransformStackTraceFingerprint) {
+		fingerprintStackTrace(v)
+	}
+	for _, d := range t.cachedDerivations() {
+		if value, ok := d.derive(v); ok {
+			v[NormalizeKey(d.key, style)] = value
+		}
+	}
+}
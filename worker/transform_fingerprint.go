@@ -0,0 +1,129 @@
+package worker
+
+/*
+	transform_fingerprint.go fingerprints stack traces
+
+	With transform.stack_trace.fingerprint, the first of
+	transform.stack_trace.fields found in an event that holds a stack trace
+	(a multiline value with at least one frame, in Java, Python, Go, Ruby,
+	JavaScript or .NET style) is normalized and hashed, and the hash is set
+	in error.fingerprint, so that identical crashes can be grouped together
+	however often, and on whichever host, they happen.
+
+	Normalizing keeps the frames and the exception types, and drops what
+	varies from one occurrence of a crash to the next:
+
+		- line and column numbers, addresses, offsets and goroutine ids
+		  (all numbers, in fact, so lambda$main$0 and lambda$main$1 are the
+		  same frame)
+		- the messages of exceptions (NullPointerException: foo is null
+		  becomes NullPointerException)
+		- the "... 12 more" lines of Java traces
+		- indentation
+
+	If the event has an error object, the fingerprint is set in it;
+	otherwise, error.fingerprint is a field of its own. Stack traces are
+	fingerprinted after levels are normalized (see transform_level.go), and
+	before derived fields are computed, so that they can be derived from
+	the fingerprint.
+*/
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const configTransformStackTraceFingerprint = "transform.stack_trace.fingerprint"
+const configTransformStackTraceFields = "transform.stack_trace.fields"
+
+// defaultStackTraceFields are the fields stack traces are read from, in
+// order
+var defaultStackTraceFields = []string{"stack_trace", "stacktrace", "stack", "exception", "error.stack_trace", "error.stack", "message"}
+
+// stackFrameRegex matches the frames of stack traces: "at ..." (Java,
+// JavaScript, .NET), File "..." (Python), "from ..." (Ruby), function
+// calls (Go), and file:line locations
+var stackFrameRegex = regexp.MustCompile(`^(at |File "|from |[\w.$/*()\[\]-]+\(.*\)$)|\.\w+:\d+`)
+
+// moreFramesRegex matches the "... 12 more" lines of Java traces
+var moreFramesRegex = regexp.MustCompile(`^\.\.\. \d+ (more|common frames omitted)$`)
+
+// stackNumberRegex matches the numbers (in decimal or hexadecimal) of frames
+var stackNumberRegex = regexp.MustCompile(`(\+?0x[0-9a-fA-F]+|\d+)`)
+
+// NormalizeStackTrace returns the frames and exception types of a stack
+// trace, without the numbers and messages that vary between occurrences; ok
+// is false if the value has no frames
+func NormalizeStackTrace(trace string) (normalized string, ok bool) {
+	if !strings.Contains(trace, "\n") {
+		return "", false
+	}
+	var lines []string
+	for _, line := range strings.Split(trace, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || moreFramesRegex.MatchString(line) {
+			continue
+		}
+		if stackFrameRegex.MatchString(line) {
+			ok = true
+		} else if i := strings.Index(line, ": "); i >= 0 {
+			// the message of an exception
+			line = line[:i]
+		}
+		line = stackNumberRegex.ReplaceAllString(line, "")
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	return strings.Join(lines, "\n"), ok
+}
+
+// StackTraceFingerprint returns the fingerprint of a stack trace, which is
+// the same for stack traces that only differ in line numbers, addresses or
+// messages; ok is false if the value has no frames
+func StackTraceFingerprint(trace string) (fingerprint string, ok bool) {
+	normalized, ok := NormalizeStackTrace(trace)
+	if !ok {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8]), true
+}
+
+// findStackTrace returns the first stack trace found in the event, among
+// transform.stack_trace.fields
+func findStackTrace(v map[string]interface{}) (string, bool) {
+	fields := viper.GetStringSlice(configTransformStackTraceFields)
+	if len(fields) == 0 {
+		fields = defaultStackTraceFields
+	}
+	for _, field := range fields {
+		value, found := lookupField(v, field)
+		if !found {
+			continue
+		}
+		if trace, isString := value.(string); isString {
+			if _, ok := NormalizeStackTrace(trace); ok {
+				return trace, true
+			}
+		}
+	}
+	return "", false
+}
+
+// fingerprintStackTrace sets the fingerprint of the first stack trace found
+// in the event
+func fingerprintStackTrace(v map[string]interface{}) {
+	trace, found := findStackTrace(v)
+	if !found {
+		return
+	}
+	fingerprint, _ := StackTraceFingerprint(trace)
+	if e, isMap := v["error"].(map[string]interface{}); isMap {
+		e["fingerprint"] = fingerprint
+	} else {
+		v["error.fingerprint"] = fingerprint
+	}
+	Counters.Inc("stack_traces_fingerprinted")
+}
@@ -0,0 +1,73 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var fingerprintTestCases = []struct {
+	a    string
+	b    string
+	same bool
+}{
+	// messages and line numbers differ
+	{"java.lang.IllegalStateException: order 1234 not found\n\tat com.example.Orders.get(Orders.java:42)\n\tat com.example.Api.handle(Api.java:17)\n\t... 12 more",
+		"java.lang.IllegalStateException: order 98 not found\n\tat com.example.Orders.get(Orders.java:45)\n\tat com.example.Api.handle(Api.java:17)", true},
+	// a different exception
+	{"java.lang.IllegalStateException: order 1234 not found\n\tat com.example.Orders.get(Orders.java:42)",
+		"java.lang.NullPointerException: order 1234 not found\n\tat com.example.Orders.get(Orders.java:42)", false},
+	// a different frame
+	{"java.lang.IllegalStateException: x\n\tat com.example.Orders.get(Orders.java:42)",
+		"java.lang.IllegalStateException: x\n\tat com.example.Orders.put(Orders.java:42)", false},
+	// goroutine ids, addresses and offsets differ
+	{"panic: runtime error: index out of range [5] with length 3\n\ngoroutine 1 [running]:\nmain.handle(0xc000012345, 0x3)\n\t/src/main.go:12 +0x1d\n",
+		"panic: runtime error: index out of range [7] with length 2\n\ngoroutine 42 [running]:\nmain.handle(0xc000099999, 0x2)\n\t/src/main.go:14 +0x2f\n", true},
+	// python
+	{"Traceback (most recent call last):\n  File \"app.py\", line 10, in <module>\n    main()\nValueError: invalid literal for int() with base 10: 'a'",
+		"Traceback (most recent call last):\n  File \"app.py\", line 11, in <module>\n    main()\nValueError: invalid literal for int() with base 10: 'b'", true},
+}
+
+func TestStackTraceFingerprint(t *testing.T) {
+	for i, tt := range fingerprintTestCases {
+		a, okA := worker.StackTraceFingerprint(tt.a)
+		b, okB := worker.StackTraceFingerprint(tt.b)
+		if !okA || !okB || (a == b) != tt.same {
+			t.Errorf("In test %d, StackTraceFingerprint(%q), StackTraceFingerprint(%q): expected same %v, actual %v (%v), %v (%v)", i, tt.a, tt.b, tt.same, a, okA, b, okB)
+		}
+	}
+	if _, ok := worker.StackTraceFingerprint("GET /index.html 200"); ok {
+		t.Errorf("StackTraceFingerprint of a single line: expected no fingerprint")
+	}
+	if _, ok := worker.StackTraceFingerprint("first line\nsecond line"); ok {
+		t.Errorf("StackTraceFingerprint of lines without frames: expected no fingerprint")
+	}
+}
+
+func TestTransformStackTraceFingerprint(t *testing.T) {
+	viper.Reset()
+	viper.Set("transform.stack_trace.fingerprint", true)
+	trace := "java.lang.IllegalStateException: x\n\tat com.example.Orders.get(Orders.java:42)"
+	fingerprint, _ := worker.StackTraceFingerprint(trace)
+	transformer := &worker.Transformer{}
+
+	v := map[string]interface{}{"stack_trace": trace}
+	transformer.Transform(v)
+	if v["error.fingerprint"] != fingerprint {
+		t.Errorf("Transform(%v): expected error.fingerprint %v, actual %v", v, fingerprint, v["error.fingerprint"])
+	}
+
+	e := map[string]interface{}{"stack_trace": trace}
+	v = map[string]interface{}{"error": e}
+	transformer.Transform(v)
+	if e["fingerprint"] != fingerprint {
+		t.Errorf("Transform(%v): expected error.fingerprint %v, actual %v", v, fingerprint, e["fingerprint"])
+	}
+
+	v = map[string]interface{}{"message": "no stack trace"}
+	transformer.Transform(v)
+	if _, found := v["error.fingerprint"]; found {
+		t.Errorf("Transform(%v): expected no fingerprint", v)
+	}
+}
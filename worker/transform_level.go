@@ -0,0 +1,166 @@
+package worker
+
+/*
+	transform_level.go normalizes log levels
+
+	Applications spell levels in many ways: WARN, warning, W, or 4 (a syslog
+	severity). With transform.level.normalize, the first of
+	transform.level.fields found in an event is mapped to a canonical level
+	name, set in level, with its number in level_value:
+
+		emergency 0   alert 1   critical 2   error 3
+		warn 4        notice 5  info 6       debug 7
+
+	(the syslog severities, so lower values are more severe). Level names are
+	matched case-insensitively, with common aliases built in (fatal is
+	critical, E is error, trace is debug, ...); transform.level.aliases adds
+	or overrides aliases, and transform.level.values adds canonical levels or
+	renumbers them, e.g.
+
+		[transform.level.aliases]
+		severe = "error"
+		verbose = "trace"
+
+		[transform.level.values]
+		trace = 8
+
+	Levels that can't be mapped are left as they are, and counted as
+	levels_unknown. Levels are normalized after keys (see transform.go), and
+	before derived fields are computed.
+*/
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const configTransformLevelNormalize = "transform.level.normalize"
+const configTransformLevelFields = "transform.level.fields"
+const configTransformLevelAliases = "transform.level.aliases"
+const configTransformLevelValues = "transform.level.values"
+
+// defaultLevelFields are the fields levels are read from, in order
+var defaultLevelFields = []string{"level", "severity", "loglevel", "log_level", "lvl"}
+
+// canonicalLevels are the numbers of the canonical levels
+var canonicalLevels = map[string]int{
+	"emergency": 0, "alert": 1, "critical": 2, "error": 3,
+	"warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// levelAliases maps the usual spellings of levels, in lower case, to the
+// canonical levels
+var levelAliases = map[string]string{
+	"emerg": "emergency", "panic": "emergency", "0": "emergency",
+	"1":    "alert",
+	"crit": "critical", "fatal": "critical", "f": "critical", "c": "critical", "2": "critical",
+	"err": "error", "e": "error", "severe": "error", "3": "error",
+	"warning": "warn", "w": "warn", "4": "warn",
+	"n": "notice", "5": "notice",
+	"informational": "info", "information": "info", "i": "info", "6": "info",
+	"trace": "debug", "d": "debug", "t": "debug", "verbose": "debug", "7": "debug",
+}
+
+// levelNormalizer maps levels to canonical levels
+type levelNormalizer struct {
+	fields  []string
+	aliases map[string]string
+	values  map[string]int
+}
+
+// levelConfig is the configuration a levelNormalizer was built from
+type levelConfig struct {
+	fields  []string
+	aliases map[string]string
+	values  map[string]interface{}
+}
+
+// newLevelNormalizer merges the configured aliases and values with the
+// built-in ones
+func newLevelNormalizer(config levelConfig) *levelNormalizer {
+	n := &levelNormalizer{fields: config.fields, aliases: make(map[string]string), values: make(map[string]int)}
+	if len(n.fields) == 0 {
+		n.fields = defaultLevelFields
+	}
+	for level, value := range canonicalLevels {
+		n.values[level] = value
+	}
+	for alias, level := range levelAliases {
+		n.aliases[alias] = level
+	}
+	for level, value := range config.values {
+		number, ok := toFloat(value)
+		if !ok {
+			reportError(&ConfigError{Key: configTransformLevelValues + "." + level, Value: value, Reason: "expected a number; ignoring it"})
+			continue
+		}
+		level = strings.ToLower(level)
+		n.values[level] = int(number)
+		// a configured level is no longer an alias, as trace is by default
+		delete(n.aliases, level)
+	}
+	for alias, level := range config.aliases {
+		n.aliases[strings.ToLower(alias)] = strings.ToLower(level)
+	}
+	return n
+}
+
+// canonical returns the canonical level of a level, and its number
+func (n *levelNormalizer) canonical(level interface{}) (string, int, bool) {
+	var name string
+	switch l := level.(type) {
+	case string:
+		name = strings.ToLower(strings.TrimSpace(l))
+	case int64:
+		name = strconv.FormatInt(l, 10)
+	case float64:
+		name = strconv.FormatFloat(l, 'f', -1, 64)
+	default:
+		return "", 0, false
+	}
+	if alias, found := n.aliases[name]; found {
+		name = alias
+	}
+	value, found := n.values[name]
+	return name, value, found
+}
+
+// normalize sets the canonical level of the event, and its number
+func (n *levelNormalizer) normalize(v map[string]interface{}) {
+	for _, field := range n.fields {
+		level, found := lookupField(v, field)
+		if !found || level == nil {
+			continue
+		}
+		name, value, ok := n.canonical(level)
+		if !ok {
+			Counters.Inc("levels_unknown")
+			return
+		}
+		v["level"] = name
+		v["level_value"] = int64(value)
+		return
+	}
+}
+
+// cachedLevels rebuilds the level normalizer if the configuration changed;
+// it returns nil if levels are not normalized
+func (t *Transformer) cachedLevels() *levelNormalizer {
+	if !viper.GetBool(configTransformLevelNormalize) {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	config := levelConfig{
+		fields:  viper.GetStringSlice(configTransformLevelFields),
+		aliases: viper.GetStringMapString(configTransformLevelAliases),
+		values:  viper.GetStringMap(configTransformLevelValues),
+	}
+	if t.levels == nil || !reflect.DeepEqual(config, t.levelConfig) {
+		t.levels = newLevelNormalizer(config)
+		t.levelConfig = config
+	}
+	return t.levels
+}
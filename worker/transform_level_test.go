@@ -0,0 +1,42 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var levelTestCases = []struct {
+	event      map[string]interface{}
+	level      interface{}
+	levelValue interface{}
+}{
+	{map[string]interface{}{"level": "WARN"}, "warn", int64(4)},
+	{map[string]interface{}{"level": "warning"}, "warn", int64(4)},
+	{map[string]interface{}{"level": "W"}, "warn", int64(4)},
+	{map[string]interface{}{"level": int64(4)}, "warn", int64(4)},
+	{map[string]interface{}{"severity": "Fatal"}, "critical", int64(2)},
+	{map[string]interface{}{"lvl": "trace"}, "trace", int64(8)},
+	{map[string]interface{}{"level": "verbose"}, "trace", int64(8)},
+	{map[string]interface{}{"level": "oops"}, "oops", nil},
+	{map[string]interface{}{"message": "no level"}, nil, nil},
+}
+
+func TestTransformLevel(t *testing.T) {
+	viper.Reset()
+	viper.Set("transform.level.normalize", true)
+	viper.Set("transform.level.aliases", map[string]interface{}{"verbose": "trace"})
+	viper.Set("transform.level.values", map[string]interface{}{"trace": 8})
+	transformer := &worker.Transformer{}
+	for i, tt := range levelTestCases {
+		v := make(map[string]interface{})
+		for key, value := range tt.event {
+			v[key] = value
+		}
+		transformer.Transform(v)
+		if v["level"] != tt.level || v["level_value"] != tt.levelValue {
+			t.Errorf("In test %d, Transform(%v): expected %v (%v), actual %v (%v)", i, tt.event, tt.level, tt.levelValue, v["level"], v["level_value"])
+		}
+	}
+}
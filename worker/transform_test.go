@@ -0,0 +1,88 @@
+package worker_test
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var classifyStatusTestCases = []struct {
+	input    interface{}
+	expected string
+	ok       bool
+}{
+	{int64(200), "2xx", true},
+	{int64(304), "3xx", true},
+	{"404", "4xx", true},
+	{float64(503), "5xx", true},
+	{int64(42), "", false},
+	{"-", "", false},
+}
+
+func TestClassifyStatus(t *testing.T) {
+	for i, tt := range classifyStatusTestCases {
+		actual, ok := worker.ClassifyStatus(tt.input)
+		if actual != tt.expected || ok != tt.ok {
+			t.Errorf("In test %d, ClassifyStatus(%v): expected %v (%v), actual %v (%v)",
+				i+1, tt.input, tt.expected, tt.ok, actual, ok)
+		}
+	}
+}
+
+func TestTransformDerive(t *testing.T) {
+	viper.Reset()
+	viper.Set("transform.derive", map[string]interface{}{
+		"status_class": "classify(status)",
+		"is_bot":       "matches(user_agent, bots)",
+		"response_kb":  "bytes / 1024",
+		"broken":       "frobnicate(status)",
+	})
+	viper.Set("transform.lists.bots", []string{"Googlebot", "spider"})
+	v := map[string]interface{}{
+		"status":     int64(404),
+		"user_agent": "Mozilla/5.0 (compatible; googlebot/2.1)",
+		"bytes":      int64(2048),
+	}
+	transformer := &worker.Transformer{}
+	transformer.Transform(v)
+	if v["status_class"] != "4xx" {
+		t.Errorf("expected status_class to be 4xx, but was %v", v["status_class"])
+	}
+	if v["is_bot"] != true {
+		t.Errorf("expected is_bot to be true, but was %v", v["is_bot"])
+	}
+	if v["response_kb"] != float64(2) {
+		t.Errorf("expected response_kb to be 2, but was %v", v["response_kb"])
+	}
+	if _, found := v["broken"]; found {
+		t.Errorf("expected broken not to be derived")
+	}
+}
+
+var normalizeKeyTestCases = []struct {
+	key      string
+	style    string
+	expected string
+}{
+	{"UserID", "snake_case", "user_id"},
+	{"user-id", "snake_case", "user_id"},
+	{"userId", "snake_case", "user_id"},
+	{"HTTPServer", "snake_case", "http_server"},
+	{"_userId", "snake_case", "_user_id"},
+	{"user_id", "camelCase", "userId"},
+	{"User-ID", "camelCase", "userId"},
+	{"server_ms", "camelCase", "serverMs"},
+	{"User-ID", "lower", "user-id"},
+	{"User-ID", "", "User-ID"},
+}
+
+func TestNormalizeKey(t *testing.T) {
+	for i, tt := range normalizeKeyTestCases {
+		actual := worker.NormalizeKey(tt.key, tt.style)
+		if actual != tt.expected {
+			t.Errorf("In test %d, NormalizeKey(%v, %v): expected %v, actual %v",
+				i+1, tt.key, tt.style, tt.expected, actual)
+		}
+	}
+}
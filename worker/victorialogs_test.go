@@ -0,0 +1,89 @@
+package worker_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+var victoriaLogsEventTestCases = []struct {
+	event    map[string]interface{}
+	expected map[string]interface{}
+}{
+	{map[string]interface{}{"message": "started", "host": "a"}, map[string]interface{}{"_msg": "started", "host": "a"}},
+	{map[string]interface{}{"host": "a"}, map[string]interface{}{"host": "a"}},
+	{map[string]interface{}{"message": "started", "created": "2024-03-01T12:00:00Z"},
+		map[string]interface{}{"_msg": "started", "created": "2024-03-01T12:00:00Z", "_time": "2024-03-01T12:00:00Z"}},
+}
+
+func TestVictoriaLogsEvent(t *testing.T) {
+	viper.Reset()
+	for i, tt := range victoriaLogsEventTestCases {
+		actual := worker.VictoriaLogsEvent(tt.event, "message")
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("In test %d, VictoriaLogsEvent(%v): expected %v, actual %v", i, tt.event, tt.expected, actual)
+		}
+	}
+}
+
+func TestVictoriaLogsInsertURL(t *testing.T) {
+	if actual, expected := worker.VictoriaLogsInsertURL("http://vl:9428", []string{"host", "service"}), "http://vl:9428/insert/jsonline?_stream_fields=host%2Cservice"; actual != expected {
+		t.Errorf("VictoriaLogsInsertURL: expected %v, actual %v", expected, actual)
+	}
+	if actual, expected := worker.VictoriaLogsInsertURL("http://vl:9428", nil), "http://vl:9428/insert/jsonline"; actual != expected {
+		t.Errorf("VictoriaLogsInsertURL: expected %v, actual %v", expected, actual)
+	}
+}
+
+func TestVictoriaLogsWorker(t *testing.T) {
+	viper.Reset()
+	type request struct {
+		streamFields string
+		accountID    string
+		lines        []map[string]interface{}
+	}
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r := request{streamFields: req.URL.Query().Get("_stream_fields"), accountID: req.Header.Get("AccountID")}
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			json.Unmarshal(scanner.Bytes(), &line)
+			r.lines = append(r.lines, line)
+		}
+		requests <- r
+	}))
+	defer server.Close()
+	viper.Set("victorialogs.url", server.URL)
+	viper.Set("victorialogs.stream_fields", []string{"host"})
+	viper.Set("victorialogs.account_id", "12")
+	viper.Set("victorialogs.flush_interval", "1h")
+
+	w := &worker.VictoriaLogsWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	w.WorkChannel <- map[string]interface{}{"host": "a", "message": "one"}
+	w.WorkChannel <- map[string]interface{}{"host": "b", "message": "two"}
+	w.Flush()
+
+	select {
+	case r := <-requests:
+		if r.streamFields != "host" || r.accountID != "12" || len(r.lines) != 2 {
+			t.Fatalf("VictoriaLogsWorker: unexpected request %v", r)
+		}
+		if r.lines[1]["_msg"] != "two" || r.lines[1]["host"] != "b" {
+			t.Errorf("VictoriaLogsWorker: unexpected line %v", r.lines[1])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("VictoriaLogsWorker: timed out waiting for the batch")
+	}
+}
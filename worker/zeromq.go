@@ -0,0 +1,312 @@
+package worker
+
+/*
+	zeromq.go publishes events on a ZeroMQ socket
+
+	The ZeroMQWorker binds a ZeroMQ socket on zeromq.address (e.g.
+	tcp://127.0.0.1:5556), which local processes connect to with ZeroMQ's
+	own libraries (see zmtp.go; only the NULL mechanism is supported, so
+	bind to a trusted interface). zeromq.socket is the kind of socket:
+
+		pub     every event goes to every SUB socket subscribed to it
+		push    each event goes to one PULL socket, taking turns
+
+	Events are sent as JSON. With zeromq.topic_field, each message has two
+	frames: the value of that field ("" if the event has none), which SUB
+	sockets subscribe to by prefix, and the JSON; without it, messages are
+	the JSON alone, and subscribers subscribe to "" or a JSON prefix.
+
+	Like ZeroMQ itself, the worker never waits for slow peers: up to
+	zeromq.buffer messages are queued for each SUB socket (or for all PULL
+	sockets, with push, including while none is connected), and further
+	messages are dropped, and counted as zeromq_dropped.
+*/
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fizx/logs"
+	"github.com/spf13/viper"
+)
+
+const configZeroMQAddress = "zeromq.address"
+const configZeroMQSocket = "zeromq.socket"
+const configZeroMQTopicField = "zeromq.topic_field"
+const configZeroMQBuffer = "zeromq.buffer"
+
+// ConfiguredZeroMQAddress returns the TCP address the socket is bound to
+func ConfiguredZeroMQAddress() string {
+	address := "tcp://127.0.0.1:5556"
+	if viper.IsSet(configZeroMQAddress) {
+		address = viper.GetString(configZeroMQAddress)
+	}
+	address = strings.TrimPrefix(address, "tcp://")
+	if strings.HasPrefix(address, "*:") {
+		address = address[1:]
+	}
+	return address
+}
+
+// ConfiguredZeroMQSocket returns the socket type: PUB or PUSH
+func ConfiguredZeroMQSocket() string {
+	if viper.IsSet(configZeroMQSocket) {
+		switch socket := strings.ToUpper(viper.GetString(configZeroMQSocket)); socket {
+		case "PUB", "PUSH":
+			return socket
+		}
+		reportError(&ConfigError{Key: configZeroMQSocket, Value: viper.Get(configZeroMQSocket), Reason: "expected pub or push; using pub"})
+	}
+	return "PUB"
+}
+
+// ConfiguredZeroMQBuffer returns how many messages are queued for a peer
+func ConfiguredZeroMQBuffer() int {
+	if viper.IsSet(configZeroMQBuffer) {
+		if buffer := viper.GetInt(configZeroMQBuffer); buffer > 0 {
+			return buffer
+		}
+		reportError(&ConfigError{Key: configZeroMQBuffer, Value: viper.Get(configZeroMQBuffer), Reason: "using 1000"})
+	}
+	return 1000
+}
+
+// zeroMQMessage is a message, with the frame subscriptions are matched
+// against
+type zeroMQMessage struct {
+	topic  string
+	frames []byte
+}
+
+// zeroMQPeer is a connected SUB or PULL socket
+type zeroMQPeer struct {
+	conn          *zmtpConn
+	messages      chan zeroMQMessage // for SUB sockets
+	lock          sync.Mutex
+	subscriptions map[string]int
+}
+
+// subscribed returns true if the peer subscribed to a prefix of topic
+func (p *zeroMQPeer) subscribed(topic string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for prefix := range p.subscriptions {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ZeroMQWorker publishes events on a ZeroMQ PUB or PUSH socket
+type ZeroMQWorker struct {
+	WorkChannel chan map[string]interface{}
+	QuitChannel chan bool
+	socketType  string
+	topicField  string
+	listener    net.Listener
+	lock        sync.Mutex
+	peers       map[*zeroMQPeer]bool
+	pushed      chan zeroMQMessage // for PULL sockets
+	startTime   time.Time
+}
+
+func (w *ZeroMQWorker) SetWorkChannel(channel chan map[string]interface{}) {
+	w.WorkChannel = channel
+}
+
+func (w *ZeroMQWorker) Init() (err error) {
+	w.QuitChannel = make(chan bool)
+	w.socketType = ConfiguredZeroMQSocket()
+	w.topicField = viper.GetString(configZeroMQTopicField)
+	w.peers = make(map[*zeroMQPeer]bool)
+	w.pushed = make(chan zeroMQMessage, ConfiguredZeroMQBuffer())
+	return
+}
+
+// Start the work
+func (w *ZeroMQWorker) Start() {
+	address := ConfiguredZeroMQAddress()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logs.Warn("Unable to bind the ZeroMQ %s socket to %s: %v", w.socketType, address, err)
+	} else {
+		w.listener = listener
+		logs.Info("Bound the ZeroMQ %s socket to tcp://%s", w.socketType, listener.Addr())
+		go w.accept()
+	}
+	go Supervise("ZeroMQWorker", w.Work)
+}
+
+// Addr returns the address the socket is bound to, or nil if it isn't
+func (w *ZeroMQWorker) Addr() net.Addr {
+	if w.listener == nil {
+		return nil
+	}
+	return w.listener.Addr()
+}
+
+// accept accepts peers until the listener is closed
+func (w *ZeroMQWorker) accept() {
+	for {
+		conn, err := w.listener.Accept()
+		if err != nil {
+			return
+		}
+		go w.serve(conn)
+	}
+}
+
+// serve greets a peer, and sends it messages until it disconnects
+func (w *ZeroMQWorker) serve(conn net.Conn) {
+	defer conn.Close()
+	c, err := acceptZMTP(conn, w.socketType)
+	if err != nil {
+		logs.Info("Closing ZeroMQ connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	peer := &zeroMQPeer{conn: c, subscriptions: make(map[string]int)}
+	if w.socketType == "PUB" {
+		peer.messages = make(chan zeroMQMessage, ConfiguredZeroMQBuffer())
+	}
+	w.lock.Lock()
+	w.peers[peer] = true
+	w.lock.Unlock()
+	Counters.Inc("zeromq_connections")
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		w.read(peer)
+	}()
+	w.write(peer, done)
+	w.lock.Lock()
+	delete(w.peers, peer)
+	w.lock.Unlock()
+	conn.Close()
+	<-done
+}
+
+// read reads the subscriptions of a SUB socket (and notices that a PULL
+// socket disconnected)
+func (w *ZeroMQWorker) read(peer *zeroMQPeer) {
+	for {
+		subscribe, prefix, err := peer.conn.readSubscription()
+		if err != nil {
+			return
+		}
+		peer.lock.Lock()
+		if subscribe {
+			peer.subscriptions[prefix]++
+		} else if peer.subscriptions[prefix]--; peer.subscriptions[prefix] <= 0 {
+			delete(peer.subscriptions, prefix)
+		}
+		peer.lock.Unlock()
+	}
+}
+
+// write sends messages to a peer until it disconnects
+func (w *ZeroMQWorker) write(peer *zeroMQPeer, done chan bool) {
+	messages := peer.messages
+	if messages == nil {
+		messages = w.pushed
+	}
+	for {
+		select {
+		case message := <-messages:
+			if _, err := peer.conn.conn.Write(message.frames); err != nil {
+				Counters.Inc("zeromq_dropped")
+				return
+			}
+			Counters.Inc("zeromq_messages")
+		case <-done:
+			return
+		}
+	}
+}
+
+// ZeroMQMessage returns the frames of the message of an event, and the
+// topic subscriptions are matched against
+func ZeroMQMessage(v map[string]interface{}, topicField string) (string, []byte, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return "", nil, err
+	}
+	if topicField == "" {
+		return string(bs), ZMTPMessage(bs), nil
+	}
+	topic := ""
+	if value, found := lookupField(v, topicField); found && value != nil {
+		topic = fmt.Sprint(value)
+	}
+	return topic, ZMTPMessage([]byte(topic), bs), nil
+}
+
+// send queues an event's message for the peers it goes to
+func (w *ZeroMQWorker) send(obj map[string]interface{}) {
+	defer ReleaseEvent(obj)
+	topic, frames, err := ZeroMQMessage(obj, w.topicField)
+	if err != nil {
+		logs.Info("Unable to marshal object %v", obj)
+		return
+	}
+	message := zeroMQMessage{topic: topic, frames: frames}
+	if w.socketType == "PUSH" {
+		select {
+		case w.pushed <- message:
+		default:
+			Counters.Inc("zeromq_dropped")
+		}
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for peer := range w.peers {
+		if !peer.subscribed(topic) {
+			continue
+		}
+		select {
+		case peer.messages <- message:
+		default:
+			Counters.Inc("zeromq_dropped")
+		}
+	}
+}
+
+// Work the queue
+func (w *ZeroMQWorker) Work() {
+	w.startTime = time.Now()
+	logs.Info("ZeroMQWorker starting work at %v", w.startTime)
+	for {
+		select {
+		case obj := <-w.WorkChannel:
+			w.send(obj)
+
+		case <-w.QuitChannel:
+			logs.Info("ZeroMQWorker received quit")
+			return
+		}
+	}
+}
+
+// Stop stops the worker, and closes the socket
+func (w *ZeroMQWorker) Stop() {
+	w.QuitChannel <- true
+	if w.listener != nil {
+		w.listener.Close()
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for peer := range w.peers {
+		peer.conn.conn.Close()
+	}
+}
+
+// Peers returns the number of connected SUB or PULL sockets
+func (w *ZeroMQWorker) Peers() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.peers)
+}
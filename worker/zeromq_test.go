@@ -0,0 +1,113 @@
+package worker_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/willf/translog/worker"
+)
+
+// readZMTPFrame reads a short frame, and returns its flags and body
+func readZMTPFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, header[1])
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	return header[0], body
+}
+
+// connectZMTP connects to address as a socket of socketType
+func connectZMTP(t *testing.T, address string, socketType string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	greeting := make([]byte, 64)
+	greeting[0], greeting[9], greeting[10] = 0xff, 0x7f, 3
+	copy(greeting[12:], "NULL")
+	conn.Write(greeting)
+	conn.Write(worker.ZMTPFrame(0x04, worker.ZMTPReady(socketType)))
+	r := bufio.NewReader(conn)
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		t.Fatal(err)
+	}
+	if flags, body := readZMTPFrame(t, r); flags != 0x04 || !bytes.Equal(body, worker.ZMTPReady("PUB")) && !bytes.Equal(body, worker.ZMTPReady("PUSH")) {
+		t.Fatalf("expected READY, actual %x %q", flags, body)
+	}
+	return conn, r
+}
+
+func TestZMTPFrame(t *testing.T) {
+	if actual, expected := worker.ZMTPMessage([]byte("a"), []byte("bc")), []byte{1, 1, 'a', 0, 2, 'b', 'c'}; !bytes.Equal(actual, expected) {
+		t.Errorf("ZMTPMessage: expected %v, actual %v", expected, actual)
+	}
+	if actual := worker.ZMTPFrame(0, make([]byte, 300)); actual[0] != 0x02 || !bytes.Equal(actual[1:9], []byte{0, 0, 0, 0, 0, 0, 1, 44}) {
+		t.Errorf("ZMTPFrame: expected a long frame, actual %v", actual[:9])
+	}
+}
+
+func TestZeroMQWorkerPub(t *testing.T) {
+	viper.Reset()
+	viper.Set("zeromq.address", "tcp://127.0.0.1:0")
+	viper.Set("zeromq.topic_field", "service")
+
+	w := &worker.ZeroMQWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	conn, r := connectZMTP(t, w.Addr().String(), "SUB")
+	conn.Write(worker.ZMTPFrame(0, []byte("\x01check")))
+	// events are sent until the subscription has arrived, and one is received
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			for _, service := range []string{"api", "checkout"} {
+				select {
+				case w.WorkChannel <- map[string]interface{}{"service": service, "n": int64(2)}:
+				case <-done:
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	flags, topic := readZMTPFrame(t, r)
+	if flags != 0x01 || string(topic) != "checkout" {
+		t.Errorf("ZeroMQWorker: expected the checkout topic, actual %x %q", flags, topic)
+	}
+	if flags, event := readZMTPFrame(t, r); flags != 0 || string(event) != `{"n":2,"service":"checkout"}` {
+		t.Errorf("ZeroMQWorker: unexpected event %x %q", flags, event)
+	}
+}
+
+func TestZeroMQWorkerPush(t *testing.T) {
+	viper.Reset()
+	viper.Set("zeromq.address", "tcp://127.0.0.1:0")
+	viper.Set("zeromq.socket", "push")
+
+	w := &worker.ZeroMQWorker{}
+	w.SetWorkChannel(make(chan map[string]interface{}))
+	w.Init()
+	w.Start()
+	defer w.Stop()
+	// events are queued until a PULL socket connects
+	w.WorkChannel <- map[string]interface{}{"n": int64(1)}
+	_, r := connectZMTP(t, w.Addr().String(), "PULL")
+	if flags, event := readZMTPFrame(t, r); flags != 0 || string(event) != `{"n":1}` {
+		t.Errorf("ZeroMQWorker: unexpected event %x %q", flags, event)
+	}
+}
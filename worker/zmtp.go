@@ -0,0 +1,198 @@
+package worker
+
+/*
+	zmtp.go speaks ZMTP 3.0, the ZeroMQ message transport protocol, with
+	the NULL security mechanism
+
+	Both peers send a 64 byte greeting, then a READY command, whose
+	properties include the Socket-Type, and then frames: a flags byte (MORE,
+	LONG, COMMAND), a size (one byte, or eight with LONG), and a body. A
+	message is one or more frames, all but the last with MORE. Subscribers
+	send their subscriptions as messages starting with 1 (subscribe) or 0
+	(cancel), followed by the topic prefix, or, from ZMTP 3.1 peers, as
+	SUBSCRIBE and CANCEL commands. That is all the ZeroMQ output needs.
+*/
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// zmtpTimeout limits the handshake
+const zmtpTimeout = 10 * time.Second
+
+// zmtpMaxFrame is the largest frame read from a peer, which only sends
+// commands and subscriptions
+const zmtpMaxFrame = 64 * 1024
+
+const (
+	zmtpMore    = 0x01
+	zmtpLong    = 0x02
+	zmtpCommand = 0x04
+)
+
+// zmtpPeers are the socket types each socket type can talk to
+var zmtpPeers = map[string][]string{
+	"PUB":  {"SUB", "XSUB"},
+	"PUSH": {"PULL"},
+}
+
+// zmtpConn is a connection to a ZeroMQ peer
+type zmtpConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	peerType string
+}
+
+// zmtpGreeting returns the greeting of ZMTP 3.0 with the NULL mechanism
+func zmtpGreeting() []byte {
+	greeting := make([]byte, 64)
+	greeting[0], greeting[9] = 0xff, 0x7f
+	greeting[10], greeting[11] = 3, 0
+	copy(greeting[12:32], "NULL")
+	return greeting
+}
+
+// ZMTPReady returns the body of a READY command for a socket type
+func ZMTPReady(socketType string) []byte {
+	body := []byte("\x05READY\x0bSocket-Type")
+	body = binary.BigEndian.AppendUint32(body, uint32(len(socketType)))
+	return append(body, socketType...)
+}
+
+// ZMTPFrame returns a frame with flags and a body
+func ZMTPFrame(flags byte, body []byte) []byte {
+	if len(body) > 255 {
+		frame := binary.BigEndian.AppendUint64([]byte{flags | zmtpLong}, uint64(len(body)))
+		return append(frame, body...)
+	}
+	return append([]byte{flags, byte(len(body))}, body...)
+}
+
+// zmtpProperties parses the properties of a READY command
+func zmtpProperties(b []byte) (map[string]string, error) {
+	properties := make(map[string]string)
+	for len(b) > 0 {
+		n := int(b[0])
+		if len(b) < 1+n+4 {
+			return nil, fmt.Errorf("truncated ZMTP property")
+		}
+		name := string(b[1 : 1+n])
+		b = b[1+n:]
+		size := binary.BigEndian.Uint32(b)
+		if uint32(len(b)-4) < size {
+			return nil, fmt.Errorf("truncated ZMTP property %s", name)
+		}
+		properties[name] = string(b[4 : 4+size])
+		b = b[4+size:]
+	}
+	return properties, nil
+}
+
+// acceptZMTP greets a peer, and exchanges READY commands with it, as a
+// socket of socketType
+func acceptZMTP(conn net.Conn, socketType string) (*zmtpConn, error) {
+	c := &zmtpConn{conn: conn, reader: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(zmtpTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write(zmtpGreeting()); err != nil {
+		return nil, err
+	}
+	greeting := make([]byte, 64)
+	if _, err := io.ReadFull(c.reader, greeting); err != nil {
+		return nil, err
+	}
+	if greeting[0] != 0xff || greeting[9] != 0x7f || greeting[10] < 3 {
+		return nil, fmt.Errorf("unsupported ZMTP peer (ZMTP 3.0 or later is required)")
+	}
+	if mechanism := string(bytes.TrimRight(greeting[12:32], "\x00")); mechanism != "NULL" {
+		return nil, fmt.Errorf("unsupported ZMTP security mechanism %s", mechanism)
+	}
+	if _, err := conn.Write(ZMTPFrame(zmtpCommand, ZMTPReady(socketType))); err != nil {
+		return nil, err
+	}
+	flags, body, err := c.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if flags&zmtpCommand == 0 || len(body) < 6 || string(body[:6]) != "\x05READY" {
+		return nil, fmt.Errorf("expected a ZMTP READY command")
+	}
+	properties, err := zmtpProperties(body[6:])
+	if err != nil {
+		return nil, err
+	}
+	c.peerType = properties["Socket-Type"]
+	for _, peerType := range zmtpPeers[socketType] {
+		if c.peerType == peerType {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("a %s socket can't talk to a %s socket", socketType, c.peerType)
+}
+
+// readFrame reads a frame, and returns its flags and body
+func (c *zmtpConn) readFrame() (byte, []byte, error) {
+	flags, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&zmtpLong != 0 {
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, b); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(b)
+	} else {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > zmtpMaxFrame {
+		return 0, nil, fmt.Errorf("ZMTP frame of %d bytes is too large", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// readSubscription reads frames until a subscription or cancellation, and
+// returns whether it subscribes, and its topic prefix
+func (c *zmtpConn) readSubscription() (bool, string, error) {
+	for {
+		flags, body, err := c.readFrame()
+		if err != nil {
+			return false, "", err
+		}
+		switch {
+		case flags&zmtpCommand != 0 && len(body) >= 10 && string(body[:10]) == "\x09SUBSCRIBE":
+			return true, string(body[10:]), nil
+		case flags&zmtpCommand != 0 && len(body) >= 7 && string(body[:7]) == "\x06CANCEL":
+			return false, string(body[7:]), nil
+		case flags&zmtpCommand == 0 && len(body) > 0 && body[0] <= 1:
+			return body[0] == 1, string(body[1:]), nil
+		}
+	}
+}
+
+// ZMTPMessage returns the frames of a message
+func ZMTPMessage(parts ...[]byte) []byte {
+	var message []byte
+	for i, part := range parts {
+		var flags byte
+		if i < len(parts)-1 {
+			flags = zmtpMore
+		}
+		message = append(message, ZMTPFrame(flags, part)...)
+	}
+	return message
+}